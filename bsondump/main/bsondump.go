@@ -58,9 +58,12 @@ func main() {
 	log.Logvf(log.DebugLow, "running bsondump with --objcheck: %v", opts.ObjCheck)
 
 	var numFound int
-	if opts.Type == bsondump.DebugOutputType {
+	switch opts.Type {
+	case bsondump.DebugOutputType:
 		numFound, err = dumper.Debug()
-	} else {
+	case bsondump.OplogOutputType:
+		numFound, err = dumper.Oplog()
+	default:
 		numFound, err = dumper.JSON()
 	}
 
@@ -0,0 +1,419 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package bsondump
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/txn"
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// oplogOpNames are the names printed for each oplog operation type.
+var oplogOpNames = map[string]string{
+	"i": "insert",
+	"u": "update",
+	"d": "delete",
+	"c": "command",
+	"n": "noop",
+}
+
+// oplogFilter selects the oplog entries printed with --type=oplog.
+type oplogFilter struct {
+	since      primitive.Timestamp
+	until      primitive.Timestamp
+	namespaces []*regexp.Regexp
+}
+
+// newOplogFilter builds an oplogFilter from the --since, --until and --ns options.
+func newOplogFilter(oo *OutputOptions) (*oplogFilter, error) {
+	filter := &oplogFilter{}
+	var err error
+	if oo.Since != "" {
+		filter.since, err = parseOplogTime(oo.Since)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing --since: %v", err)
+		}
+	}
+	if oo.Until != "" {
+		filter.until, err = parseOplogTime(oo.Until)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing --until: %v", err)
+		}
+	}
+	for _, pattern := range oo.Namespaces {
+		re, err := compileNamespacePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --ns '%v': %v", pattern, err)
+		}
+		filter.namespaces = append(filter.namespaces, re)
+	}
+	return filter, nil
+}
+
+// compileNamespacePattern compiles an --ns pattern, in which '*' matches any
+// run of characters and '\' escapes the character after it, like the
+// namespace patterns of mongorestore.
+func compileNamespacePattern(pattern string) (*regexp.Regexp, error) {
+	expr := "^"
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr += ".*"
+		case '\\':
+			i++
+			if i == len(pattern) {
+				return nil, fmt.Errorf("'\\' at the end of the pattern")
+			}
+			expr += regexp.QuoteMeta(pattern[i : i+1])
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+	return regexp.Compile(expr + "$")
+}
+
+// parseOplogTime parses either a <seconds>[:ordinal] timestamp or an RFC 3339 date.
+func parseOplogTime(value string) (primitive.Timestamp, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return primitive.Timestamp{T: uint32(t.Unix())}, nil
+	}
+	parts := strings.SplitN(value, ":", 2)
+	seconds, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("expected <seconds>[:ordinal] or an RFC 3339 date, got '%v'", value)
+	}
+	ts := primitive.Timestamp{T: uint32(seconds)}
+	if len(parts) == 2 && parts[1] != "" {
+		ordinal, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return primitive.Timestamp{}, fmt.Errorf("expected <seconds>[:ordinal] or an RFC 3339 date, got '%v'", value)
+		}
+		ts.I = uint32(ordinal)
+	}
+	return ts, nil
+}
+
+// inRange returns true if ts is at or after --since and before --until.
+func (f *oplogFilter) inRange(ts primitive.Timestamp) bool {
+	if (f.since != primitive.Timestamp{}) && util.TimestampGreaterThan(f.since, ts) {
+		return false
+	}
+	if (f.until != primitive.Timestamp{}) && !util.TimestampGreaterThan(f.until, ts) {
+		return false
+	}
+	return true
+}
+
+// hasNamespace returns true if the op acts on a namespace matching --ns.
+func (f *oplogFilter) hasNamespace(op db.Oplog) bool {
+	if len(f.namespaces) == 0 {
+		return true
+	}
+	namespace := oplogTargetNamespace(op)
+	for _, re := range f.namespaces {
+		if re.MatchString(namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// pastRange returns true if ts is at or after --until, after which nothing
+// more can be printed.
+func (f *oplogFilter) pastRange(ts primitive.Timestamp) bool {
+	return (f.until != primitive.Timestamp{}) && !util.TimestampGreaterThan(f.until, ts)
+}
+
+// Oplog iterates through an oplog BSON file and prints a timeline with one
+// line per operation. Operations applied together by a transaction or an
+// applyOps command are grouped under a header line. Periodic no-ops are
+// skipped.
+// It returns the number of oplog entries read and a non-nil error if one is
+// encountered before the end of the file is reached.
+func (bd *BSONDump) Oplog() (int, error) {
+	numFound := 0
+
+	if bd.InputSource == nil {
+		panic("Tried to call Oplog() before opening file")
+	}
+	filter, err := newOplogFilter(bd.OutputOptions)
+	if err != nil {
+		return 0, err
+	}
+
+	// data entries of multi-entry transactions, held until commit or abort
+	pending := map[string][]db.Oplog{}
+
+	for {
+		raw := bd.InputSource.LoadNext()
+		if raw == nil {
+			break
+		}
+		numFound++
+
+		op := db.Oplog{}
+		if err := bson.Unmarshal(raw, &op); err != nil {
+			if bd.OutputOptions.ObjCheck {
+				return numFound, fmt.Errorf("failed to read oplog entry %v: %v", numFound, err)
+			}
+			continue
+		}
+		if filter.pastRange(op.Timestamp) {
+			break
+		}
+
+		meta, err := txn.NewMeta(op)
+		if err != nil {
+			return numFound, err
+		}
+		var lines []string
+		switch {
+		case meta.IsTxn():
+			key := txnKey(op)
+			if meta.IsData() {
+				inner, err := innerOps(op)
+				if err != nil {
+					return numFound, err
+				}
+				pending[key] = append(pending[key], inner...)
+			}
+			if meta.IsAbort() {
+				if filter.inRange(op.Timestamp) {
+					lines = append(lines, fmt.Sprintf("%v transaction %v aborted, %v %v discarded",
+						formatOplogTimestamp(op.Timestamp), txnLabel(op), len(pending[key]),
+						util.Pluralize(len(pending[key]), "op", "ops")))
+				}
+				delete(pending, key)
+			} else if meta.IsCommit() {
+				if filter.inRange(op.Timestamp) {
+					lines = formatOplogGroup(op.Timestamp, "transaction "+txnLabel(op), pending[key], filter)
+				}
+				delete(pending, key)
+			}
+		case op.Operation == "n":
+		case !filter.inRange(op.Timestamp):
+		case op.Operation == "c" && len(op.Object) > 0 && op.Object[0].Key == "applyOps":
+			inner, err := innerOps(op)
+			if err != nil {
+				return numFound, err
+			}
+			lines = formatOplogGroup(op.Timestamp, "applyOps", inner, filter)
+		case filter.hasNamespace(op):
+			lines = append(lines, formatOplogTimestamp(op.Timestamp)+" "+formatOplogOp(op))
+		}
+
+		for _, line := range lines {
+			if _, err := bd.OutputWriter.Write([]byte(line + "\n")); err != nil {
+				return numFound, err
+			}
+		}
+	}
+	if err := bd.InputSource.Err(); err != nil {
+		return numFound, err
+	}
+	return numFound, nil
+}
+
+// formatOplogGroup formats a header line followed by an indented line for each
+// op matching the namespace filter. It returns nothing if no op matches.
+func formatOplogGroup(ts primitive.Timestamp, header string, ops []db.Oplog, filter *oplogFilter) []string {
+	var lines []string
+	for _, op := range ops {
+		if filter.hasNamespace(op) {
+			lines = append(lines, "\t"+formatOplogOp(op))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	header = fmt.Sprintf("%v %v (%v %v)", formatOplogTimestamp(ts), header, len(ops), util.Pluralize(len(ops), "op", "ops"))
+	return append([]string{header}, lines...)
+}
+
+// formatOplogTimestamp formats a timestamp as a UTC date followed by the raw
+// <seconds>:<ordinal> value accepted by --oplogLimit.
+func formatOplogTimestamp(ts primitive.Timestamp) string {
+	return fmt.Sprintf("%v %v:%v", time.Unix(int64(ts.T), 0).UTC().Format(time.RFC3339), ts.T, ts.I)
+}
+
+// formatOplogOp formats a single op as its type, namespace, document key and
+// a summary of the change.
+func formatOplogOp(op db.Oplog) string {
+	name, ok := oplogOpNames[op.Operation]
+	if !ok {
+		name = op.Operation
+	}
+	parts := []string{name, op.Namespace}
+	switch op.Operation {
+	case "i":
+		key := op.Query
+		if key == nil {
+			key = bson.D{}
+			for _, elem := range op.Object {
+				if elem.Key == "_id" {
+					key = bson.D{elem}
+				}
+			}
+		}
+		parts = append(parts, compactJSON(key))
+	case "u":
+		parts = append(parts, compactJSON(op.Query))
+		parts = append(parts, formatUpdate(op.Object))
+	default:
+		parts = append(parts, compactJSON(op.Object))
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatUpdate summarizes an update as the paths it sets and unsets, or as a
+// replacement document.
+func formatUpdate(update bson.D) string {
+	set := bson.D{}
+	var unset []string
+	isModifier := false
+	for _, elem := range update {
+		switch elem.Key {
+		case "$v":
+			isModifier = true
+		case "$set":
+			isModifier = true
+			if fields, ok := elem.Value.(bson.D); ok {
+				set = append(set, fields...)
+			}
+		case "$unset":
+			isModifier = true
+			if fields, ok := elem.Value.(bson.D); ok {
+				for _, field := range fields {
+					unset = append(unset, field.Key)
+				}
+			}
+		case "diff":
+			isModifier = true
+			if diff, ok := elem.Value.(bson.D); ok {
+				collectUpdateDiff(diff, "", &set, &unset)
+			}
+		default:
+			if strings.HasPrefix(elem.Key, "$") {
+				isModifier = true
+				set = append(set, bson.E{Key: elem.Key, Value: elem.Value})
+			}
+		}
+	}
+	if !isModifier {
+		return "replace " + compactJSON(update)
+	}
+
+	var summary []string
+	if len(set) > 0 {
+		summary = append(summary, "set "+compactJSON(set))
+	}
+	if len(unset) > 0 {
+		sort.Strings(unset)
+		summary = append(summary, "unset "+strings.Join(unset, ","))
+	}
+	return strings.Join(summary, " ")
+}
+
+// collectUpdateDiff flattens a $v: 2 update diff into set and unset paths.
+func collectUpdateDiff(diff bson.D, prefix string, set *bson.D, unset *[]string) {
+	for _, elem := range diff {
+		fields, _ := elem.Value.(bson.D)
+		switch {
+		case elem.Key == "u" || elem.Key == "i":
+			for _, field := range fields {
+				*set = append(*set, bson.E{Key: prefix + field.Key, Value: field.Value})
+			}
+		case elem.Key == "d":
+			for _, field := range fields {
+				*unset = append(*unset, prefix+field.Key)
+			}
+		case strings.HasPrefix(elem.Key, "s"):
+			path := prefix + elem.Key[1:]
+			if isArrayDiff(fields) {
+				*set = append(*set, bson.E{Key: path, Value: fields})
+			} else {
+				collectUpdateDiff(fields, path+".", set, unset)
+			}
+		}
+	}
+}
+
+// isArrayDiff returns true if a sub-diff describes changes to an array.
+func isArrayDiff(diff bson.D) bool {
+	for _, elem := range diff {
+		if elem.Key == "a" {
+			return true
+		}
+	}
+	return false
+}
+
+// oplogTargetNamespace returns the namespace an op acts on. For commands this
+// is the collection they name rather than the database's $cmd namespace.
+func oplogTargetNamespace(op db.Oplog) string {
+	if op.Operation != "c" || len(op.Object) == 0 {
+		return op.Namespace
+	}
+	target, ok := op.Object[0].Value.(string)
+	if !ok {
+		return op.Namespace
+	}
+	if op.Object[0].Key == "renameCollection" {
+		return target
+	}
+	dbName, _ := util.SplitNamespace(op.Namespace)
+	return dbName + "." + target
+}
+
+// innerOps returns the ops nested in an applyOps entry.
+func innerOps(op db.Oplog) ([]db.Oplog, error) {
+	raw, err := bson.Marshal(op.Object)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read applyOps entry: %v", err)
+	}
+	var cmd struct {
+		ApplyOps []db.Oplog `bson:"applyOps"`
+	}
+	if err = bson.Unmarshal(raw, &cmd); err != nil {
+		return nil, fmt.Errorf("cannot read applyOps entry: %v", err)
+	}
+	return cmd.ApplyOps, nil
+}
+
+// txnKey identifies the transaction an oplog entry belongs to.
+func txnKey(op db.Oplog) string {
+	return fmt.Sprintf("%x-%d", []byte(op.LSID), *op.TxnNumber)
+}
+
+// txnLabel formats a transaction's session id and transaction number.
+func txnLabel(op db.Oplog) string {
+	session := compactJSON(op.LSID)
+	if id, err := op.LSID.LookupErr("id"); err == nil {
+		if subtype, data, ok := id.BinaryOK(); ok && subtype == 4 && len(data) == 16 {
+			session = fmt.Sprintf("%x-%x-%x-%x-%x", data[0:4], data[4:6], data[6:8], data[8:10], data[10:16])
+		}
+	}
+	return fmt.Sprintf("%v:%v", session, *op.TxnNumber)
+}
+
+// compactJSON formats a document as single-line relaxed extended JSON.
+func compactJSON(doc interface{}) string {
+	out, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return "<unable to format document>"
+	}
+	return string(out)
+}
@@ -0,0 +1,147 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package bsondump
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (*bufferCloser) Close() error { return nil }
+
+func oplogDump(oo *OutputOptions, entries ...interface{}) (string, int, error) {
+	var input []byte
+	for _, entry := range entries {
+		raw, err := bson.Marshal(entry)
+		So(err, ShouldBeNil)
+		input = append(input, raw...)
+	}
+	out := &bufferCloser{}
+	bd := &BSONDump{
+		OutputOptions: oo,
+		OutputWriter:  out,
+		InputSource:   db.NewBSONSource(ReadNopCloser{bytes.NewReader(input)}),
+	}
+	numFound, err := bd.Oplog()
+	return out.String(), numFound, err
+}
+
+func TestFormatOplogOp(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Oplog ops should be formatted with their key and changes", t, func() {
+		So(formatOplogOp(db.Oplog{Operation: "i", Namespace: "db.c", Object: bson.D{{"_id", 1}, {"a", 2}}}),
+			ShouldEqual, `insert db.c {"_id":1}`)
+		So(formatOplogOp(db.Oplog{Operation: "d", Namespace: "db.c", Object: bson.D{{"_id", 1}}}),
+			ShouldEqual, `delete db.c {"_id":1}`)
+		So(formatOplogOp(db.Oplog{
+			Operation: "u", Namespace: "db.c", Query: bson.D{{"_id", 1}},
+			Object: bson.D{{"$set", bson.D{{"a", 3}}}, {"$unset", bson.D{{"b", true}}}},
+		}), ShouldEqual, `update db.c {"_id":1} set {"a":3} unset b`)
+		So(formatOplogOp(db.Oplog{
+			Operation: "u", Namespace: "db.c", Query: bson.D{{"_id", 1}},
+			Object: bson.D{{"$v", 2}, {"diff", bson.D{
+				{"u", bson.D{{"a", 3}}},
+				{"d", bson.D{{"b", false}}},
+				{"sx", bson.D{{"i", bson.D{{"y", "z"}}}}},
+			}}},
+		}), ShouldEqual, `update db.c {"_id":1} set {"a":3,"x.y":"z"} unset b`)
+		So(formatOplogOp(db.Oplog{
+			Operation: "u", Namespace: "db.c", Query: bson.D{{"_id", 1}}, Object: bson.D{{"_id", 1}, {"a", 4}},
+		}), ShouldEqual, `update db.c {"_id":1} replace {"_id":1,"a":4}`)
+		So(formatOplogOp(db.Oplog{Operation: "c", Namespace: "db.$cmd", Object: bson.D{{"drop", "c"}}}),
+			ShouldEqual, `command db.$cmd {"drop":"c"}`)
+	})
+}
+
+func TestOplogTimeline(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	lsid := bson.D{{"id", primitive.Binary{Subtype: 4, Data: []byte("0123456789abcdef")}}}
+	entries := []interface{}{
+		bson.D{{"ts", primitive.Timestamp{T: 100, I: 1}}, {"op", "n"}, {"ns", ""}, {"o", bson.D{{"msg", "periodic noop"}}}},
+		bson.D{{"ts", primitive.Timestamp{T: 100, I: 2}}, {"op", "i"}, {"ns", "db.a"}, {"o", bson.D{{"_id", 1}}}},
+		bson.D{{"ts", primitive.Timestamp{T: 101, I: 1}}, {"op", "i"}, {"ns", "other.b"}, {"o", bson.D{{"_id", 2}}}},
+		bson.D{{"ts", primitive.Timestamp{T: 102, I: 1}}, {"op", "c"}, {"ns", "admin.$cmd"},
+			{"lsid", lsid}, {"txnNumber", int64(7)},
+			{"o", bson.D{{"applyOps", bson.A{
+				bson.D{{"op", "i"}, {"ns", "db.a"}, {"o", bson.D{{"_id", 3}}}},
+				bson.D{{"op", "d"}, {"ns", "other.b"}, {"o", bson.D{{"_id", 2}}}},
+			}}}}},
+		bson.D{{"ts", primitive.Timestamp{T: 103, I: 1}}, {"op", "d"}, {"ns", "db.a"}, {"o", bson.D{{"_id", 1}}}},
+	}
+
+	Convey("With an oplog containing a transaction", t, func() {
+		Convey("every entry should be printed, grouping transaction ops", func() {
+			out, numFound, err := oplogDump(&OutputOptions{}, entries...)
+			So(err, ShouldBeNil)
+			So(numFound, ShouldEqual, 5)
+			So(strings.Split(out, "\n"), ShouldResemble, []string{
+				`1970-01-01T00:01:40Z 100:2 insert db.a {"_id":1}`,
+				`1970-01-01T00:01:41Z 101:1 insert other.b {"_id":2}`,
+				`1970-01-01T00:01:42Z 102:1 transaction 30313233-3435-3637-3839-616263646566:7 (2 ops)`,
+				"\t" + `insert db.a {"_id":3}`,
+				"\t" + `delete other.b {"_id":2}`,
+				`1970-01-01T00:01:43Z 103:1 delete db.a {"_id":1}`,
+				``,
+			})
+		})
+
+		Convey("time range and namespace filters should be applied", func() {
+			out, _, err := oplogDump(&OutputOptions{Since: "101", Until: "103", Namespaces: []string{"db.*"}}, entries...)
+			So(err, ShouldBeNil)
+			So(strings.Split(out, "\n"), ShouldResemble, []string{
+				`1970-01-01T00:01:42Z 102:1 transaction 30313233-3435-3637-3839-616263646566:7 (2 ops)`,
+				"\t" + `insert db.a {"_id":3}`,
+				``,
+			})
+		})
+	})
+
+	Convey("Oplog times should accept timestamps and dates", t, func() {
+		ts, err := parseOplogTime("100:5")
+		So(err, ShouldBeNil)
+		So(ts, ShouldResemble, primitive.Timestamp{T: 100, I: 5})
+		ts, err = parseOplogTime("1970-01-01T00:01:40Z")
+		So(err, ShouldBeNil)
+		So(ts, ShouldResemble, primitive.Timestamp{T: 100})
+		_, err = parseOplogTime("yesterday")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCompileNamespacePattern(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Namespace patterns should match like mongorestore's", t, func() {
+		for pattern, matches := range map[string]map[string]bool{
+			"db.*":    {"db.a": true, "db.": true, "dbx.a": false, "other.db.a": false},
+			"*.users": {"a.users": true, "a.users2": false},
+			`db.a\*`:  {"db.a*": true, "db.ab": false},
+			"db.a.b":  {"db.a.b": true, "db.aXb": false},
+		} {
+			re, err := compileNamespacePattern(pattern)
+			So(err, ShouldBeNil)
+			for namespace, expected := range matches {
+				So(re.MatchString(namespace), ShouldEqual, expected)
+			}
+		}
+		_, err := compileNamespacePattern(`db.\`)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -29,11 +29,12 @@ type Options struct {
 const (
 	DebugOutputType = "debug"
 	JSONOutputType  = "json"
+	OplogOutputType = "oplog"
 )
 
 type OutputOptions struct {
 	// Format to display the BSON data file
-	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"type of output: debug, json"`
+	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"type of output: debug, json, oplog"`
 
 	// Validate each BSON document before displaying
 	ObjCheck bool `long:"objcheck" description:"validate BSON during processing"`
@@ -46,6 +47,11 @@ type OutputOptions struct {
 
 	// Path to output file
 	OutFileName string `long:"outFile" description:"path to output file to dump BSON to; default is stdout"`
+
+	// Filters for --type=oplog
+	Since      string   `long:"since" value-name:"<timestamp>" description:"with --type=oplog, only show entries at or after the given <seconds>[:ordinal] timestamp or RFC 3339 date"`
+	Until      string   `long:"until" value-name:"<timestamp>" description:"with --type=oplog, only show entries before the given <seconds>[:ordinal] timestamp or RFC 3339 date"`
+	Namespaces []string `long:"ns" value-name:"<namespace-pattern>" description:"with --type=oplog, only show operations on matching namespaces, e.g. 'db.*' (may be specified multiple times)"`
 }
 
 func (*OutputOptions) Name() string {
@@ -80,8 +86,16 @@ func ParseOptions(rawArgs []string, versionStr, gitCommit string) (Options, erro
 
 	switch outputOpts.Type {
 	case "", DebugOutputType, JSONOutputType:
+		if outputOpts.Since != "" || outputOpts.Until != "" || len(outputOpts.Namespaces) > 0 {
+			return Options{}, fmt.Errorf("--since, --until and --ns can only be used with --type=%v", OplogOutputType)
+		}
+		return Options{toolOpts, outputOpts}, nil
+	case OplogOutputType:
+		if _, err := newOplogFilter(outputOpts); err != nil {
+			return Options{}, err
+		}
 		return Options{toolOpts, outputOpts}, nil
 	default:
-		return Options{}, fmt.Errorf("unsupported output type '%v'. Must be either '%v' or '%v'", DebugOutputType, JSONOutputType, outputOpts.Type)
+		return Options{}, fmt.Errorf("unsupported output type '%v'. Must be one of '%v', '%v' or '%v'", outputOpts.Type, DebugOutputType, JSONOutputType, OplogOutputType)
 	}
 }
@@ -0,0 +1,323 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// avroMagic begins every Avro object container file.
+var avroMagic = []byte("Obj\x01")
+
+// avroBlockSize is the number of encoded bytes after which a block of
+// documents is compressed and written.
+const avroBlockSize = 64 * 1024
+
+// AvroExportOutput is an implementation of ExportOutput that writes documents
+// to the output as an Avro object container file, compressed with deflate.
+// Field names that are not valid Avro names have their invalid characters
+// replaced by underscores.
+type AvroExportOutput struct {
+	// NumExported maintains a running total of the number of documents written.
+	NumExported int64
+
+	sampler       schemaSampler
+	out           io.Writer
+	headerWritten bool
+	sync          []byte
+	block         []byte
+	blockCount    int64
+}
+
+// NewAvroExportOutput returns an AvroExportOutput that writes to the given
+// io.Writer. If schema is nil, the schema is inferred from the first
+// sampleSize documents.
+func NewAvroExportOutput(schema *exportSchema, sampleSize int, catchAll string, out io.Writer) *AvroExportOutput {
+	return &AvroExportOutput{
+		sampler: schemaSampler{schema: schema, sampleSize: sampleSize, catchAll: catchAll},
+		out:     out,
+	}
+}
+
+// WriteHeader is a no-op for Avro export formats; the file header holds
+// the schema, so it is written once the schema is known.
+func (a *AvroExportOutput) WriteHeader() error {
+	return nil
+}
+
+// ExportDocument encodes a document, writing a block once enough documents
+// are pending.
+func (a *AvroExportOutput) ExportDocument(document bson.D) error {
+	docs, err := a.sampler.add(document)
+	if err != nil {
+		return err
+	}
+	return a.writeDocuments(docs)
+}
+
+// WriteFooter writes the pending documents. Avro files have no footer.
+func (a *AvroExportOutput) WriteFooter() error {
+	docs, err := a.sampler.finish()
+	if err != nil {
+		return err
+	}
+	if err = a.writeDocuments(docs); err != nil {
+		return err
+	}
+	if err = a.writeHeader(); err != nil {
+		return err
+	}
+	return a.writeBlock()
+}
+
+// Flush is a no-op for Avro export formats; documents are written in
+// blocks.
+func (a *AvroExportOutput) Flush() error {
+	return nil
+}
+
+func (a *AvroExportOutput) writeDocuments(docs []bson.D) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	if err := a.writeHeader(); err != nil {
+		return err
+	}
+	schema := a.sampler.schema
+	for _, doc := range docs {
+		values, extra, err := schema.convertDocument(doc)
+		if err != nil {
+			return err
+		}
+		for i, field := range schema.fields {
+			a.block = appendAvroValue(a.block, field.typ, values[i])
+		}
+		a.block = appendAvroValue(a.block, &fieldType{kind: kindJSON}, extra)
+		a.blockCount++
+		a.NumExported++
+		if len(a.block) >= avroBlockSize {
+			if err = a.writeBlock(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeHeader writes the magic bytes, the metadata holding the schema, and
+// the sync marker that ends every block.
+func (a *AvroExportOutput) writeHeader() error {
+	if a.headerWritten {
+		return nil
+	}
+	a.headerWritten = true
+	schema, err := json.Marshal(avroSchema(a.sampler.schema))
+	if err != nil {
+		return fmt.Errorf("error building Avro schema: %v", err)
+	}
+	a.sync = make([]byte, 16)
+	if _, err = rand.Read(a.sync); err != nil {
+		return fmt.Errorf("error generating Avro sync marker: %v", err)
+	}
+
+	header := append([]byte{}, avroMagic...)
+	header = appendAvroLong(header, 2)
+	header = appendAvroBytes(header, []byte("avro.schema"))
+	header = appendAvroBytes(header, schema)
+	header = appendAvroBytes(header, []byte("avro.codec"))
+	header = appendAvroBytes(header, []byte("deflate"))
+	header = appendAvroLong(header, 0)
+	header = append(header, a.sync...)
+	_, err = a.out.Write(header)
+	return err
+}
+
+// writeBlock compresses and writes the pending documents.
+func (a *AvroExportOutput) writeBlock() error {
+	if a.blockCount == 0 {
+		return nil
+	}
+	compressed := &bytes.Buffer{}
+	w, err := flate.NewWriter(compressed, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(a.block); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	block := appendAvroLong(nil, a.blockCount)
+	block = appendAvroBytes(block, compressed.Bytes())
+	block = append(block, a.sync...)
+	a.block = a.block[:0]
+	a.blockCount = 0
+	_, err = a.out.Write(block)
+	return err
+}
+
+// avroRecord is the JSON form of an Avro record schema.
+type avroRecord struct {
+	Type   string            `json:"type"`
+	Name   string            `json:"name"`
+	Fields []avroRecordField `json:"fields"`
+}
+
+type avroRecordField struct {
+	Name    string      `json:"name"`
+	Type    interface{} `json:"type"`
+	Default interface{} `json:"default"`
+}
+
+// avroSchema returns the record schema of the exported documents. Every
+// field is a union with null that defaults to null.
+func avroSchema(schema *exportSchema) avroRecord {
+	names := map[string]bool{}
+	fields := append(append([]*schemaField{}, schema.fields...),
+		&schemaField{schema.catchAll, &fieldType{kind: kindJSON}})
+	return avroRecordSchema("Document", fields, names)
+}
+
+func avroRecordSchema(name string, fields []*schemaField, names map[string]bool) avroRecord {
+	record := avroRecord{Type: "record", Name: uniqueAvroName(name, names)}
+	fieldNames := map[string]bool{}
+	for _, field := range fields {
+		fieldName := uniqueAvroName(avroName(field.name), fieldNames)
+		record.Fields = append(record.Fields, avroRecordField{
+			Name: fieldName,
+			Type: []interface{}{"null", avroType(record.Name+"_"+fieldName, field.typ, names)},
+		})
+	}
+	return record
+}
+
+// avroType returns the schema of the non-null values of a field type.
+func avroType(name string, typ *fieldType, names map[string]bool) interface{} {
+	switch typ.kind {
+	case kindBoolean:
+		return "boolean"
+	case kindInt32:
+		return "int"
+	case kindInt64:
+		return "long"
+	case kindDouble:
+		return "double"
+	case kindDecimal:
+		return map[string]interface{}{
+			"type":        "bytes",
+			"logicalType": "decimal",
+			"precision":   decimalPrecision,
+			"scale":       typ.scale,
+		}
+	case kindDate:
+		return map[string]interface{}{"type": "long", "logicalType": "timestamp-millis"}
+	case kindBinary:
+		return "bytes"
+	case kindDocument:
+		return avroRecordSchema(name, typ.fields, names)
+	case kindArray:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": []interface{}{"null", avroType(name, typ.elem, names)},
+		}
+	}
+	// strings, ObjectIds and JSON
+	return "string"
+}
+
+// avroName replaces the characters that may not appear in an Avro name.
+func avroName(name string) string {
+	out := []byte(name)
+	for i, c := range out {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			out[i] = '_'
+		}
+	}
+	if len(out) == 0 {
+		return "_"
+	}
+	return string(out)
+}
+
+// uniqueAvroName returns name, with a numeric suffix if it was already used.
+func uniqueAvroName(name string, used map[string]bool) string {
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%v_%v", name, i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// appendAvroValue appends the encoding of a value from convertValue as a
+// union of null and its field type.
+func appendAvroValue(buf []byte, typ *fieldType, v interface{}) []byte {
+	if v == nil {
+		return appendAvroLong(buf, 0)
+	}
+	buf = appendAvroLong(buf, 1)
+	switch typ.kind {
+	case kindBoolean:
+		if v.(bool) {
+			return append(buf, 1)
+		}
+		return append(buf, 0)
+	case kindInt32:
+		return appendAvroLong(buf, int64(v.(int32)))
+	case kindInt64, kindDate:
+		return appendAvroLong(buf, v.(int64))
+	case kindDouble:
+		bits := make([]byte, 8)
+		binary.LittleEndian.PutUint64(bits, math.Float64bits(v.(float64)))
+		return append(buf, bits...)
+	case kindDecimal:
+		return appendAvroBytes(buf, twosComplement(v.(*big.Int), 0))
+	case kindBinary:
+		return appendAvroBytes(buf, v.([]byte))
+	case kindDocument:
+		values := v.([]interface{})
+		for i, field := range typ.fields {
+			buf = appendAvroValue(buf, field.typ, values[i])
+		}
+		return buf
+	case kindArray:
+		values := v.([]interface{})
+		if len(values) > 0 {
+			buf = appendAvroLong(buf, int64(len(values)))
+			for _, value := range values {
+				buf = appendAvroValue(buf, typ.elem, value)
+			}
+		}
+		return appendAvroLong(buf, 0)
+	}
+	return appendAvroBytes(buf, []byte(v.(string)))
+}
+
+// appendAvroLong appends a zigzag varint.
+func appendAvroLong(buf []byte, v int64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	return append(buf, tmp[:binary.PutVarint(tmp, v)]...)
+}
+
+// appendAvroBytes appends a length-prefixed byte string.
+func appendAvroBytes(buf []byte, b []byte) []byte {
+	return append(appendAvroLong(buf, int64(len(b))), b...)
+}
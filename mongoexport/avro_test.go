@@ -0,0 +1,104 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// avroTestReader decodes the parts of an Avro container the tests check.
+type avroTestReader struct {
+	buf []byte
+}
+
+func (r *avroTestReader) long() int64 {
+	v, n := binary.Varint(r.buf)
+	So(n, ShouldBeGreaterThan, 0)
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *avroTestReader) bytes() []byte {
+	n := r.long()
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *avroTestReader) fixed(n int) []byte {
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func TestWriteAvro(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an Avro export output", t, func() {
+		out := &bytes.Buffer{}
+		exporter := NewAvroExportOutput(nil, 1, "_extra", out)
+		So(exporter.WriteHeader(), ShouldBeNil)
+		So(exporter.ExportDocument(bson.D{{"_id", int32(1)}, {"name", "a"}, {"a-b", true}}), ShouldBeNil)
+		So(exporter.ExportDocument(bson.D{{"_id", int32(2)}, {"name", int32(5)}}), ShouldBeNil)
+		So(exporter.WriteFooter(), ShouldBeNil)
+		So(exporter.NumExported, ShouldEqual, 2)
+
+		r := &avroTestReader{buf: out.Bytes()}
+		So(r.fixed(4), ShouldResemble, avroMagic)
+		meta := map[string][]byte{}
+		for count := r.long(); count != 0; count = r.long() {
+			for i := int64(0); i < count; i++ {
+				key := string(r.bytes())
+				meta[key] = r.bytes()
+			}
+		}
+		sync := r.fixed(16)
+
+		Convey("the header should hold the schema with valid names", func() {
+			So(string(meta["avro.codec"]), ShouldEqual, "deflate")
+			var schema avroRecord
+			So(json.Unmarshal(meta["avro.schema"], &schema), ShouldBeNil)
+			So(schema.Name, ShouldEqual, "Document")
+			var names []string
+			for _, field := range schema.Fields {
+				names = append(names, field.Name)
+				So(field.Type, ShouldResemble, []interface{}{"null", field.Type.([]interface{})[1]})
+			}
+			So(names, ShouldResemble, []string{"_id", "name", "a_b", "_extra"})
+		})
+
+		Convey("the documents should be encoded as unions with null", func() {
+			So(r.long(), ShouldEqual, 2)
+			block, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(r.bytes())))
+			So(err, ShouldBeNil)
+			So(r.fixed(16), ShouldResemble, sync)
+			So(len(r.buf), ShouldEqual, 0)
+
+			extra := `{"name":{"$numberInt":"5"}}`
+			expected := []byte{2, 2, 2, 2, 'a', 2, 1, 0, 2, 4, 0, 0, 2, byte(2 * len(extra))}
+			So(block, ShouldResemble, append(expected, extra...))
+		})
+	})
+
+	Convey("Avro names should be sanitized and unique", t, func() {
+		So(avroName("a.b c"), ShouldEqual, "a_b_c")
+		So(avroName("1st"), ShouldEqual, "_st")
+		So(avroName(""), ShouldEqual, "_")
+		used := map[string]bool{}
+		So(uniqueAvroName("a", used), ShouldEqual, "a")
+		So(uniqueAvroName("a", used), ShouldEqual, "a_2")
+	})
+}
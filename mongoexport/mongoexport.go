@@ -4,7 +4,7 @@
 // not use this file except in compliance with the License. You may obtain
 // a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
 
-// Package mongoexport produces a JSON or CSV export of data stored in a MongoDB instance.
+// Package mongoexport produces a JSON, CSV, Parquet or Avro export of data stored in a MongoDB instance.
 package mongoexport
 
 import (
@@ -32,6 +32,8 @@ import (
 const (
 	CSV                            = "csv"
 	JSON                           = "json"
+	PARQUET                        = "parquet"
+	AVRO                           = "avro"
 	watchProgressorUpdateFrequency = 8000
 )
 
@@ -172,8 +174,20 @@ func (exp *MongoExport) validateSettings() error {
 		// special error for an empty type value
 		return fmt.Errorf("--type cannot be empty")
 	}
-	if exp.OutputOpts.Type != CSV && exp.OutputOpts.Type != JSON {
-		return fmt.Errorf("invalid output type '%v', choose 'json' or 'csv'", exp.OutputOpts.Type)
+	switch exp.OutputOpts.Type {
+	case CSV, JSON:
+	case PARQUET, AVRO:
+		if exp.OutputOpts.SampleSize < 1 {
+			return fmt.Errorf("--sampleSize must be at least 1")
+		}
+		if exp.OutputOpts.CatchAllField == "" {
+			return fmt.Errorf("--catchAllField can not be empty")
+		}
+	default:
+		return fmt.Errorf("invalid output type '%v', choose 'json', 'csv', 'parquet' or 'avro'", exp.OutputOpts.Type)
+	}
+	if exp.OutputOpts.SchemaFile != "" && exp.OutputOpts.Type != PARQUET && exp.OutputOpts.Type != AVRO {
+		return fmt.Errorf("--schemaFile is only valid for Parquet and Avro output")
 	}
 
 	if exp.OutputOpts.JSONFormat != Canonical && exp.OutputOpts.JSONFormat != Relaxed {
@@ -508,6 +522,20 @@ func (exp *MongoExport) getExportOutput(out io.Writer) (ExportOutput, error) {
 
 		return NewCSVExportOutput(exportFields, exp.OutputOpts.NoHeaderLine, out), nil
 	}
+	if exp.OutputOpts.Type == PARQUET || exp.OutputOpts.Type == AVRO {
+		var schema *exportSchema
+		if exp.OutputOpts.SchemaFile != "" {
+			var err error
+			schema, err = readSchemaFile(exp.OutputOpts.SchemaFile, exp.OutputOpts.CatchAllField)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if exp.OutputOpts.Type == PARQUET {
+			return NewParquetExportOutput(schema, exp.OutputOpts.SampleSize, exp.OutputOpts.CatchAllField, out), nil
+		}
+		return NewAvroExportOutput(schema, exp.OutputOpts.SampleSize, exp.OutputOpts.CatchAllField, out), nil
+	}
 	return NewJSONExportOutput(exp.OutputOpts.JSONArray, exp.OutputOpts.Pretty, out, exp.OutputOpts.JSONFormat), nil
 }
 
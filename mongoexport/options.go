@@ -17,7 +17,7 @@ import (
 
 var Usage = `<options> <connection-string>
 
-Export data from MongoDB in CSV or JSON format.
+Export data from MongoDB in CSV, JSON, Parquet or Avro format.
 
 Connection strings must begin with mongodb:// or mongodb+srv://.
 
@@ -31,8 +31,8 @@ type OutputFormatOptions struct {
 	// FieldFile is a filename that refers to a list of fields to export, 1 per line.
 	FieldFile string `long:"fieldFile" value-name:"<filename>" description:"file with field names - 1 per line"`
 
-	// Type selects the type of output to export as (json or csv).
-	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"the output format, either json or csv"`
+	// Type selects the type of output to export as (json, csv, parquet or avro).
+	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"the output format, one of json, csv, parquet or avro"`
 
 	// Deprecated: allow legacy --csv option in place of --type=csv
 	CSVOutputType bool `long:"csv" hidden:"true"`
@@ -49,6 +49,15 @@ type OutputFormatOptions struct {
 	// NoHeaderLine, if set, will export CSV data without a list of field names at the first line.
 	NoHeaderLine bool `long:"noHeaderLine" description:"export CSV data without a list of field names at the first line"`
 
+	// SchemaFile is a JSON file giving the type of each field for Parquet and Avro output.
+	SchemaFile string `long:"schemaFile" value-name:"<filename>" description:"JSON file mapping field names to types for Parquet and Avro output, e.g. {\"name\": \"string\", \"price\": \"decimal(2)\", \"tags\": [\"string\"]}. Types are one of: binary, boolean, date, decimal(<scale>), double, int32, int64, json, objectId (written as its hex string), string. If not specified, the schema is inferred from the first documents"`
+
+	// SampleSize is the number of documents the Parquet or Avro schema is inferred from.
+	SampleSize int `long:"sampleSize" value-name:"<count>" default:"1000" description:"number of documents to infer the Parquet or Avro schema from (defaults to 1000)"`
+
+	// CatchAllField names the field that holds values that do not fit the Parquet or Avro schema.
+	CatchAllField string `long:"catchAllField" value-name:"<field>" default:"_extra" description:"field that holds, as extended JSON, the fields of each document that do not fit the Parquet or Avro schema (defaults to '_extra')"`
+
 	// JSONFormat specifies what extended JSON format to export (canonical or relaxed). Defaults to relaxed.
 	JSONFormat JSONFormat `long:"jsonFormat" value-name:"<type>" default:"relaxed" description:"the extended JSON format to output, either canonical or relaxed (defaults to 'relaxed')"`
 }
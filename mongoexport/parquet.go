@@ -0,0 +1,242 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"io"
+	"math/big"
+
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// parquetRowGroupSize is the number of documents written per row group.
+const parquetRowGroupSize = 50000
+
+// ParquetExportOutput is an implementation of ExportOutput that writes
+// documents to the output as a Parquet file.
+type ParquetExportOutput struct {
+	// NumExported maintains a running total of the number of documents written.
+	NumExported int64
+
+	sampler schemaSampler
+	out     io.Writer
+	writer  *goparquet.FileWriter
+
+	// rows is the number of rows added since the last row group was written
+	rows int
+}
+
+// NewParquetExportOutput returns a ParquetExportOutput that writes to the
+// given io.Writer. If schema is nil, the schema is inferred from the first
+// sampleSize documents.
+func NewParquetExportOutput(schema *exportSchema, sampleSize int, catchAll string, out io.Writer) *ParquetExportOutput {
+	return &ParquetExportOutput{
+		sampler: schemaSampler{schema: schema, sampleSize: sampleSize, catchAll: catchAll},
+		out:     out,
+	}
+}
+
+// WriteHeader is a no-op for Parquet export formats; the file header is
+// written along with the first row group.
+func (p *ParquetExportOutput) WriteHeader() error {
+	return nil
+}
+
+// ExportDocument converts a document to a row, writing a row group once
+// enough rows are pending.
+func (p *ParquetExportOutput) ExportDocument(document bson.D) error {
+	docs, err := p.sampler.add(document)
+	if err != nil {
+		return err
+	}
+	return p.writeDocuments(docs)
+}
+
+// WriteFooter writes the pending rows and the file footer.
+func (p *ParquetExportOutput) WriteFooter() error {
+	docs, err := p.sampler.finish()
+	if err != nil {
+		return err
+	}
+	if err = p.writeDocuments(docs); err != nil {
+		return err
+	}
+	if err = p.openWriter(); err != nil {
+		return err
+	}
+	// The writer starts the file along with its first row group, so a file
+	// without rows needs its leading magic number written here.
+	if p.NumExported == 0 {
+		if _, err = io.WriteString(p.out, "PAR1"); err != nil {
+			return err
+		}
+	}
+	return p.writer.Close()
+}
+
+// Flush is a no-op for Parquet export formats; rows are written in row
+// groups.
+func (p *ParquetExportOutput) Flush() error {
+	return nil
+}
+
+func (p *ParquetExportOutput) writeDocuments(docs []bson.D) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	if err := p.openWriter(); err != nil {
+		return err
+	}
+	schema := p.sampler.schema
+	for _, doc := range docs {
+		values, extra, err := schema.convertDocument(doc)
+		if err != nil {
+			return err
+		}
+		row := map[string]interface{}{}
+		for i, field := range schema.fields {
+			row[field.name] = parquetValue(field.typ, values[i])
+		}
+		if extra != nil {
+			row[schema.catchAll] = []byte(extra.(string))
+		}
+		if err = p.writer.AddData(row); err != nil {
+			return err
+		}
+		p.NumExported++
+		p.rows++
+		if p.rows >= parquetRowGroupSize {
+			if err = p.writer.FlushRowGroup(); err != nil {
+				return err
+			}
+			p.rows = 0
+		}
+	}
+	return nil
+}
+
+func (p *ParquetExportOutput) openWriter() error {
+	if p.writer != nil {
+		return nil
+	}
+	schema := p.sampler.schema
+	root := &parquetschema.ColumnDefinition{
+		SchemaElement: &parquet.SchemaElement{Name: "schema"},
+	}
+	for _, field := range schema.fields {
+		root.Children = append(root.Children, parquetColumn(field.name, field.typ))
+	}
+	root.Children = append(root.Children, parquetColumn(schema.catchAll, &fieldType{kind: kindJSON}))
+	p.writer = goparquet.NewFileWriter(p.out,
+		goparquet.WithCreator("mongo-tools"),
+		goparquet.WithCompressionCodec(parquet.CompressionCodec_SNAPPY))
+	return p.writer.SetSchemaDefinition(parquetschema.SchemaDefinitionFromColumnDefinition(root))
+}
+
+// parquetColumn returns the optional Parquet field for a field type, with
+// both its logical type and the converted type older readers understand.
+// Arrays use the standard three-level LIST layout. Parquet has no ObjectId
+// type, so ObjectIds are written as UTF8 strings of their hex digits and
+// read back as strings.
+func parquetColumn(name string, typ *fieldType) *parquetschema.ColumnDefinition {
+	element := &parquet.SchemaElement{
+		Name:           name,
+		RepetitionType: parquet.FieldRepetitionTypePtr(parquet.FieldRepetitionType_OPTIONAL),
+		LogicalType:    parquet.NewLogicalType(),
+	}
+	column := &parquetschema.ColumnDefinition{SchemaElement: element}
+	switch typ.kind {
+	case kindBoolean:
+		element.Type = parquet.TypePtr(parquet.Type_BOOLEAN)
+	case kindInt32:
+		element.Type = parquet.TypePtr(parquet.Type_INT32)
+	case kindInt64:
+		element.Type = parquet.TypePtr(parquet.Type_INT64)
+	case kindDouble:
+		element.Type = parquet.TypePtr(parquet.Type_DOUBLE)
+	case kindDecimal:
+		typeLength, scale, precision := int32(16), int32(typ.scale), int32(decimalPrecision)
+		element.Type = parquet.TypePtr(parquet.Type_FIXED_LEN_BYTE_ARRAY)
+		element.TypeLength = &typeLength
+		element.Scale = &scale
+		element.Precision = &precision
+		element.LogicalType.DECIMAL = &parquet.DecimalType{Scale: scale, Precision: precision}
+		element.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_DECIMAL)
+	case kindString, kindObjectID:
+		element.Type = parquet.TypePtr(parquet.Type_BYTE_ARRAY)
+		element.LogicalType.STRING = parquet.NewStringType()
+		element.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8)
+	case kindDate:
+		element.Type = parquet.TypePtr(parquet.Type_INT64)
+		element.LogicalType.TIMESTAMP = &parquet.TimestampType{
+			IsAdjustedToUTC: true,
+			Unit:            &parquet.TimeUnit{MILLIS: parquet.NewMilliSeconds()},
+		}
+		element.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_TIMESTAMP_MILLIS)
+	case kindBinary:
+		element.Type = parquet.TypePtr(parquet.Type_BYTE_ARRAY)
+	case kindDocument:
+		for _, field := range typ.fields {
+			column.Children = append(column.Children, parquetColumn(field.name, field.typ))
+		}
+	case kindArray:
+		element.LogicalType.LIST = parquet.NewListType()
+		element.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_LIST)
+		column.Children = []*parquetschema.ColumnDefinition{{
+			SchemaElement: &parquet.SchemaElement{
+				Name:           "list",
+				RepetitionType: parquet.FieldRepetitionTypePtr(parquet.FieldRepetitionType_REPEATED),
+			},
+			Children: []*parquetschema.ColumnDefinition{parquetColumn("element", typ.elem)},
+		}}
+	default:
+		element.Type = parquet.TypePtr(parquet.Type_BYTE_ARRAY)
+		element.LogicalType.JSON = parquet.NewJsonType()
+		element.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_JSON)
+	}
+	if *element.LogicalType == (parquet.LogicalType{}) {
+		element.LogicalType = nil
+	}
+	return column
+}
+
+// parquetValue returns a value from convertValue as the Go value its
+// Parquet field holds.
+func parquetValue(typ *fieldType, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch typ.kind {
+	case kindDecimal:
+		return twosComplement(v.(*big.Int), 16)
+	case kindJSON, kindString, kindObjectID:
+		return []byte(v.(string))
+	case kindDocument:
+		values := v.([]interface{})
+		group := map[string]interface{}{}
+		for i, field := range typ.fields {
+			group[field.name] = parquetValue(field.typ, values[i])
+		}
+		return group
+	case kindArray:
+		// The writer stores an empty repeated group as a single null
+		// element, so an empty list leaves the group out.
+		values := v.([]interface{})
+		if len(values) == 0 {
+			return map[string]interface{}{}
+		}
+		elements := make([]map[string]interface{}, len(values))
+		for i, value := range values {
+			elements[i] = map[string]interface{}{"element": parquetValue(typ.elem, value)}
+		}
+		return map[string]interface{}{"list": elements}
+	}
+	return v
+}
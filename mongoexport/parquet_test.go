@@ -0,0 +1,116 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"bytes"
+	"testing"
+
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWriteParquet(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a Parquet export output", t, func() {
+		out := &bytes.Buffer{}
+		id := primitive.NewObjectID()
+		price, _ := primitive.ParseDecimal128("-1.50")
+		docs := []bson.D{
+			{
+				{"_id", id},
+				{"price", price},
+				{"at", primitive.DateTime(1600000000123)},
+				{"data", primitive.Binary{Data: []byte{1, 2}}},
+				{"tags", bson.A{"a", nil}},
+				{"address", bson.D{{"city", "paris"}}},
+			},
+			{{"_id", id}, {"price", "free"}},
+		}
+
+		readBack := func(sampleSize int) (*goparquet.FileReader, []map[string]interface{}) {
+			exporter := NewParquetExportOutput(nil, sampleSize, "_extra", out)
+			So(exporter.WriteHeader(), ShouldBeNil)
+			for _, doc := range docs {
+				So(exporter.ExportDocument(doc), ShouldBeNil)
+			}
+			So(exporter.WriteFooter(), ShouldBeNil)
+			So(exporter.Flush(), ShouldBeNil)
+			So(exporter.NumExported, ShouldEqual, len(docs))
+
+			f, err := goparquet.NewFileReader(bytes.NewReader(out.Bytes()))
+			So(err, ShouldBeNil)
+			So(f.RowGroupCount(), ShouldEqual, 1)
+			var rows []map[string]interface{}
+			for range docs {
+				row, err := f.NextRow()
+				So(err, ShouldBeNil)
+				rows = append(rows, row)
+			}
+			return f, rows
+		}
+
+		Convey("BSON types should map to Parquet logical types", func() {
+			f, rows := readBack(1)
+			var paths []string
+			for _, column := range f.Columns() {
+				paths = append(paths, column.FlatName())
+			}
+			So(paths, ShouldResemble, []string{"_id", "price", "at", "data", "tags.list.element", "address.city", "_extra"})
+			elements := f.GetSchemaDefinition().RootColumn.Children
+			So(elements[0].SchemaElement.GetLogicalType().IsSetSTRING(), ShouldBeTrue)
+			So(elements[1].SchemaElement.GetLogicalType().DECIMAL, ShouldResemble, &parquet.DecimalType{Scale: 2, Precision: 38})
+			So(elements[1].SchemaElement.GetConvertedType(), ShouldEqual, parquet.ConvertedType_DECIMAL)
+			So(elements[2].SchemaElement.GetLogicalType().TIMESTAMP.GetUnit().IsSetMILLIS(), ShouldBeTrue)
+			So(elements[4].SchemaElement.GetLogicalType().IsSetLIST(), ShouldBeTrue)
+			So(elements[6].SchemaElement.GetLogicalType().IsSetJSON(), ShouldBeTrue)
+
+			So(rows[0]["_id"], ShouldResemble, []byte(id.Hex()))
+			So(rows[0]["price"], ShouldResemble, []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x6a,
+			})
+			So(rows[0]["at"], ShouldEqual, int64(1600000000123))
+			So(rows[0]["tags"], ShouldResemble, map[string]interface{}{"list": []map[string]interface{}{
+				{"element": []byte("a")},
+				{},
+			}})
+			So(rows[0]["_extra"], ShouldBeNil)
+		})
+
+		Convey("documents after the sample that do not fit should use the catch-all field", func() {
+			_, rows := readBack(1)
+			So(rows[1]["price"], ShouldBeNil)
+			So(rows[1]["_extra"], ShouldResemble, []byte(`{"price":"free"}`))
+		})
+
+		Convey("conflicting types within the sample should be widened", func() {
+			_, rows := readBack(2)
+			So(rows[0]["price"], ShouldResemble, []byte(`{"$numberDecimal":"-1.50"}`))
+			So(rows[1]["price"], ShouldResemble, []byte(`"free"`))
+			So(rows[1]["_extra"], ShouldBeNil)
+		})
+
+		Convey("empty arrays and empty exports should be read back", func() {
+			docs = []bson.D{{{"tags", bson.A{}}}}
+			_, rows := readBack(1)
+			So(rows[0]["tags"], ShouldResemble, map[string]interface{}{})
+
+			out.Reset()
+			exporter := NewParquetExportOutput(nil, 1, "_extra", out)
+			So(exporter.WriteFooter(), ShouldBeNil)
+			f, err := goparquet.NewFileReader(bytes.NewReader(out.Bytes()))
+			So(err, ShouldBeNil)
+			So(f.NumRows(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,535 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Parquet and Avro output need a fixed schema, which is either read from a
+// schema file or inferred from the first documents exported. Conflicting
+// types in the sample are widened, and values that still do not fit the
+// schema are written to a catch-all field as extended JSON.
+
+// fieldKind is the type of an exported field.
+type fieldKind int
+
+const (
+	// kindJSON holds any value as canonical extended JSON.
+	kindJSON fieldKind = iota
+	kindBoolean
+	kindInt32
+	kindInt64
+	kindDouble
+	kindDecimal
+	kindString
+	kindDate
+	kindObjectID
+	kindBinary
+	kindDocument
+	kindArray
+)
+
+// decimalPrecision is the number of digits of exported decimals, which is
+// the most a 16 byte Parquet decimal can hold.
+const decimalPrecision = 38
+
+// fieldType is the type of a field or array element.
+type fieldType struct {
+	kind fieldKind
+
+	// scale is the number of digits after the point of a decimal.
+	scale int
+
+	// fields are the fields of a document.
+	fields []*schemaField
+
+	// elem is the element type of an array.
+	elem *fieldType
+}
+
+// schemaField is a named field of a document.
+type schemaField struct {
+	name string
+	typ  *fieldType
+}
+
+// exportSchema is the schema of the exported documents. Every field is
+// nullable.
+type exportSchema struct {
+	fields []*schemaField
+
+	// catchAll is the name of the field that holds the values that do not
+	// fit the schema.
+	catchAll string
+
+	index map[string]int
+}
+
+func newExportSchema(fields []*schemaField, catchAll string) (*exportSchema, error) {
+	s := &exportSchema{fields: fields, catchAll: catchAll, index: map[string]int{}}
+	for i, field := range fields {
+		if field.name == catchAll {
+			return nil, fmt.Errorf("the schema has a field named '%v', which is the catch-all field", catchAll)
+		}
+		s.index[field.name] = i
+	}
+	return s, nil
+}
+
+// schemaTypeNames maps the type names of a schema file to their kinds.
+var schemaTypeNames = map[string]fieldKind{
+	"json":     kindJSON,
+	"boolean":  kindBoolean,
+	"int32":    kindInt32,
+	"int64":    kindInt64,
+	"double":   kindDouble,
+	"decimal":  kindDecimal,
+	"string":   kindString,
+	"date":     kindDate,
+	"objectId": kindObjectID,
+	"binary":   kindBinary,
+}
+
+// readSchemaFile reads a schema from a JSON document that maps each field
+// name to a type name, to a document of nested fields, or to an array
+// holding the type of its elements, e.g.
+// {"name": "string", "price": "decimal(2)", "tags": ["string"]}.
+func readSchemaFile(path, catchAll string) (*exportSchema, error) {
+	content, err := ioutil.ReadFile(util.ToUniversalPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema file: %v", err)
+	}
+	var doc bson.D
+	if err = bson.UnmarshalExtJSON(content, false, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing schema file as JSON: %v", err)
+	}
+	typ, err := parseSchemaType(doc, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema file: %v", err)
+	}
+	return newExportSchema(typ.fields, catchAll)
+}
+
+// parseSchemaType parses the type of the field at path in a schema file.
+func parseSchemaType(v interface{}, path string) (*fieldType, error) {
+	switch v := v.(type) {
+	case string:
+		name, scale := v, 0
+		if strings.HasPrefix(v, "decimal(") && strings.HasSuffix(v, ")") {
+			var err error
+			name = "decimal"
+			scale, err = strconv.Atoi(v[len("decimal(") : len(v)-1])
+			if err != nil || scale < 0 || scale > decimalPrecision {
+				return nil, fmt.Errorf("field '%v' has an invalid decimal scale in '%v'", path, v)
+			}
+		}
+		kind, ok := schemaTypeNames[name]
+		if !ok {
+			return nil, fmt.Errorf("field '%v' has unknown type '%v'", path, v)
+		}
+		return &fieldType{kind: kind, scale: scale}, nil
+	case bson.D:
+		if len(v) == 0 {
+			return nil, fmt.Errorf("document '%v' has no fields", path)
+		}
+		typ := &fieldType{kind: kindDocument}
+		for _, e := range v {
+			fieldPath := e.Key
+			if path != "" {
+				fieldPath = path + "." + e.Key
+			}
+			fieldTyp, err := parseSchemaType(e.Value, fieldPath)
+			if err != nil {
+				return nil, err
+			}
+			typ.fields = append(typ.fields, &schemaField{e.Key, fieldTyp})
+		}
+		return typ, nil
+	case bson.A:
+		if len(v) != 1 {
+			return nil, fmt.Errorf("array '%v' must hold exactly one element type", path)
+		}
+		elem, err := parseSchemaType(v[0], path+".$")
+		if err != nil {
+			return nil, err
+		}
+		return &fieldType{kind: kindArray, elem: elem}, nil
+	}
+	return nil, fmt.Errorf("field '%v' must be a type name, a document or an array", path)
+}
+
+// inferSchema returns a schema that fits every document of the sample.
+func inferSchema(sample []bson.D, catchAll string) (*exportSchema, error) {
+	root := &fieldType{kind: kindDocument}
+	for _, doc := range sample {
+		root = widenType(root, typeOf(doc))
+	}
+	var fields []*schemaField
+	for _, field := range root.fields {
+		if field.name == catchAll {
+			// leave it to the catch-all field
+			continue
+		}
+		fields = append(fields, &schemaField{field.name, finishType(field.typ)})
+	}
+	return newExportSchema(fields, catchAll)
+}
+
+// typeOf returns the type of a value, or nil for null.
+func typeOf(v interface{}) *fieldType {
+	switch v := v.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return nil
+	case bool:
+		return &fieldType{kind: kindBoolean}
+	case int32:
+		return &fieldType{kind: kindInt32}
+	case int64:
+		return &fieldType{kind: kindInt64}
+	case float64:
+		return &fieldType{kind: kindDouble}
+	case primitive.Decimal128:
+		_, exp, err := v.BigInt()
+		if err != nil || -exp > decimalPrecision {
+			return &fieldType{kind: kindJSON}
+		}
+		typ := &fieldType{kind: kindDecimal}
+		if exp < 0 {
+			typ.scale = -exp
+		}
+		return typ
+	case string:
+		return &fieldType{kind: kindString}
+	case primitive.DateTime:
+		return &fieldType{kind: kindDate}
+	case primitive.ObjectID:
+		return &fieldType{kind: kindObjectID}
+	case primitive.Binary:
+		return &fieldType{kind: kindBinary}
+	case bson.D:
+		typ := &fieldType{kind: kindDocument}
+		for _, e := range v {
+			typ = widenType(typ, &fieldType{kind: kindDocument, fields: []*schemaField{{e.Key, typeOf(e.Value)}}})
+		}
+		return typ
+	case bson.A:
+		typ := &fieldType{kind: kindArray}
+		for _, elem := range v {
+			typ.elem = widenType(typ.elem, typeOf(elem))
+		}
+		return typ
+	}
+	return &fieldType{kind: kindJSON}
+}
+
+// numericRank orders the numeric kinds by how much they can hold. Longs
+// and doubles widen to double, which holds most longs exactly; the ones it
+// cannot hold do not fit, so they keep their exact value in the catch-all
+// field.
+var numericRank = map[fieldKind]int{kindInt32: 1, kindInt64: 2, kindDouble: 3}
+
+// widenType returns a type that fits the values of both types.
+func widenType(a, b *fieldType) *fieldType {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.kind == b.kind {
+		switch a.kind {
+		case kindDecimal:
+			if b.scale > a.scale {
+				return b
+			}
+		case kindArray:
+			return &fieldType{kind: kindArray, elem: widenType(a.elem, b.elem)}
+		case kindDocument:
+			typ := &fieldType{kind: kindDocument}
+			typ.fields = append(typ.fields, a.fields...)
+		fields:
+			for _, bf := range b.fields {
+				for i, af := range typ.fields {
+					if af.name == bf.name {
+						typ.fields[i] = &schemaField{af.name, widenType(af.typ, bf.typ)}
+						continue fields
+					}
+				}
+				typ.fields = append(typ.fields, bf)
+			}
+			return typ
+		}
+		return a
+	}
+
+	rankA, numericA := numericRank[a.kind]
+	rankB, numericB := numericRank[b.kind]
+	switch {
+	case numericA && numericB:
+		if rankA > rankB {
+			return a
+		}
+		return b
+	case a.kind == kindDecimal && numericB && b.kind != kindDouble:
+		return a
+	case b.kind == kindDecimal && numericA && a.kind != kindDouble:
+		return b
+	}
+	return &fieldType{kind: kindJSON}
+}
+
+// finishType replaces the types nothing was learned about, such as fields
+// that were always null, with JSON.
+func finishType(typ *fieldType) *fieldType {
+	switch {
+	case typ == nil:
+		return &fieldType{kind: kindJSON}
+	case typ.kind == kindDocument:
+		if len(typ.fields) == 0 {
+			return &fieldType{kind: kindJSON}
+		}
+		finished := &fieldType{kind: kindDocument}
+		for _, field := range typ.fields {
+			finished.fields = append(finished.fields, &schemaField{field.name, finishType(field.typ)})
+		}
+		return finished
+	case typ.kind == kindArray:
+		return &fieldType{kind: kindArray, elem: finishType(typ.elem)}
+	}
+	return typ
+}
+
+// convertDocument returns the values of the schema's fields in a document,
+// as convertValue returns them, and the extended JSON of the document's
+// other fields for the catch-all field, or nil if there are none.
+func (s *exportSchema) convertDocument(doc bson.D) ([]interface{}, interface{}, error) {
+	values := make([]interface{}, len(s.fields))
+	var extra bson.D
+	for _, e := range doc {
+		if i, ok := s.index[e.Key]; ok {
+			if v, ok := convertValue(s.fields[i].typ, e.Value); ok {
+				values[i] = v
+				continue
+			}
+		}
+		extra = append(extra, e)
+	}
+	if extra == nil {
+		return values, nil, nil
+	}
+	extraJSON, err := bson.MarshalExtJSON(extra, true, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error converting fields that do not fit the schema to JSON: %v", err)
+	}
+	return values, string(extraJSON), nil
+}
+
+// convertValue returns a value as the Go type that stands for its field
+// type: bool, int32, int64 or float64 for the numeric kinds, the unscaled
+// *big.Int of a decimal, int64 milliseconds since the epoch for a date, the
+// hex string of an ObjectId, which Parquet and Avro have no type for, the []byte of binary data, a string for JSON
+// and strings, and a []interface{} of the field values of a document or the
+// elements of an array. It returns false if the value does not fit the type.
+func convertValue(typ *fieldType, v interface{}) (interface{}, bool) {
+	switch v.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return nil, true
+	}
+	switch typ.kind {
+	case kindJSON:
+		return extJSONValue(v)
+	case kindBoolean:
+		b, ok := v.(bool)
+		return b, ok
+	case kindInt32:
+		n, ok := v.(int32)
+		return n, ok
+	case kindInt64:
+		switch n := v.(type) {
+		case int32:
+			return int64(n), true
+		case int64:
+			return n, true
+		}
+	case kindDouble:
+		switch n := v.(type) {
+		case int32:
+			return float64(n), true
+		case int64:
+			// longs a double cannot hold exactly go to the catch-all field
+			f := float64(n)
+			return f, f >= -(1<<63) && f < 1<<63 && int64(f) == n
+		case float64:
+			return n, true
+		}
+	case kindDecimal:
+		return decimalValue(v, typ.scale)
+	case kindString:
+		s, ok := v.(string)
+		return s, ok
+	case kindDate:
+		d, ok := v.(primitive.DateTime)
+		return int64(d), ok
+	case kindObjectID:
+		id, ok := v.(primitive.ObjectID)
+		return id.Hex(), ok
+	case kindBinary:
+		b, ok := v.(primitive.Binary)
+		return b.Data, ok
+	case kindDocument:
+		doc, ok := v.(bson.D)
+		if !ok {
+			return nil, false
+		}
+		values := make([]interface{}, len(typ.fields))
+		set := make([]bool, len(typ.fields))
+	elements:
+		for _, e := range doc {
+			for i, field := range typ.fields {
+				if field.name == e.Key && !set[i] {
+					if values[i], ok = convertValue(field.typ, e.Value); !ok {
+						return nil, false
+					}
+					set[i] = true
+					continue elements
+				}
+			}
+			// not in the schema
+			return nil, false
+		}
+		return values, true
+	case kindArray:
+		array, ok := v.(bson.A)
+		if !ok {
+			return nil, false
+		}
+		values := make([]interface{}, len(array))
+		for i, elem := range array {
+			if values[i], ok = convertValue(typ.elem, elem); !ok {
+				return nil, false
+			}
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// extJSONValue returns a value as canonical extended JSON.
+func extJSONValue(v interface{}) (interface{}, bool) {
+	wrapped, err := bson.MarshalExtJSON(bson.D{{"v", v}}, true, false)
+	if err != nil {
+		return nil, false
+	}
+	// strip {"v": and }
+	return string(wrapped[len(`{"v":`) : len(wrapped)-1]), true
+}
+
+// decimalValue returns the unscaled value of an integer or Decimal128 at
+// the given scale, or false if it would lose digits.
+func decimalValue(v interface{}, scale int) (interface{}, bool) {
+	var unscaled *big.Int
+	exp := 0
+	switch n := v.(type) {
+	case int32:
+		unscaled = big.NewInt(int64(n))
+	case int64:
+		unscaled = big.NewInt(n)
+	case primitive.Decimal128:
+		var err error
+		if unscaled, exp, err = n.BigInt(); err != nil {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	ten := big.NewInt(10)
+	if unscaled.Sign() != 0 {
+		shift := exp + scale
+		switch {
+		case shift > decimalPrecision:
+			return nil, false
+		case shift > 0:
+			unscaled.Mul(unscaled, new(big.Int).Exp(ten, big.NewInt(int64(shift)), nil))
+		case shift < 0:
+			if -shift > 2*decimalPrecision {
+				return nil, false
+			}
+			var rem big.Int
+			unscaled.QuoRem(unscaled, new(big.Int).Exp(ten, big.NewInt(int64(-shift)), nil), &rem)
+			if rem.Sign() != 0 {
+				return nil, false
+			}
+		}
+	}
+	limit := new(big.Int).Exp(ten, big.NewInt(decimalPrecision), nil)
+	if new(big.Int).Abs(unscaled).Cmp(limit) >= 0 {
+		return nil, false
+	}
+	return unscaled, true
+}
+
+// twosComplement returns the big-endian two's complement of an integer in
+// size bytes, or in as few bytes as it takes if size is 0.
+func twosComplement(n *big.Int, size int) []byte {
+	if size == 0 {
+		size = n.BitLen()/8 + 1
+	}
+	v := n
+	if n.Sign() < 0 {
+		v = new(big.Int).Add(n, new(big.Int).Lsh(big.NewInt(1), uint(8*size)))
+	}
+	b := v.Bytes()
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
+
+// schemaSampler buffers the first documents exported until it has enough of
+// them to infer a schema, unless the schema was given.
+type schemaSampler struct {
+	schema     *exportSchema
+	sample     []bson.D
+	sampleSize int
+	catchAll   string
+}
+
+// add returns the documents that are ready to be written: none while
+// sampling, the whole sample once the schema is inferred, and then each
+// document as it comes.
+func (s *schemaSampler) add(doc bson.D) ([]bson.D, error) {
+	if s.schema != nil {
+		return []bson.D{doc}, nil
+	}
+	s.sample = append(s.sample, doc)
+	if len(s.sample) < s.sampleSize {
+		return nil, nil
+	}
+	return s.finish()
+}
+
+// finish infers the schema if it is not yet known and returns the buffered
+// documents.
+func (s *schemaSampler) finish() ([]bson.D, error) {
+	if s.schema == nil {
+		var err error
+		if s.schema, err = inferSchema(s.sample, s.catchAll); err != nil {
+			return nil, err
+		}
+	}
+	sample := s.sample
+	s.sample = nil
+	return sample, nil
+}
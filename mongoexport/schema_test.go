@@ -0,0 +1,166 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestInferSchema(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a sample of documents", t, func() {
+		price, _ := primitive.ParseDecimal128("1.5")
+		cents, _ := primitive.ParseDecimal128("0.25")
+		sample := []bson.D{
+			{{"_id", primitive.NewObjectID()}, {"n", int32(1)}, {"price", price}, {"tags", bson.A{"a"}}},
+			{{"_id", primitive.NewObjectID()}, {"n", int64(2)}, {"price", int32(3)}, {"tags", bson.A{}}},
+			{{"_id", primitive.NewObjectID()}, {"n", 2.5}, {"price", cents}, {"mixed", "x"}, {"empty", nil}},
+			{{"_id", primitive.NewObjectID()}, {"mixed", int32(1)}, {"address", bson.D{{"city", "paris"}}}},
+			{{"_id", primitive.NewObjectID()}, {"address", bson.D{{"zip", int32(75001)}}}},
+		}
+		schema, err := inferSchema(sample, "_extra")
+		So(err, ShouldBeNil)
+
+		Convey("fields should be in order of first appearance", func() {
+			var names []string
+			for _, field := range schema.fields {
+				names = append(names, field.name)
+			}
+			So(names, ShouldResemble, []string{"_id", "n", "price", "tags", "mixed", "empty", "address"})
+		})
+
+		Convey("conflicting types should be widened", func() {
+			So(schema.fields[0].typ.kind, ShouldEqual, kindObjectID)
+			So(schema.fields[1].typ.kind, ShouldEqual, kindDouble)
+			So(schema.fields[2].typ.kind, ShouldEqual, kindDecimal)
+			So(schema.fields[2].typ.scale, ShouldEqual, 2)
+			So(schema.fields[3].typ.kind, ShouldEqual, kindArray)
+			So(schema.fields[3].typ.elem.kind, ShouldEqual, kindString)
+			So(schema.fields[4].typ.kind, ShouldEqual, kindJSON)
+			So(schema.fields[5].typ.kind, ShouldEqual, kindJSON)
+			address := schema.fields[6].typ
+			So(address.kind, ShouldEqual, kindDocument)
+			So(len(address.fields), ShouldEqual, 2)
+			So(address.fields[1].typ.kind, ShouldEqual, kindInt32)
+		})
+
+		Convey("values that do not fit should go to the catch-all field", func() {
+			values, extra, err := schema.convertDocument(bson.D{
+				{"n", int32(4)},
+				{"price", 1.25},
+				{"address", bson.D{{"city", "rome"}, {"country", "it"}}},
+				{"other", true},
+			})
+			So(err, ShouldBeNil)
+			So(values[1], ShouldEqual, 4.0)
+			So(values[2], ShouldBeNil)
+			So(values[6], ShouldBeNil)
+			So(extra, ShouldEqual, `{"price":{"$numberDouble":"1.25"},"address":{"city":"rome","country":"it"},"other":true}`)
+		})
+
+		Convey("longs a double cannot hold exactly should go to the catch-all field", func() {
+			values, extra, err := schema.convertDocument(bson.D{{"n", int64(1<<53 + 1)}})
+			So(err, ShouldBeNil)
+			So(values[1], ShouldBeNil)
+			So(extra, ShouldEqual, `{"n":{"$numberLong":"9007199254740993"}}`)
+
+			values, extra, err = schema.convertDocument(bson.D{{"n", int64(1 << 60)}})
+			So(err, ShouldBeNil)
+			So(values[1], ShouldEqual, float64(1<<60))
+			So(extra, ShouldBeNil)
+		})
+
+		Convey("values that fit should be converted", func() {
+			id := primitive.NewObjectID()
+			values, extra, err := schema.convertDocument(bson.D{
+				{"_id", id},
+				{"price", int32(7)},
+				{"mixed", bson.D{{"a", int32(1)}}},
+				{"address", bson.D{{"zip", int32(1)}}},
+			})
+			So(err, ShouldBeNil)
+			So(extra, ShouldBeNil)
+			So(values[0], ShouldEqual, id.Hex())
+			So(values[2].(*big.Int).Int64(), ShouldEqual, 700)
+			So(values[4], ShouldEqual, `{"a":{"$numberInt":"1"}}`)
+			So(values[6], ShouldResemble, []interface{}{nil, int32(1)})
+		})
+	})
+}
+
+func TestDecimalValue(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Decimals should be rescaled only when no digits are lost", t, func() {
+		d, _ := primitive.ParseDecimal128("12.3400")
+		v, ok := decimalValue(d, 2)
+		So(ok, ShouldBeTrue)
+		So(v.(*big.Int).Int64(), ShouldEqual, 1234)
+
+		d, _ = primitive.ParseDecimal128("-0.001")
+		_, ok = decimalValue(d, 2)
+		So(ok, ShouldBeFalse)
+
+		d, _ = primitive.ParseDecimal128("1E+40")
+		_, ok = decimalValue(d, 0)
+		So(ok, ShouldBeFalse)
+
+		d, _ = primitive.ParseDecimal128("NaN")
+		_, ok = decimalValue(d, 0)
+		So(ok, ShouldBeFalse)
+
+		So(twosComplement(big.NewInt(-2), 0), ShouldResemble, []byte{0xfe})
+		So(twosComplement(big.NewInt(255), 0), ShouldResemble, []byte{0x00, 0xff})
+		So(twosComplement(big.NewInt(-1), 4), ShouldResemble, []byte{0xff, 0xff, 0xff, 0xff})
+	})
+}
+
+func TestReadSchemaFile(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a schema file", t, func() {
+		dir, err := ioutil.TempDir("", "mongoexport_schema")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "schema.json")
+
+		Convey("fields, documents and arrays should be parsed in order", func() {
+			content := `{"name": "string", "price": "decimal(2)", "tags": ["string"], "address": {"zip": "int32"}}`
+			So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+			schema, err := readSchemaFile(path, "_extra")
+			So(err, ShouldBeNil)
+			So(len(schema.fields), ShouldEqual, 4)
+			So(schema.fields[1].typ, ShouldResemble, &fieldType{kind: kindDecimal, scale: 2})
+			So(schema.fields[2].typ.elem.kind, ShouldEqual, kindString)
+			So(schema.fields[3].typ.fields[0].name, ShouldEqual, "zip")
+		})
+
+		Convey("unknown types and the catch-all field should be errors", func() {
+			So(ioutil.WriteFile(path, []byte(`{"a": "uuid"}`), 0644), ShouldBeNil)
+			_, err := readSchemaFile(path, "_extra")
+			So(err, ShouldNotBeNil)
+
+			So(ioutil.WriteFile(path, []byte(`{"a": ["string", "int32"]}`), 0644), ShouldBeNil)
+			_, err = readSchemaFile(path, "_extra")
+			So(err, ShouldNotBeNil)
+
+			So(ioutil.WriteFile(path, []byte(`{"_extra": "string"}`), 0644), ShouldBeNil)
+			_, err = readSchemaFile(path, "_extra")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,414 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mongodb/mongo-tools-common/bsonutil"
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Writes that change events are applied as.
+const (
+	cdcInsert  = "insert"
+	cdcReplace = "replace"
+	cdcUpdate  = "update"
+	cdcDelete  = "delete"
+)
+
+// changeEvent is the write that a change event describes, in the change
+// stream or Debezium format, applied with --mode=cdc.
+type changeEvent struct {
+	operation string
+	// key selects the document the event applies to
+	key bson.D
+	// document is the inserted or replacement document
+	document bson.D
+	// update holds the $set and $unset of an update
+	update bson.D
+	// truncate holds the $push of an update that truncates arrays, which is
+	// applied before the rest of the update
+	truncate bson.D
+}
+
+// parseChangeEvent returns the write that a change event describes, or nil
+// if the event does not change documents (e.g. a drop or invalidate event).
+// Events with an operationType field are read as change stream events and
+// events with an op field, possibly in a payload envelope, as Debezium events.
+func (imp *MongoImport) parseChangeEvent(event bson.D) (*changeEvent, error) {
+	if payload, ok := eventDocument(event, "payload"); ok {
+		event = payload
+	}
+	if _, err := bsonutil.FindValueByKey("operationType", &event); err == nil {
+		return imp.parseChangeStreamEvent(event)
+	}
+	if _, err := bsonutil.FindValueByKey("op", &event); err == nil {
+		return imp.parseDebeziumEvent(event)
+	}
+	return nil, fmt.Errorf("unrecognized change event: it has neither an operationType nor an op field")
+}
+
+// parseChangeStreamEvent reads an event in the format of MongoDB change
+// streams.
+func (imp *MongoImport) parseChangeStreamEvent(event bson.D) (*changeEvent, error) {
+	operationType, err := bsonutil.FindStringValueByKey("operationType", &event)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operationType: %v", err)
+	}
+	fullDocument, hasFullDocument := eventDocument(event, "fullDocument")
+	key, ok := eventDocument(event, "documentKey")
+	if !ok && hasFullDocument {
+		key = constructUpsertDocument(imp.upsertFields, fullDocument)
+	}
+
+	switch operationType {
+	case cdcInsert, cdcReplace, cdcUpdate, cdcDelete:
+	default:
+		log.Logvf(log.Info, "skipping %v change event", operationType)
+		return nil, nil
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%v change event has no documentKey", operationType)
+	}
+
+	change := &changeEvent{operation: operationType, key: key}
+	switch operationType {
+	case cdcInsert, cdcReplace:
+		if !hasFullDocument {
+			return nil, fmt.Errorf("%v change event has no fullDocument", operationType)
+		}
+		change.document = fullDocument
+	case cdcUpdate:
+		if description, ok := eventDocument(event, "updateDescription"); ok {
+			return change, change.setUpdateDescription(description)
+		}
+		// without an update description, the looked up document replaces the
+		// existing one
+		if !hasFullDocument {
+			return nil, fmt.Errorf("update change event has neither an updateDescription nor a fullDocument")
+		}
+		change.operation = cdcReplace
+		change.document = fullDocument
+	}
+	return change, nil
+}
+
+// parseDebeziumEvent reads an event in the format of Debezium connectors.
+// The before and after images and the update fields of the MongoDB connector
+// may be extended JSON strings. Documents are selected by --upsertFields in
+// the before or after image, or by the filter of the MongoDB connector.
+func (imp *MongoImport) parseDebeziumEvent(event bson.D) (*changeEvent, error) {
+	op, err := bsonutil.FindStringValueByKey("op", &event)
+	if err != nil {
+		return nil, fmt.Errorf("invalid op: %v", err)
+	}
+	after, hasAfter, err := eventJSONDocument(event, "after")
+	if err != nil {
+		return nil, err
+	}
+	before, _, err := eventJSONDocument(event, "before")
+	if err != nil {
+		return nil, err
+	}
+	key, _, err := eventJSONDocument(event, "filter")
+	if err != nil {
+		return nil, err
+	}
+	if key == nil && after != nil {
+		key = constructUpsertDocument(imp.upsertFields, after)
+	}
+	if key == nil && before != nil {
+		key = constructUpsertDocument(imp.upsertFields, before)
+	}
+
+	change := &changeEvent{key: key}
+	switch op {
+	case "c":
+		change.operation = cdcInsert
+	case "r":
+		change.operation = cdcReplace
+	case "u":
+		change.operation = cdcReplace
+		if hasAfter {
+			break
+		}
+		change.operation = cdcUpdate
+		if description, ok := eventDocument(event, "updateDescription"); ok {
+			if err = change.setUpdateDescription(description); err != nil {
+				return nil, err
+			}
+			break
+		}
+		patch, hasPatch, err := eventJSONDocument(event, "patch")
+		if err != nil {
+			return nil, err
+		}
+		if !hasPatch {
+			return nil, fmt.Errorf("update event has neither an after image nor an update description")
+		}
+		if len(patch) == 0 || !strings.HasPrefix(patch[0].Key, "$") {
+			// the patch is a replacement document
+			change.operation = cdcReplace
+			after = patch
+			break
+		}
+		for _, operator := range patch {
+			if operator.Key != "$v" {
+				change.update = append(change.update, operator)
+			}
+		}
+	case "d":
+		change.operation = cdcDelete
+	default:
+		log.Logvf(log.Info, "skipping '%v' change event", op)
+		return nil, nil
+	}
+
+	if change.operation == cdcInsert || change.operation == cdcReplace {
+		if after == nil {
+			return nil, fmt.Errorf("'%v' change event has no after image", op)
+		}
+		change.document = after
+		if change.key == nil {
+			change.key = constructUpsertDocument(imp.upsertFields, after)
+		}
+	}
+	if len(change.key) == 0 {
+		return nil, fmt.Errorf("could not find %v in '%v' change event", strings.Join(imp.upsertFields, ","), op)
+	}
+	return change, nil
+}
+
+// setUpdateDescription sets the update of an event from its updatedFields,
+// removedFields and truncatedArrays.
+func (change *changeEvent) setUpdateDescription(description bson.D) error {
+	updatedFields, _, err := eventJSONDocument(description, "updatedFields")
+	if err != nil {
+		return err
+	}
+	if len(updatedFields) > 0 {
+		change.update = append(change.update, bson.E{Key: "$set", Value: updatedFields})
+	}
+
+	removedFields, _ := bsonutil.FindValueByKey("removedFields", &description)
+	if removed, ok := asArray(removedFields); ok && len(removed) > 0 {
+		unset := bson.D{}
+		for _, field := range removed {
+			name, ok := field.(string)
+			if !ok {
+				return fmt.Errorf("invalid removedFields: %v is not a string", field)
+			}
+			unset = append(unset, bson.E{Key: name, Value: ""})
+		}
+		change.update = append(change.update, bson.E{Key: "$unset", Value: unset})
+	}
+
+	truncatedArrays, _ := bsonutil.FindValueByKey("truncatedArrays", &description)
+	if truncated, ok := asArray(truncatedArrays); ok && len(truncated) > 0 {
+		push := bson.D{}
+		for _, array := range truncated {
+			doc, ok := asDocument(array)
+			if !ok {
+				return fmt.Errorf("invalid truncatedArrays: %v is not a document", array)
+			}
+			field, err := bsonutil.FindStringValueByKey("field", &doc)
+			if err != nil {
+				return fmt.Errorf("invalid truncatedArrays: %v", err)
+			}
+			newSize, _ := bsonutil.FindValueByKey("newSize", &doc)
+			push = append(push, bson.E{Key: field, Value: bson.D{
+				{Key: "$each", Value: bson.A{}},
+				{Key: "$slice", Value: newSize},
+			}})
+		}
+		change.truncate = bson.D{{Key: "$push", Value: push}}
+	}
+	return nil
+}
+
+// routedChange is a change event parsed by routeChangeEvents, or the error
+// parsing it.
+type routedChange struct {
+	event  bson.D
+	change *changeEvent
+	err    error
+}
+
+// ingestChangeEvents applies the change events read with the given number
+// of insertion workers.
+func (imp *MongoImport) ingestChangeEvents(readDocs chan bson.D, numWorkers int) error {
+	workerChanges := make([]chan routedChange, numWorkers)
+	for i := range workerChanges {
+		workerChanges[i] = make(chan routedChange, workerBufferSize)
+	}
+	go imp.routeChangeEvents(readDocs, workerChanges)
+	return imp.runWorkers(numWorkers, func(i int) error {
+		return imp.runChangeEventWorker(workerChanges[i])
+	})
+}
+
+// routeChangeEvents parses each change event read and sends it to one of the
+// workers, chosen by its document key, so that the events of each document
+// are applied in order. Events that cannot be parsed go to the first worker,
+// which reports the error, and events that do not change documents are
+// skipped.
+func (imp *MongoImport) routeChangeEvents(readDocs chan bson.D, workerChanges []chan routedChange) {
+	defer func() {
+		for _, changes := range workerChanges {
+			close(changes)
+		}
+	}()
+	for event := range readDocs {
+		change, err := imp.parseChangeEvent(event)
+		if err == nil && change == nil {
+			continue
+		}
+		worker := 0
+		if err == nil {
+			if key, err := bson.Marshal(change.key); err == nil {
+				hash := fnv.New32a()
+				hash.Write(key)
+				worker = int(hash.Sum32() % uint32(len(workerChanges)))
+			}
+		}
+		select {
+		case workerChanges[worker] <- routedChange{event: event, change: change, err: err}:
+		case <-imp.Dying():
+			return
+		}
+	}
+}
+
+// runChangeEventWorker applies the change events routed to a worker.
+func (imp *MongoImport) runChangeEventWorker(changes chan routedChange) error {
+	inserter, collection, err := imp.newInserter()
+	if err != nil {
+		return err
+	}
+
+readLoop:
+	for {
+		select {
+		case routed, alive := <-changes:
+			if !alive {
+				break readLoop
+			}
+			err = imp.importChangeEvent(inserter, collection, routed)
+			if db.FilterError(imp.IngestOptions.StopOnError, err) != nil {
+				return err
+			}
+		case <-imp.Dying():
+			return nil
+		}
+	}
+	return imp.flushInserter(inserter)
+}
+
+// importChangeEvent applies a change event and updates the success and
+// failure counts. Events that cannot be parsed or whose update fails are
+// saved to the rejects file, as are the write errors of the inserter.
+func (imp *MongoImport) importChangeEvent(inserter *db.BufferedBulkInserter, collection *mongo.Collection, routed routedChange) error {
+	err := routed.err
+	if err == nil {
+		var result *mongo.BulkWriteResult
+		result, err = imp.applyChangeEvent(inserter, collection, routed.change)
+		imp.updateCounts(result, err)
+		if rejectErr := imp.rejects.rejectWrites(err); rejectErr != nil {
+			return rejectErr
+		}
+		if _, ok := err.(mongo.BulkWriteException); ok || err == nil {
+			return err
+		}
+	}
+	atomic.AddUint64(&imp.failureCount, 1)
+	if rejectErr := imp.rejects.rejectDocument(routed.event, err); rejectErr != nil {
+		return rejectErr
+	}
+	return err
+}
+
+// applyChangeEvent adds the writes of a change event to the inserter. Inserts
+// are applied as upserts, so that events delivered more than once do not
+// fail. Updates must match an existing document, so the writes before them
+// are flushed and they are applied one at a time without upserting.
+func (imp *MongoImport) applyChangeEvent(inserter *db.BufferedBulkInserter, collection *mongo.Collection, change *changeEvent) (*mongo.BulkWriteResult, error) {
+	switch change.operation {
+	case cdcInsert, cdcReplace:
+		return inserter.Replace(change.key, change.document)
+	case cdcDelete:
+		return inserter.Delete(change.key, nil)
+	}
+	if len(change.truncate) == 0 && len(change.update) == 0 {
+		log.Logvf(log.Info, "skipping update change event without changes")
+		return nil, nil
+	}
+
+	result, err := inserter.Flush()
+	if err != nil {
+		return result, err
+	}
+	if result == nil {
+		result = &mongo.BulkWriteResult{}
+	}
+	modified := false
+	for _, update := range []bson.D{change.truncate, change.update} {
+		if len(update) == 0 {
+			continue
+		}
+		updateResult, err := collection.UpdateOne(context.Background(), change.key, update)
+		if err != nil {
+			return result, err
+		}
+		if updateResult.MatchedCount == 0 {
+			return result, fmt.Errorf("%v change event matches no document with key %v", change.operation, change.key)
+		}
+		modified = modified || updateResult.ModifiedCount > 0
+	}
+	if modified {
+		result.ModifiedCount++
+	}
+	return result, nil
+}
+
+// eventDocument returns the document in a field of an event.
+func eventDocument(event bson.D, key string) (bson.D, bool) {
+	value, err := bsonutil.FindValueByKey(key, &event)
+	if err != nil {
+		return nil, false
+	}
+	return asDocument(value)
+}
+
+// eventJSONDocument returns the document in a field of an event, which may be
+// given as an extended JSON string. It returns false if the field is missing
+// or null.
+func eventJSONDocument(event bson.D, key string) (bson.D, bool, error) {
+	value, err := bsonutil.FindValueByKey(key, &event)
+	if err != nil || value == nil {
+		return nil, false, nil
+	}
+	if str, ok := value.(string); ok {
+		var doc bson.D
+		if err = bson.UnmarshalExtJSON([]byte(str), false, &doc); err != nil {
+			return nil, false, fmt.Errorf("invalid %v: %v", key, err)
+		}
+		return doc, true, nil
+	}
+	doc, ok := asDocument(value)
+	if !ok {
+		return nil, false, fmt.Errorf("invalid %v: %v is not a document", key, value)
+	}
+	return doc, true, nil
+}
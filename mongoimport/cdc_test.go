@@ -0,0 +1,258 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// testChangeEvent parses a change event given as extended JSON.
+func testChangeEvent(imp *MongoImport, event string) (*changeEvent, error) {
+	var doc bson.D
+	So(bson.UnmarshalExtJSON([]byte(event), false, &doc), ShouldBeNil)
+	return imp.parseChangeEvent(doc)
+}
+
+func TestParseChangeEvent(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With change stream events", t, func() {
+		imp := NewMockMongoImport()
+		imp.upsertFields = []string{"_id"}
+
+		Convey("inserts and replacements should replace the document by its key", func() {
+			change, err := testChangeEvent(imp, `{"_id": {"_data": "1"}, "operationType": "insert",
+				"documentKey": {"_id": 1}, "fullDocument": {"_id": 1, "a": "x"}}`)
+			So(err, ShouldBeNil)
+			So(change.operation, ShouldEqual, cdcInsert)
+			So(change.key, ShouldResemble, bson.D{{"_id", int32(1)}})
+			So(change.document, ShouldResemble, bson.D{{"_id", int32(1)}, {"a", "x"}})
+
+			change, err = testChangeEvent(imp, `{"operationType": "replace",
+				"documentKey": {"_id": 1}, "fullDocument": {"_id": 1, "a": "y"}}`)
+			So(err, ShouldBeNil)
+			So(change.operation, ShouldEqual, cdcReplace)
+		})
+
+		Convey("updates should be built from the update description", func() {
+			change, err := testChangeEvent(imp, `{"operationType": "update", "documentKey": {"_id": 1},
+				"updateDescription": {"updatedFields": {"a": 2, "b.c": "x"}, "removedFields": ["d"],
+					"truncatedArrays": [{"field": "arr", "newSize": 2}]}}`)
+			So(err, ShouldBeNil)
+			So(change.operation, ShouldEqual, cdcUpdate)
+			So(change.update, ShouldResemble, bson.D{
+				{"$set", bson.D{{"a", int32(2)}, {"b.c", "x"}}},
+				{"$unset", bson.D{{"d", ""}}},
+			})
+			So(change.truncate, ShouldResemble, bson.D{
+				{"$push", bson.D{{"arr", bson.D{{"$each", bson.A{}}, {"$slice", int32(2)}}}}},
+			})
+		})
+
+		Convey("updates with only a looked up document should replace it", func() {
+			change, err := testChangeEvent(imp, `{"operationType": "update", "documentKey": {"_id": 1},
+				"fullDocument": {"_id": 1, "a": 3}}`)
+			So(err, ShouldBeNil)
+			So(change.operation, ShouldEqual, cdcReplace)
+		})
+
+		Convey("deletes should select the document by its key", func() {
+			change, err := testChangeEvent(imp, `{"operationType": "delete", "documentKey": {"_id": 1}}`)
+			So(err, ShouldBeNil)
+			So(change.operation, ShouldEqual, cdcDelete)
+			So(change.key, ShouldResemble, bson.D{{"_id", int32(1)}})
+		})
+
+		Convey("events that do not change documents should be skipped", func() {
+			change, err := testChangeEvent(imp, `{"operationType": "drop", "ns": {"db": "a", "coll": "b"}}`)
+			So(err, ShouldBeNil)
+			So(change, ShouldBeNil)
+		})
+
+		Convey("incomplete events should be errors", func() {
+			_, err := testChangeEvent(imp, `{"operationType": "delete"}`)
+			So(err, ShouldNotBeNil)
+			_, err = testChangeEvent(imp, `{"operationType": "insert", "documentKey": {"_id": 1}}`)
+			So(err, ShouldNotBeNil)
+			_, err = testChangeEvent(imp, `{"a": 1}`)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("With Debezium events", t, func() {
+		imp := NewMockMongoImport()
+		imp.upsertFields = []string{"id"}
+
+		Convey("relational row changes should be keyed by --upsertFields", func() {
+			change, err := testChangeEvent(imp, `{"schema": {}, "payload": {"op": "c", "before": null,
+				"after": {"id": 7, "name": "x"}, "source": {"table": "t"}}}`)
+			So(err, ShouldBeNil)
+			So(change.operation, ShouldEqual, cdcInsert)
+			So(change.key, ShouldResemble, bson.D{{"id", int32(7)}})
+
+			change, err = testChangeEvent(imp, `{"op": "u", "before": {"id": 7, "name": "x"}, "after": {"id": 7, "name": "y"}}`)
+			So(err, ShouldBeNil)
+			So(change.operation, ShouldEqual, cdcReplace)
+			So(change.document, ShouldResemble, bson.D{{"id", int32(7)}, {"name", "y"}})
+
+			change, err = testChangeEvent(imp, `{"op": "d", "before": {"id": 7, "name": "y"}, "after": null}`)
+			So(err, ShouldBeNil)
+			So(change.operation, ShouldEqual, cdcDelete)
+			So(change.key, ShouldResemble, bson.D{{"id", int32(7)}})
+		})
+
+		Convey("MongoDB connector events should have their JSON strings parsed", func() {
+			imp.upsertFields = []string{"_id"}
+			change, err := testChangeEvent(imp, `{"op": "r", "after": "{\"_id\": {\"$numberLong\": \"5\"}, \"a\": 1}"}`)
+			So(err, ShouldBeNil)
+			So(change.operation, ShouldEqual, cdcReplace)
+			So(change.key, ShouldResemble, bson.D{{"_id", int64(5)}})
+
+			change, err = testChangeEvent(imp, `{"op": "u", "filter": "{\"_id\": 5}",
+				"patch": "{\"$v\": 1, \"$set\": {\"a\": 2}}"}`)
+			So(err, ShouldBeNil)
+			So(change.operation, ShouldEqual, cdcUpdate)
+			So(change.key, ShouldResemble, bson.D{{"_id", int32(5)}})
+			So(change.update, ShouldResemble, bson.D{{"$set", bson.D{{"a", int32(2)}}}})
+
+			change, err = testChangeEvent(imp, `{"op": "u", "filter": "{\"_id\": 5}",
+				"updateDescription": {"updatedFields": "{\"a\": 3}", "removedFields": null, "truncatedArrays": null}}`)
+			So(err, ShouldBeNil)
+			So(change.update, ShouldResemble, bson.D{{"$set", bson.D{{"a", int32(3)}}}})
+		})
+
+		Convey("events without a key should be errors", func() {
+			_, err := testChangeEvent(imp, `{"op": "d", "before": {"name": "y"}}`)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRouteChangeEvents(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With change events routed to three workers", t, func() {
+		imp := NewMockMongoImport()
+		imp.upsertFields = []string{"_id"}
+		readDocs := make(chan bson.D, 20)
+		workerChanges := []chan routedChange{
+			make(chan routedChange, 20), make(chan routedChange, 20), make(chan routedChange, 20),
+		}
+
+		Convey("events should be parsed and routed by document key", func() {
+			for i := 0; i < 20; i++ {
+				readDocs <- bson.D{
+					{"operationType", "update"},
+					{"documentKey", bson.D{{"_id", int32(i % 4)}}},
+					{"updateDescription", bson.D{{"updatedFields", bson.D{{"n", int32(i)}}}}},
+				}
+			}
+			close(readDocs)
+			imp.routeChangeEvents(readDocs, workerChanges)
+
+			workers := map[int32]int{}
+			previous := map[int32]int32{}
+			total := 0
+			for worker, changes := range workerChanges {
+				for routed := range changes {
+					So(routed.err, ShouldBeNil)
+					id := routed.change.key[0].Value.(int32)
+					n := routed.change.update[0].Value.(bson.D)[0].Value.(int32)
+					if w, ok := workers[id]; ok {
+						So(w, ShouldEqual, worker)
+						So(n, ShouldBeGreaterThan, previous[id])
+					}
+					workers[id] = worker
+					previous[id] = n
+					total++
+				}
+			}
+			So(total, ShouldEqual, 20)
+			So(len(workers), ShouldEqual, 4)
+		})
+
+		Convey("invalid events should go to the first worker and other events should be skipped", func() {
+			invalid := bson.D{{"operationType", "insert"}, {"documentKey", bson.D{{"_id", int32(1)}}}}
+			readDocs <- bson.D{{"operationType", "drop"}}
+			readDocs <- invalid
+			close(readDocs)
+			imp.routeChangeEvents(readDocs, workerChanges)
+
+			routed := <-workerChanges[0]
+			So(routed.err, ShouldNotBeNil)
+			So(routed.event, ShouldResemble, invalid)
+			for _, changes := range workerChanges {
+				_, open := <-changes
+				So(open, ShouldBeFalse)
+			}
+		})
+	})
+}
+
+func TestImportChangeEvents(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+
+	Convey("With change events imported with --mode=cdc", t, func() {
+		sessionProvider, err := db.NewSessionProvider(*getBasicToolOptions())
+		So(err, ShouldBeNil)
+		session, err := sessionProvider.GetSession()
+		So(err, ShouldBeNil)
+		collection := session.Database(testDb).Collection(testCollection)
+		_, err = collection.DeleteMany(nil, bson.D{})
+		So(err, ShouldBeNil)
+
+		dir, err := ioutil.TempDir("", "mongoimport_cdc")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "events.json")
+
+		imp, err := NewMongoImport()
+		So(err, ShouldBeNil)
+		imp.IngestOptions.Mode = modeCDC
+		imp.IngestOptions.NumInsertionWorkers = 2
+		imp.InputOptions.File = file
+		So(imp.validateSettings([]string{}), ShouldBeNil)
+
+		Convey("updates should apply to existing documents", func() {
+			So(ioutil.WriteFile(file, []byte(`
+{"operationType": "insert", "documentKey": {"_id": 1}, "fullDocument": {"_id": 1, "a": 1, "arr": [1, 2, 3]}}
+{"operationType": "update", "documentKey": {"_id": 1}, "updateDescription": {"updatedFields": {"a": 2}, "truncatedArrays": [{"field": "arr", "newSize": 1}]}}
+{"op": "c", "after": "{\"_id\": 2, \"a\": 1}"}
+{"op": "d", "filter": "{\"_id\": 2}"}
+`), 0644), ShouldBeNil)
+			numProcessed, numFailed, err := imp.ImportDocuments()
+			So(err, ShouldBeNil)
+			So(numProcessed, ShouldEqual, 4)
+			So(numFailed, ShouldEqual, 0)
+			So(checkOnlyHasDocuments(sessionProvider, []bson.M{
+				{"_id": int32(1), "a": int32(2), "arr": bson.A{int32(1)}},
+			}), ShouldBeNil)
+		})
+
+		Convey("updates of missing documents should be errors and not insert them", func() {
+			So(ioutil.WriteFile(file, []byte(`
+{"operationType": "insert", "documentKey": {"_id": 1}, "fullDocument": {"_id": 1, "a": 1}}
+{"operationType": "update", "documentKey": {"_id": 2}, "updateDescription": {"updatedFields": {"a": 2}}}
+`), 0644), ShouldBeNil)
+			_, numFailed, err := imp.ImportDocuments()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "matches no document")
+			So(numFailed, ShouldEqual, 1)
+			count, err := collection.CountDocuments(nil, bson.D{{"_id", int32(2)}})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+	})
+}
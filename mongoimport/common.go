@@ -171,7 +171,8 @@ func constructUpsertDocument(upsertFields []string, document bson.D) bson.D {
 // doSequentialStreaming takes a slice of workers, a readDocs (input) channel and
 // an outputChan (output) channel. It sequentially writes unprocessed data read from
 // the input channel to each worker and then sequentially reads the processed data
-// from each worker before passing it on to the output channel
+// from each worker before passing it on to the output channel. Workers send a nil
+// document for each record they skip, which is not passed on.
 func doSequentialStreaming(workers []*importWorker, readDocs chan Converter, outputChan chan bson.D) {
 	numWorkers := len(workers)
 
@@ -196,10 +197,10 @@ func doSequentialStreaming(workers []*importWorker, readDocs chan Converter, out
 	i := 0
 	for {
 		processedDocument, open := <-workers[i].processedDocumentChan
-		if open {
-			outputChan <- processedDocument
-		} else {
+		if !open {
 			numDoneWorkers++
+		} else if processedDocument != nil {
+			outputChan <- processedDocument
 		}
 		if numDoneWorkers == numWorkers {
 			break
@@ -484,9 +485,11 @@ func streamDocuments(ordered bool, numDecoders int, readDocs chan Converter, out
 
 // coercionError should only be used as a specific error type to check
 // whether tokensToBSON wants the row to print
-type coercionError struct{}
+type coercionError struct {
+	reason string
+}
 
-func (coercionError) Error() string { return "coercionError" }
+func (e coercionError) Error() string { return e.reason }
 
 // tokensToBSON reads in slice of records - along with ordered column names -
 // and returns a BSON document for the record.
@@ -499,24 +502,12 @@ func tokensToBSON(colSpecs []ColumnSpec, tokens []string, numProcessed uint64, i
 			continue
 		}
 		if index < len(colSpecs) {
-			parsedValue, err := colSpecs[index].Parser.Parse(token)
+			parsedValue, ok, err := parseToken(colSpecs[index], token, tokens, numProcessed)
 			if err != nil {
-				log.Logvf(log.DebugHigh, "parse failure in document #%d for column '%s',"+
-					"could not parse token '%s' to type %s",
-					numProcessed, colSpecs[index].Name, token, colSpecs[index].TypeName)
-				switch colSpecs[index].ParseGrace {
-				case pgAutoCast:
-					parsedValue = autoParse(token)
-				case pgSkipField:
-					continue
-				case pgSkipRow:
-					log.Logvf(log.Always, "skipping row #%d: %v", numProcessed, tokens)
-					return nil, coercionError{}
-				case pgStop:
-					return nil, fmt.Errorf("type coercion failure in document #%d for column '%s', "+
-						"could not parse token '%s' to type %s",
-						numProcessed, colSpecs[index].Name, token, colSpecs[index].TypeName)
-				}
+				return nil, err
+			}
+			if !ok {
+				continue
 			}
 			if len(colSpecs[index].NameParts) > 1 {
 				err = setNestedDocumentValue(colSpecs[index].NameParts, parsedValue, &document, useArrayIndexFields)
@@ -539,6 +530,32 @@ func tokensToBSON(colSpecs []ColumnSpec, tokens []string, numProcessed uint64, i
 	return document, nil
 }
 
+// parseToken parses the token of a record in the given column. Type coercion
+// failures are handled according to the parse grace of the column; it
+// returns false if the field should be skipped.
+func parseToken(colSpec ColumnSpec, token string, tokens []string, numProcessed uint64) (interface{}, bool, error) {
+	parsedValue, err := colSpec.Parser.Parse(token)
+	if err == nil {
+		return parsedValue, true, nil
+	}
+	log.Logvf(log.DebugHigh, "parse failure in document #%d for column '%s',"+
+		"could not parse token '%s' to type %s",
+		numProcessed, colSpec.Name, token, colSpec.TypeName)
+	switch colSpec.ParseGrace {
+	case pgAutoCast:
+		return autoParse(token), true, nil
+	case pgSkipField:
+		return nil, false, nil
+	case pgSkipRow:
+		log.Logvf(log.Always, "skipping row #%d: %v", numProcessed, tokens)
+		return nil, false, coercionError{fmt.Sprintf("could not parse token '%s' in column '%s' to type %s",
+			token, colSpec.Name, colSpec.TypeName)}
+	}
+	return nil, false, fmt.Errorf("type coercion failure in document #%d for column '%s', "+
+		"could not parse token '%s' to type %s",
+		numProcessed, colSpec.Name, token, colSpec.TypeName)
+}
+
 // validateFields takes a slice of fields and returns an error if the fields
 // are invalid, returns nil otherwise. Fields are invalid in the following cases:
 //
@@ -810,7 +827,9 @@ func (iw *importWorker) processDocuments(ordered bool) error {
 			if err != nil {
 				return err
 			}
-			if document == nil {
+			// a nil document is a record that was skipped; ordered workers
+			// still pass it on so doSequentialStreaming keeps its turns
+			if document == nil && !ordered {
 				continue
 			}
 			iw.processedDocumentChan <- document
@@ -0,0 +1,173 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression formats of input files.
+const (
+	compressionNone  = ""
+	compressionGzip  = "gzip"
+	compressionZstd  = "zstd"
+	compressionBzip2 = "bzip2"
+	compressionXz    = "xz"
+)
+
+// compressionExtensions maps the extensions of compressed files to their
+// compression format.
+var compressionExtensions = map[string]string{
+	".gz":   compressionGzip,
+	".gzip": compressionGzip,
+	".zst":  compressionZstd,
+	".zstd": compressionZstd,
+	".bz2":  compressionBzip2,
+	".xz":   compressionXz,
+}
+
+// plainExtensions are the extensions of files that are read as they are,
+// without looking for the magic bytes of a compression format.
+var plainExtensions = map[string]bool{
+	".csv":     true,
+	".tsv":     true,
+	".json":    true,
+	".jsonl":   true,
+	".ndjson":  true,
+	".txt":     true,
+	".parquet": true,
+}
+
+// compressionMagic holds the bytes each compression format starts with.
+var compressionMagic = []struct {
+	compression string
+	magic       []byte
+}{
+	{compressionGzip, []byte{0x1f, 0x8b}},
+	{compressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{compressionXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// compressionMagicSize is the number of bytes needed to detect a format.
+const compressionMagicSize = 10
+
+// isBzip2 reports whether header starts a bzip2 stream: "BZh", the block
+// size from 1 to 9, and the magic of the first block or of the end of an
+// empty stream.
+func isBzip2(header []byte) bool {
+	if len(header) < compressionMagicSize || !bytes.HasPrefix(header, []byte("BZh")) ||
+		header[3] < '1' || header[3] > '9' {
+		return false
+	}
+	magic := header[4:compressionMagicSize]
+	return bytes.Equal(magic, []byte("1AY&SY")) ||
+		bytes.Equal(magic, []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90})
+}
+
+// detectCompression returns the compression format of an input, by the
+// extension of its file name or else by the bytes it starts with. Files with
+// the extension of an uncompressed format are not sniffed.
+func detectCompression(name string, header []byte) string {
+	extension := strings.ToLower(filepath.Ext(name))
+	if compression, ok := compressionExtensions[extension]; ok {
+		return compression
+	}
+	if plainExtensions[extension] {
+		return compressionNone
+	}
+	for _, format := range compressionMagic {
+		if bytes.HasPrefix(header, format.magic) {
+			return format.compression
+		}
+	}
+	if isBzip2(header) {
+		return compressionBzip2
+	}
+	return compressionNone
+}
+
+// decompressingReader implements io.ReadCloser and sizeTracker by
+// decompressing an input while it is read. Its size is the number of
+// compressed bytes read, so that progress is measured against the size of
+// the compressed file.
+type decompressingReader struct {
+	io.Reader
+	compressed *sizeTrackingReader
+	closers    []io.Closer
+}
+
+func (dr *decompressingReader) Size() int64 {
+	return dr.compressed.Size()
+}
+
+func (dr *decompressingReader) Close() error {
+	var err error
+	for _, closer := range dr.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// newDecompressingReader returns a reader of the decompressed contents of
+// source. The file name of the input, which is empty for stdin, is used to
+// detect its compression along with the bytes it starts with.
+func newDecompressingReader(name string, source io.ReadCloser) (io.ReadCloser, error) {
+	compressed := newSizeTrackingReader(source)
+	buffered := bufio.NewReader(compressed)
+	// a short or failed peek leaves the header empty; errors are reported by
+	// the first read
+	header, _ := buffered.Peek(compressionMagicSize)
+
+	dr := &decompressingReader{
+		compressed: compressed,
+		closers:    []io.Closer{source},
+	}
+	compression := detectCompression(name, header)
+	switch compression {
+	case compressionNone:
+		dr.Reader = buffered
+	case compressionGzip:
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip-compressed input: %v", err)
+		}
+		dr.Reader = gzipReader
+		dr.closers = append([]io.Closer{gzipReader}, dr.closers...)
+	case compressionZstd:
+		zstdReader, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("error reading zstd-compressed input: %v", err)
+		}
+		dr.Reader = zstdReader
+		dr.closers = append([]io.Closer{zstdReader.IOReadCloser()}, dr.closers...)
+	case compressionBzip2:
+		dr.Reader = bzip2.NewReader(buffered)
+	case compressionXz:
+		xzReader, err := xz.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("error reading xz-compressed input: %v", err)
+		}
+		dr.Reader = xzReader
+	}
+	if compression != compressionNone {
+		log.Logvf(log.Info, "decompressing %v input", compression)
+	}
+	return dr, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDetectCompression(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Compression should be detected by extension or else by magic bytes", t, func() {
+		So(detectCompression("data.csv.GZ", nil), ShouldEqual, compressionGzip)
+		So(detectCompression("data.json.zst", nil), ShouldEqual, compressionZstd)
+		So(detectCompression("data.tsv.bz2", nil), ShouldEqual, compressionBzip2)
+		So(detectCompression("data.csv.xz", nil), ShouldEqual, compressionXz)
+		So(detectCompression("data", []byte{0x1f, 0x8b, 0x08}), ShouldEqual, compressionGzip)
+		So(detectCompression("", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}), ShouldEqual, compressionZstd)
+		So(detectCompression("", []byte("BZh91AY&SY\x00")), ShouldEqual, compressionBzip2)
+		So(detectCompression("", []byte("BZh9\x17\x72\x45\x38\x50\x90")), ShouldEqual, compressionBzip2)
+		So(detectCompression("", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}), ShouldEqual, compressionXz)
+		So(detectCompression("data.csv", []byte("1,2,3")), ShouldEqual, compressionNone)
+		So(detectCompression("data.csv", nil), ShouldEqual, compressionNone)
+	})
+
+	Convey("Text that looks like magic bytes should not be taken for compressed input", t, func() {
+		So(detectCompression("", []byte("BZh is a header\n")), ShouldEqual, compressionNone)
+		So(detectCompression("", []byte("BZh0AY&SY\n")), ShouldEqual, compressionNone)
+		So(detectCompression("data.csv", []byte("BZh91AY&SY\x00")), ShouldEqual, compressionNone)
+		So(detectCompression("data.JSON", []byte{0x1f, 0x8b, 0x08}), ShouldEqual, compressionNone)
+	})
+}
+
+func TestDecompressingReader(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With compressed copies of a CSV file", t, func() {
+		plain, err := ioutil.ReadFile("testdata/test.csv")
+		So(err, ShouldBeNil)
+		imp := NewMockMongoImport()
+		imp.InputOptions.Type = CSV
+
+		Convey("each should be decompressed while read, with progress in compressed bytes", func() {
+			for _, path := range []string{"testdata/test.csv", "testdata/test.csv.gz", "testdata/test.csv.zst", "testdata/test.csv.bz2", "testdata/test.csv.xz"} {
+				info, err := os.Stat(path)
+				So(err, ShouldBeNil)
+				source, size, err := imp.getSourceReader(path)
+				So(err, ShouldBeNil)
+				So(size, ShouldEqual, info.Size())
+				contents, err := ioutil.ReadAll(source)
+				So(err, ShouldBeNil)
+				So(string(contents), ShouldEqual, string(plain))
+				So(source.(sizeTracker).Size(), ShouldEqual, info.Size())
+				So(source.Close(), ShouldBeNil)
+			}
+		})
+
+		Convey("files without a compression extension should be detected by their contents", func() {
+			dir, err := ioutil.TempDir("", "mongoimport_compression")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			for _, extension := range []string{"zst", "bz2", "xz"} {
+				compressed, err := ioutil.ReadFile("testdata/test.csv." + extension)
+				So(err, ShouldBeNil)
+				path := filepath.Join(dir, "test")
+				So(ioutil.WriteFile(path, compressed, 0644), ShouldBeNil)
+
+				source, _, err := imp.getSourceReader(path)
+				So(err, ShouldBeNil)
+				contents, err := ioutil.ReadAll(source)
+				So(err, ShouldBeNil)
+				So(string(contents), ShouldEqual, string(plain))
+				So(source.Close(), ShouldBeNil)
+			}
+		})
+
+		Convey("compressed files should be imported like plain ones", func() {
+			readDocs := make(chan bson.D, 12)
+			fields := "a,b,c"
+			imp.InputOptions.Fields = &fields
+			imp.IngestOptions.NumDecodingWorkers = 1
+			So(imp.streamFiles([]string{"testdata/test.csv.gz", "testdata/test.csv.bz2", "testdata/test.csv.xz"}, nil, readDocs), ShouldBeNil)
+			So(len(readDocs), ShouldEqual, 9)
+		})
+	})
+}
@@ -7,9 +7,11 @@
 package mongoimport
 
 import (
+	"bytes"
 	gocsv "encoding/csv"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/mongodb/mongo-tools/mongoimport/csv"
 	"go.mongodb.org/mongo-driver/bson"
@@ -26,6 +28,12 @@ type CSVInputReader struct {
 	// csvRejectWriter is where coercion-failed rows are written, if applicable
 	csvRejectWriter *gocsv.Writer
 
+	// rejects is where rows that fail to parse are written with --rejectsFile
+	rejects *recordRejects
+
+	// mapping builds the documents from the columns with --mappingFile
+	mapping *fieldMapping
+
 	// csvRecord stores each line of input we read from the underlying reader
 	csvRecord []string
 
@@ -50,9 +58,12 @@ type CSVConverter struct {
 	colSpecs            []ColumnSpec
 	data                []string
 	index               uint64
+	line                uint64
 	ignoreBlanks        bool
 	useArrayIndexFields bool
 	rejectWriter        *gocsv.Writer
+	rejects             *recordRejects
+	mapping             *fieldMapping
 }
 
 // NewCSVInputReader returns a CSVInputReader configured to read data from the
@@ -105,6 +116,11 @@ func (r *CSVInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err
 // in read order and a channel on which to stream the documents processed from
 // the underlying reader. Returns a non-nil error if streaming fails.
 func (r *CSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (retErr error) {
+	if r.mapping != nil {
+		if err := r.mapping.validateColumns(r.colSpecs); err != nil {
+			return err
+		}
+	}
 	csvRecordChan := make(chan Converter, r.numDecoders)
 	csvErrChan := make(chan error)
 
@@ -127,9 +143,12 @@ func (r *CSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (ret
 				colSpecs:            r.colSpecs,
 				data:                r.csvRecord,
 				index:               r.numProcessed,
+				line:                uint64(r.csvReader.Line()),
 				ignoreBlanks:        r.ignoreBlanks,
 				useArrayIndexFields: r.useArrayIndexFields,
 				rejectWriter:        r.csvRejectWriter,
+				rejects:             r.rejects,
+				mapping:             r.mapping,
 			}
 			r.numProcessed++
 		}
@@ -145,14 +164,21 @@ func (r *CSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (ret
 // Convert implements the Converter interface for CSV input. It converts a
 // CSVConverter struct to a BSON document.
 func (c CSVConverter) Convert() (b bson.D, err error) {
-	b, err = tokensToBSON(
-		c.colSpecs,
-		c.data,
-		c.index,
-		c.ignoreBlanks,
-		c.useArrayIndexFields,
-	)
+	if c.mapping != nil {
+		b, err = c.mapping.tokensToBSON(c.colSpecs, c.data, c.index, c.ignoreBlanks)
+	} else {
+		b, err = tokensToBSON(
+			c.colSpecs,
+			c.data,
+			c.index,
+			c.ignoreBlanks,
+			c.useArrayIndexFields,
+		)
+	}
 	if _, ok := err.(coercionError); ok {
+		if c.rejects != nil {
+			return nil, c.rejects.rejectRecord("line", c.line, c.raw(), err)
+		}
 		c.Print()
 		err = nil
 	}
@@ -162,3 +188,12 @@ func (c CSVConverter) Convert() (b bson.D, err error) {
 func (c CSVConverter) Print() {
 	c.rejectWriter.Write(c.data)
 }
+
+// raw returns the record as a line of CSV.
+func (c CSVConverter) raw() string {
+	buf := &bytes.Buffer{}
+	w := gocsv.NewWriter(buf)
+	w.Write(c.data)
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
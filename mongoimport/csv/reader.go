@@ -112,6 +112,7 @@ type Reader struct {
 	TrailingComma    bool // ignored; here for backwards compatibility
 	TrimLeadingSpace bool // trim leading space
 	line             int
+	recordLine       int
 	column           int
 	r                *bufio.Reader
 	field            bytes.Buffer
@@ -158,6 +159,11 @@ func (r *Reader) Read() (record []string, err error) {
 	return record, nil
 }
 
+// Line returns the line on which the last record read began.
+func (r *Reader) Line() int {
+	return r.recordLine
+}
+
 // ReadAll reads all the remaining records from r.
 // Each record is a slice of fields.
 // A successful call returns err == nil, not err == EOF. Because ReadAll is
@@ -217,6 +223,7 @@ func (r *Reader) parseRecord() (fields []string, err error) {
 	// number (lines start at 1, not 0) and set column to -1
 	// so as we increment in readRune it points to the character we read.
 	r.line++
+	r.recordLine = r.line
 	r.column = -1
 
 	// Peek at the first rune.  If it is an error we are done.
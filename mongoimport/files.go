@@ -0,0 +1,215 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/progress"
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// expandInputFiles returns the files to import from the given paths, which
+// may be files, directories or glob patterns. Patterns are replaced by the
+// paths they match and directories by the regular files they contain, each
+// in lexical order; files whose names start with a dot are left out unless
+// the pattern starts with one too. Other paths are kept as they are, so that
+// missing files are reported when they are opened.
+func expandInputFiles(paths []string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	for _, path := range paths {
+		path = util.ToUniversalPath(path)
+		matches := []string{path}
+		if strings.ContainsAny(path, "*?[") {
+			globbed, err := filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid file pattern '%v': %v", path, err)
+			}
+			// as in shells, wildcards do not match a leading dot
+			hidden := strings.HasPrefix(filepath.Base(path), ".")
+			matches = nil
+			for _, match := range globbed {
+				if hidden || !strings.HasPrefix(filepath.Base(match), ".") {
+					matches = append(matches, match)
+				}
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match '%v'", path)
+			}
+		}
+
+		for _, match := range matches {
+			candidates := []string{match}
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				entries, err := ioutil.ReadDir(match)
+				if err != nil {
+					return nil, fmt.Errorf("error reading directory '%v': %v", match, err)
+				}
+				candidates = nil
+				for _, entry := range entries {
+					if entry.Mode().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+						candidates = append(candidates, filepath.Join(match, entry.Name()))
+					}
+				}
+			}
+			for _, file := range candidates {
+				if !seen[file] {
+					seen[file] = true
+					files = append(files, file)
+				}
+			}
+		}
+	}
+	if len(paths) > 0 && len(files) == 0 {
+		return nil, fmt.Errorf("no files to import in %v", strings.Join(paths, ", "))
+	}
+	return files, nil
+}
+
+// inputSources returns the files to import from, or a single empty name,
+// which stands for stdin, if no file was given.
+func (imp *MongoImport) inputSources() []string {
+	if len(imp.inputFiles) == 0 {
+		return []string{""}
+	}
+	return imp.inputFiles
+}
+
+// streamFiles reads the documents of every input file into readDocs, closing
+// it once every file has been read. Each file is read by its own
+// InputReader, up to --numParallelFiles at a time, or one at a time in order
+// with --maintainInsertionOrder and --mode=cdc. An empty file name stands for stdin, which
+// is read from stdin if it is not nil.
+func (imp *MongoImport) streamFiles(files []string, stdin io.Reader, readDocs chan bson.D) error {
+	numParallel := imp.InputOptions.NumParallelFiles
+	if numParallel < 1 || imp.orderedInput() {
+		numParallel = 1
+	}
+	if numParallel > len(files) {
+		numParallel = len(files)
+	}
+
+	progressManager := progress.NewBarWriter(log.Writer(0), progressBarWaitTime, progressBarLength, true)
+	progressManager.Start()
+	defer progressManager.Stop()
+
+	fileChan := make(chan string, len(files))
+	for _, file := range files {
+		fileChan <- file
+	}
+	close(fileChan)
+
+	// after an error, the other workers finish their files and stop
+	stop := make(chan struct{})
+	errChan := make(chan error, numParallel)
+	for i := 0; i < numParallel; i++ {
+		go func() {
+			for file := range fileChan {
+				select {
+				case <-stop:
+					errChan <- nil
+					return
+				default:
+				}
+				name := fmt.Sprintf("%v.%v", imp.ToolOptions.DB, imp.ToolOptions.Collection)
+				if len(files) > 1 {
+					name = file
+				}
+				if err := imp.streamFile(file, name, stdin, readDocs, progressManager); err != nil {
+					if len(files) > 1 {
+						err = fmt.Errorf("error importing %v: %v", file, err)
+					}
+					errChan <- err
+					return
+				}
+			}
+			errChan <- nil
+		}()
+	}
+
+	var firstErr error
+	for i := 0; i < numParallel; i++ {
+		if err := <-errChan; err != nil && firstErr == nil {
+			firstErr = err
+			close(stop)
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	close(readDocs)
+	return nil
+}
+
+// streamFile reads the documents of one input file into readDocs, reporting
+// its progress under the given name.
+func (imp *MongoImport) streamFile(file, name string, stdin io.Reader, readDocs chan bson.D, progressManager progress.Manager) error {
+	var source io.ReadCloser
+	var fileSize int64
+	var err error
+	replayStdin := file == "" && stdin != nil
+	if replayStdin {
+		// stdin was already opened to infer the fields
+		source = ioutil.NopCloser(stdin)
+	} else {
+		source, fileSize, err = imp.getSourceReader(file)
+		if err != nil {
+			return err
+		}
+	}
+	defer source.Close()
+
+	var in io.Reader = source
+	if imp.inferredFields != nil && imp.InputOptions.HeaderLine && !replayStdin {
+		// the inferred fields replace the header line
+		if _, _, in, err = imp.sampleRecords(source, 0); err != nil {
+			return err
+		}
+	}
+
+	inputReader, err := imp.getInputReader(in, file)
+	if err != nil {
+		return err
+	}
+	if imp.InputOptions.HeaderLine && imp.inferredFields == nil {
+		if imp.InputOptions.ColumnsHaveTypes {
+			err = inputReader.ReadAndValidateTypedHeader(ParsePG(imp.InputOptions.ParseGrace))
+		} else {
+			err = inputReader.ReadAndValidateHeader()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// progress is measured in bytes of the file, which are compressed bytes
+	// for compressed files
+	var tracker sizeTracker = inputReader
+	if fileTracker, ok := source.(sizeTracker); ok {
+		tracker = fileTracker
+	}
+	progressManager.Attach(name, &fileSizeProgressor{fileSize, tracker})
+	defer progressManager.Detach(name)
+
+	docs := make(chan bson.D, workerBufferSize)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- inputReader.StreamDocument(imp.orderedInput(), docs)
+	}()
+	for doc := range docs {
+		readDocs <- doc
+	}
+	return <-errChan
+}
@@ -0,0 +1,136 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestExpandInputFiles(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a directory of input files", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport_files")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		for _, name := range []string{"b.csv", "a.csv", "c.json", ".hidden.csv"} {
+			So(ioutil.WriteFile(filepath.Join(dir, name), []byte("a\n1\n"), 0644), ShouldBeNil)
+		}
+		So(os.Mkdir(filepath.Join(dir, "sub"), 0755), ShouldBeNil)
+
+		Convey("a directory should be expanded to its regular files in order", func() {
+			files, err := expandInputFiles([]string{dir})
+			So(err, ShouldBeNil)
+			So(files, ShouldResemble, []string{
+				filepath.Join(dir, "a.csv"),
+				filepath.Join(dir, "b.csv"),
+				filepath.Join(dir, "c.json"),
+			})
+		})
+
+		Convey("a glob should be expanded to the files it matches", func() {
+			files, err := expandInputFiles([]string{filepath.Join(dir, "*.csv")})
+			So(err, ShouldBeNil)
+			So(files, ShouldResemble, []string{
+				filepath.Join(dir, "a.csv"),
+				filepath.Join(dir, "b.csv"),
+			})
+		})
+
+		Convey("files given more than once should only be imported once", func() {
+			files, err := expandInputFiles([]string{filepath.Join(dir, "b.csv"), filepath.Join(dir, "*.csv")})
+			So(err, ShouldBeNil)
+			So(files, ShouldResemble, []string{
+				filepath.Join(dir, "b.csv"),
+				filepath.Join(dir, "a.csv"),
+			})
+		})
+
+		Convey("literal paths should be kept even if they do not exist", func() {
+			files, err := expandInputFiles([]string{filepath.Join(dir, "missing.csv")})
+			So(err, ShouldBeNil)
+			So(files, ShouldResemble, []string{filepath.Join(dir, "missing.csv")})
+		})
+
+		Convey("globs that match nothing and empty directories should be errors", func() {
+			_, err := expandInputFiles([]string{filepath.Join(dir, "*.tsv")})
+			So(err, ShouldNotBeNil)
+			_, err = expandInputFiles([]string{filepath.Join(dir, "sub")})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("no paths should mean stdin", func() {
+			files, err := expandInputFiles(nil)
+			So(err, ShouldBeNil)
+			So(files, ShouldBeEmpty)
+			So(NewMockMongoImport().inputSources(), ShouldResemble, []string{""})
+		})
+	})
+}
+
+func TestStreamFiles(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With several CSV files with header lines", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport_files")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		files := []string{filepath.Join(dir, "a.csv"), filepath.Join(dir, "b.csv")}
+		So(ioutil.WriteFile(files[0], []byte("n,s\n1,x\n2,y\n"), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(files[1], []byte("n,s\n3,z\n"), 0644), ShouldBeNil)
+
+		imp := NewMockMongoImport()
+		imp.InputOptions.Type = CSV
+		imp.InputOptions.HeaderLine = true
+		imp.InputOptions.NumParallelFiles = 2
+		imp.IngestOptions.NumDecodingWorkers = 1
+
+		readAll := func() ([]int, error) {
+			readDocs := make(chan bson.D, 8)
+			err := imp.streamFiles(files, nil, readDocs)
+			var values []int
+			for len(readDocs) > 0 {
+				doc := <-readDocs
+				values = append(values, int(doc[0].Value.(int32)))
+			}
+			sort.Ints(values)
+			return values, err
+		}
+
+		Convey("the documents of every file should be read", func() {
+			values, err := readAll()
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []int{1, 2, 3})
+		})
+
+		Convey("inferred fields should replace the header line of every file", func() {
+			imp.InputOptions.InferSchema = inferImport
+			imp.InputOptions.InferSampleSize = 10
+			imp.inferredFields, _, err = imp.inferFieldsFromFiles(files)
+			So(err, ShouldBeNil)
+			So(imp.inferredFields, ShouldResemble, []string{"n.int32()", "s.string()"})
+			values, err := readAll()
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []int{1, 2, 3})
+		})
+
+		Convey("an error should name the file it happened in", func() {
+			files = append(files, filepath.Join(dir, "missing.csv"))
+			_, err := readAll()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "missing.csv")
+		})
+	})
+}
@@ -0,0 +1,269 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools/mongoimport/csv"
+)
+
+// Modes accepted by --inferSchema.
+const (
+	inferPrint  = "print"
+	inferImport = "import"
+)
+
+// maxExactDoubleDigits is the number of significant digits a double can hold
+// without losing precision.
+const maxExactDoubleDigits = 15
+
+// inferDateLayouts are the date layouts recognized when inferring column
+// types, in order of preference.
+var inferDateLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006/01/02",
+	"01/02/2006",
+	"02/01/2006",
+	"01/02/2006 15:04:05",
+	"02/01/2006 15:04:05",
+}
+
+// typeCandidate is a column type that a column may have, as the type part of
+// a typed field (e.g. "int32()").
+type typeCandidate struct {
+	typ    string
+	parser FieldParser
+}
+
+// inferCandidates returns every type a column may be inferred to have, from
+// narrowest to widest.
+func inferCandidates() []typeCandidate {
+	candidates := []typeCandidate{
+		{"int32()", numberParser{new(FieldInt32Parser)}},
+		{"int64()", numberParser{new(FieldInt64Parser)}},
+		{"double()", numberParser{exactDoubleParser{}}},
+		{"decimal()", numberParser{new(FieldDecimalParser)}},
+	}
+	for _, layout := range inferDateLayouts {
+		candidates = append(candidates, typeCandidate{"date_go(" + layout + ")", &FieldDateParser{layout}})
+	}
+	return append(candidates,
+		typeCandidate{"boolean()", new(FieldBooleanParser)},
+		typeCandidate{"string()", new(FieldStringParser)},
+	)
+}
+
+// numberParser parses numbers without leading zeros. Values such as zip
+// codes ("02134") would lose their zeros as numbers, so they stay strings.
+type numberParser struct {
+	FieldParser
+}
+
+func (p numberParser) Parse(in string) (interface{}, error) {
+	digits := strings.TrimLeft(in, "+-")
+	if len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' {
+		return nil, fmt.Errorf("%v has a leading zero", in)
+	}
+	return p.FieldParser.Parse(in)
+}
+
+// exactDoubleParser parses the numbers a double represents without losing
+// digits, so that wider numbers are inferred to be decimals.
+type exactDoubleParser struct{}
+
+func (exactDoubleParser) Parse(in string) (interface{}, error) {
+	value, err := strconv.ParseFloat(in, 64)
+	if err != nil {
+		return nil, err
+	}
+	mantissa := strings.TrimLeft(in, "+-")
+	if i := strings.IndexAny(mantissa, "eE"); i >= 0 {
+		mantissa = mantissa[:i]
+	}
+	digits := strings.Trim(strings.Replace(mantissa, ".", "", 1), "0")
+	if len(digits) > maxExactDoubleDigits {
+		return nil, fmt.Errorf("%v has too many digits for a double", in)
+	}
+	return value, nil
+}
+
+// columnInference narrows down the type of a column as values are sampled.
+type columnInference struct {
+	candidates []typeCandidate
+	sampled    bool
+}
+
+// add removes the candidates that cannot parse a value. Blank values are
+// ignored.
+func (c *columnInference) add(value string) {
+	if value == "" {
+		return
+	}
+	if !c.sampled {
+		c.candidates = inferCandidates()
+		c.sampled = true
+	}
+	remaining := c.candidates[:0]
+	for _, candidate := range c.candidates {
+		if _, err := candidate.parser.Parse(value); err == nil {
+			remaining = append(remaining, candidate)
+		}
+	}
+	c.candidates = remaining
+}
+
+// typ returns the narrowest type that parses every value sampled, or auto if
+// every value was blank.
+func (c *columnInference) typ() string {
+	if !c.sampled {
+		return "auto()"
+	}
+	return c.candidates[0].typ
+}
+
+// inferTypedFields returns a typed field for each column of the sampled
+// records, named by names or, for columns past the end of names, by their
+// index as tokensToBSON does.
+func inferTypedFields(names []string, records [][]string) []string {
+	var columns []*columnInference
+	for _, record := range records {
+		for i, value := range record {
+			if i == len(columns) {
+				columns = append(columns, &columnInference{})
+			}
+			columns[i].add(value)
+		}
+	}
+	for len(columns) < len(names) {
+		columns = append(columns, &columnInference{})
+	}
+
+	fields := make([]string, len(columns))
+	for i, column := range columns {
+		name := "field" + strconv.Itoa(i)
+		if i < len(names) {
+			name = names[i]
+		}
+		fields[i] = name + "." + column.typ()
+	}
+	return fields
+}
+
+// sampleRecords reads the header line, if any, and up to sampleSize records
+// from the input. It returns them along with a reader of the input after the
+// header line that replays the sampled records.
+func (imp *MongoImport) sampleRecords(in io.Reader, sampleSize int) ([]string, [][]string, io.Reader, error) {
+	source := newBomDiscardingReader(in)
+	sampled := &bytes.Buffer{}
+	buffered := bufio.NewReader(io.TeeReader(source, sampled))
+
+	var readRecord func() ([]string, error)
+	if imp.InputOptions.Type == CSV {
+		csvReader := csv.NewReader(buffered)
+		csvReader.FieldsPerRecord = -1
+		csvReader.TrimLeadingSpace = true
+		readRecord = csvReader.Read
+	} else {
+		readRecord = func() ([]string, error) {
+			line, err := buffered.ReadString(entryDelimiter)
+			if err == io.EOF && line != "" {
+				err = nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return strings.Split(strings.TrimRight(line, "\r\n"), tokenSeparator), nil
+		}
+	}
+
+	var header []string
+	headerLength := 0
+	if imp.InputOptions.HeaderLine {
+		var err error
+		header, err = readRecord()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error reading header line: %v", err)
+		}
+		headerLength = sampled.Len() - buffered.Buffered()
+	}
+
+	var records [][]string
+	for len(records) < sampleSize {
+		record, err := readRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("read error on entry #%v: %v", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+
+	rest := io.MultiReader(bytes.NewReader(sampled.Bytes()[headerLength:]), source)
+	return header, records, rest, nil
+}
+
+// inferFieldsFromFiles samples each input file for --inferSchema and returns
+// the typed fields inferred from all of the samples, named by the header
+// line of the first file if there is one. If the input is stdin, it also
+// returns a reader of stdin after the header line that replays the sampled
+// records.
+func (imp *MongoImport) inferFieldsFromFiles(files []string) ([]string, io.Reader, error) {
+	names, err := imp.getFieldNames()
+	if err != nil {
+		return nil, nil, err
+	}
+	var records [][]string
+	var stdin io.Reader
+	for i, file := range files {
+		source, _, err := imp.getSourceReader(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		header, sampled, rest, err := imp.sampleRecords(source, imp.InputOptions.InferSampleSize)
+		if file == "" {
+			stdin = rest
+		} else {
+			source.Close()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if i == 0 && imp.InputOptions.HeaderLine {
+			names = header
+		}
+		records = append(records, sampled...)
+	}
+	log.Logvf(log.Info, "inferred field types from %v record(s)", len(records))
+	return inferTypedFields(names, records), stdin, nil
+}
+
+// PrintInferredFields samples the input and writes the typed fields inferred
+// from it to out, one per line, in the format of --fieldFile with
+// --columnsHaveTypes.
+func (imp *MongoImport) PrintInferredFields(out io.Writer) error {
+	fields, _, err := imp.inferFieldsFromFiles(imp.inputSources())
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if _, err = fmt.Fprintln(out, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestInferTypedFields(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The narrowest type that parses every sampled value should be inferred", t, func() {
+		records := [][]string{
+			{"1", "1", "1.5", "2020-01-02", "true", "a", "", "1", "12345678901234567.5"},
+			{"2", "3000000000", "2", "2021-12-31", "FALSE", "1", "", "1.5", "1"},
+			{"", "", "", "", "", "", "", "x"},
+		}
+		names := []string{"a", "b", "c", "d", "e", "f", "g"}
+		So(inferTypedFields(names, records), ShouldResemble, []string{
+			"a.int32()",
+			"b.int64()",
+			"c.double()",
+			"d.date_go(2006-01-02)",
+			"e.boolean()",
+			"f.string()",
+			"g.auto()",
+			"field7.string()",
+			"field8.decimal()",
+		})
+	})
+
+	Convey("Columns with no sampled values should have the auto type", t, func() {
+		So(inferTypedFields([]string{"a", "b"}, nil), ShouldResemble, []string{"a.auto()", "b.auto()"})
+	})
+
+	Convey("Numbers with leading zeros should stay strings", t, func() {
+		records := [][]string{{"02134", "0", "0.5", "-0.25"}, {"10001", "7", "00.5", "1"}}
+		So(inferTypedFields([]string{"zip", "a", "b", "c"}, records), ShouldResemble,
+			[]string{"zip.string()", "a.int32()", "b.string()", "c.double()"})
+	})
+
+	Convey("Dates should use the first layout that parses every value", t, func() {
+		records := [][]string{{"01/02/2020"}, {"12/31/2020"}}
+		So(inferTypedFields([]string{"d"}, records), ShouldResemble, []string{"d.date_go(01/02/2006)"})
+		records = append(records, []string{"31/12/2020"})
+		So(inferTypedFields([]string{"d"}, records), ShouldResemble, []string{"d.string()"})
+	})
+}
+
+// withStdin runs f with os.Stdin reading contents.
+func withStdin(dir, contents string, f func()) {
+	path := filepath.Join(dir, "stdin")
+	So(ioutil.WriteFile(path, []byte(contents), 0644), ShouldBeNil)
+	stdin, err := os.Open(path)
+	So(err, ShouldBeNil)
+	defer stdin.Close()
+	saved := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = saved }()
+	f()
+}
+
+func TestInferFieldsFromFiles(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a CSV input with a header line", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport_infer")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		imp := NewMockMongoImport()
+		imp.InputOptions.Type = CSV
+		imp.InputOptions.HeaderLine = true
+		imp.InputOptions.InferSampleSize = 2
+		contents := "\xEF\xBB\xBFname,\"count\"\nann,1\nbob,2\ncid,x\n"
+		path := filepath.Join(dir, "a.csv")
+		So(ioutil.WriteFile(path, []byte(contents), 0644), ShouldBeNil)
+
+		Convey("the fields should be inferred from the sampled rows only", func() {
+			fields, _, err := imp.inferFieldsFromFiles([]string{path})
+			So(err, ShouldBeNil)
+			So(fields, ShouldResemble, []string{"name.string()", "count.int32()"})
+		})
+
+		Convey("the fields should be inferred from the samples of every file", func() {
+			other := filepath.Join(dir, "b.csv")
+			So(ioutil.WriteFile(other, []byte("name,count\ndan,3000000000\n"), 0644), ShouldBeNil)
+			fields, _, err := imp.inferFieldsFromFiles([]string{path, other})
+			So(err, ShouldBeNil)
+			So(fields, ShouldResemble, []string{"name.string()", "count.int64()"})
+		})
+
+		Convey("stdin should be replayed after the header line", func() {
+			withStdin(dir, contents, func() {
+				_, rest, err := imp.inferFieldsFromFiles([]string{""})
+				So(err, ShouldBeNil)
+				replayed, err := ioutil.ReadAll(rest)
+				So(err, ShouldBeNil)
+				So(string(replayed), ShouldEqual, "ann,1\nbob,2\ncid,x\n")
+			})
+		})
+
+		Convey("the inferred fields should be used to import", func() {
+			imp.InputOptions.ParseGrace = "skipField"
+			withStdin(dir, contents, func() {
+				var rest io.Reader
+				var err error
+				imp.inferredFields, rest, err = imp.inferFieldsFromFiles([]string{""})
+				So(err, ShouldBeNil)
+				r, err := imp.getInputReader(rest, "")
+				So(err, ShouldBeNil)
+				docChan := make(chan bson.D, 3)
+				So(r.StreamDocument(true, docChan), ShouldBeNil)
+				So(<-docChan, ShouldResemble, bson.D{{"name", "ann"}, {"count", int32(1)}})
+				So(<-docChan, ShouldResemble, bson.D{{"name", "bob"}, {"count", int32(2)}})
+				So(<-docChan, ShouldResemble, bson.D{{"name", "cid"}})
+			})
+		})
+	})
+
+	Convey("With a TSV input and --fields", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport_infer")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		imp := NewMockMongoImport()
+		imp.InputOptions.Type = TSV
+		fields := "a,b"
+		imp.InputOptions.Fields = &fields
+		imp.InputOptions.InferSampleSize = 10
+
+		Convey("the fields should be named and the last line read without a newline", func() {
+			contents := "1\t2020-01-02 10:00:00\n2\t2020-01-03 11:30:00"
+			withStdin(dir, contents, func() {
+				inferred, rest, err := imp.inferFieldsFromFiles([]string{""})
+				So(err, ShouldBeNil)
+				So(inferred, ShouldResemble, []string{"a.int32()", "b.date_go(2006-01-02 15:04:05)"})
+				replayed, err := ioutil.ReadAll(rest)
+				So(err, ShouldBeNil)
+				So(string(replayed), ShouldEqual, contents)
+			})
+		})
+	})
+}
@@ -7,6 +7,7 @@
 package mongoimport
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -57,6 +58,9 @@ type JSONInputReader struct {
 
 	// legacyExtJSON specifies whether or not the legacy extended JSON format should be used.
 	legacyExtJSON bool
+
+	// rejects is where documents that fail to parse are written with --rejectsFile
+	rejects *recordRejects
 }
 
 // JSONConverter implements the Converter interface for JSON input.
@@ -64,6 +68,7 @@ type JSONConverter struct {
 	data          []byte
 	index         uint64
 	legacyExtJSON bool
+	rejects       *recordRejects
 }
 
 var (
@@ -141,6 +146,7 @@ func (r *JSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) (re
 				data:          rawBytes,
 				index:         r.numProcessed,
 				legacyExtJSON: r.legacyExtJSON,
+				rejects:       r.rejects,
 			}
 			r.numProcessed++
 		}
@@ -157,6 +163,14 @@ func (r *JSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) (re
 // Convert implements the Converter interface for JSON input. It converts a
 // JSONConverter struct to a BSON document.
 func (c JSONConverter) Convert() (bson.D, error) {
+	doc, err := c.convert()
+	if err != nil && c.rejects != nil {
+		return nil, c.rejects.rejectRecord("document", c.index+1, string(bytes.TrimSpace(c.data)), err)
+	}
+	return doc, err
+}
+
+func (c JSONConverter) convert() (bson.D, error) {
 	if c.legacyExtJSON {
 		return c.convertLegacyExtJSON()
 	}
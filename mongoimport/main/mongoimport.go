@@ -48,6 +48,14 @@ func main() {
 	}
 	defer m.Close()
 
+	if opts.InferSchema == "print" {
+		if err = m.PrintInferredFields(os.Stdout); err != nil {
+			log.Logvf(log.Always, "Failed: %v", err)
+			os.Exit(util.ExitFailure)
+		}
+		return
+	}
+
 	numDocs, numFailure, err := m.ImportDocuments()
 	if !opts.Quiet {
 		if err != nil {
@@ -0,0 +1,671 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fieldMapping builds documents from the columns of CSV and TSV records
+// according to a --mappingFile. The mapping file is an extended JSON
+// document that is the template of every imported document: strings of the
+// form "$column" are replaced by the value of that column, operators compute
+// values from columns, and every other value is a constant. Columns that the
+// mapping does not use are not imported.
+type fieldMapping struct {
+	document   *documentExpr
+	columns    []string
+	parseGrace ParseGrace
+}
+
+// mappingRow holds the values of the columns of a record by column name.
+// Blank and skipped columns are missing.
+type mappingRow map[string]interface{}
+
+// mappingExpr is a compiled expression of a mapping. eval returns false if
+// the value is missing, in which case the field it is assigned to is left
+// out of the document. Errors are reported for the given field path.
+type mappingExpr interface {
+	eval(path string, row mappingRow) (interface{}, bool, error)
+}
+
+// readMappingFile reads and compiles a field mapping from an extended JSON
+// file.
+func readMappingFile(path string) (*fieldMapping, error) {
+	data, err := ioutil.ReadFile(util.ToUniversalPath(path))
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err = bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing mapping: %v", err)
+	}
+	return compileMapping(doc)
+}
+
+// compileMapping compiles the template document of a field mapping.
+func compileMapping(doc bson.D) (*fieldMapping, error) {
+	m := &fieldMapping{parseGrace: pgStop}
+	expr, err := m.compileDocument("", doc)
+	if err != nil {
+		return nil, err
+	}
+	m.document = expr
+	return m, nil
+}
+
+// validateColumns returns an error if the mapping uses a column that the
+// input does not have. Columns past the named ones are named field<i>, as in
+// tokensToBSON.
+func (m *fieldMapping) validateColumns(colSpecs []ColumnSpec) error {
+	names := ColumnNames(colSpecs)
+	for _, column := range m.columns {
+		if util.StringSliceContains(names, column) {
+			continue
+		}
+		if strings.HasPrefix(column, "field") {
+			if index, err := strconv.Atoi(column[len("field"):]); err == nil && index >= len(names) {
+				continue
+			}
+		}
+		return fmt.Errorf("mapping uses column '%v', which is not one of the input fields", column)
+	}
+	return nil
+}
+
+// tokensToBSON parses the tokens of a record as tokensToBSON does, and returns
+// the document the mapping builds from them. Failures to evaluate the mapping
+// are handled according to the parse grace of the mapping: the field is left
+// out with autoCast and skipField, the row is skipped with skipRow and the
+// import stops with stop.
+func (m *fieldMapping) tokensToBSON(colSpecs []ColumnSpec, tokens []string, numProcessed uint64, ignoreBlanks bool) (bson.D, error) {
+	log.Logvf(log.DebugHigh, "got line: %v", tokens)
+	row := mappingRow{}
+	for index, token := range tokens {
+		if token == "" && ignoreBlanks {
+			continue
+		}
+		if index < len(colSpecs) {
+			parsedValue, ok, err := parseToken(colSpecs[index], token, tokens, numProcessed)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				row[colSpecs[index].Name] = parsedValue
+			}
+		} else {
+			row["field"+strconv.Itoa(index)] = autoParse(token)
+		}
+	}
+
+	document, err := m.document.evalDocument("", row, func(err error) error {
+		switch m.parseGrace {
+		case pgSkipRow:
+			log.Logvf(log.Always, "skipping row #%d: %v", numProcessed, err)
+			return coercionError{err.Error()}
+		case pgStop:
+			return fmt.Errorf("mapping failure in document #%d: %v", numProcessed, err)
+		}
+		log.Logvf(log.DebugHigh, "mapping failure in document #%d: %v", numProcessed, err)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// compileExpr compiles the expression at the given path of the template.
+func (m *fieldMapping) compileExpr(path string, value interface{}) (mappingExpr, error) {
+	switch v := value.(type) {
+	case string:
+		if !strings.HasPrefix(v, "$") {
+			return constExpr{v}, nil
+		}
+		if v == "$" {
+			return nil, schemaError(path, "'$' must be followed by a column name")
+		}
+		m.columns = append(m.columns, v[1:])
+		return columnExpr{v[1:]}, nil
+	case bson.A:
+		expr := arrayExpr{}
+		for i, item := range v {
+			itemExpr, err := m.compileExpr(joinPath(path, strconv.Itoa(i)), item)
+			if err != nil {
+				return nil, err
+			}
+			expr.items = append(expr.items, itemExpr)
+		}
+		return expr, nil
+	case bson.D:
+		if len(v) > 0 && strings.HasPrefix(v[0].Key, "$") {
+			if len(v) > 1 {
+				return nil, schemaError(path, "operator %v must be the only field of its document", v[0].Key)
+			}
+			return m.compileOperator(path, v[0].Key, v[0].Value)
+		}
+		return m.compileDocument(path, v)
+	}
+	return constExpr{value}, nil
+}
+
+// compileDocument compiles a document of the template whose fields are
+// expressions. Dotted field names build nested documents.
+func (m *fieldMapping) compileDocument(path string, doc bson.D) (*documentExpr, error) {
+	expr := &documentExpr{}
+	var names []string
+	for _, field := range doc {
+		names = append(names, field.Key)
+	}
+	if err := validateFields(names, false); err != nil {
+		return nil, schemaError(path, "%v", err)
+	}
+	for _, field := range doc {
+		fieldExpr, err := m.compileExpr(joinPath(path, field.Key), field.Value)
+		if err != nil {
+			return nil, err
+		}
+		expr.fields = append(expr.fields, mappedField{
+			name:      field.Key,
+			nameParts: strings.Split(field.Key, "."),
+			expr:      fieldExpr,
+		})
+	}
+	return expr, nil
+}
+
+// compileArgs compiles the arguments of an operator, which must be an array
+// of between min and max expressions; a max of -1 means no limit. A single
+// argument may be given without the array.
+func (m *fieldMapping) compileArgs(path, operator string, value interface{}, min, max int) ([]mappingExpr, error) {
+	args, ok := value.(bson.A)
+	if !ok {
+		args = bson.A{value}
+	}
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		switch {
+		case min == max:
+			return nil, schemaError(path, "%v takes %v argument(s)", operator, min)
+		case max >= 0:
+			return nil, schemaError(path, "%v takes %v to %v arguments", operator, min, max)
+		}
+		return nil, schemaError(path, "%v takes at least %v argument(s)", operator, min)
+	}
+	var exprs []mappingExpr
+	for _, arg := range args {
+		expr, err := m.compileExpr(path, arg)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
+// compileOperator compiles an operator expression.
+func (m *fieldMapping) compileOperator(path, operator string, value interface{}) (mappingExpr, error) {
+	switch operator {
+	case "$literal":
+		return constExpr{value}, nil
+	case "$concat":
+		args, err := m.compileArgs(path, operator, value, 1, -1)
+		if err != nil {
+			return nil, err
+		}
+		return concatExpr{args}, nil
+	case "$split":
+		args, ok := value.(bson.A)
+		if !ok || len(args) != 2 {
+			return nil, schemaError(path, "$split takes a value and a separator")
+		}
+		separator, ok := args[1].(string)
+		if !ok || separator == "" {
+			return nil, schemaError(path, "the separator of $split must be a non-empty string")
+		}
+		input, err := m.compileExpr(path, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return splitExpr{input, separator}, nil
+	case "$geoPoint":
+		doc, ok := value.(bson.D)
+		if !ok {
+			return nil, schemaError(path, "$geoPoint must be a document with lng and lat")
+		}
+		expr := geoPointExpr{}
+		for _, field := range doc {
+			coordinate, err := m.compileExpr(path, field.Value)
+			if err != nil {
+				return nil, err
+			}
+			switch field.Key {
+			case "lng":
+				expr.lng = coordinate
+			case "lat":
+				expr.lat = coordinate
+			default:
+				return nil, schemaError(path, "unknown $geoPoint field '%v'", field.Key)
+			}
+		}
+		if expr.lng == nil || expr.lat == nil {
+			return nil, schemaError(path, "$geoPoint must be a document with lng and lat")
+		}
+		return expr, nil
+	case "$cond":
+		if doc, ok := value.(bson.D); ok {
+			expr := condExpr{}
+			for _, field := range doc {
+				branch, err := m.compileExpr(path, field.Value)
+				if err != nil {
+					return nil, err
+				}
+				switch field.Key {
+				case "if":
+					expr.cond = branch
+				case "then":
+					expr.then = branch
+				case "else":
+					expr.otherwise = branch
+				default:
+					return nil, schemaError(path, "unknown $cond field '%v'", field.Key)
+				}
+			}
+			if expr.cond == nil || expr.then == nil {
+				return nil, schemaError(path, "$cond requires if and then")
+			}
+			return expr, nil
+		}
+		exprs, err := m.compileArgs(path, operator, value, 2, 3)
+		if err != nil {
+			return nil, err
+		}
+		expr := condExpr{cond: exprs[0], then: exprs[1]}
+		if len(exprs) == 3 {
+			expr.otherwise = exprs[2]
+		}
+		return expr, nil
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		args, err := m.compileArgs(path, operator, value, 2, 2)
+		if err != nil {
+			return nil, err
+		}
+		return compareExpr{operator, args[0], args[1]}, nil
+	case "$and", "$or":
+		args, err := m.compileArgs(path, operator, value, 1, -1)
+		if err != nil {
+			return nil, err
+		}
+		return logicExpr{operator, args}, nil
+	case "$not":
+		args, err := m.compileArgs(path, operator, value, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{args[0]}, nil
+	}
+	return nil, schemaError(path, "unknown operator %v", operator)
+}
+
+// constExpr is a constant value.
+type constExpr struct {
+	value interface{}
+}
+
+func (e constExpr) eval(string, mappingRow) (interface{}, bool, error) {
+	return e.value, true, nil
+}
+
+// columnExpr is the value of a column, written "$column".
+type columnExpr struct {
+	column string
+}
+
+func (e columnExpr) eval(_ string, row mappingRow) (interface{}, bool, error) {
+	value, ok := row[e.column]
+	return value, ok, nil
+}
+
+// documentExpr is a document whose fields are expressions.
+type documentExpr struct {
+	fields []mappedField
+}
+
+type mappedField struct {
+	name      string
+	nameParts []string
+	expr      mappingExpr
+}
+
+func (e *documentExpr) eval(path string, row mappingRow) (interface{}, bool, error) {
+	doc, err := e.evalDocument(path, row, func(err error) error { return err })
+	return doc, err == nil, err
+}
+
+// evalDocument builds the document. Errors evaluating a field are passed to
+// onError; the field is left out if it returns nil.
+func (e *documentExpr) evalDocument(path string, row mappingRow, onError func(error) error) (bson.D, error) {
+	doc := bson.D{}
+	for _, field := range e.fields {
+		fieldPath := joinPath(path, field.name)
+		var value interface{}
+		var ok bool
+		var err error
+		if nested, isDoc := field.expr.(*documentExpr); isDoc {
+			// errors in nested documents have already been passed to onError
+			if value, err = nested.evalDocument(fieldPath, row, onError); err != nil {
+				return nil, err
+			}
+			ok = true
+		} else {
+			value, ok, err = field.expr.eval(fieldPath, row)
+		}
+		if err != nil {
+			if err = onError(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err = setNestedDocumentValue(field.nameParts, value, &doc, false); err != nil {
+			return nil, schemaError(fieldPath, "%v", err)
+		}
+	}
+	return doc, nil
+}
+
+// arrayExpr is an array whose items are expressions. Missing items are null.
+type arrayExpr struct {
+	items []mappingExpr
+}
+
+func (e arrayExpr) eval(path string, row mappingRow) (interface{}, bool, error) {
+	array := bson.A{}
+	for i, item := range e.items {
+		value, _, err := item.eval(joinPath(path, strconv.Itoa(i)), row)
+		if err != nil {
+			return nil, false, err
+		}
+		array = append(array, value)
+	}
+	return array, true, nil
+}
+
+// concatExpr concatenates its arguments as strings. It is missing if any
+// argument is.
+type concatExpr struct {
+	args []mappingExpr
+}
+
+func (e concatExpr) eval(path string, row mappingRow) (interface{}, bool, error) {
+	var concatenated strings.Builder
+	for _, arg := range e.args {
+		value, ok, err := arg.eval(path, row)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		str, err := mappingString(path, value)
+		if err != nil {
+			return nil, false, err
+		}
+		concatenated.WriteString(str)
+	}
+	return concatenated.String(), true, nil
+}
+
+// splitExpr splits a string into an array of strings.
+type splitExpr struct {
+	input     mappingExpr
+	separator string
+}
+
+func (e splitExpr) eval(path string, row mappingRow) (interface{}, bool, error) {
+	value, ok, err := e.input.eval(path, row)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	str, err := mappingString(path, value)
+	if err != nil {
+		return nil, false, err
+	}
+	array := bson.A{}
+	if str != "" {
+		for _, part := range strings.Split(str, e.separator) {
+			array = append(array, part)
+		}
+	}
+	return array, true, nil
+}
+
+// geoPointExpr is a GeoJSON point. It is missing if either coordinate is.
+type geoPointExpr struct {
+	lng, lat mappingExpr
+}
+
+func (e geoPointExpr) eval(path string, row mappingRow) (interface{}, bool, error) {
+	lngValue, lngOK, err := e.lng.eval(path, row)
+	if err != nil {
+		return nil, false, err
+	}
+	latValue, latOK, err := e.lat.eval(path, row)
+	if err != nil || !lngOK || !latOK {
+		return nil, false, err
+	}
+	lng, ok := mappingNumber(lngValue)
+	if !ok {
+		return nil, false, schemaError(path, "longitude %v is not a number", lngValue)
+	}
+	lat, ok := mappingNumber(latValue)
+	if !ok {
+		return nil, false, schemaError(path, "latitude %v is not a number", latValue)
+	}
+	if lng < -180 || lng > 180 {
+		return nil, false, schemaError(path, "longitude %v is out of range", lng)
+	}
+	if lat < -90 || lat > 90 {
+		return nil, false, schemaError(path, "latitude %v is out of range", lat)
+	}
+	return bson.D{{Key: "type", Value: "Point"}, {Key: "coordinates", Value: bson.A{lng, lat}}}, true, nil
+}
+
+// condExpr is then if cond is true, and otherwise else, or missing if there
+// is no else.
+type condExpr struct {
+	cond, then, otherwise mappingExpr
+}
+
+func (e condExpr) eval(path string, row mappingRow) (interface{}, bool, error) {
+	cond, ok, err := e.cond.eval(path, row)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok && mappingTruth(cond) {
+		return e.then.eval(path, row)
+	}
+	if e.otherwise == nil {
+		return nil, false, nil
+	}
+	return e.otherwise.eval(path, row)
+}
+
+// compareExpr compares two values. Missing values compare as null.
+type compareExpr struct {
+	operator    string
+	left, right mappingExpr
+}
+
+func (e compareExpr) eval(path string, row mappingRow) (interface{}, bool, error) {
+	left, _, err := e.left.eval(path, row)
+	if err != nil {
+		return nil, false, err
+	}
+	right, _, err := e.right.eval(path, row)
+	if err != nil {
+		return nil, false, err
+	}
+	switch e.operator {
+	case "$eq":
+		return mappingEqual(left, right), true, nil
+	case "$ne":
+		return !mappingEqual(left, right), true, nil
+	}
+	cmp, ok := mappingCompare(left, right)
+	if !ok {
+		return nil, false, schemaError(path, "%v cannot compare %v and %v", e.operator, left, right)
+	}
+	switch e.operator {
+	case "$gt":
+		return cmp > 0, true, nil
+	case "$gte":
+		return cmp >= 0, true, nil
+	case "$lt":
+		return cmp < 0, true, nil
+	}
+	return cmp <= 0, true, nil
+}
+
+// logicExpr is the conjunction or disjunction of its arguments.
+type logicExpr struct {
+	operator string
+	args     []mappingExpr
+}
+
+func (e logicExpr) eval(path string, row mappingRow) (interface{}, bool, error) {
+	or := e.operator == "$or"
+	for _, arg := range e.args {
+		value, ok, err := arg.eval(path, row)
+		if err != nil {
+			return nil, false, err
+		}
+		if (ok && mappingTruth(value)) == or {
+			return or, true, nil
+		}
+	}
+	return !or, true, nil
+}
+
+// notExpr negates its argument.
+type notExpr struct {
+	arg mappingExpr
+}
+
+func (e notExpr) eval(path string, row mappingRow) (interface{}, bool, error) {
+	value, ok, err := e.arg.eval(path, row)
+	if err != nil {
+		return nil, false, err
+	}
+	return !(ok && mappingTruth(value)), true, nil
+}
+
+// mappingTruth returns whether a value counts as true in a condition: null,
+// false, zero and the empty string are false, and everything else is true.
+func mappingTruth(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	if n, ok := mappingNumber(value); ok {
+		return n != 0
+	}
+	return true
+}
+
+// mappingNumber converts a numeric value to a float64.
+func mappingNumber(value interface{}) (float64, bool) {
+	n, ok := numberOf(value)
+	return n.float, ok && !math.IsNaN(n.float)
+}
+
+// mappingString converts a value to the string it is concatenated or split
+// as.
+func mappingString(path string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case primitive.Decimal128:
+		return v.String(), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano), nil
+	}
+	return "", schemaError(path, "cannot convert %v to a string", value)
+}
+
+// mappingTime converts a date value to a time.Time.
+func mappingTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case primitive.DateTime:
+		return v.Time(), true
+	}
+	return time.Time{}, false
+}
+
+// mappingEqual returns whether two values are equal, comparing numbers by
+// value whatever their type.
+func mappingEqual(left, right interface{}) bool {
+	if cmp, ok := mappingCompare(left, right); ok {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// mappingCompare orders two numbers, strings or dates, comparing numbers
+// exactly as --validateSchema does. It returns false if the values cannot be
+// ordered.
+func mappingCompare(left, right interface{}) (int, bool) {
+	if l, ok := numberOf(left); ok {
+		if r, ok := numberOf(right); ok {
+			return l.compare(r)
+		}
+		return 0, false
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), true
+		}
+		return 0, false
+	}
+	if l, ok := mappingTime(left); ok {
+		if r, ok := mappingTime(right); ok {
+			switch {
+			case l.Before(r):
+				return -1, true
+			case l.After(r):
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,165 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// testMapping compiles a mapping given as extended JSON.
+func testMapping(mapping string) (*fieldMapping, error) {
+	var doc bson.D
+	So(bson.UnmarshalExtJSON([]byte(mapping), false, &doc), ShouldBeNil)
+	return compileMapping(doc)
+}
+
+func TestFieldMapping(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a mapping of columns to a document", t, func() {
+		mapping, err := testMapping(`{
+			"_id": "$id",
+			"name": {"$concat": ["$first", " ", "$last"]},
+			"tags": {"$split": ["$tags", ";"]},
+			"location": {"$geoPoint": {"lng": "$lng", "lat": "$lat"}},
+			"source": "feed",
+			"address.city": "$city",
+			"status": {"$cond": {"if": {"$eq": ["$status", "A"]}, "then": "active", "else": "inactive"}},
+			"adult": {"$cond": [{"$gte": ["$age", 18]}, true]},
+			"price": {"$literal": "$5"}
+		}`)
+		So(err, ShouldBeNil)
+		colSpecs, err := ParseTypedHeaders([]string{
+			"id.int32()", "first.string()", "last.string()", "tags.string()",
+			"lat.double()", "lng.double()", "city.string()", "status.string()", "age.int32()",
+		}, pgStop)
+		So(err, ShouldBeNil)
+		So(mapping.validateColumns(colSpecs), ShouldBeNil)
+
+		Convey("documents should be built from the columns the mapping uses", func() {
+			tokens := []string{"1", "Ann", "Lee", "a;b", "48.85", "2.35", "Paris", "A", "30"}
+			doc, err := mapping.tokensToBSON(colSpecs, tokens, 0, false)
+			So(err, ShouldBeNil)
+			So(doc, ShouldResemble, bson.D{
+				{"_id", int32(1)},
+				{"name", "Ann Lee"},
+				{"tags", bson.A{"a", "b"}},
+				{"location", bson.D{{"type", "Point"}, {"coordinates", bson.A{2.35, 48.85}}}},
+				{"source", "feed"},
+				{"address", &bson.D{{"city", "Paris"}}},
+				{"status", "active"},
+				{"adult", true},
+				{"price", "$5"},
+			})
+		})
+
+		Convey("fields computed from blank columns should be left out", func() {
+			tokens := []string{"2", "Bo", "", "", "", "2.35", "", "B", "12"}
+			doc, err := mapping.tokensToBSON(colSpecs, tokens, 0, true)
+			So(err, ShouldBeNil)
+			So(doc, ShouldResemble, bson.D{
+				{"_id", int32(2)},
+				{"source", "feed"},
+				{"status", "inactive"},
+				{"price", "$5"},
+			})
+		})
+
+		Convey("mapping failures should be handled by the parse grace", func() {
+			tokens := []string{"3", "Cy", "Ng", "", "95", "2.35", "Oslo", "A", "40"}
+			_, err := mapping.tokensToBSON(colSpecs, tokens, 0, false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "field 'location': latitude 95 is out of range")
+
+			mapping.parseGrace = pgSkipRow
+			_, err = mapping.tokensToBSON(colSpecs, tokens, 0, false)
+			So(err, ShouldHaveSameTypeAs, coercionError{})
+
+			mapping.parseGrace = pgSkipField
+			doc, err := mapping.tokensToBSON(colSpecs, tokens, 0, false)
+			So(err, ShouldBeNil)
+			So(len(doc), ShouldEqual, 8)
+		})
+
+		Convey("columns the input does not have should be errors", func() {
+			So(mapping.validateColumns(colSpecs[:3]), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Extra columns should be usable by their index", t, func() {
+		mapping, err := testMapping(`{"a": "$a", "b": {"$or": ["$field1", false]}}`)
+		So(err, ShouldBeNil)
+		colSpecs := ParseAutoHeaders([]string{"a"})
+		So(mapping.validateColumns(colSpecs), ShouldBeNil)
+		doc, err := mapping.tokensToBSON(colSpecs, []string{"x", "1"}, 0, false)
+		So(err, ShouldBeNil)
+		So(doc, ShouldResemble, bson.D{{"a", "x"}, {"b", true}})
+	})
+
+	Convey("Numbers should be compared exactly", t, func() {
+		large := int64(1) << 60
+		So(mappingEqual(large, large+1), ShouldBeFalse)
+		So(mappingEqual(int32(2), 2.0), ShouldBeTrue)
+		cmp, ok := mappingCompare(large+1, large)
+		So(ok, ShouldBeTrue)
+		So(cmp, ShouldEqual, 1)
+		_, ok = mappingCompare(int64(1), "1")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Invalid mappings should be errors", t, func() {
+		for _, mapping := range []string{
+			`{"a": "$"}`,
+			`{"a": {"$unknown": 1}}`,
+			`{"a": {"$concat": []}}`,
+			`{"a": {"$split": ["$b", ""]}}`,
+			`{"a": {"$geoPoint": {"lng": "$b"}}}`,
+			`{"a": {"$cond": {"then": 1}}}`,
+			`{"a": {"$eq": ["$b"]}}`,
+			`{"a": {"$not": 1, "b": 2}}`,
+			`{"a": 1, "a.b": 2}`,
+			`{"$a": 1}`,
+		} {
+			_, err := testMapping(mapping)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("With a mapping file", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport_mapping")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "mapping.json")
+		So(ioutil.WriteFile(path, []byte(`{"n": "$n", "at": {"$date": "2020-01-02T00:00:00Z"}}`), 0644), ShouldBeNil)
+		mapping, err := readMappingFile(path)
+		So(err, ShouldBeNil)
+
+		Convey("CSV records should be imported through the mapping", func() {
+			r := NewCSVInputReader(ParseAutoHeaders([]string{"n", "unused"}), bytes.NewReader([]byte("1,x\n2,y\n")), os.Stdout, 1, false, false)
+			r.mapping = mapping
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			doc := <-docChan
+			So(doc[0], ShouldResemble, bson.E{"n", int32(1)})
+			So(len(doc), ShouldEqual, 2)
+		})
+
+		Convey("TSV input without the columns the mapping uses should be an error", func() {
+			r := NewTSVInputReader(ParseAutoHeaders([]string{"m"}), bytes.NewReader([]byte("1\n")), os.Stdout, 1, false, false)
+			r.mapping = mapping
+			So(r.StreamDocument(true, make(chan bson.D, 1)), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,149 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mergeIgnore is the strategy of fields that are not written in merge mode.
+const mergeIgnore = "ignore"
+
+// mergeOperators are the update operators that fields can be merged with.
+var mergeOperators = map[string]bool{
+	"$set":         true,
+	"$inc":         true,
+	"$max":         true,
+	"$min":         true,
+	"$addToSet":    true,
+	"$setOnInsert": true,
+}
+
+// mergeStrategies holds how each field is merged into existing documents
+// with --mode=merge and --mergeStrategies. Fields without a strategy are set.
+type mergeStrategies struct {
+	// fields maps field paths to an update operator or mergeIgnore
+	fields map[string]string
+	// parents holds the paths of the documents that contain fields with a
+	// strategy
+	parents map[string]bool
+}
+
+// readMergeStrategiesFile reads merge strategies from an extended JSON file
+// that maps field paths to an update operator or "ignore", e.g.
+// {"visits": "$inc", "lastSeen": "$max", "tags": "$addToSet"}.
+func readMergeStrategiesFile(path string) (*mergeStrategies, error) {
+	data, err := ioutil.ReadFile(util.ToUniversalPath(path))
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err = bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing merge strategies: %v", err)
+	}
+	return newMergeStrategies(doc)
+}
+
+// newMergeStrategies returns the merge strategies of a document mapping
+// field paths to strategies.
+func newMergeStrategies(doc bson.D) (*mergeStrategies, error) {
+	s := &mergeStrategies{
+		fields:  map[string]string{},
+		parents: map[string]bool{},
+	}
+	var paths []string
+	for _, field := range doc {
+		strategy, ok := field.Value.(string)
+		if !ok || !(mergeOperators[strategy] || strategy == mergeIgnore) {
+			return nil, fmt.Errorf("invalid strategy for field '%v': must be one of $set, $inc, $max, $min, $addToSet, $setOnInsert or ignore", field.Key)
+		}
+		s.fields[field.Key] = strategy
+		paths = append(paths, field.Key)
+
+		parts := strings.Split(field.Key, ".")
+		for i := 1; i < len(parts); i++ {
+			s.parents[strings.Join(parts[:i], ".")] = true
+		}
+	}
+	if err := validateFields(paths, false); err != nil {
+		return nil, fmt.Errorf("invalid merge strategies: %v", err)
+	}
+	return s, nil
+}
+
+// updateDocument returns the update that merges a document into an existing
+// one. Fields are grouped under the update operator of their strategy, and
+// documents that contain fields with a strategy are merged field by field.
+// It returns an empty update if every field is ignored.
+func (s *mergeStrategies) updateDocument(document bson.D) bson.D {
+	update := bson.D{}
+	addField := func(operator, path string, value interface{}) {
+		if operator == "$addToSet" {
+			if array, ok := asArray(value); ok {
+				value = bson.D{{Key: "$each", Value: array}}
+			}
+		}
+		for i := range update {
+			if update[i].Key == operator {
+				update[i].Value = append(update[i].Value.(bson.D), bson.E{Key: path, Value: value})
+				return
+			}
+		}
+		update = append(update, bson.E{Key: operator, Value: bson.D{{Key: path, Value: value}}})
+	}
+
+	var addDocument func(prefix string, document bson.D)
+	addDocument = func(prefix string, document bson.D) {
+		for _, field := range document {
+			path := joinPath(prefix, field.Key)
+			if strategy, ok := s.fields[path]; ok {
+				if strategy != mergeIgnore {
+					addField(strategy, path, field.Value)
+				}
+				continue
+			}
+			if s.parents[path] {
+				if subDocument, ok := asDocument(field.Value); ok {
+					addDocument(path, subDocument)
+					continue
+				}
+			}
+			addField("$set", path, field.Value)
+		}
+	}
+	addDocument("", document)
+	return update
+}
+
+// asDocument returns the value as a document if it is one.
+func asDocument(value interface{}) (bson.D, bool) {
+	switch v := value.(type) {
+	case bson.D:
+		return v, true
+	case *bson.D:
+		return *v, true
+	}
+	return nil, false
+}
+
+// asArray returns the value as an array if it is one.
+func asArray(value interface{}) (bson.A, bool) {
+	switch v := value.(type) {
+	case bson.A:
+		return v, true
+	case *bson.A:
+		return *v, true
+	case []interface{}:
+		return bson.A(v), true
+	}
+	return nil, false
+}
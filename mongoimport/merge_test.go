@@ -0,0 +1,90 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMergeStrategies(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With merge strategies for some fields", t, func() {
+		strategies, err := newMergeStrategies(bson.D{
+			{"visits", "$inc"},
+			{"stats.lastSeen", "$max"},
+			{"stats.firstSeen", "$min"},
+			{"tags", "$addToSet"},
+			{"created", "$setOnInsert"},
+			{"scratch", "ignore"},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("fields should be grouped under the operator of their strategy", func() {
+			update := strategies.updateDocument(bson.D{
+				{"_id", int32(1)},
+				{"visits", int32(3)},
+				{"stats", &bson.D{{"lastSeen", int32(10)}, {"firstSeen", int32(2)}, {"source", "feed"}}},
+				{"tags", bson.A{"a", "b"}},
+				{"created", "today"},
+				{"scratch", true},
+				{"name", "x"},
+			})
+			So(update, ShouldResemble, bson.D{
+				{"$set", bson.D{{"_id", int32(1)}, {"stats.source", "feed"}, {"name", "x"}}},
+				{"$inc", bson.D{{"visits", int32(3)}}},
+				{"$max", bson.D{{"stats.lastSeen", int32(10)}}},
+				{"$min", bson.D{{"stats.firstSeen", int32(2)}}},
+				{"$addToSet", bson.D{{"tags", bson.D{{"$each", bson.A{"a", "b"}}}}}},
+				{"$setOnInsert", bson.D{{"created", "today"}}},
+			})
+		})
+
+		Convey("single values should be added to sets as they are", func() {
+			update := strategies.updateDocument(bson.D{{"tags", "a"}})
+			So(update, ShouldResemble, bson.D{{"$addToSet", bson.D{{"tags", "a"}}}})
+		})
+
+		Convey("documents without fields with a strategy should be set whole", func() {
+			update := strategies.updateDocument(bson.D{{"stats", "none"}, {"other", bson.D{{"a", int32(1)}}}})
+			So(update, ShouldResemble, bson.D{{"$set", bson.D{{"stats", "none"}, {"other", bson.D{{"a", int32(1)}}}}}})
+		})
+
+		Convey("documents with only ignored fields should have an empty update", func() {
+			So(strategies.updateDocument(bson.D{{"scratch", int32(1)}}), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Invalid merge strategies should be errors", t, func() {
+		for _, doc := range []bson.D{
+			{{"a", "$push"}},
+			{{"a", int32(1)}},
+			{{"a", "$inc"}, {"a.b", "$max"}},
+		} {
+			_, err := newMergeStrategies(doc)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Merge strategies should be read from an extended JSON file", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport_merge")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "strategies.json")
+		So(ioutil.WriteFile(path, []byte(`{"count": "$inc", "note": "ignore"}`), 0644), ShouldBeNil)
+		strategies, err := readMergeStrategiesFile(path)
+		So(err, ShouldBeNil)
+		So(strategies.fields, ShouldResemble, map[string]string{"count": "$inc", "note": "ignore"})
+	})
+}
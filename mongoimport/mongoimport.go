@@ -4,14 +4,13 @@
 // not use this file except in compliance with the License. You may obtain
 // a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
 
-// Package mongoimport allows importing content from a JSON, CSV, or TSV into a MongoDB instance.
+// Package mongoimport allows importing content from a JSON, CSV, TSV, or Parquet file into a MongoDB instance.
 package mongoimport
 
 import (
 	"github.com/mongodb/mongo-tools-common/db"
 	"github.com/mongodb/mongo-tools-common/log"
 	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/progress"
 	"github.com/mongodb/mongo-tools-common/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -24,13 +23,15 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Input format types accepted by mongoimport.
 const (
-	CSV  = "csv"
-	TSV  = "tsv"
-	JSON = "json"
+	CSV     = "csv"
+	TSV     = "tsv"
+	JSON    = "json"
+	PARQUET = "parquet"
 )
 
 // Modes accepted by mongoimport.
@@ -39,11 +40,13 @@ const (
 	modeUpsert = "upsert"
 	modeMerge  = "merge"
 	modeDelete = "delete"
+	modeCDC    = "cdc"
 )
 
 const (
-	workerBufferSize  = 16
-	progressBarLength = 24
+	workerBufferSize    = 16
+	progressBarLength   = 24
+	progressBarWaitTime = time.Second * 3
 )
 
 // MongoImport is a container for the user-specified options and
@@ -55,6 +58,7 @@ type MongoImport struct {
 	processedCount uint64
 
 	// failureCount keeps track of how many documents have failed to be inserted into the database.
+	// Records rejected while parsing are counted by the rejectWriter and added at the end.
 	// Should be updated atomically.
 	failureCount uint64
 
@@ -79,6 +83,24 @@ type MongoImport struct {
 
 	// type of node the SessionProvider is connected to
 	nodeType db.NodeType
+
+	// rejects is where records that fail to import are written with --rejectsFile
+	rejects *rejectWriter
+
+	// inferredFields are the typed fields inferred with --inferSchema=import
+	inferredFields []string
+
+	// schema is the schema documents are validated against with --validateSchema
+	schema *documentSchema
+
+	// inputFiles are the files to import from, with globs and directories expanded
+	inputFiles []string
+
+	// mapping builds documents from the columns of CSV and TSV input with --mappingFile
+	mapping *fieldMapping
+
+	// mergeStrategies are how fields are merged with --mode=merge and --mergeStrategies
+	mergeStrategies *mergeStrategies
 }
 
 type InputReader interface {
@@ -112,6 +134,28 @@ func New(opts Options) (*MongoImport, error) {
 	if err := mi.validateSettings(opts.ParsedArgs); err != nil {
 		return nil, fmt.Errorf("error validating settings: %v", err)
 	}
+	if opts.ValidateSchema != "" {
+		schema, err := readSchemaFile(opts.ValidateSchema)
+		if err != nil {
+			return nil, fmt.Errorf("error reading --validateSchema file: %v", err)
+		}
+		mi.schema = schema
+	}
+	if opts.MappingFile != "" {
+		mapping, err := readMappingFile(opts.MappingFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading --mappingFile: %v", err)
+		}
+		mapping.parseGrace = ParsePG(opts.ParseGrace)
+		mi.mapping = mapping
+	}
+	if opts.MergeStrategies != "" {
+		strategies, err := readMergeStrategiesFile(opts.MergeStrategies)
+		if err != nil {
+			return nil, fmt.Errorf("error reading --mergeStrategies file: %v", err)
+		}
+		mi.mergeStrategies = strategies
+	}
 
 	sessionProvider, err := db.NewSessionProvider(*opts.ToolOptions)
 	if err != nil {
@@ -139,6 +183,15 @@ func (imp *MongoImport) validateSettings(args []string) error {
 		return fmt.Errorf("invalid database name: %v", err)
 	}
 
+	paths := imp.InputOptions.Files
+	if imp.InputOptions.File != "" {
+		paths = append([]string{imp.InputOptions.File}, paths...)
+	}
+	imp.inputFiles, err = expandInputFiles(paths)
+	if err != nil {
+		return err
+	}
+
 	imp.InputOptions.Type = strings.ToLower(imp.InputOptions.Type)
 	// use JSON as default input type
 	if imp.InputOptions.Type == "" {
@@ -146,7 +199,8 @@ func (imp *MongoImport) validateSettings(args []string) error {
 	} else {
 		if !(imp.InputOptions.Type == TSV ||
 			imp.InputOptions.Type == JSON ||
-			imp.InputOptions.Type == CSV) {
+			imp.InputOptions.Type == CSV ||
+			imp.InputOptions.Type == PARQUET) {
 			return fmt.Errorf("unknown type %v", imp.InputOptions.Type)
 		}
 	}
@@ -182,6 +236,47 @@ func (imp *MongoImport) validateSettings(args []string) error {
 		if imp.InputOptions.Legacy {
 			return fmt.Errorf("cannot use --legacy if input type is not JSON")
 		}
+		if imp.InputOptions.InferSchema != "" {
+			if imp.InputOptions.ColumnsHaveTypes {
+				return fmt.Errorf("incompatible options: --inferSchema and --columnsHaveTypes")
+			}
+			if imp.InputOptions.InferSampleSize < 1 {
+				return fmt.Errorf("--inferSampleSize must be greater than 0")
+			}
+		}
+	} else if imp.InputOptions.Type == PARQUET {
+		if len(imp.inputFiles) == 0 {
+			return fmt.Errorf("parquet input must be read from a file; use --file")
+		}
+		if imp.InputOptions.HeaderLine {
+			return fmt.Errorf("can not use --headerline when input type is parquet")
+		}
+		if imp.InputOptions.FieldFile != nil &&
+			*imp.InputOptions.FieldFile == "" {
+			return fmt.Errorf("--fieldFile can not be empty string")
+		}
+		if imp.InputOptions.Fields != nil &&
+			imp.InputOptions.FieldFile != nil {
+			return fmt.Errorf("incompatible options: --fields and --fieldFile")
+		}
+		if imp.InputOptions.JSONArray {
+			return fmt.Errorf("can not use --jsonArray when input type is parquet")
+		}
+		if imp.IngestOptions.IgnoreBlanks {
+			return fmt.Errorf("can not use --ignoreBlanks when input type is parquet")
+		}
+		if imp.InputOptions.ColumnsHaveTypes {
+			return fmt.Errorf("can not use --columnsHaveTypes when input type is parquet")
+		}
+		if imp.InputOptions.Legacy {
+			return fmt.Errorf("cannot use --legacy if input type is not JSON")
+		}
+		if imp.InputOptions.InferSchema != "" {
+			return fmt.Errorf("can not use --inferSchema when input type is parquet")
+		}
+		if imp.InputOptions.MappingFile != "" {
+			return fmt.Errorf("can not use --mappingFile when input type is parquet")
+		}
 	} else {
 		// input type is JSON
 		if imp.InputOptions.HeaderLine {
@@ -199,6 +294,12 @@ func (imp *MongoImport) validateSettings(args []string) error {
 		if imp.InputOptions.ColumnsHaveTypes {
 			return fmt.Errorf("can not use --columnsHaveTypes when input type is JSON")
 		}
+		if imp.InputOptions.InferSchema != "" {
+			return fmt.Errorf("can not use --inferSchema when input type is JSON")
+		}
+		if imp.InputOptions.MappingFile != "" {
+			return fmt.Errorf("can not use --mappingFile when input type is JSON")
+		}
 	}
 
 	// deprecated
@@ -230,11 +331,29 @@ func (imp *MongoImport) validateSettings(args []string) error {
 	if !(imp.IngestOptions.Mode == modeInsert ||
 		imp.IngestOptions.Mode == modeUpsert ||
 		imp.IngestOptions.Mode == modeDelete ||
-		imp.IngestOptions.Mode == modeMerge) {
+		imp.IngestOptions.Mode == modeMerge ||
+		imp.IngestOptions.Mode == modeCDC) {
 		return fmt.Errorf("invalid --mode argument: %v", imp.IngestOptions.Mode)
 	}
 
-	if imp.IngestOptions.Mode != modeInsert {
+	if imp.IngestOptions.MergeStrategies != "" && imp.IngestOptions.Mode != modeMerge {
+		return fmt.Errorf("--mergeStrategies can only be used with --mode=merge")
+	}
+
+	if imp.IngestOptions.Mode == modeCDC {
+		if imp.InputOptions.Type != JSON {
+			return fmt.Errorf("--mode=cdc can only be used with JSON input")
+		}
+		if imp.IngestOptions.ValidateSchema != "" {
+			return fmt.Errorf("incompatible options: --mode=cdc and --validateSchema")
+		}
+		// events are read in order and applied in order for each document
+		// key, so several insertion workers can be used. Applying the events
+		// after a failed one could leave documents in the wrong state, so
+		// --mode=cdc implies --stopOnError.
+		imp.IngestOptions.StopOnError = true
+		log.Logvf(log.Info, "using upsert fields: %v", imp.upsertFields)
+	} else if imp.IngestOptions.Mode != modeInsert {
 		imp.IngestOptions.MaintainInsertionOrder = true
 		log.Logvf(log.Info, "using upsert fields: %v", imp.upsertFields)
 	}
@@ -263,7 +382,15 @@ func (imp *MongoImport) validateSettings(args []string) error {
 	// ensure we have a valid string to use for the collection
 	if imp.ToolOptions.Collection == "" {
 		log.Logvf(log.Always, "no collection specified")
-		fileBaseName := filepath.Base(imp.InputOptions.File)
+		fileName := imp.InputOptions.File
+		if len(imp.inputFiles) > 0 {
+			fileName = imp.inputFiles[0]
+		}
+		fileBaseName := filepath.Base(fileName)
+		// data.csv.gz is imported into data, like data.csv
+		if _, ok := compressionExtensions[strings.ToLower(filepath.Ext(fileBaseName))]; ok {
+			fileBaseName = strings.TrimSuffix(fileBaseName, filepath.Ext(fileBaseName))
+		}
 		lastDotIndex := strings.LastIndex(fileBaseName, ".")
 		if lastDotIndex != -1 {
 			fileBaseName = fileBaseName[0:lastDotIndex]
@@ -278,27 +405,41 @@ func (imp *MongoImport) validateSettings(args []string) error {
 	return nil
 }
 
-// getSourceReader returns an io.Reader to read from the input source. Also
-// returns a progress.Progressor which can be used to track progress if the
-// reader supports it.
-func (imp *MongoImport) getSourceReader() (io.ReadCloser, int64, error) {
-	if imp.InputOptions.File != "" {
-		file, err := os.Open(util.ToUniversalPath(imp.InputOptions.File))
+// getSourceReader returns an io.Reader to read from the given input file, or
+// from stdin if the file name is empty. Compressed input is decompressed as
+// it is read, except for parquet files which are read in place. Also returns
+// the size of the file, which can be used to track progress.
+func (imp *MongoImport) getSourceReader(path string) (io.ReadCloser, int64, error) {
+	if path != "" {
+		file, err := os.Open(util.ToUniversalPath(path))
 		if err != nil {
 			return nil, -1, err
 		}
 		fileStat, err := file.Stat()
 		if err != nil {
+			file.Close()
 			return nil, -1, err
 		}
 		log.Logvf(log.Info, "filesize: %v bytes", fileStat.Size())
-		return file, int64(fileStat.Size()), err
+		if imp.InputOptions.Type == PARQUET {
+			return file, int64(fileStat.Size()), nil
+		}
+		source, err := newDecompressingReader(path, file)
+		if err != nil {
+			file.Close()
+			return nil, -1, err
+		}
+		return source, int64(fileStat.Size()), nil
 	}
 
 	log.Logvf(log.Info, "reading from stdin")
 
+	source, err := newDecompressingReader("", os.Stdin)
+	if err != nil {
+		return nil, -1, err
+	}
 	// Stdin has undefined max size, so return 0
-	return os.Stdin, 0, nil
+	return source, 0, nil
 }
 
 // fileSizeProgressor implements Progressor to allow a sizeTracker to hook up with a
@@ -315,47 +456,51 @@ func (fsp *fileSizeProgressor) Progress() (int64, int64) {
 // ImportDocuments is used to write input data to the database. It returns the
 // number of documents successfully imported to the appropriate namespace,
 // the number of failures, and any error encountered in doing this
-func (imp *MongoImport) ImportDocuments() (uint64, uint64, error) {
-	source, fileSize, err := imp.getSourceReader()
-	if err != nil {
-		return 0, 0, err
+func (imp *MongoImport) ImportDocuments() (numImported uint64, numFailed uint64, err error) {
+	files := imp.inputSources()
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if _, err = os.Stat(file); err != nil {
+			return 0, 0, err
+		}
 	}
-	defer source.Close()
 
-	inputReader, err := imp.getInputReader(source)
-	if err != nil {
-		return 0, 0, err
+	if imp.InputOptions.RejectsFile != "" {
+		imp.rejects, err = newRejectWriter(imp.InputOptions.RejectsFile)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer func() {
+			numRejects, closeErr := imp.rejects.close()
+			if err == nil {
+				err = closeErr
+			}
+			log.Logvf(log.Always, "%v rejected record(s) written to %v", numRejects, imp.InputOptions.RejectsFile)
+		}()
 	}
 
-	if imp.InputOptions.HeaderLine {
-		if imp.InputOptions.ColumnsHaveTypes {
-			err = inputReader.ReadAndValidateTypedHeader(ParsePG(imp.InputOptions.ParseGrace))
-		} else {
-			err = inputReader.ReadAndValidateHeader()
-		}
+	var stdin io.Reader
+	if imp.InputOptions.InferSchema == inferImport {
+		imp.inferredFields, stdin, err = imp.inferFieldsFromFiles(files)
 		if err != nil {
 			return 0, 0, err
 		}
+		log.Logvf(log.Always, "using inferred fields: %v", strings.Join(imp.inferredFields, ","))
 	}
-
-	bar := &progress.Bar{
-		Name:      fmt.Sprintf("%v.%v", imp.ToolOptions.DB, imp.ToolOptions.Collection),
-		Watching:  &fileSizeProgressor{fileSize, inputReader},
-		Writer:    log.Writer(0),
-		BarLength: progressBarLength,
-		IsBytes:   true,
+	if len(files) > 1 {
+		log.Logvf(log.Always, "importing %v files", len(files))
 	}
-	bar.Start()
-	defer bar.Stop()
-	return imp.importDocuments(inputReader)
+	return imp.importDocuments(files, stdin)
 }
 
 // importDocuments is a helper to ImportDocuments and does all the ingestion
-// work by taking data from the inputReader source and writing it to the
+// work by taking data from the input files and writing it to the
 // appropriate namespace. It returns the number of documents successfully
 // imported to the appropriate namespace, the number of failures, and any error
 // encountered in doing this
-func (imp *MongoImport) importDocuments(inputReader InputReader) (uint64, uint64, error) {
+func (imp *MongoImport) importDocuments(files []string, stdin io.Reader) (uint64, uint64, error) {
 	session, err := imp.SessionProvider.GetSession()
 	if err != nil {
 		return 0, 0, err
@@ -388,11 +533,10 @@ func (imp *MongoImport) importDocuments(inputReader InputReader) (uint64, uint64
 
 	readDocs := make(chan bson.D, workerBufferSize)
 	processingErrChan := make(chan error)
-	ordered := imp.IngestOptions.MaintainInsertionOrder
 
-	// read and process from the input reader
+	// read and process from the input files
 	go func() {
-		processingErrChan <- inputReader.StreamDocument(ordered, readDocs)
+		processingErrChan <- imp.streamFiles(files, stdin, readDocs)
 	}()
 
 	// insert documents into the target database
@@ -402,14 +546,14 @@ func (imp *MongoImport) importDocuments(inputReader InputReader) (uint64, uint64
 
 	e1 := channelQuorumError(processingErrChan, 2)
 	processedCount := atomic.LoadUint64(&imp.processedCount)
-	failureCount := atomic.LoadUint64(&imp.failureCount)
+	failureCount := atomic.LoadUint64(&imp.failureCount) + imp.rejects.recordCount()
 	return processedCount, failureCount, e1
 }
 
 // ingestDocuments accepts a channel from which it reads documents to be inserted
 // into the target collection. It spreads the insert/upsert workload across one
 // or more workers.
-func (imp *MongoImport) ingestDocuments(readDocs chan bson.D) (retErr error) {
+func (imp *MongoImport) ingestDocuments(readDocs chan bson.D) error {
 	numInsertionWorkers := imp.IngestOptions.NumInsertionWorkers
 	if numInsertionWorkers <= 0 {
 		numInsertionWorkers = 1
@@ -423,18 +567,29 @@ func (imp *MongoImport) ingestDocuments(readDocs chan bson.D) (retErr error) {
 	// 3. There is an insertion/update error - e.g. duplicate key
 	//    error - and stopOnError is set to true
 
+	if imp.IngestOptions.Mode == modeCDC {
+		return imp.ingestChangeEvents(readDocs, numInsertionWorkers)
+	}
+	return imp.runWorkers(numInsertionWorkers, func(int) error {
+		return imp.runInsertionWorker(readDocs)
+	})
+}
+
+// runWorkers runs the given number of insertion workers and returns the
+// first error of any of them, which stops the others.
+func (imp *MongoImport) runWorkers(numWorkers int, worker func(int) error) (retErr error) {
 	wg := new(sync.WaitGroup)
-	for i := 0; i < numInsertionWorkers; i++ {
+	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
-		go func() {
+		go func(i int) {
 			defer wg.Done()
 			// only set the first insertion error and cause sibling goroutines to terminate immediately
-			err := imp.runInsertionWorker(readDocs)
+			err := worker(i)
 			if err != nil && retErr == nil {
 				retErr = err
 				imp.Kill(err)
 			}
-		}()
+		}(i)
 	}
 	wg.Wait()
 	return
@@ -443,16 +598,10 @@ func (imp *MongoImport) ingestDocuments(readDocs chan bson.D) (retErr error) {
 // runInsertionWorker is a helper to InsertDocuments - it reads document off
 // the read channel and prepares then in batches for insertion into the database
 func (imp *MongoImport) runInsertionWorker(readDocs chan bson.D) (err error) {
-	session, err := imp.SessionProvider.GetSession()
+	inserter, _, err := imp.newInserter()
 	if err != nil {
-		return fmt.Errorf("error connecting to mongod: %v", err)
+		return err
 	}
-	collection := session.Database(imp.ToolOptions.DB).Collection(imp.ToolOptions.Collection)
-
-	inserter := db.NewUnorderedBufferedBulkInserter(collection, imp.IngestOptions.BulkBufferSize).
-		SetBypassDocumentValidation(imp.IngestOptions.BypassDocumentValidation).
-		SetOrdered(imp.IngestOptions.MaintainInsertionOrder).
-		SetUpsert(true)
 
 readLoop:
 	for {
@@ -461,7 +610,14 @@ readLoop:
 			if !alive {
 				break readLoop
 			}
-			err := imp.importDocument(inserter, document)
+			valid, err := imp.validateDocument(document)
+			if err != nil {
+				return err
+			}
+			if !valid {
+				continue
+			}
+			err = imp.importDocument(inserter, document)
 			if db.FilterError(imp.IngestOptions.StopOnError, err) != nil {
 				return err
 			}
@@ -469,8 +625,32 @@ readLoop:
 			return nil
 		}
 	}
+	return imp.flushInserter(inserter)
+}
+
+// newInserter returns a bulk inserter of the target collection for an
+// insertion worker, along with the collection.
+func (imp *MongoImport) newInserter() (*db.BufferedBulkInserter, *mongo.Collection, error) {
+	session, err := imp.SessionProvider.GetSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to mongod: %v", err)
+	}
+	collection := session.Database(imp.ToolOptions.DB).Collection(imp.ToolOptions.Collection)
+
+	inserter := db.NewUnorderedBufferedBulkInserter(collection, imp.IngestOptions.BulkBufferSize).
+		SetBypassDocumentValidation(imp.IngestOptions.BypassDocumentValidation).
+		SetOrdered(imp.orderedInput()).
+		SetUpsert(true)
+	return inserter, collection, nil
+}
+
+// flushInserter writes the documents left in the inserter of a worker.
+func (imp *MongoImport) flushInserter(inserter *db.BufferedBulkInserter) error {
 	result, err := inserter.Flush()
 	imp.updateCounts(result, err)
+	if rejectErr := imp.rejects.rejectWrites(err); rejectErr != nil {
+		return rejectErr
+	}
 	return db.FilterError(imp.IngestOptions.StopOnError, err)
 }
 
@@ -483,6 +663,37 @@ func (imp *MongoImport) updateCounts(result *mongo.BulkWriteResult, err error) {
 	}
 }
 
+// orderedInput returns whether documents must be read and written in the
+// order of the input, which is the case with --maintainInsertionOrder and
+// for the change events of --mode=cdc.
+func (imp *MongoImport) orderedInput() bool {
+	return imp.IngestOptions.MaintainInsertionOrder || imp.IngestOptions.Mode == modeCDC
+}
+
+// validateDocument checks a document against --validateSchema, handling a
+// failure according to --validationGrace. It returns whether the document
+// should be imported.
+func (imp *MongoImport) validateDocument(document bson.D) (bool, error) {
+	if imp.schema == nil {
+		return true, nil
+	}
+	err := imp.schema.validateDocument(document)
+	if err == nil {
+		return true, nil
+	}
+	switch imp.IngestOptions.ValidationGrace {
+	case validationWarn:
+		log.Logvf(log.Always, "document failed validation: %v", err)
+		return true, nil
+	case validationSkip:
+		log.Logvf(log.Info, "skipping document that failed validation: %v", err)
+		atomic.AddUint64(&imp.failureCount, 1)
+		return false, imp.rejects.rejectDocument(document, err)
+	}
+	atomic.AddUint64(&imp.failureCount, 1)
+	return false, fmt.Errorf("document failed validation: %v", err)
+}
+
 func (imp *MongoImport) importDocument(inserter *db.BufferedBulkInserter, document bson.D) error {
 	var result *mongo.BulkWriteResult
 	var err error
@@ -502,6 +713,13 @@ func (imp *MongoImport) importDocument(inserter *db.BufferedBulkInserter, docume
 			imp.fallbackToInsert(inserter, document)
 		} else {
 			updateDoc := bson.D{{"$set", document}}
+			if imp.mergeStrategies != nil {
+				updateDoc = imp.mergeStrategies.updateDocument(document)
+			}
+			if len(updateDoc) == 0 {
+				log.Logvf(log.Info, "every field of the document is ignored, skipping document")
+				return nil
+			}
 			result, err = inserter.Update(selector, updateDoc)
 		}
 	} else if imp.IngestOptions.Mode == modeDelete {
@@ -516,6 +734,9 @@ func (imp *MongoImport) importDocument(inserter *db.BufferedBulkInserter, docume
 
 	// Update success and failure counts
 	imp.updateCounts(result, err)
+	if rejectErr := imp.rejects.rejectWrites(err); rejectErr != nil {
+		return rejectErr
+	}
 
 	return err
 }
@@ -546,20 +767,28 @@ func splitInlineHeader(header string) (headers []string) {
 	return
 }
 
-// getInputReader returns an implementation of InputReader based on the input type
-func (imp *MongoImport) getInputReader(in io.Reader) (InputReader, error) {
-	var colSpecs []ColumnSpec
-	var headers []string
-	var err error
+// getFieldNames returns the fields given by --fields or --fieldFile, if any.
+func (imp *MongoImport) getFieldNames() ([]string, error) {
 	if imp.InputOptions.Fields != nil {
-		headers = splitInlineHeader(*imp.InputOptions.Fields)
+		return splitInlineHeader(*imp.InputOptions.Fields), nil
 	} else if imp.InputOptions.FieldFile != nil {
-		headers, err = util.GetFieldsFromFile(*imp.InputOptions.FieldFile)
-		if err != nil {
-			return nil, err
-		}
+		return util.GetFieldsFromFile(*imp.InputOptions.FieldFile)
+	}
+	return nil, nil
+}
+
+// getInputReader returns an implementation of InputReader based on the input type,
+// reading the given input file, which is empty for stdin
+func (imp *MongoImport) getInputReader(in io.Reader, file string) (InputReader, error) {
+	var colSpecs []ColumnSpec
+	headers, err := imp.getFieldNames()
+	if err != nil {
+		return nil, err
+	}
+	if imp.inferredFields != nil {
+		headers = imp.inferredFields
 	}
-	if imp.InputOptions.ColumnsHaveTypes {
+	if imp.InputOptions.ColumnsHaveTypes || imp.inferredFields != nil {
 		colSpecs, err = ParseTypedHeaders(headers, ParsePG(imp.InputOptions.ParseGrace))
 		if err != nil {
 			return nil, err
@@ -569,7 +798,7 @@ func (imp *MongoImport) getInputReader(in io.Reader) (InputReader, error) {
 	}
 
 	// header fields validation can only happen once we have an input reader
-	if !imp.InputOptions.HeaderLine {
+	if !imp.InputOptions.HeaderLine || imp.inferredFields != nil {
 		if err = validateReaderFields(ColumnNames(colSpecs), imp.InputOptions.UseArrayIndexFields); err != nil {
 			return nil, err
 		}
@@ -579,9 +808,24 @@ func (imp *MongoImport) getInputReader(in io.Reader) (InputReader, error) {
 
 	ignoreBlanks := imp.IngestOptions.IgnoreBlanks && imp.InputOptions.Type != JSON
 	if imp.InputOptions.Type == CSV {
-		return NewCSVInputReader(colSpecs, in, out, imp.IngestOptions.NumDecodingWorkers, ignoreBlanks, imp.InputOptions.UseArrayIndexFields), nil
+		r := NewCSVInputReader(colSpecs, in, out, imp.IngestOptions.NumDecodingWorkers, ignoreBlanks, imp.InputOptions.UseArrayIndexFields)
+		r.rejects = imp.rejects.forFile(file)
+		r.mapping = imp.mapping
+		return r, nil
 	} else if imp.InputOptions.Type == TSV {
-		return NewTSVInputReader(colSpecs, in, out, imp.IngestOptions.NumDecodingWorkers, ignoreBlanks, imp.InputOptions.UseArrayIndexFields), nil
+		r := NewTSVInputReader(colSpecs, in, out, imp.IngestOptions.NumDecodingWorkers, ignoreBlanks, imp.InputOptions.UseArrayIndexFields)
+		r.rejects = imp.rejects.forFile(file)
+		r.mapping = imp.mapping
+		return r, nil
+	} else if imp.InputOptions.Type == PARQUET {
+		r, err := NewParquetInputReader(ColumnNames(colSpecs), in, imp.IngestOptions.NumDecodingWorkers)
+		if err != nil {
+			return nil, err
+		}
+		r.rejects = imp.rejects.forFile(file)
+		return r, nil
 	}
-	return NewJSONInputReader(imp.InputOptions.JSONArray, imp.InputOptions.Legacy, in, imp.IngestOptions.NumDecodingWorkers), nil
+	r := NewJSONInputReader(imp.InputOptions.JSONArray, imp.InputOptions.Legacy, in, imp.IngestOptions.NumDecodingWorkers)
+	r.rejects = imp.rejects.forFile(file)
+	return r, nil
 }
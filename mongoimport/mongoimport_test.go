@@ -271,6 +271,79 @@ func TestMongoImportValidateSettings(t *testing.T) {
 			So(imp.validateSettings([]string{}), ShouldNotBeNil)
 		})
 
+		Convey("parquet input should be read from a file, optionally with --fields", func() {
+			imp := NewMockMongoImport()
+			imp.InputOptions.Type = PARQUET
+			So(imp.validateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.File = "testdata/test.parquet"
+			So(imp.validateSettings([]string{}), ShouldBeNil)
+			fields := "name,address"
+			imp.InputOptions.Fields = &fields
+			So(imp.validateSettings([]string{}), ShouldBeNil)
+		})
+
+		Convey("an error should be thrown if --headerline or --ignoreBlanks is used with parquet input", func() {
+			imp := NewMockMongoImport()
+			imp.InputOptions.Type = PARQUET
+			imp.InputOptions.File = "testdata/test.parquet"
+			imp.InputOptions.HeaderLine = true
+			So(imp.validateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.HeaderLine = false
+			imp.IngestOptions.IgnoreBlanks = true
+			So(imp.validateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("--inferSchema should only be used with untyped CSV or TSV fields", func() {
+			imp := NewMockMongoImport()
+			imp.InputOptions.Type = CSV
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.InferSchema = inferImport
+			imp.InputOptions.InferSampleSize = 10
+			So(imp.validateSettings([]string{}), ShouldBeNil)
+			imp.InputOptions.ColumnsHaveTypes = true
+			So(imp.validateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.ColumnsHaveTypes = false
+			imp.InputOptions.InferSampleSize = 0
+			So(imp.validateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.InferSampleSize = 10
+			imp.InputOptions.Type = JSON
+			imp.InputOptions.HeaderLine = false
+			So(imp.validateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("--mergeStrategies should only be used with --mode=merge", func() {
+			imp := NewMockMongoImport()
+			imp.IngestOptions.Mode = modeMerge
+			imp.IngestOptions.MergeStrategies = "strategies.json"
+			So(imp.validateSettings([]string{}), ShouldBeNil)
+			imp.IngestOptions.Mode = modeUpsert
+			So(imp.validateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("--mode=cdc should only be used with JSON input and allow several insertion workers", func() {
+			imp := NewMockMongoImport()
+			imp.IngestOptions.Mode = modeCDC
+			imp.IngestOptions.NumInsertionWorkers = 4
+			So(imp.validateSettings([]string{}), ShouldBeNil)
+			So(imp.IngestOptions.NumInsertionWorkers, ShouldEqual, 4)
+			So(imp.IngestOptions.StopOnError, ShouldBeTrue)
+			So(imp.orderedInput(), ShouldBeTrue)
+			imp.InputOptions.Type = CSV
+			imp.InputOptions.HeaderLine = true
+			So(imp.validateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("--mappingFile should only be used with CSV or TSV input", func() {
+			imp := NewMockMongoImport()
+			imp.InputOptions.Type = TSV
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.MappingFile = "mapping.json"
+			So(imp.validateSettings([]string{}), ShouldBeNil)
+			imp.InputOptions.Type = JSON
+			imp.InputOptions.HeaderLine = false
+			So(imp.validateSettings([]string{}), ShouldNotBeNil)
+		})
+
 		Convey("no error should be thrown if --headerline is not supplied "+
 			"but --fieldFile is supplied", func() {
 			imp := NewMockMongoImport()
@@ -406,6 +479,22 @@ func TestMongoImportValidateSettings(t *testing.T) {
 			So(imp.ToolOptions.Namespace.Collection, ShouldEqual, "input")
 		})
 
+		Convey("the compression extension of a file should be removed along with "+
+			"its format extension from the collection name", func() {
+			for file, collection := range map[string]string{
+				"/path/to/x.csv.gz":  "x",
+				"/path/to/x.json.xz": "x",
+				"x.TSV.GZ":           "x",
+				"x.gz":               "x",
+			} {
+				imp := NewMockMongoImport()
+				imp.InputOptions.File = file
+				imp.ToolOptions.Namespace.Collection = ""
+				So(imp.validateSettings([]string{}), ShouldBeNil)
+				So(imp.ToolOptions.Namespace.Collection, ShouldEqual, collection)
+			}
+		})
+
 		Convey("error should be thrown if --legacy is specified and input type is not JSON", func() {
 			imp := NewMockMongoImport()
 			imp.InputOptions.Type = CSV
@@ -427,7 +516,7 @@ func TestGetSourceReader(t *testing.T) {
 				imp.InputOptions.File = "/path/to/input/file/dot/input.txt"
 				imp.InputOptions.Type = CSV
 				imp.ToolOptions.Namespace.Collection = ""
-				_, _, err := imp.getSourceReader()
+				_, _, err := imp.getSourceReader(imp.InputOptions.File)
 				So(err, ShouldNotBeNil)
 			})
 
@@ -435,14 +524,14 @@ func TestGetSourceReader(t *testing.T) {
 				imp := NewMockMongoImport()
 				imp.InputOptions.File = "testdata/test_array.json"
 				imp.InputOptions.Type = JSON
-				_, _, err := imp.getSourceReader()
+				_, _, err := imp.getSourceReader(imp.InputOptions.File)
 				So(err, ShouldBeNil)
 			})
 
 			Convey("no error should be thrown if stdin is used", func() {
 				imp := NewMockMongoImport()
 				imp.InputOptions.File = ""
-				_, _, err := imp.getSourceReader()
+				_, _, err := imp.getSourceReader(imp.InputOptions.File)
 				So(err, ShouldBeNil)
 			})
 		})
@@ -457,7 +546,7 @@ func TestGetInputReader(t *testing.T) {
 			*imp.InputOptions.Fields = "foo.auto(),bar.date(January 2, 2006)"
 			imp.InputOptions.File = "/path/to/input/file/dot/input.txt"
 			imp.InputOptions.ColumnsHaveTypes = true
-			_, err := imp.getInputReader(&os.File{})
+			_, err := imp.getInputReader(&os.File{}, "")
 			So(err, ShouldBeNil)
 		})
 		Convey("should complain about non-escaped new lines in --fields", func() {
@@ -466,14 +555,14 @@ func TestGetInputReader(t *testing.T) {
 			*imp.InputOptions.Fields = "foo.auto(),\nblah.binary(hex),bar.date(January 2, 2006)"
 			imp.InputOptions.File = "/path/to/input/file/dot/input.txt"
 			imp.InputOptions.ColumnsHaveTypes = true
-			_, err := imp.getInputReader(&os.File{})
+			_, err := imp.getInputReader(&os.File{}, "")
 			So(err, ShouldBeNil)
 		})
 		Convey("no error should be thrown if neither --fields nor --fieldFile "+
 			"is used", func() {
 			imp := NewMockMongoImport()
 			imp.InputOptions.File = "/path/to/input/file/dot/input.txt"
-			_, err := imp.getInputReader(&os.File{})
+			_, err := imp.getInputReader(&os.File{}, "")
 			So(err, ShouldBeNil)
 		})
 		Convey("no error should be thrown if --fields is used", func() {
@@ -481,7 +570,7 @@ func TestGetInputReader(t *testing.T) {
 			fields := "a,b,c"
 			imp.InputOptions.Fields = &fields
 			imp.InputOptions.File = "/path/to/input/file/dot/input.txt"
-			_, err := imp.getInputReader(&os.File{})
+			_, err := imp.getInputReader(&os.File{}, "")
 			So(err, ShouldBeNil)
 		})
 		Convey("no error should be thrown if --fieldFile is used and it "+
@@ -489,7 +578,7 @@ func TestGetInputReader(t *testing.T) {
 			imp := NewMockMongoImport()
 			fieldFile := "testdata/test.csv"
 			imp.InputOptions.FieldFile = &fieldFile
-			_, err := imp.getInputReader(&os.File{})
+			_, err := imp.getInputReader(&os.File{}, "")
 			So(err, ShouldBeNil)
 		})
 		Convey("an error should be thrown if --fieldFile is used and it "+
@@ -497,25 +586,25 @@ func TestGetInputReader(t *testing.T) {
 			imp := NewMockMongoImport()
 			fieldFile := "/path/to/input/file/dot/input.txt"
 			imp.InputOptions.FieldFile = &fieldFile
-			_, err := imp.getInputReader(&os.File{})
+			_, err := imp.getInputReader(&os.File{}, "")
 			So(err, ShouldNotBeNil)
 		})
 		Convey("no error should be thrown for CSV import inputs", func() {
 			imp := NewMockMongoImport()
 			imp.InputOptions.Type = CSV
-			_, err := imp.getInputReader(&os.File{})
+			_, err := imp.getInputReader(&os.File{}, "")
 			So(err, ShouldBeNil)
 		})
 		Convey("no error should be thrown for TSV import inputs", func() {
 			imp := NewMockMongoImport()
 			imp.InputOptions.Type = TSV
-			_, err := imp.getInputReader(&os.File{})
+			_, err := imp.getInputReader(&os.File{}, "")
 			So(err, ShouldBeNil)
 		})
 		Convey("no error should be thrown for JSON import inputs", func() {
 			imp := NewMockMongoImport()
 			imp.InputOptions.Type = JSON
-			_, err := imp.getInputReader(&os.File{})
+			_, err := imp.getInputReader(&os.File{}, "")
 			So(err, ShouldBeNil)
 		})
 		Convey("an error should be thrown if --fieldFile fields are invalid", func() {
@@ -524,7 +613,7 @@ func TestGetInputReader(t *testing.T) {
 			imp.InputOptions.FieldFile = &fieldFile
 			file, err := os.Open(fieldFile)
 			So(err, ShouldBeNil)
-			_, err = imp.getInputReader(file)
+			_, err = imp.getInputReader(file, "")
 			So(err, ShouldNotBeNil)
 		})
 		Convey("no error should be thrown if --fieldFile fields are valid", func() {
@@ -533,7 +622,7 @@ func TestGetInputReader(t *testing.T) {
 			imp.InputOptions.FieldFile = &fieldFile
 			file, err := os.Open(fieldFile)
 			So(err, ShouldBeNil)
-			_, err = imp.getInputReader(file)
+			_, err = imp.getInputReader(file, "")
 			So(err, ShouldBeNil)
 		})
 	})
@@ -14,9 +14,9 @@ import (
 	"github.com/mongodb/mongo-tools-common/options"
 )
 
-var Usage = `<options> <connection-string> <file> 
+var Usage = `<options> <connection-string> <file>...
 
-Import CSV, TSV or JSON data into MongoDB. If no file is provided, mongoimport reads from stdin.
+Import CSV, TSV, JSON or Parquet data into MongoDB. Files may be given as paths, directories or glob patterns, and gzip, zstd, bzip2 or xz compressed input is decompressed as it is read. If no file is provided, mongoimport reads from stdin.
 
 Connection strings must begin with mongodb:// or mongodb+srv://.
 
@@ -25,13 +25,22 @@ See http://docs.mongodb.org/manual/reference/program/mongoimport/ for more infor
 // InputOptions defines the set of options for reading input data.
 type InputOptions struct {
 	// Fields is an option to directly specify comma-separated fields to import to CSV.
-	Fields *string `long:"fields" value-name:"<field>[,<field>]*" short:"f" description:"comma separated list of fields, e.g. -f name,age"`
+	Fields *string `long:"fields" value-name:"<field>[,<field>]*" short:"f" description:"comma separated list of fields, e.g. -f name,age; for Parquet, the columns to import"`
 
 	// FieldFile is a filename that refers to a list of fields to import, 1 per line.
 	FieldFile *string `long:"fieldFile" value-name:"<filename>" description:"file with field names - 1 per line"`
 
+	// MappingFile is a filename that refers to a mapping that builds documents from the columns.
+	MappingFile string `long:"mappingFile" value-name:"<filename>" description:"extended JSON file describing how to build each document from the CSV or TSV columns; columns it does not use are not imported"`
+
 	// Specifies the location and name of a file containing the data to import.
-	File string `long:"file" value-name:"<filename>" description:"file to import from; if not specified, stdin is used"`
+	File string `long:"file" value-name:"<filename>" description:"file, directory or glob pattern to import from; if not specified, stdin is used"`
+
+	// Files holds the files given as positional arguments after the first.
+	Files []string `no-flag:"true"`
+
+	// Sets the number of input files read concurrently.
+	NumParallelFiles int `long:"numParallelFiles" value-name:"<number>" default:"4" description:"number of input files to read concurrently when importing from several files"`
 
 	// Treats the input source's first line as field list (csv and tsv only).
 	HeaderLine bool `long:"headerline" description:"use first line in input source as the field list (CSV and TSV only)"`
@@ -42,8 +51,8 @@ type InputOptions struct {
 	// Indicates how to handle type coercion failures
 	ParseGrace string `long:"parseGrace" value-name:"<grace>" default:"stop" description:"controls behavior when type coercion fails - one of: autoCast, skipField, skipRow, stop"`
 
-	// Specifies the file type to import. The default format is JSON, but it’s possible to import CSV and TSV files.
-	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"input format to import: json, csv, or tsv"`
+	// Specifies the file type to import. The default format is JSON, but it’s possible to import CSV, TSV and Parquet files.
+	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"input format to import: json, csv, tsv, or parquet"`
 
 	// Indicates that field names include type descriptions
 	ColumnsHaveTypes bool `long:"columnsHaveTypes" description:"indicates that the field list (from --fields, --fieldsFile, or --headerline) specifies types; They must be in the form of '<colName>.<type>(<arg>)'. The type can be one of: auto, binary, boolean, date, date_go, date_ms, date_oracle, decimal, double, int32, int64, string. For each of the date types, the argument is a datetime layout string. For the binary type, the argument can be one of: base32, base64, hex. All other types take an empty argument. Only valid for CSV and TSV imports. e.g. zipcode.string(), thumbnail.binary(base64)"`
@@ -51,6 +60,15 @@ type InputOptions struct {
 	// Indicates that the legacy extended JSON format should be used to parse JSON documents. Defaults to false.
 	Legacy bool `long:"legacy" description:"use the legacy extended JSON format"`
 
+	// Specifies a file to write the records that fail to import to.
+	RejectsFile string `long:"rejectsFile" value-name:"<filename>" description:"file to write rejected records to, one extended JSON document per line with the reason they were rejected. Records are rejected when the server fails to write them, when JSON or Parquet documents fail to parse, and when --parseGrace=skipRow skips CSV or TSV rows; other CSV and TSV rows that fail to parse are handled by --parseGrace instead. Records that fail to parse are written with the input file they came from"`
+
+	// Infers the type of each column of CSV and TSV input from a sample of rows.
+	InferSchema string `long:"inferSchema" value-name:"<mode>" optional:"true" optional-value:"print" choice:"print" choice:"import" description:"infer the type of each CSV or TSV column from a sample of rows, ignoring blank values. print: print the inferred fields as a field file for --fieldFile with --columnsHaveTypes, without importing. import: import using the inferred types. If flag is specified without a value, the fields are printed"`
+
+	// Sets the number of rows sampled by --inferSchema.
+	InferSampleSize int `long:"inferSampleSize" value-name:"<number>" default:"1000" description:"number of rows sampled by --inferSchema"`
+
 	UseArrayIndexFields bool `long:"useArrayIndexFields" description:"indicates that field names may include array indexes that should be used to construct arrays during import (e.g. foo.0,foo.1). Indexes must start from 0 and increase sequentially (foo.1,foo.0 would fail)."`
 }
 
@@ -82,14 +100,18 @@ type IngestOptions struct {
 	// "upsert": Insert new documents or replace existing ones.
 	// "merge": Insert new documents or modify existing ones; Preserve values in the database that are not overwritten.
 	// "delete": Skip new documents or delete existing ones that match --upsertFields.
+	// "cdc": Apply change events, in the change stream or Debezium format, in order for each document.
 	// We don't set `default: insert` here since we need to be able to set mode to upsert if --mode isn't set and --upsertFields is set.
-	Mode string `long:"mode" choice:"insert" choice:"upsert" choice:"merge" choice:"delete" description:"insert: insert only, skips matching documents. upsert: insert new documents or replace existing documents. merge: insert new documents or modify existing documents. delete: deletes matching documents only. If upsert fields match more than one document, only one document is deleted. (default: insert)"`
+	Mode string `long:"mode" choice:"insert" choice:"upsert" choice:"merge" choice:"delete" choice:"cdc" description:"insert: insert only, skips matching documents. upsert: insert new documents or replace existing documents. merge: insert new documents or modify existing documents. delete: deletes matching documents only. If upsert fields match more than one document, only one document is deleted. cdc: apply JSON change events, in the change stream or Debezium format, as inserts, replacements, updates and deletes, in order for each document key; implies --stopOnError. (default: insert)"`
 
 	Upsert bool `long:"upsert" hidden:"true" description:"(deprecated; same as --mode=upsert) insert or update objects that already exist"`
 
 	// Specifies a list of fields for the query portion of the upsert; defaults to _id field.
 	UpsertFields string `long:"upsertFields" value-name:"<field>[,<field>]*" description:"comma-separated fields for the query part when --mode is set to upsert or merge"`
 
+	// Specifies how each field is merged into existing documents with --mode=merge.
+	MergeStrategies string `long:"mergeStrategies" value-name:"<filename>" description:"extended JSON file mapping field paths to how they are merged with --mode=merge - one of $set, $inc, $max, $min, $addToSet, $setOnInsert, ignore; other fields are set"`
+
 	// Sets write concern level for write operations.
 	// By default mongoimport uses a write concern of 'majority'.
 	// Cannot be used simultaneously with write concern options in a URI.
@@ -98,6 +120,12 @@ type IngestOptions struct {
 	// Indicates that the server should bypass document validation on import.
 	BypassDocumentValidation bool `long:"bypassDocumentValidation" description:"bypass document validation"`
 
+	// Specifies a JSON Schema that documents are validated against before they are written.
+	ValidateSchema string `long:"validateSchema" value-name:"<filename>" description:"JSON Schema file, or file with a {$jsonSchema: <schema>} validator, to validate documents against before writing them, using the keywords that $jsonSchema supports"`
+
+	// Indicates how to handle documents that fail --validateSchema.
+	ValidationGrace string `long:"validationGrace" value-name:"<grace>" default:"stop" choice:"stop" choice:"skip" choice:"warn" description:"controls behavior when a document fails --validateSchema - one of: stop, skip (skip the document, writing it to --rejectsFile if set), warn (log the failure and import the document)"`
+
 	// Specifies the number of threads to use in processing data read from the input source
 	NumDecodingWorkers int `long:"numDecodingWorkers" default:"0" hidden:"true"`
 
@@ -132,13 +160,6 @@ func ParseOptions(rawArgs []string, versionStr, gitCommit string) (Options, erro
 		return Options{}, err
 	}
 
-	if len(extraArgs) > 1 {
-		return Options{}, fmt.Errorf("error parsing positional arguments: " +
-			"provide only one file name and only one MongoDB connection string. " +
-			"Connection strings must begin with mongodb:// or mongodb+srv:// schemes",
-		)
-	}
-
 	log.SetVerbosity(opts.Verbosity)
 	opts.URI.LogUnsupportedOptions()
 
@@ -156,8 +177,11 @@ func ParseOptions(rawArgs []string, versionStr, gitCommit string) (Options, erro
 
 	if inputOpts.File == "" {
 		if len(extraArgs) != 0 {
-			// if --file is not supplied, use the positional argument supplied
+			// if --file is not supplied, use the positional arguments supplied
 			inputOpts.File = extraArgs[0]
+			if len(extraArgs) > 1 {
+				inputOpts.Files = extraArgs[1:]
+			}
 		}
 	}
 
@@ -194,15 +194,31 @@ func TestPositionalArgumentParsing(t *testing.T) {
 			},
 			{
 				InputArgs: []string{"foo", "bar"},
-				ExpectErr: "error parsing positional arguments: " +
-					"provide only one file name and only one MongoDB connection string. " +
-					"Connection strings must begin with mongodb:// or mongodb+srv:// schemes",
+				ExpectedOpts: Options{
+					ToolOptions: &options.ToolOptions{
+						URI: &options.URI{
+							ConnectionString: "mongodb://localhost/",
+						},
+					},
+					InputOptions: &InputOptions{
+						File:  "foo",
+						Files: []string{"bar"},
+					},
+				},
 			},
 			{
 				InputArgs: []string{"foo", "bar", "mongodb://foo"},
-				ExpectErr: "error parsing positional arguments: " +
-					"provide only one file name and only one MongoDB connection string. " +
-					"Connection strings must begin with mongodb:// or mongodb+srv:// schemes",
+				ExpectedOpts: Options{
+					ToolOptions: &options.ToolOptions{
+						URI: &options.URI{
+							ConnectionString: "mongodb://foo",
+						},
+					},
+					InputOptions: &InputOptions{
+						File:  "foo",
+						Files: []string{"bar"},
+					},
+				},
 			},
 			{
 				InputArgs: []string{"mongodb://foo", "--uri=mongodb://bar"},
@@ -227,6 +243,7 @@ func TestPositionalArgumentParsing(t *testing.T) {
 			} else {
 				So(err, ShouldBeNil)
 				So(opts.File, ShouldEqual, tc.ExpectedOpts.File)
+				So(opts.Files, ShouldResemble, tc.ExpectedOpts.Files)
 				So(opts.ConnectionString, ShouldEqual, tc.ExpectedOpts.ConnectionString)
 			}
 
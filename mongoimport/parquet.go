@@ -0,0 +1,527 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// julianUnixEpoch is the Julian day number of 1970-01-01, used to convert
+// INT96 timestamps.
+const julianUnixEpoch = 2440588
+
+const millisPerDay = 24 * 60 * 60 * 1000
+
+// ParquetInputReader is an implementation of InputReader that reads documents
+// from a Parquet file, one row group at a time.
+type ParquetInputReader struct {
+	// file is the open Parquet file
+	file *goparquet.FileReader
+
+	// schema holds the fields of the columns to read
+	schema *parquetNode
+
+	// numProcessed indicates the number of rows processed
+	numProcessed uint64
+
+	// bytesRead is the compressed size of the row groups read so far
+	bytesRead int64
+
+	// numDecoders is the number of concurrent goroutines to use for decoding
+	numDecoders int
+
+	// rejects is where rows that fail to convert are written with --rejectsFile
+	rejects *recordRejects
+}
+
+// ParquetConverter implements the Converter interface for Parquet input.
+type ParquetConverter struct {
+	schema  *parquetNode
+	row     map[string]interface{}
+	index   uint64
+	rejects *recordRejects
+}
+
+// parquetNode is a field of the Parquet schema, limited to the columns being
+// read. The root node is the message, whose children are the top-level
+// fields.
+type parquetNode struct {
+	name     string
+	path     string
+	repeated bool
+	leaf     bool
+	logical  *parquet.LogicalType
+	children []*parquetNode
+}
+
+// NewParquetInputReader returns a ParquetInputReader for the given source,
+// which must support random access. If fields is non-empty, only the columns
+// under those fields are imported.
+func NewParquetInputReader(fields []string, in io.Reader, numDecoders int) (*ParquetInputReader, error) {
+	source, ok := in.(io.ReadSeeker)
+	if !ok {
+		return nil, fmt.Errorf("parquet input must be read from a file")
+	}
+	file, err := goparquet.NewFileReader(source, fields...)
+	if err != nil {
+		return nil, fmt.Errorf("error opening parquet file: %v", err)
+	}
+	schema, err := parquetSchema(file.GetSchemaDefinition().RootColumn, fields)
+	if err != nil {
+		return nil, err
+	}
+	return &ParquetInputReader{
+		file:        file,
+		schema:      schema,
+		numDecoders: numDecoders,
+	}, nil
+}
+
+// parquetSchema returns the fields at or under the given fields, or every
+// field if no fields are given.
+func parquetSchema(root *parquetschema.ColumnDefinition, fields []string) (*parquetNode, error) {
+	matched := make([]bool, len(fields))
+	schema := newParquetNode(root, "", fields, matched)
+	for i, field := range fields {
+		if !matched[i] {
+			return nil, fmt.Errorf("field '%v' is not in the parquet schema", field)
+		}
+	}
+	if schema == nil {
+		schema = &parquetNode{}
+	}
+	return schema, nil
+}
+
+// newParquetNode returns the node for a column and the children it has at
+// or under the given fields, or nil if it has none.
+func newParquetNode(column *parquetschema.ColumnDefinition, path string, fields []string, matched []bool) *parquetNode {
+	element := column.SchemaElement
+	node := &parquetNode{
+		name:     element.GetName(),
+		path:     path,
+		repeated: path != "" && element.GetRepetitionType() == parquet.FieldRepetitionType_REPEATED,
+		logical:  parquetLogicalType(element),
+	}
+	if len(column.Children) == 0 && path != "" {
+		node.leaf = true
+		include := len(fields) == 0
+		for i, field := range fields {
+			if path == field || strings.HasPrefix(path, field+".") {
+				matched[i] = true
+				include = true
+			}
+		}
+		if !include {
+			return nil
+		}
+		return node
+	}
+	for _, child := range column.Children {
+		childPath := child.SchemaElement.GetName()
+		if path != "" {
+			childPath = path + "." + childPath
+		}
+		if childNode := newParquetNode(child, childPath, fields, matched); childNode != nil {
+			node.children = append(node.children, childNode)
+		}
+	}
+	if len(node.children) == 0 {
+		return nil
+	}
+	return node
+}
+
+// parquetLogicalType returns a schema element's logical type, falling back
+// to its converted type for files written before logical types existed.
+func parquetLogicalType(element *parquet.SchemaElement) *parquet.LogicalType {
+	if element.IsSetLogicalType() {
+		return element.GetLogicalType()
+	}
+	logical := parquet.NewLogicalType()
+	if !element.IsSetConvertedType() {
+		return logical
+	}
+	switch converted := element.GetConvertedType(); converted {
+	case parquet.ConvertedType_UTF8:
+		logical.STRING = parquet.NewStringType()
+	case parquet.ConvertedType_MAP, parquet.ConvertedType_MAP_KEY_VALUE:
+		logical.MAP = parquet.NewMapType()
+	case parquet.ConvertedType_LIST:
+		logical.LIST = parquet.NewListType()
+	case parquet.ConvertedType_ENUM:
+		logical.ENUM = parquet.NewEnumType()
+	case parquet.ConvertedType_DECIMAL:
+		logical.DECIMAL = &parquet.DecimalType{Scale: element.GetScale(), Precision: element.GetPrecision()}
+	case parquet.ConvertedType_DATE:
+		logical.DATE = parquet.NewDateType()
+	case parquet.ConvertedType_TIME_MILLIS:
+		logical.TIME = &parquet.TimeType{Unit: &parquet.TimeUnit{MILLIS: parquet.NewMilliSeconds()}}
+	case parquet.ConvertedType_TIME_MICROS:
+		logical.TIME = &parquet.TimeType{Unit: &parquet.TimeUnit{MICROS: parquet.NewMicroSeconds()}}
+	case parquet.ConvertedType_TIMESTAMP_MILLIS:
+		logical.TIMESTAMP = &parquet.TimestampType{Unit: &parquet.TimeUnit{MILLIS: parquet.NewMilliSeconds()}}
+	case parquet.ConvertedType_TIMESTAMP_MICROS:
+		logical.TIMESTAMP = &parquet.TimestampType{Unit: &parquet.TimeUnit{MICROS: parquet.NewMicroSeconds()}}
+	case parquet.ConvertedType_JSON:
+		logical.JSON = parquet.NewJsonType()
+	case parquet.ConvertedType_BSON:
+		logical.BSON = parquet.NewBsonType()
+	default:
+		if converted >= parquet.ConvertedType_UINT_8 && converted <= parquet.ConvertedType_INT_64 {
+			// UINT_8, UINT_16, UINT_32, UINT_64, INT_8, INT_16, INT_32, INT_64
+			i := int(converted - parquet.ConvertedType_UINT_8)
+			logical.INTEGER = &parquet.IntType{BitWidth: int8(8 << uint(i%4)), IsSigned: i >= 4}
+		}
+	}
+	return logical
+}
+
+// ReadAndValidateHeader is a no-op for Parquet imports; always returns nil.
+func (r *ParquetInputReader) ReadAndValidateHeader() error {
+	return nil
+}
+
+// ReadAndValidateTypedHeader is a no-op for Parquet imports; always returns nil.
+func (r *ParquetInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) error {
+	return nil
+}
+
+// StreamDocument takes a boolean indicating if the documents should be streamed
+// in read order and a channel on which to stream the documents processed from
+// the underlying reader. Returns a non-nil error if encountered.
+func (r *ParquetInputReader) StreamDocument(ordered bool, readChan chan bson.D) error {
+	rawChan := make(chan Converter, r.numDecoders)
+	parquetErrChan := make(chan error)
+
+	// begin reading row groups from the file
+	go func() {
+		for i := 0; i < r.file.RowGroupCount(); i++ {
+			numRows, err := r.file.RowGroupNumRows()
+			for n := int64(0); err == nil && n < numRows; n++ {
+				var row map[string]interface{}
+				if row, err = r.file.NextRow(); err != nil {
+					break
+				}
+				rawChan <- ParquetConverter{
+					schema:  r.schema,
+					row:     row,
+					index:   r.numProcessed,
+					rejects: r.rejects,
+				}
+				r.numProcessed++
+			}
+			if err != nil {
+				close(rawChan)
+				parquetErrChan <- fmt.Errorf("error reading rows after document #%v: %v", r.numProcessed, err)
+				return
+			}
+			atomic.AddInt64(&r.bytesRead, parquetRowGroupSize(r.file.CurrentRowGroup()))
+		}
+		close(rawChan)
+		parquetErrChan <- nil
+	}()
+
+	// begin processing read rows
+	go func() {
+		parquetErrChan <- streamDocuments(ordered, r.numDecoders, rawChan, readChan)
+	}()
+
+	return channelQuorumError(parquetErrChan, 2)
+}
+
+// parquetRowGroupSize returns the compressed size of a row group's columns.
+func parquetRowGroupSize(rowGroup *parquet.RowGroup) int64 {
+	var size int64
+	if rowGroup == nil {
+		return size
+	}
+	for _, column := range rowGroup.Columns {
+		if column.MetaData != nil {
+			size += column.MetaData.TotalCompressedSize
+		}
+	}
+	return size
+}
+
+// Size returns the compressed size of the row groups read so far.
+func (r *ParquetInputReader) Size() int64 {
+	return atomic.LoadInt64(&r.bytesRead)
+}
+
+// Convert implements the Converter interface for Parquet input. It converts a
+// ParquetConverter struct to a BSON document.
+func (c ParquetConverter) Convert() (bson.D, error) {
+	doc, err := convertParquetGroup(c.schema, c.row)
+	if err != nil {
+		if c.rejects != nil {
+			return nil, c.rejects.rejectRecord("document", c.index+1, "", err)
+		}
+		return nil, fmt.Errorf("error converting document #%v: %v", c.index+1, err)
+	}
+	return doc, nil
+}
+
+// convertParquetGroup converts a group to a document with its fields in
+// schema order.
+func convertParquetGroup(node *parquetNode, group map[string]interface{}) (bson.D, error) {
+	doc := bson.D{}
+	for _, child := range node.children {
+		converted, err := convertParquetField(child, group[child.name])
+		if err != nil {
+			return nil, err
+		}
+		doc = append(doc, bson.E{Key: child.name, Value: converted})
+	}
+	return doc, nil
+}
+
+// convertParquetField converts the value of a field, which is a list of
+// elements if the field is repeated.
+func convertParquetField(node *parquetNode, value interface{}) (interface{}, error) {
+	if !node.repeated {
+		return convertParquetValue(node, value)
+	}
+	elements := parquetElements(value)
+	array := make(bson.A, 0, len(elements))
+	for _, element := range elements {
+		converted, err := convertParquetValue(node, element)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, converted)
+	}
+	return array, nil
+}
+
+// parquetElements returns the elements of a repeated field's value, which is
+// a slice of groups or of the column's values.
+func parquetElements(value interface{}) []interface{} {
+	if groups, ok := value.([]map[string]interface{}); ok {
+		elements := make([]interface{}, len(groups))
+		for i, group := range groups {
+			if group != nil {
+				elements[i] = group
+			}
+		}
+		return elements
+	}
+	slice := reflect.ValueOf(value)
+	if slice.Kind() != reflect.Slice {
+		return nil
+	}
+	elements := make([]interface{}, slice.Len())
+	for i := range elements {
+		elements[i] = slice.Index(i).Interface()
+	}
+	return elements
+}
+
+// convertParquetValue converts a single value of a field.
+func convertParquetValue(node *parquetNode, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if node.leaf {
+		converted, err := convertParquetLeaf(node, value)
+		if err != nil {
+			return nil, fmt.Errorf("field '%v': %v", node.path, err)
+		}
+		return converted, nil
+	}
+	group, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("field '%v' is not a group", node.path)
+	}
+	switch {
+	case node.logical.IsSetLIST():
+		if array, ok, err := convertParquetList(node, group); ok {
+			return array, err
+		}
+	case node.logical.IsSetMAP():
+		if doc, ok, err := convertParquetMap(node, group); ok {
+			return doc, err
+		}
+	}
+	return convertParquetGroup(node, group)
+}
+
+// convertParquetList converts a group annotated as a LIST to an array. It
+// returns false if the group does not have the structure of a list.
+func convertParquetList(node *parquetNode, group map[string]interface{}) (bson.A, bool, error) {
+	if len(node.children) != 1 || !node.children[0].repeated {
+		return nil, false, nil
+	}
+	repeated := node.children[0]
+	elements := parquetElements(group[repeated.name])
+	array := make(bson.A, 0, len(elements))
+
+	// In the standard layout the repeated group wraps a single element
+	// field. Older writers repeat the element itself, or name a one-field
+	// element group "array" or "<list>_tuple".
+	wrapped := !repeated.leaf && len(repeated.children) == 1 &&
+		repeated.name != "array" && repeated.name != node.name+"_tuple"
+	for _, element := range elements {
+		var converted interface{}
+		var err error
+		if wrapped {
+			inner, _ := element.(map[string]interface{})
+			converted, err = convertParquetField(repeated.children[0], inner[repeated.children[0].name])
+		} else {
+			converted, err = convertParquetValue(repeated, element)
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		array = append(array, converted)
+	}
+	return array, true, nil
+}
+
+// convertParquetMap converts a group annotated as a MAP to a document keyed
+// by the map's keys. It returns false if the group does not have the
+// structure of a map.
+func convertParquetMap(node *parquetNode, group map[string]interface{}) (bson.D, bool, error) {
+	if len(node.children) != 1 || !node.children[0].repeated {
+		return nil, false, nil
+	}
+	keyValue := node.children[0]
+	if keyValue.leaf || len(keyValue.children) == 0 || len(keyValue.children) > 2 {
+		return nil, false, nil
+	}
+	keyNode := keyValue.children[0]
+	entries := parquetElements(group[keyValue.name])
+	doc := make(bson.D, 0, len(entries))
+	for _, entry := range entries {
+		entryGroup, _ := entry.(map[string]interface{})
+		key, err := convertParquetField(keyNode, entryGroup[keyNode.name])
+		if err != nil {
+			return nil, true, err
+		}
+		keyString, ok := key.(string)
+		if !ok {
+			keyString = fmt.Sprint(key)
+		}
+		var value interface{}
+		if len(keyValue.children) == 2 {
+			valueNode := keyValue.children[1]
+			if value, err = convertParquetField(valueNode, entryGroup[valueNode.name]); err != nil {
+				return nil, true, err
+			}
+		}
+		doc = append(doc, bson.E{Key: keyString, Value: value})
+	}
+	return doc, true, nil
+}
+
+// convertParquetLeaf converts a non-null leaf value according to its logical
+// type.
+func convertParquetLeaf(node *parquetNode, value interface{}) (interface{}, error) {
+	logical := node.logical
+	switch v := value.(type) {
+	case bool, float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case [12]byte:
+		nanos := int64(binary.LittleEndian.Uint64(v[:8]))
+		day := int64(binary.LittleEndian.Uint32(v[8:]))
+		return primitive.DateTime((day-julianUnixEpoch)*millisPerDay + floorDiv(nanos, 1000000)), nil
+	case int32:
+		switch {
+		case logical.IsSetDATE():
+			return primitive.DateTime(int64(v) * millisPerDay), nil
+		case logical.IsSetDECIMAL():
+			return parquetDecimal(big.NewInt(int64(v)), int(logical.DECIMAL.GetScale()))
+		case logical.IsSetINTEGER():
+			if !logical.INTEGER.GetIsSigned() && logical.INTEGER.GetBitWidth() == 32 {
+				return int64(uint32(v)), nil
+			}
+		}
+		return v, nil
+	case int64:
+		switch {
+		case logical.IsSetTIMESTAMP():
+			return primitive.DateTime(parquetMillis(v, logical.TIMESTAMP.GetUnit())), nil
+		case logical.IsSetDECIMAL():
+			return parquetDecimal(big.NewInt(v), int(logical.DECIMAL.GetScale()))
+		case logical.IsSetINTEGER():
+			if !logical.INTEGER.GetIsSigned() && v < 0 {
+				return parquetDecimal(new(big.Int).SetUint64(uint64(v)), 0)
+			}
+		}
+		return v, nil
+	case []byte:
+		switch {
+		case logical.IsSetSTRING(), logical.IsSetENUM(), logical.IsSetJSON():
+			return string(v), nil
+		case logical.IsSetBSON():
+			var doc bson.D
+			if err := bson.Unmarshal(v, &doc); err != nil {
+				return nil, fmt.Errorf("invalid BSON value: %v", err)
+			}
+			return doc, nil
+		case logical.IsSetDECIMAL():
+			unscaled := new(big.Int).SetBytes(v)
+			if len(v) > 0 && v[0]&0x80 != 0 {
+				// two's complement
+				unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(v))))
+			}
+			return parquetDecimal(unscaled, int(logical.DECIMAL.GetScale()))
+		case logical.IsSetUUID():
+			return primitive.Binary{Subtype: 0x04, Data: v}, nil
+		}
+		return primitive.Binary{Data: v}, nil
+	}
+	return nil, fmt.Errorf("unsupported value of type %T", value)
+}
+
+// parquetMillis converts a timestamp in the given unit to milliseconds.
+func parquetMillis(v int64, unit *parquet.TimeUnit) int64 {
+	switch {
+	case unit.IsSetMICROS():
+		return floorDiv(v, 1000)
+	case unit.IsSetNANOS():
+		return floorDiv(v, 1000000)
+	}
+	return v
+}
+
+// parquetDecimal converts an unscaled decimal value to a Decimal128.
+func parquetDecimal(unscaled *big.Int, scale int) (primitive.Decimal128, error) {
+	if scale > math.MaxInt16 {
+		return primitive.Decimal128{}, fmt.Errorf("decimal scale %v is out of range", scale)
+	}
+	d, ok := primitive.ParseDecimal128FromBigInt(unscaled, -scale)
+	if !ok {
+		return primitive.Decimal128{}, fmt.Errorf("decimal %ve-%v does not fit in a Decimal128", unscaled, scale)
+	}
+	return d, nil
+}
+
+// floorDiv divides a by b, rounding towards negative infinity so that times
+// before the epoch truncate to the earlier millisecond.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,210 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParquetStreamDocument(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+	Convey("With a parquet input reader", t, func() {
+		fileHandle, err := os.Open("testdata/test.parquet")
+		So(err, ShouldBeNil)
+		defer fileHandle.Close()
+
+		uid := make([]byte, 16)
+		for i := range uid {
+			uid[i] = byte(i)
+		}
+		price, _ := primitive.ParseDecimal128("123.45")
+		negative, _ := primitive.ParseDecimal128("-0.01")
+
+		Convey("every column should be converted by its logical type", func() {
+			r, err := NewParquetInputReader(nil, fileHandle, 1)
+			So(err, ShouldBeNil)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{
+				{"_id", int64(1)},
+				{"name", "ann"},
+				{"created", primitive.DateTime(1600000000123)},
+				{"day", primitive.DateTime(18500 * millisPerDay)},
+				{"price", price},
+				{"legacy_ts", primitive.DateTime(18412*millisPerDay + 3600000)},
+				{"tags", bson.A{"a", "b"}},
+				{"attrs", bson.D{{"x", int32(1)}, {"y", nil}}},
+				{"address", bson.D{
+					{"city", "paris"},
+					{"uid", primitive.Binary{Subtype: 0x04, Data: uid}},
+				}},
+			})
+			So(<-docChan, ShouldResemble, bson.D{
+				{"_id", int64(2)},
+				{"name", nil},
+				{"created", nil},
+				{"day", nil},
+				{"price", negative},
+				{"legacy_ts", nil},
+				{"tags", nil},
+				{"attrs", bson.D{}},
+				{"address", nil},
+			})
+			So(r.Size(), ShouldBeGreaterThan, 0)
+		})
+
+		Convey("--fields should select columns and whole groups", func() {
+			r, err := NewParquetInputReader([]string{"name", "address"}, fileHandle, 1)
+			So(err, ShouldBeNil)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{
+				{"name", "ann"},
+				{"address", bson.D{
+					{"city", "paris"},
+					{"uid", primitive.Binary{Subtype: 0x04, Data: uid}},
+				}},
+			})
+			So(<-docChan, ShouldResemble, bson.D{{"name", nil}, {"address", nil}})
+		})
+
+		Convey("a nested field should select only its columns", func() {
+			r, err := NewParquetInputReader([]string{"address.city"}, fileHandle, 1)
+			So(err, ShouldBeNil)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"address", bson.D{{"city", "paris"}}}})
+			So(<-docChan, ShouldResemble, bson.D{{"address", nil}})
+		})
+
+		Convey("a field that is not in the schema should be an error", func() {
+			_, err := NewParquetInputReader([]string{"missing"}, fileHandle, 1)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("input that cannot be read at random should be an error", func() {
+			_, err := NewParquetInputReader(nil, bytes.NewBufferString("PAR1"), 1)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestParquetFixtures(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	// the rows of the files written by testdata/generate_parquet.py
+	expected := []bson.D{
+		{
+			{"id", int64(1)},
+			{"name", "alpha"},
+			{"tags", bson.A{"x", "y"}},
+			{"attrs", bson.D{{"a", int32(1)}, {"b", int32(2)}}},
+		},
+		{{"id", int64(2)}, {"name", nil}, {"tags", bson.A{}}, {"attrs", nil}},
+		{{"id", int64(3)}, {"name", "beta"}, {"tags", nil}, {"attrs", bson.D{}}},
+		{
+			{"id", int64(4)},
+			{"name", "alpha"},
+			{"tags", bson.A{"z", nil}},
+			{"attrs", bson.D{{"c", nil}}},
+		},
+		{
+			{"id", int64(5)},
+			{"name", "beta"},
+			{"tags", bson.A{"x"}},
+			{"attrs", bson.D{{"a", int32(5)}}},
+		},
+		{{"id", int64(6)}, {"name", "alpha"}, {"tags", nil}, {"attrs", nil}},
+	}
+
+	for _, name := range []string{"test_dictionary.parquet", "test_v2.parquet"} {
+		Convey("The snappy compressed, dictionary encoded pages of "+name+" should be imported", t, func() {
+			fileHandle, err := os.Open("testdata/" + name)
+			So(err, ShouldBeNil)
+			defer fileHandle.Close()
+			r, err := NewParquetInputReader(nil, fileHandle, 1)
+			So(err, ShouldBeNil)
+			docChan := make(chan bson.D, len(expected))
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			for _, doc := range expected {
+				So(<-docChan, ShouldResemble, doc)
+			}
+		})
+	}
+}
+
+func TestParquetConvertLeaf(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+	Convey("With parquet leaf values", t, func() {
+		Convey("timestamps before the epoch should round down", func() {
+			micros := &parquet.TimeUnit{MICROS: parquet.NewMicroSeconds()}
+			nanos := &parquet.TimeUnit{NANOS: parquet.NewNanoSeconds()}
+			millis := &parquet.TimeUnit{MILLIS: parquet.NewMilliSeconds()}
+			So(parquetMillis(-1500, micros), ShouldEqual, -2)
+			So(parquetMillis(2500000, nanos), ShouldEqual, 2)
+			So(parquetMillis(-7, millis), ShouldEqual, -7)
+		})
+
+		Convey("unsigned integers should not overflow", func() {
+			node := &parquetNode{logical: &parquet.LogicalType{INTEGER: &parquet.IntType{BitWidth: 32}}}
+			v, err := convertParquetLeaf(node, int32(-1))
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, int64(math.MaxUint32))
+
+			node.logical.INTEGER.BitWidth = 64
+			v, err = convertParquetLeaf(node, int64(-1))
+			So(err, ShouldBeNil)
+			So(v.(primitive.Decimal128).String(), ShouldEqual, "18446744073709551615")
+		})
+
+		Convey("decimals that do not fit in a Decimal128 should be an error", func() {
+			huge := new(big.Int).Lsh(big.NewInt(1), 200)
+			_, err := parquetDecimal(huge, 0)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("BSON columns should be unmarshalled", func() {
+			raw, err := bson.Marshal(bson.D{{"a", int32(1)}})
+			So(err, ShouldBeNil)
+			node := &parquetNode{logical: &parquet.LogicalType{BSON: parquet.NewBsonType()}}
+			v, err := convertParquetLeaf(node, raw)
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, bson.D{{"a", int32(1)}})
+		})
+
+		Convey("legacy converted types should be read as logical types", func() {
+			element := &parquet.SchemaElement{
+				ConvertedType: parquet.ConvertedTypePtr(parquet.ConvertedType_UINT_32),
+			}
+			node := &parquetNode{logical: parquetLogicalType(element)}
+			v, err := convertParquetLeaf(node, int32(-1))
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, int64(math.MaxUint32))
+
+			element.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_TIMESTAMP_MICROS)
+			node.logical = parquetLogicalType(element)
+			v, err = convertParquetLeaf(node, int64(-1500))
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, primitive.DateTime(-2))
+
+			node.logical = parquetLogicalType(&parquet.SchemaElement{})
+			v, err = convertParquetLeaf(node, []byte("raw"))
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, primitive.Binary{Data: []byte("raw")})
+		})
+	})
+}
@@ -0,0 +1,158 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// rejectWriter writes the records that fail to import to --rejectsFile, one
+// canonical extended JSON document per line. Records that fail to parse are
+// written as {file: <input file>, line: <line number>, reason: <error>, raw:
+// <input text>} for CSV and TSV input and as {file: <input file>, document:
+// <document number>, reason: <error>, raw: <input text>} for JSON and Parquet
+// input, where Parquet rows have no raw text and records read from stdin have
+// no file. Documents that the server fails to write are written as
+// {code: <error code>, reason: <error message>, document: <document>}, and
+// documents skipped by --validateSchema as {reason: <error>, document:
+// <document>}. Rejects are written as they happen, so memory use does not
+// grow with their number. A nil rejectWriter writes nothing.
+type rejectWriter struct {
+	mutex sync.Mutex
+	file  *os.File
+	out   *bufio.Writer
+	count uint64
+
+	// records is the number of records that failed to parse.
+	records uint64
+}
+
+func newRejectWriter(path string) (*rejectWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating rejects file: %v", err)
+	}
+	return &rejectWriter{file: file, out: bufio.NewWriter(file)}, nil
+}
+
+// write saves one reject.
+func (w *rejectWriter) write(reject bson.D) error {
+	out, err := bson.MarshalExtJSON(reject, true, false)
+	if err != nil {
+		return fmt.Errorf("error encoding rejected document: %v", err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, err = w.out.Write(append(out, '\n')); err != nil {
+		return fmt.Errorf("error writing rejects file: %v", err)
+	}
+	w.count++
+	return nil
+}
+
+// recordRejects writes the records of one input file that fail to parse to
+// a rejectWriter. A nil recordRejects writes nothing.
+type recordRejects struct {
+	writer *rejectWriter
+	// file is the name of the input file, or empty for stdin
+	file string
+}
+
+// forFile returns the recordRejects of an input file, or nil if w is nil.
+func (w *rejectWriter) forFile(file string) *recordRejects {
+	if w == nil {
+		return nil
+	}
+	return &recordRejects{writer: w, file: file}
+}
+
+// rejectRecord saves an input record that failed to parse. The key is
+// "line" or "document" depending on what number identifies the record.
+func (r *recordRejects) rejectRecord(key string, number uint64, raw string, reason error) error {
+	if r == nil {
+		return nil
+	}
+	atomic.AddUint64(&r.writer.records, 1)
+	var reject bson.D
+	if r.file != "" {
+		reject = append(reject, bson.E{"file", r.file})
+	}
+	reject = append(reject, bson.E{key, int64(number)}, bson.E{"reason", reason.Error()})
+	if raw != "" {
+		reject = append(reject, bson.E{"raw", raw})
+	}
+	return r.writer.write(reject)
+}
+
+// recordCount returns the number of records that failed to parse.
+func (w *rejectWriter) recordCount() uint64 {
+	if w == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&w.records)
+}
+
+// rejectDocument saves a converted document that was not imported.
+func (w *rejectWriter) rejectDocument(doc bson.D, reason error) error {
+	if w == nil {
+		return nil
+	}
+	return w.write(bson.D{{"reason", reason.Error()}, {"document", doc}})
+}
+
+// rejectWrites saves the documents of the write errors in a bulk write
+// exception. Other errors are ignored.
+func (w *rejectWriter) rejectWrites(err error) error {
+	bwe, ok := err.(mongo.BulkWriteException)
+	if w == nil || !ok {
+		return nil
+	}
+	for _, writeErr := range bwe.WriteErrors {
+		var document interface{}
+		switch model := writeErr.Request.(type) {
+		case *mongo.InsertOneModel:
+			document = model.Document
+		case *mongo.ReplaceOneModel:
+			document = model.Replacement
+		case *mongo.UpdateOneModel:
+			document = model.Update
+		case *mongo.DeleteOneModel:
+			document = model.Filter
+		}
+		reject := bson.D{{"code", int32(writeErr.Code)}, {"reason", writeErr.Message}, {"document", document}}
+		if err := w.write(reject); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close flushes and closes the rejects file and returns the number of
+// rejects written.
+func (w *rejectWriter) close() (uint64, error) {
+	if w == nil {
+		return 0, nil
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	err := w.out.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return w.count, fmt.Errorf("error writing rejects file: %v", err)
+	}
+	return w.count, nil
+}
@@ -0,0 +1,157 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// readRejects returns the rejects written to a rejects file.
+func readRejects(path string) []bson.M {
+	file, err := os.Open(path)
+	So(err, ShouldBeNil)
+	defer file.Close()
+	var rejects []bson.M
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var reject bson.M
+		So(bson.UnmarshalExtJSON(scanner.Bytes(), true, &reject), ShouldBeNil)
+		rejects = append(rejects, reject)
+	}
+	So(scanner.Err(), ShouldBeNil)
+	return rejects
+}
+
+func TestRejectWriter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a rejects file", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport_rejects")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "rejects.json")
+		rejects, err := newRejectWriter(path)
+		So(err, ShouldBeNil)
+
+		Convey("CSV rows skipped by --parseGrace=skipRow should be written with their file and line", func() {
+			colSpecs, err := ParseTypedHeaders([]string{"a.int32()", "b.string()"}, pgSkipRow)
+			So(err, ShouldBeNil)
+			contents := "1,x\n\n\"bad\nvalue\",y\nz,w\n3,\"q\"\n"
+			r := NewCSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false, false)
+			r.rejects = rejects.forFile("data/a.csv")
+			docChan := make(chan bson.D, 4)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 2)
+
+			count, err := rejects.close()
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+			written := readRejects(path)
+			So(written[0]["file"], ShouldEqual, "data/a.csv")
+			So(written[0]["line"], ShouldEqual, 3)
+			So(written[0]["raw"], ShouldEqual, "\"bad\nvalue\",y")
+			So(written[0]["reason"], ShouldContainSubstring, "column 'a'")
+			So(written[1]["line"], ShouldEqual, 5)
+			So(written[1]["raw"], ShouldEqual, "z,w")
+		})
+
+		Convey("TSV rows should be numbered after the header line", func() {
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte("a.int32()\tb.string()\n1\tx\nz\tw\n")), os.Stdout, 1, false, false)
+			r.rejects = rejects.forFile("")
+			So(r.ReadAndValidateTypedHeader(pgSkipRow), ShouldBeNil)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 1)
+
+			_, err := rejects.close()
+			So(err, ShouldBeNil)
+			written := readRejects(path)
+			So(len(written), ShouldEqual, 1)
+			So(written[0], ShouldNotContainKey, "file")
+			So(written[0]["line"], ShouldEqual, 3)
+			So(written[0]["raw"], ShouldEqual, "z\tw")
+		})
+
+		Convey("JSON documents that fail to parse should be written instead of stopping the import", func() {
+			contents := `{"a": 1} {"a": {"$date": "never"}} {"a": 3}`
+			r := NewJSONInputReader(false, false, bytes.NewReader([]byte(contents)), 1)
+			r.rejects = rejects.forFile("")
+			docChan := make(chan bson.D, 3)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 2)
+
+			_, err := rejects.close()
+			So(err, ShouldBeNil)
+			written := readRejects(path)
+			So(len(written), ShouldEqual, 1)
+			So(written[0]["document"], ShouldEqual, 2)
+			So(written[0]["raw"], ShouldEqual, `{"a": {"$date": "never"}}`)
+		})
+
+		Convey("rejected JSON documents should not reorder or stall several decoders", func() {
+			contents := &bytes.Buffer{}
+			for i := 0; i < 30; i++ {
+				if i%3 == 1 {
+					contents.WriteString(`{"a": {"$date": "never"}} `)
+				} else {
+					fmt.Fprintf(contents, `{"a": %v} `, i)
+				}
+			}
+			r := NewJSONInputReader(false, false, contents, 4)
+			r.rejects = rejects.forFile("")
+			docChan := make(chan bson.D, 30)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 20)
+			previous := int32(-1)
+			for doc := range docChan {
+				a := doc[0].Value.(int32)
+				So(a, ShouldBeGreaterThan, previous)
+				previous = a
+			}
+			So(rejects.recordCount(), ShouldEqual, 10)
+			_, err := rejects.close()
+			So(err, ShouldBeNil)
+		})
+
+		Convey("documents that fail to be written should be saved with their error code", func() {
+			doc := bson.D{{"_id", int32(1)}}
+			bwe := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+				{WriteError: mongo.WriteError{Code: 11000, Message: "duplicate key"}, Request: mongo.NewInsertOneModel().SetDocument(doc)},
+				{WriteError: mongo.WriteError{Code: 121, Message: "failed validation"}, Request: mongo.NewUpdateOneModel().SetUpdate(bson.D{{"$set", doc}})},
+			}}
+			So(rejects.rejectWrites(bwe), ShouldBeNil)
+			count, err := rejects.close()
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			written := readRejects(path)
+			So(written[0], ShouldResemble, bson.M{"code": int32(11000), "reason": "duplicate key", "document": bson.M{"_id": int32(1)}})
+			So(written[1]["code"], ShouldEqual, 121)
+			So(written[1]["document"], ShouldResemble, bson.M{"$set": bson.M{"_id": int32(1)}})
+		})
+	})
+
+	Convey("A nil rejectWriter should write nothing", t, func() {
+		var rejects *rejectWriter
+		So(rejects.forFile("a.csv").rejectRecord("line", 1, "a", bson.ErrDecodeToNil), ShouldBeNil)
+		So(rejects.rejectWrites(mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{}}}), ShouldBeNil)
+		count, err := rejects.close()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 0)
+	})
+}
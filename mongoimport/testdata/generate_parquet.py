@@ -0,0 +1,329 @@
+#!/usr/bin/env python3
+# Copyright (C) MongoDB, Inc. 2014-present.
+#
+# Licensed under the Apache License, Version 2.0 (the "License"); you may
+# not use this file except in compliance with the License. You may obtain
+# a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+"""Generates the Parquet files the Parquet import tests read.
+
+The files are written from the Parquet format specification, independently
+of any Parquet library, with the page layout of parquet-mr and Arrow: snappy
+compressed column chunks split into several data pages, dictionary pages
+ahead of dictionary encoded columns, and three-level LIST and MAP groups.
+test_dictionary.parquet has version 1 data pages and test_v2.parquet
+version 2 data pages; both hold the same rows:
+
+    id  name    tags          attrs
+    1   alpha   [x, y]        {a: 1, b: 2}
+    2   null    []            null
+    3   beta    null          {}
+    4   alpha   [z, null]     {c: null}
+    5   beta    [x]           {a: 5}
+    6   alpha   null          null
+
+Run it from this directory to regenerate the files.
+"""
+
+import struct
+
+# thrift compact protocol types
+T_TRUE, T_FALSE, T_I32, T_I64, T_BINARY, T_LIST, T_STRUCT = 1, 2, 5, 6, 8, 9, 12
+
+
+def varint(n):
+    out = bytearray()
+    while True:
+        b = n & 0x7F
+        n >>= 7
+        if n:
+            out.append(b | 0x80)
+        else:
+            out.append(b)
+            return bytes(out)
+
+
+def zigzag(n):
+    return (n << 1) ^ (n >> 63)
+
+
+class I32(int):
+    pass
+
+
+class I64(int):
+    pass
+
+
+def thrift_type(value):
+    if isinstance(value, bool):
+        return T_TRUE if value else T_FALSE
+    if isinstance(value, I64):
+        return T_I64
+    if isinstance(value, int):
+        return T_I32
+    if isinstance(value, (str, bytes)):
+        return T_BINARY
+    if isinstance(value, list):
+        return T_LIST
+    return T_STRUCT
+
+
+def thrift_value(value):
+    if isinstance(value, bool):
+        return b"\x01" if value else b"\x02"
+    if isinstance(value, int):
+        return varint(zigzag(value))
+    if isinstance(value, str):
+        value = value.encode()
+    if isinstance(value, bytes):
+        return varint(len(value)) + value
+    if isinstance(value, list):
+        elem = thrift_type(value[0]) if value else T_I32
+        header = bytes([len(value) << 4 | elem]) if len(value) < 15 else bytes([0xF0 | elem]) + varint(len(value))
+        return header + b"".join(thrift_value(v) for v in value)
+    return thrift_struct(value)
+
+
+def thrift_struct(fields):
+    """Encodes a dict of field ids to values as a compact protocol struct."""
+    out = bytearray()
+    last = 0
+    for fid in sorted(fields):
+        value = fields[fid]
+        if value is None:
+            continue
+        typ = thrift_type(value)
+        delta = fid - last
+        if 0 < delta <= 15:
+            out.append(delta << 4 | typ)
+        else:
+            out.append(typ)
+            out += varint(zigzag(fid))
+        if not isinstance(value, bool):
+            out += thrift_value(value)
+        last = fid
+    out.append(0)
+    return bytes(out)
+
+
+def snappy(data):
+    """Compresses data into a valid snappy block made only of literals."""
+    out = bytearray(varint(len(data)))
+    for i in range(0, len(data), 60):
+        chunk = data[i:i + 60]
+        out.append((len(chunk) - 1) << 2)
+        out += chunk
+    return bytes(out)
+
+
+def bit_width(max_value):
+    return max_value.bit_length()
+
+
+def rle(values, width):
+    """Encodes values as RLE runs of the hybrid encoding."""
+    out = bytearray()
+    i = 0
+    while i < len(values):
+        j = i
+        while j < len(values) and values[j] == values[i]:
+            j += 1
+        out += varint((j - i) << 1)
+        out += values[i].to_bytes((width + 7) // 8, "little")
+        i = j
+    return bytes(out)
+
+
+def bit_packed(values, width):
+    """Encodes values as bit-packed groups of eight of the hybrid encoding."""
+    groups = (len(values) + 7) // 8
+    padded = values + [0] * (groups * 8 - len(values))
+    bits = 0
+    for i, v in enumerate(padded):
+        bits |= v << (i * width)
+    return varint(groups << 1 | 1) + bits.to_bytes(groups * width, "little")
+
+
+def plain(values, physical):
+    out = bytearray()
+    for v in values:
+        if physical == INT32:
+            out += struct.pack("<i", v)
+        elif physical == INT64:
+            out += struct.pack("<q", v)
+        else:
+            out += struct.pack("<I", len(v)) + v
+    return bytes(out)
+
+
+# physical types, encodings, converted types and page types
+INT32, INT64, BYTE_ARRAY = 1, 2, 6
+PLAIN, PLAIN_DICTIONARY, RLE, RLE_DICTIONARY = 0, 2, 3, 8
+UTF8, MAP, LIST = 0, 1, 3
+REQUIRED, OPTIONAL, REPEATED = 0, 1, 2
+DATA_PAGE, DICTIONARY_PAGE, DATA_PAGE_V2 = 0, 2, 3
+SNAPPY = 1
+
+STRING = {1: {}}
+
+SCHEMA = [
+    {4: "schema", 5: 4},
+    {1: INT64, 3: REQUIRED, 4: "id"},
+    {1: BYTE_ARRAY, 3: OPTIONAL, 4: "name", 6: UTF8, 10: STRING},
+    {3: OPTIONAL, 4: "tags", 5: 1, 6: LIST, 10: {3: {}}},
+    {3: REPEATED, 4: "list", 5: 1},
+    {1: BYTE_ARRAY, 3: OPTIONAL, 4: "element", 6: UTF8, 10: STRING},
+    {3: OPTIONAL, 4: "attrs", 5: 1, 6: MAP, 10: {2: {}}},
+    {3: REPEATED, 4: "key_value", 5: 2},
+    {1: BYTE_ARRAY, 3: REQUIRED, 4: "key", 6: UTF8, 10: STRING},
+    {1: INT32, 3: OPTIONAL, 4: "value"},
+]
+
+# each column's path, physical type, maximum levels, whether it is
+# dictionary encoded, and the (repetition, definition, value) triples of
+# rows 1-3 and 4-6, which go in separate pages
+COLUMNS = [
+    (["id"], INT64, 0, 0, False, [
+        [(0, 0, 1), (0, 0, 2), (0, 0, 3)],
+        [(0, 0, 4), (0, 0, 5), (0, 0, 6)],
+    ]),
+    (["name"], BYTE_ARRAY, 0, 1, True, [
+        [(0, 1, b"alpha"), (0, 0, None), (0, 1, b"beta")],
+        [(0, 1, b"alpha"), (0, 1, b"beta"), (0, 1, b"alpha")],
+    ]),
+    (["tags", "list", "element"], BYTE_ARRAY, 1, 3, True, [
+        [(0, 3, b"x"), (1, 3, b"y"), (0, 1, None), (0, 0, None)],
+        [(0, 3, b"z"), (1, 2, None), (0, 3, b"x"), (0, 0, None)],
+    ]),
+    (["attrs", "key_value", "key"], BYTE_ARRAY, 1, 2, False, [
+        [(0, 2, b"a"), (1, 2, b"b"), (0, 0, None), (0, 1, None)],
+        [(0, 2, b"c"), (0, 2, b"a"), (0, 0, None)],
+    ]),
+    (["attrs", "key_value", "value"], INT32, 1, 3, False, [
+        [(0, 3, 1), (1, 3, 2), (0, 0, None), (0, 1, None)],
+        [(0, 2, None), (0, 3, 5), (0, 0, None)],
+    ]),
+]
+
+
+# the PageHeader field holding the header of each page type
+PAGE_HEADER_FIELDS = {DATA_PAGE: 5, DICTIONARY_PAGE: 7, DATA_PAGE_V2: 8}
+
+
+def page_header(page_type, uncompressed, compressed, header):
+    return thrift_struct({
+        1: I32(page_type),
+        2: I32(uncompressed),
+        3: I32(compressed),
+        PAGE_HEADER_FIELDS[page_type]: header,
+    })
+
+
+def column_chunk(out, column, version):
+    path, physical, max_rep, max_def, dictionary, pages = column
+    start = len(out)
+    dict_offset = None
+    data_encoding = PLAIN
+    dict_values = []
+    if dictionary:
+        for page in pages:
+            for _, d, v in page:
+                if d == max_def and v not in dict_values:
+                    dict_values.append(v)
+        body = plain(dict_values, physical)
+        compressed = snappy(body)
+        dict_offset = len(out)
+        out += page_header(DICTIONARY_PAGE, len(body), len(compressed), {
+            1: I32(len(dict_values)),
+            2: I32(PLAIN_DICTIONARY if version == 1 else PLAIN),
+        }) + compressed
+        data_encoding = PLAIN_DICTIONARY if version == 1 else RLE_DICTIONARY
+
+    data_offset = len(out)
+    uncompressed_total = 0
+    num_values = 0
+    for page in pages:
+        reps = [r for r, _, _ in page]
+        defs = [d for _, d, _ in page]
+        values = [v for _, d, v in page if d == max_def]
+        num_values += len(page)
+        if dictionary:
+            width = bit_width(len(dict_values) - 1)
+            body = bytes([width]) + bit_packed([dict_values.index(v) for v in values], width)
+        else:
+            body = plain(values, physical)
+        rep_levels = rle(reps, bit_width(max_rep)) if max_rep else b""
+        def_levels = rle(defs, bit_width(max_def)) if max_def else b""
+        rows = sum(1 for r in reps if r == 0)
+        nulls = sum(1 for d in defs if d != max_def)
+        if version == 1:
+            levels = b""
+            if max_rep:
+                levels += struct.pack("<I", len(rep_levels)) + rep_levels
+            if max_def:
+                levels += struct.pack("<I", len(def_levels)) + def_levels
+            uncompressed = levels + body
+            compressed = snappy(uncompressed)
+            out += page_header(DATA_PAGE, len(uncompressed), len(compressed), {
+                1: I32(len(page)),
+                2: I32(data_encoding),
+                3: I32(RLE),
+                4: I32(RLE),
+            }) + compressed
+            uncompressed_total += len(uncompressed)
+        else:
+            compressed = snappy(body)
+            levels = rep_levels + def_levels
+            out += page_header(DATA_PAGE_V2, len(levels) + len(body), len(levels) + len(compressed), {
+                1: I32(len(page)),
+                2: I32(nulls),
+                3: I32(rows),
+                4: I32(data_encoding),
+                5: I32(len(def_levels)),
+                6: I32(len(rep_levels)),
+                7: True,
+            }) + levels + compressed
+            uncompressed_total += len(levels) + len(body)
+
+    encodings = [I32(RLE), I32(PLAIN)]
+    if dictionary:
+        encodings.append(I32(data_encoding))
+    meta = {
+        1: I32(physical),
+        2: encodings,
+        3: path,
+        4: I32(SNAPPY),
+        5: I64(num_values),
+        6: I64(uncompressed_total),
+        7: I64(len(out) - start),
+        9: I64(data_offset),
+    }
+    if dict_offset is not None:
+        meta[11] = I64(dict_offset)
+    return {2: I64(start), 3: meta}, len(out) - start
+
+
+def write_file(name, version):
+    out = bytearray(b"PAR1")
+    chunks = []
+    total = 0
+    for column in COLUMNS:
+        chunk, size = column_chunk(out, column, version)
+        chunks.append(chunk)
+        total += size
+    footer = thrift_struct({
+        1: I32(version),
+        2: SCHEMA,
+        3: I64(6),
+        4: [{1: chunks, 2: I64(total), 3: I64(6)}],
+        6: "mongo-tools testdata/generate_parquet.py",
+    })
+    out += footer + struct.pack("<I", len(footer)) + b"PAR1"
+    with open(name, "wb") as f:
+        f.write(out)
+
+
+if __name__ == "__main__":
+    write_file("test_dictionary.parquet", 1)
+    write_file("test_v2.parquet", 2)
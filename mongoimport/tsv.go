@@ -33,6 +33,15 @@ type TSVInputReader struct {
 	// tsvRejectWriter is where coercion-failed rows are written, if applicable
 	tsvRejectWriter io.Writer
 
+	// rejects is where rows that fail to parse are written with --rejectsFile
+	rejects *recordRejects
+
+	// mapping builds the documents from the columns with --mappingFile
+	mapping *fieldMapping
+
+	// headerLines is the number of lines read as the header
+	headerLines uint64
+
 	// tsvRecord stores each line of input we read from the underlying reader
 	tsvRecord string
 
@@ -57,9 +66,12 @@ type TSVConverter struct {
 	colSpecs            []ColumnSpec
 	data                string
 	index               uint64
+	line                uint64
 	ignoreBlanks        bool
 	useArrayIndexFields bool
 	rejectWriter        io.Writer
+	rejects             *recordRejects
+	mapping             *fieldMapping
 }
 
 // NewTSVInputReader returns a TSVInputReader configured to read input from the
@@ -85,6 +97,7 @@ func (r *TSVInputReader) ReadAndValidateHeader() (err error) {
 	if err != nil {
 		return err
 	}
+	r.headerLines = 1
 	var headerFields []string
 	for _, field := range strings.Split(header, tokenSeparator) {
 		headerFields = append(headerFields, strings.TrimRight(field, "\r\n"))
@@ -100,6 +113,7 @@ func (r *TSVInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err
 	if err != nil {
 		return err
 	}
+	r.headerLines = 1
 	var headerFields []string
 	for _, field := range strings.Split(header, tokenSeparator) {
 		headerFields = append(headerFields, strings.TrimRight(field, "\r\n"))
@@ -115,6 +129,11 @@ func (r *TSVInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err
 // in read order and a channel on which to stream the documents processed from
 // the underlying reader. Returns a non-nil error if streaming fails.
 func (r *TSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (retErr error) {
+	if r.mapping != nil {
+		if err := r.mapping.validateColumns(r.colSpecs); err != nil {
+			return err
+		}
+	}
 	tsvRecordChan := make(chan Converter, r.numDecoders)
 	tsvErrChan := make(chan error)
 
@@ -137,9 +156,12 @@ func (r *TSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (ret
 				colSpecs:            r.colSpecs,
 				data:                r.tsvRecord,
 				index:               r.numProcessed,
+				line:                r.headerLines + r.numProcessed + 1,
 				ignoreBlanks:        r.ignoreBlanks,
 				useArrayIndexFields: r.useArrayIndexFields,
 				rejectWriter:        r.tsvRejectWriter,
+				rejects:             r.rejects,
+				mapping:             r.mapping,
 			}
 			r.numProcessed++
 		}
@@ -156,14 +178,22 @@ func (r *TSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (ret
 // Convert implements the Converter interface for TSV input. It converts a
 // TSVConverter struct to a BSON document.
 func (c TSVConverter) Convert() (b bson.D, err error) {
-	b, err = tokensToBSON(
-		c.colSpecs,
-		strings.Split(strings.TrimRight(c.data, "\r\n"), tokenSeparator),
-		c.index,
-		c.ignoreBlanks,
-		c.useArrayIndexFields,
-	)
+	tokens := strings.Split(strings.TrimRight(c.data, "\r\n"), tokenSeparator)
+	if c.mapping != nil {
+		b, err = c.mapping.tokensToBSON(c.colSpecs, tokens, c.index, c.ignoreBlanks)
+	} else {
+		b, err = tokensToBSON(
+			c.colSpecs,
+			tokens,
+			c.index,
+			c.ignoreBlanks,
+			c.useArrayIndexFields,
+		)
+	}
 	if _, ok := err.(coercionError); ok {
+		if c.rejects != nil {
+			return nil, c.rejects.rejectRecord("line", c.line, strings.TrimRight(c.data, "\r\n"), err)
+		}
 		c.Print()
 		err = nil
 	}
@@ -0,0 +1,769 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions accepted by --validationGrace.
+const (
+	validationStop = "stop"
+	validationSkip = "skip"
+	validationWarn = "warn"
+)
+
+// schemaTypes maps the names accepted by bsonType and type to the BSON types
+// they match. "number" matches every numeric type.
+var schemaTypes = map[string][]bsontype.Type{
+	"double":              {bsontype.Double},
+	"string":              {bsontype.String},
+	"object":              {bsontype.EmbeddedDocument},
+	"array":               {bsontype.Array},
+	"binData":             {bsontype.Binary},
+	"undefined":           {bsontype.Undefined},
+	"objectId":            {bsontype.ObjectID},
+	"bool":                {bsontype.Boolean},
+	"boolean":             {bsontype.Boolean},
+	"date":                {bsontype.DateTime},
+	"null":                {bsontype.Null},
+	"regex":               {bsontype.Regex},
+	"dbPointer":           {bsontype.DBPointer},
+	"javascript":          {bsontype.JavaScript},
+	"symbol":              {bsontype.Symbol},
+	"javascriptWithScope": {bsontype.CodeWithScope},
+	"int":                 {bsontype.Int32},
+	"timestamp":           {bsontype.Timestamp},
+	"long":                {bsontype.Int64},
+	"decimal":             {bsontype.Decimal128},
+	"minKey":              {bsontype.MinKey},
+	"maxKey":              {bsontype.MaxKey},
+	"number":              {bsontype.Int32, bsontype.Int64, bsontype.Double, bsontype.Decimal128},
+}
+
+// jsonTypes are the names that the JSON Schema type keyword accepts; the
+// others are only valid for bsonType.
+var jsonTypes = map[string]bool{
+	"object": true, "array": true, "number": true, "boolean": true, "string": true, "null": true,
+}
+
+// ignoredKeywords are annotations that do not affect validation.
+var ignoredKeywords = map[string]bool{
+	"title": true, "description": true, "$schema": true, "$id": true, "$comment": true, "default": true, "examples": true,
+}
+
+// documentSchema is a compiled JSON Schema that documents are validated
+// against with --validateSchema. It supports the keywords that the server
+// supports in $jsonSchema validators.
+type documentSchema struct {
+	types []bsontype.Type
+
+	// object keywords
+	required             []string
+	properties           map[string]*documentSchema
+	patternProperties    []patternSchema
+	additionalProperties *documentSchema
+	noAdditional         bool
+	minProperties        int
+	maxProperties        int
+	dependencies         map[string]dependency
+
+	// number keywords
+	minimum          *schemaNumber
+	maximum          *schemaNumber
+	exclusiveMinimum bool
+	exclusiveMaximum bool
+	multipleOf       *schemaNumber
+
+	// string keywords
+	minLength int
+	maxLength int
+	pattern   *regexp.Regexp
+
+	// array keywords
+	items             *documentSchema
+	itemList          []*documentSchema
+	additionalItems   *documentSchema
+	noAdditionalItems bool
+	minItems          int
+	maxItems          int
+	uniqueItems       bool
+
+	enum  []bson.RawValue
+	allOf []*documentSchema
+	anyOf []*documentSchema
+	oneOf []*documentSchema
+	not   *documentSchema
+}
+
+type patternSchema struct {
+	pattern *regexp.Regexp
+	schema  *documentSchema
+}
+
+// dependency is either the properties or the schema that a property
+// requires.
+type dependency struct {
+	properties []string
+	schema     *documentSchema
+}
+
+// readSchemaFile reads and compiles a JSON Schema from an extended JSON file.
+// The file may hold the schema itself or a validator of the form
+// {$jsonSchema: <schema>}.
+func readSchemaFile(path string) (*documentSchema, error) {
+	data, err := ioutil.ReadFile(util.ToUniversalPath(path))
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err = bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing schema: %v", err)
+	}
+	if len(doc) == 1 && doc[0].Key == "$jsonSchema" {
+		if _, ok := doc[0].Value.(bson.D); !ok {
+			return nil, fmt.Errorf("$jsonSchema must be a document")
+		}
+		doc = doc[0].Value.(bson.D)
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing schema: %v", err)
+	}
+	return compileSchema(raw)
+}
+
+// compileSchema compiles one schema document.
+func compileSchema(raw bson.Raw) (*documentSchema, error) {
+	s := &documentSchema{maxProperties: -1, maxLength: -1, maxItems: -1}
+	elements, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+	for _, element := range elements {
+		key, value := element.Key(), element.Value()
+		if err = s.compileKeyword(key, value); err != nil {
+			return nil, fmt.Errorf("invalid '%v': %v", key, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *documentSchema) compileKeyword(key string, value bson.RawValue) (err error) {
+	switch key {
+	case "bsonType", "type":
+		return s.compileTypes(key, value)
+	case "required":
+		s.required, err = stringList(value)
+	case "properties":
+		s.properties = map[string]*documentSchema{}
+		return forEachSchema(value, func(name string, schema *documentSchema) error {
+			s.properties[name] = schema
+			return nil
+		})
+	case "patternProperties":
+		return forEachSchema(value, func(pattern string, schema *documentSchema) error {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return err
+			}
+			s.patternProperties = append(s.patternProperties, patternSchema{re, schema})
+			return nil
+		})
+	case "additionalProperties":
+		s.additionalProperties, s.noAdditional, err = boolOrSchema(value)
+	case "minProperties":
+		s.minProperties, err = nonNegativeInt(value)
+	case "maxProperties":
+		s.maxProperties, err = nonNegativeInt(value)
+	case "dependencies":
+		s.dependencies = map[string]dependency{}
+		doc, ok := value.DocumentOK()
+		if !ok {
+			return fmt.Errorf("must be a document")
+		}
+		elements, _ := doc.Elements()
+		for _, element := range elements {
+			var d dependency
+			if element.Value().Type == bsontype.Array {
+				d.properties, err = stringList(element.Value())
+			} else {
+				d.schema, err = subschema(element.Value())
+			}
+			if err != nil {
+				return fmt.Errorf("'%v': %v", element.Key(), err)
+			}
+			s.dependencies[element.Key()] = d
+		}
+	case "minimum":
+		s.minimum, err = number(value)
+	case "maximum":
+		s.maximum, err = number(value)
+	case "exclusiveMinimum":
+		s.exclusiveMinimum, err = boolean(value)
+	case "exclusiveMaximum":
+		s.exclusiveMaximum, err = boolean(value)
+	case "multipleOf":
+		s.multipleOf, err = number(value)
+		if err == nil && (s.multipleOf.decimal == nil || s.multipleOf.decimal.Sign() <= 0) {
+			err = fmt.Errorf("must be greater than 0")
+		}
+	case "minLength":
+		s.minLength, err = nonNegativeInt(value)
+	case "maxLength":
+		s.maxLength, err = nonNegativeInt(value)
+	case "pattern":
+		pattern, ok := value.StringValueOK()
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		s.pattern, err = regexp.Compile(pattern)
+	case "items":
+		if value.Type == bsontype.Array {
+			s.itemList, err = schemaList(value)
+		} else {
+			s.items, err = subschema(value)
+		}
+	case "additionalItems":
+		s.additionalItems, s.noAdditionalItems, err = boolOrSchema(value)
+	case "minItems":
+		s.minItems, err = nonNegativeInt(value)
+	case "maxItems":
+		s.maxItems, err = nonNegativeInt(value)
+	case "uniqueItems":
+		s.uniqueItems, err = boolean(value)
+	case "enum":
+		array, ok := value.ArrayOK()
+		if !ok {
+			return fmt.Errorf("must be an array")
+		}
+		s.enum, _ = array.Values()
+		if len(s.enum) == 0 {
+			return fmt.Errorf("must not be empty")
+		}
+	case "allOf":
+		s.allOf, err = schemaList(value)
+	case "anyOf":
+		s.anyOf, err = schemaList(value)
+	case "oneOf":
+		s.oneOf, err = schemaList(value)
+	case "not":
+		s.not, err = subschema(value)
+	default:
+		if !ignoredKeywords[key] {
+			return fmt.Errorf("unsupported keyword")
+		}
+	}
+	return err
+}
+
+func (s *documentSchema) compileTypes(key string, value bson.RawValue) error {
+	var names []string
+	if name, ok := value.StringValueOK(); ok {
+		names = []string{name}
+	} else {
+		var err error
+		if names, err = stringList(value); err != nil {
+			return fmt.Errorf("must be a string or an array of strings")
+		}
+	}
+	for _, name := range names {
+		types, ok := schemaTypes[name]
+		if !ok || key == "type" && !jsonTypes[name] {
+			return fmt.Errorf("unknown type '%v'", name)
+		}
+		s.types = append(s.types, types...)
+	}
+	return nil
+}
+
+func subschema(value bson.RawValue) (*documentSchema, error) {
+	doc, ok := value.DocumentOK()
+	if !ok {
+		return nil, fmt.Errorf("must be a document")
+	}
+	return compileSchema(doc)
+}
+
+func forEachSchema(value bson.RawValue, f func(string, *documentSchema) error) error {
+	doc, ok := value.DocumentOK()
+	if !ok {
+		return fmt.Errorf("must be a document")
+	}
+	elements, _ := doc.Elements()
+	for _, element := range elements {
+		schema, err := subschema(element.Value())
+		if err == nil {
+			err = f(element.Key(), schema)
+		}
+		if err != nil {
+			return fmt.Errorf("'%v': %v", element.Key(), err)
+		}
+	}
+	return nil
+}
+
+func schemaList(value bson.RawValue) ([]*documentSchema, error) {
+	array, ok := value.ArrayOK()
+	if !ok {
+		return nil, fmt.Errorf("must be an array")
+	}
+	values, _ := array.Values()
+	if len(values) == 0 {
+		return nil, fmt.Errorf("must not be empty")
+	}
+	schemas := make([]*documentSchema, len(values))
+	for i, v := range values {
+		schema, err := subschema(v)
+		if err != nil {
+			return nil, fmt.Errorf("item %v: %v", i, err)
+		}
+		schemas[i] = schema
+	}
+	return schemas, nil
+}
+
+func boolOrSchema(value bson.RawValue) (*documentSchema, bool, error) {
+	if b, ok := value.BooleanOK(); ok {
+		return nil, !b, nil
+	}
+	schema, err := subschema(value)
+	if err != nil {
+		return nil, false, fmt.Errorf("must be a boolean or a document")
+	}
+	return schema, false, nil
+}
+
+func stringList(value bson.RawValue) ([]string, error) {
+	array, ok := value.ArrayOK()
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+	values, _ := array.Values()
+	strs := make([]string, len(values))
+	for i, v := range values {
+		str, ok := v.StringValueOK()
+		if !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+		strs[i] = str
+	}
+	return strs, nil
+}
+
+func boolean(value bson.RawValue) (bool, error) {
+	b, ok := value.BooleanOK()
+	if !ok {
+		return false, fmt.Errorf("must be a boolean")
+	}
+	return b, nil
+}
+
+func number(value bson.RawValue) (*schemaNumber, error) {
+	n, ok := numericValue(value)
+	if !ok || math.IsNaN(n.float) {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &n, nil
+}
+
+func nonNegativeInt(value bson.RawValue) (int, error) {
+	n, ok := numericValue(value)
+	if !ok || n.exact == nil || !n.exact.IsInt() || n.exact.Sign() < 0 || n.exact.Num().BitLen() > 31 {
+		return 0, fmt.Errorf("must be a non-negative integer")
+	}
+	return int(n.exact.Num().Int64()), nil
+}
+
+// schemaNumber is the value of a number, which is compared exactly whatever
+// its BSON type. NaN and the infinities have no exact value and are compared
+// as their float64.
+type schemaNumber struct {
+	exact *big.Rat
+	float float64
+	text  string
+
+	// decimal is the value of the shortest decimal that reads back as the
+	// number, which multipleOf uses so that doubles such as 0.1 divide the
+	// numbers they appear to.
+	decimal *big.Rat
+}
+
+func (n schemaNumber) String() string {
+	return n.text
+}
+
+// compare returns -1, 0 or 1 as n is less than, equal to or greater than m,
+// and false if either is NaN.
+func (n schemaNumber) compare(m schemaNumber) (int, bool) {
+	switch {
+	case math.IsNaN(n.float) || math.IsNaN(m.float):
+		return 0, false
+	case n.exact != nil && m.exact != nil:
+		return n.exact.Cmp(m.exact), true
+	case n.exact != nil:
+		// m is infinite
+		if m.float > 0 {
+			return -1, true
+		}
+		return 1, true
+	case m.exact != nil:
+		if n.float > 0 {
+			return 1, true
+		}
+		return -1, true
+	case n.float < m.float:
+		return -1, true
+	case n.float > m.float:
+		return 1, true
+	}
+	return 0, true
+}
+
+// numericValue returns the value of a number.
+func numericValue(value bson.RawValue) (schemaNumber, bool) {
+	switch value.Type {
+	case bsontype.Int32:
+		return numberOf(value.Int32())
+	case bsontype.Int64:
+		return numberOf(value.Int64())
+	case bsontype.Double:
+		return numberOf(value.Double())
+	case bsontype.Decimal128:
+		return numberOf(value.Decimal128())
+	}
+	return schemaNumber{}, false
+}
+
+// numberOf returns the value of an int32, int64, float64 or Decimal128.
+func numberOf(value interface{}) (schemaNumber, bool) {
+	switch v := value.(type) {
+	case int32:
+		return numberOf(int64(v))
+	case int64:
+		exact := new(big.Rat).SetInt64(v)
+		return schemaNumber{exact, float64(v), strconv.FormatInt(v, 10), exact}, true
+	case float64:
+		n := schemaNumber{float: v, text: strconv.FormatFloat(v, 'g', -1, 64)}
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			n.exact = new(big.Rat).SetFloat64(v)
+			n.decimal, _ = new(big.Rat).SetString(n.text)
+		}
+		return n, true
+	case primitive.Decimal128:
+		n := schemaNumber{text: v.String()}
+		n.float, _ = strconv.ParseFloat(n.text, 64)
+		if coefficient, exp, err := v.BigInt(); err == nil {
+			if exp < 0 {
+				n.exact = new(big.Rat).SetFrac(coefficient, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-exp)), nil))
+			} else {
+				n.exact = new(big.Rat).SetInt(coefficient.Mul(coefficient, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)))
+			}
+			n.decimal = n.exact
+		}
+		return n, true
+	}
+	return schemaNumber{}, false
+}
+
+// validateDocument returns an error describing the first way in which a
+// document does not match the schema, or nil if it matches.
+func (s *documentSchema) validateDocument(doc bson.D) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return s.validate("", bson.RawValue{Type: bsontype.EmbeddedDocument, Value: raw})
+}
+
+// validate checks a value at the given path against the schema.
+func (s *documentSchema) validate(path string, value bson.RawValue) error {
+	if len(s.types) > 0 && !hasType(s.types, value.Type) {
+		return schemaError(path, "type %v does not match the schema", typeName(value.Type))
+	}
+	if len(s.enum) > 0 {
+		found := false
+		for _, v := range s.enum {
+			if rawValuesEqual(v, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return schemaError(path, "value %v is not in the enum", value)
+		}
+	}
+
+	var err error
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		err = s.validateObject(path, value.Document())
+	case bsontype.Array:
+		err = s.validateArray(path, value.Array())
+	case bsontype.String:
+		err = s.validateString(path, value.StringValue())
+	case bsontype.Int32, bsontype.Int64, bsontype.Double, bsontype.Decimal128:
+		n, _ := numericValue(value)
+		err = s.validateNumber(path, n)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, schema := range s.allOf {
+		if err := schema.validate(path, value); err != nil {
+			return err
+		}
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, schema := range s.anyOf {
+			if schema.validate(path, value) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return schemaError(path, "value does not match any schema in anyOf")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matches := 0
+		for _, schema := range s.oneOf {
+			if schema.validate(path, value) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return schemaError(path, "value matches %v schemas in oneOf instead of exactly one", matches)
+		}
+	}
+	if s.not != nil && s.not.validate(path, value) == nil {
+		return schemaError(path, "value matches the schema in not")
+	}
+	return nil
+}
+
+func (s *documentSchema) validateObject(path string, doc bson.Raw) error {
+	elements, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+	present := map[string]bool{}
+	for _, element := range elements {
+		present[element.Key()] = true
+	}
+	for _, name := range s.required {
+		if !present[name] {
+			return schemaError(joinPath(path, name), "required field is missing")
+		}
+	}
+	if len(elements) < s.minProperties {
+		return schemaError(path, "document has fewer than %v fields", s.minProperties)
+	}
+	if s.maxProperties >= 0 && len(elements) > s.maxProperties {
+		return schemaError(path, "document has more than %v fields", s.maxProperties)
+	}
+
+	for _, element := range elements {
+		name, value := element.Key(), element.Value()
+		fieldPath := joinPath(path, name)
+		matched := false
+		if schema, ok := s.properties[name]; ok {
+			matched = true
+			if err := schema.validate(fieldPath, value); err != nil {
+				return err
+			}
+		}
+		for _, p := range s.patternProperties {
+			if p.pattern.MatchString(name) {
+				matched = true
+				if err := p.schema.validate(fieldPath, value); err != nil {
+					return err
+				}
+			}
+		}
+		if !matched {
+			if s.noAdditional {
+				return schemaError(fieldPath, "field is not allowed by the schema")
+			}
+			if s.additionalProperties != nil {
+				if err := s.additionalProperties.validate(fieldPath, value); err != nil {
+					return err
+				}
+			}
+		}
+
+		if d, ok := s.dependencies[name]; ok {
+			for _, dependent := range d.properties {
+				if !present[dependent] {
+					return schemaError(joinPath(path, dependent), "field is required when '%v' is present", name)
+				}
+			}
+			if d.schema != nil {
+				if err := d.schema.validate(path, bson.RawValue{Type: bsontype.EmbeddedDocument, Value: doc}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *documentSchema) validateArray(path string, array bson.Raw) error {
+	values, err := array.Values()
+	if err != nil {
+		return err
+	}
+	if len(values) < s.minItems {
+		return schemaError(path, "array has fewer than %v items", s.minItems)
+	}
+	if s.maxItems >= 0 && len(values) > s.maxItems {
+		return schemaError(path, "array has more than %v items", s.maxItems)
+	}
+	for i, value := range values {
+		itemPath := joinPath(path, strconv.Itoa(i))
+		schema := s.items
+		if s.itemList != nil {
+			if i < len(s.itemList) {
+				schema = s.itemList[i]
+			} else if s.noAdditionalItems {
+				return schemaError(itemPath, "array has more than %v items", len(s.itemList))
+			} else {
+				schema = s.additionalItems
+			}
+		}
+		if schema != nil {
+			if err := schema.validate(itemPath, value); err != nil {
+				return err
+			}
+		}
+	}
+	if s.uniqueItems {
+		for i := range values {
+			for j := i + 1; j < len(values); j++ {
+				if rawValuesEqual(values[i], values[j]) {
+					return schemaError(path, "array items %v and %v are equal", i, j)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *documentSchema) validateString(path string, str string) error {
+	length := utf8.RuneCountInString(str)
+	if length < s.minLength {
+		return schemaError(path, "string is shorter than %v characters", s.minLength)
+	}
+	if s.maxLength >= 0 && length > s.maxLength {
+		return schemaError(path, "string is longer than %v characters", s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return schemaError(path, "string does not match the pattern '%v'", s.pattern)
+	}
+	return nil
+}
+
+func (s *documentSchema) validateNumber(path string, n schemaNumber) error {
+	if s.minimum != nil {
+		c, ok := n.compare(*s.minimum)
+		if !ok || c < 0 || s.exclusiveMinimum && c == 0 {
+			return schemaError(path, "%v is less than the minimum of %v", n, s.minimum)
+		}
+	}
+	if s.maximum != nil {
+		c, ok := n.compare(*s.maximum)
+		if !ok || c > 0 || s.exclusiveMaximum && c == 0 {
+			return schemaError(path, "%v is greater than the maximum of %v", n, s.maximum)
+		}
+	}
+	if s.multipleOf != nil {
+		if n.decimal == nil || !new(big.Rat).Quo(n.decimal, s.multipleOf.decimal).IsInt() {
+			return schemaError(path, "%v is not a multiple of %v", n, s.multipleOf)
+		}
+	}
+	return nil
+}
+
+func hasType(types []bsontype.Type, t bsontype.Type) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+// typeName returns the bsonType name of a BSON type.
+func typeName(t bsontype.Type) string {
+	for name, types := range schemaTypes {
+		if len(types) == 1 && types[0] == t && name != "boolean" {
+			return name
+		}
+	}
+	return t.String()
+}
+
+// rawValuesEqual compares values as the server does, so numbers of different
+// types are equal if their values are, including within documents and
+// arrays.
+func rawValuesEqual(a, b bson.RawValue) bool {
+	an, aNumber := numericValue(a)
+	bn, bNumber := numericValue(b)
+	if aNumber || bNumber {
+		c, ok := an.compare(bn)
+		return aNumber && bNumber && ok && c == 0
+	}
+	if a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case bsontype.EmbeddedDocument, bsontype.Array:
+		aElements, aErr := bson.Raw(a.Value).Elements()
+		bElements, bErr := bson.Raw(b.Value).Elements()
+		if aErr != nil || bErr != nil || len(aElements) != len(bElements) {
+			return false
+		}
+		for i := range aElements {
+			if aElements[i].Key() != bElements[i].Key() ||
+				!rawValuesEqual(aElements[i].Value(), bElements[i].Value()) {
+				return false
+			}
+		}
+		return true
+	}
+	return bytes.Equal(a.Value, b.Value)
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func schemaError(path string, format string, args ...interface{}) error {
+	if path == "" {
+		path = "document"
+	} else {
+		path = "field '" + path + "'"
+	}
+	return fmt.Errorf("%v: %v", path, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,230 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testSchema compiles a schema given as extended JSON.
+func testSchema(schema string) (*documentSchema, error) {
+	var doc bson.D
+	So(bson.UnmarshalExtJSON([]byte(schema), false, &doc), ShouldBeNil)
+	raw, err := bson.Marshal(doc)
+	So(err, ShouldBeNil)
+	return compileSchema(raw)
+}
+
+func TestValidateDocument(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a schema of a document", t, func() {
+		schema, err := testSchema(`{
+			"bsonType": "object",
+			"required": ["name", "age"],
+			"additionalProperties": false,
+			"properties": {
+				"_id": {"bsonType": "objectId"},
+				"name": {"type": "string", "minLength": 2, "pattern": "^[A-Z]"},
+				"age": {"bsonType": ["int", "long"], "minimum": 0, "maximum": 150, "exclusiveMaximum": true},
+				"score": {"bsonType": "number", "multipleOf": 0.5},
+				"born": {"bsonType": "date"},
+				"status": {"enum": ["active", "retired", 1]},
+				"tags": {"bsonType": "array", "items": {"bsonType": "string"}, "maxItems": 2, "uniqueItems": true},
+				"address": {
+					"bsonType": "object",
+					"properties": {"zip": {"bsonType": "string"}},
+					"dependencies": {"zip": ["city"]}
+				}
+			},
+			"patternProperties": {"^x_": {"bsonType": "bool"}}
+		}`)
+		So(err, ShouldBeNil)
+
+		valid := bson.D{
+			{"_id", primitive.NewObjectID()},
+			{"name", "Ann"},
+			{"age", int32(30)},
+			{"score", 7.5},
+			{"born", time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC)},
+			{"status", 1.0},
+			{"tags", bson.A{"a", "b"}},
+			{"address", bson.D{{"city", "Paris"}, {"zip", "75001"}}},
+			{"x_flag", true},
+		}
+
+		Convey("a matching document should be valid", func() {
+			So(schema.validateDocument(valid), ShouldBeNil)
+		})
+
+		Convey("mismatches should be described with the path of the field", func() {
+			with := func(key string, value interface{}) bson.D {
+				doc := bson.D{}
+				for _, e := range valid {
+					if e.Key == key {
+						e.Value = value
+					}
+					doc = append(doc, e)
+				}
+				return doc
+			}
+			tests := []struct {
+				doc     bson.D
+				message string
+			}{
+				{valid[1:2], "field 'age': required field is missing"},
+				{with("age", "30"), "field 'age': type string does not match the schema"},
+				{with("age", int64(150)), "field 'age': 150 is greater than the maximum of 150"},
+				{with("name", "ann"), "field 'name': string does not match the pattern '^[A-Z]'"},
+				{with("name", "A"), "field 'name': string is shorter than 2 characters"},
+				{with("score", int32(3)), ""},
+				{with("score", 0.2), "field 'score': 0.2 is not a multiple of 0.5"},
+				{with("status", "gone"), "field 'status': value \"gone\" is not in the enum"},
+				{with("tags", bson.A{"a", int32(1)}), "field 'tags.1': type int does not match the schema"},
+				{with("tags", bson.A{"a", "a"}), "field 'tags': array items 0 and 1 are equal"},
+				{with("address", bson.D{{"zip", "75001"}}), "field 'address.city': field is required when 'zip' is present"},
+				{with("x_flag", "yes"), "field 'x_flag': type string does not match the schema"},
+				{append(with("", nil), bson.E{"other", int32(1)}), "field 'other': field is not allowed by the schema"},
+			}
+			for _, test := range tests {
+				err := schema.validateDocument(test.doc)
+				if test.message == "" {
+					So(err, ShouldBeNil)
+				} else {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, test.message)
+				}
+			}
+		})
+	})
+
+	Convey("Combining keywords should be supported", t, func() {
+		schema, err := testSchema(`{
+			"properties": {
+				"a": {"anyOf": [{"bsonType": "string"}, {"bsonType": "int"}]},
+				"b": {"oneOf": [{"minimum": 0}, {"maximum": 10}]},
+				"c": {"not": {"bsonType": "null"}},
+				"d": {"items": [{"bsonType": "string"}], "additionalItems": false}
+			}
+		}`)
+		So(err, ShouldBeNil)
+		So(schema.validateDocument(bson.D{{"a", int32(1)}, {"b", int32(-1)}, {"c", 1.0}, {"d", bson.A{"x"}}}), ShouldBeNil)
+		So(schema.validateDocument(bson.D{{"a", 1.5}}), ShouldNotBeNil)
+		So(schema.validateDocument(bson.D{{"b", int32(5)}}), ShouldNotBeNil)
+		So(schema.validateDocument(bson.D{{"c", nil}}), ShouldNotBeNil)
+		So(schema.validateDocument(bson.D{{"d", bson.A{"x", "y"}}}), ShouldNotBeNil)
+	})
+
+	Convey("Numbers should be compared exactly whatever their type", t, func() {
+		schema, err := testSchema(`{
+			"properties": {
+				"a": {"multipleOf": 0.1},
+				"b": {"maximum": {"$numberLong": "9007199254740992"}},
+				"c": {"minimum": {"$numberDecimal": "0.3"}, "exclusiveMinimum": true},
+				"d": {"enum": [1, {"x": [2]}]},
+				"e": {"uniqueItems": true}
+			}
+		}`)
+		So(err, ShouldBeNil)
+		decimal := func(s string) primitive.Decimal128 {
+			d, err := primitive.ParseDecimal128(s)
+			So(err, ShouldBeNil)
+			return d
+		}
+		So(schema.validateDocument(bson.D{{"a", decimal("0.3")}}), ShouldBeNil)
+		So(schema.validateDocument(bson.D{{"a", decimal("0.35")}}), ShouldNotBeNil)
+		So(schema.validateDocument(bson.D{{"b", int64(9007199254740992)}}), ShouldBeNil)
+		So(schema.validateDocument(bson.D{{"b", int64(9007199254740993)}}), ShouldNotBeNil)
+		So(schema.validateDocument(bson.D{{"c", decimal("0.30")}}), ShouldNotBeNil)
+		So(schema.validateDocument(bson.D{{"c", 0.31}}), ShouldBeNil)
+		So(schema.validateDocument(bson.D{{"a", 0.3}, {"c", 1e300}}), ShouldBeNil)
+		So(schema.validateDocument(bson.D{{"d", 1.0}}), ShouldBeNil)
+		So(schema.validateDocument(bson.D{{"d", decimal("1.00")}}), ShouldBeNil)
+		So(schema.validateDocument(bson.D{{"d", bson.D{{"x", bson.A{int64(2)}}}}}), ShouldBeNil)
+		So(schema.validateDocument(bson.D{{"d", bson.D{{"x", bson.A{2.5}}}}}), ShouldNotBeNil)
+		So(schema.validateDocument(bson.D{{"e", bson.A{int32(1), 1.0}}}), ShouldNotBeNil)
+		So(schema.validateDocument(bson.D{{"e", bson.A{bson.D{{"x", int32(1)}}, bson.D{{"x", int64(1)}}}}}), ShouldNotBeNil)
+		So(schema.validateDocument(bson.D{{"e", bson.A{int64(9007199254740992), int64(9007199254740993)}}}), ShouldBeNil)
+	})
+
+	Convey("Invalid schemas should be errors", t, func() {
+		for _, schema := range []string{
+			`{"bsonType": "integer"}`,
+			`{"type": "int"}`,
+			`{"required": "a"}`,
+			`{"minLength": -1}`,
+			`{"pattern": "("}`,
+			`{"enum": []}`,
+			`{"properties": {"a": {"$ref": "#/definitions/a"}}}`,
+		} {
+			_, err := testSchema(schema)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestReadSchemaFile(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a validator file", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport_schema")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "schema.json")
+		content := `{"$jsonSchema": {"$schema": "http://json-schema.org/draft-04/schema#", "required": ["a"], "properties": {"a": {"maximum": {"$numberLong": "10"}}}}}`
+		So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+
+		Convey("the $jsonSchema should be compiled", func() {
+			schema, err := readSchemaFile(path)
+			So(err, ShouldBeNil)
+			So(schema.validateDocument(bson.D{{"a", int32(10)}}), ShouldBeNil)
+			So(schema.validateDocument(bson.D{{"a", int32(11)}}), ShouldNotBeNil)
+			So(schema.validateDocument(bson.D{{"b", int32(1)}}), ShouldNotBeNil)
+		})
+
+		Convey("documents that fail validation should be handled by --validationGrace", func() {
+			imp := NewMockMongoImport()
+			imp.schema, err = readSchemaFile(path)
+			So(err, ShouldBeNil)
+			invalid := bson.D{{"b", int32(1)}}
+
+			ok, err := imp.validateDocument(bson.D{{"a", int32(1)}})
+			So(ok, ShouldBeTrue)
+			So(err, ShouldBeNil)
+
+			_, err = imp.validateDocument(invalid)
+			So(err, ShouldNotBeNil)
+
+			imp.IngestOptions.ValidationGrace = validationWarn
+			ok, err = imp.validateDocument(invalid)
+			So(ok, ShouldBeTrue)
+			So(err, ShouldBeNil)
+
+			imp.IngestOptions.ValidationGrace = validationSkip
+			imp.rejects, err = newRejectWriter(filepath.Join(dir, "rejects.json"))
+			So(err, ShouldBeNil)
+			ok, err = imp.validateDocument(invalid)
+			So(ok, ShouldBeFalse)
+			So(err, ShouldBeNil)
+			So(imp.failureCount, ShouldEqual, 2)
+			_, err = imp.rejects.close()
+			So(err, ShouldBeNil)
+			So(readRejects(filepath.Join(dir, "rejects.json")), ShouldResemble, []bson.M{
+				{"reason": "field 'a': required field is missing", "document": bson.M{"b": int32(1)}},
+			})
+		})
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// getValuePath returns the value at a dotted path in a bson.D or bson.M.
+func getValuePath(container interface{}, path string) (interface{}, bool) {
+	parts := strings.SplitN(path, ".", 2)
+	var value interface{}
+	found := false
+	switch c := container.(type) {
+	case bson.D:
+		for _, elem := range c {
+			if elem.Key == parts[0] {
+				value, found = elem.Value, true
+				break
+			}
+		}
+	case bson.M:
+		value, found = c[parts[0]]
+	}
+	if !found || len(parts) == 1 {
+		return value, found
+	}
+	return getValuePath(value, parts[1])
+}
+
+// removeValuePath removes a dotted path from a bson.D or bson.M, returning the
+// possibly reallocated container and whether anything was removed.
+func removeValuePath(container interface{}, path string) (interface{}, bool) {
+	parts := strings.SplitN(path, ".", 2)
+	switch c := container.(type) {
+	case bson.D:
+		for i, elem := range c {
+			if elem.Key != parts[0] {
+				continue
+			}
+			if len(parts) == 1 {
+				return append(c[:i:i], c[i+1:]...), true
+			}
+			sub, removed := removeValuePath(elem.Value, parts[1])
+			c[i].Value = sub
+			return c, removed
+		}
+	case bson.M:
+		value, ok := c[parts[0]]
+		if !ok {
+			break
+		}
+		if len(parts) == 1 {
+			delete(c, parts[0])
+			return c, true
+		}
+		sub, removed := removeValuePath(value, parts[1])
+		c[parts[0]] = sub
+		return c, removed
+	}
+	return container, false
+}
+
+// setValuePath sets a dotted path in a bson.D or bson.M, creating intermediate
+// documents as needed, and returns the possibly reallocated container.
+func setValuePath(container interface{}, path string, value interface{}) interface{} {
+	parts := strings.SplitN(path, ".", 2)
+	valueFor := func(existing interface{}) interface{} {
+		if len(parts) == 1 {
+			return value
+		}
+		switch existing.(type) {
+		case bson.D, bson.M:
+		default:
+			existing = bson.D{}
+		}
+		return setValuePath(existing, parts[1], value)
+	}
+
+	switch c := container.(type) {
+	case bson.M:
+		c[parts[0]] = valueFor(c[parts[0]])
+		return c
+	case bson.D:
+		for i, elem := range c {
+			if elem.Key == parts[0] {
+				c[i].Value = valueFor(elem.Value)
+				return c
+			}
+		}
+		return append(c, bson.E{Key: parts[0], Value: valueFor(nil)})
+	default:
+		return bson.D{{parts[0], valueFor(nil)}}
+	}
+}
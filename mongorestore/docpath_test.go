@@ -0,0 +1,49 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestValuePaths(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a document", t, func() {
+		doc := bson.D{{"a", int32(1)}, {"b", bson.D{{"c", bson.M{"d": "x"}}}}}
+
+		Convey("values should be found by dotted path", func() {
+			value, ok := getValuePath(doc, "b.c.d")
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, "x")
+			_, ok = getValuePath(doc, "b.e")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("values should be set, creating intermediate documents", func() {
+			set := setValuePath(doc, "b.c.d", "y")
+			set = setValuePath(set, "e.f", int32(2))
+			So(set, ShouldResemble, bson.D{
+				{"a", int32(1)},
+				{"b", bson.D{{"c", bson.M{"d": "y"}}}},
+				{"e", bson.D{{"f", int32(2)}}},
+			})
+		})
+
+		Convey("values should be removed by dotted path", func() {
+			removed, ok := removeValuePath(doc, "b.c.d")
+			So(ok, ShouldBeTrue)
+			So(removed, ShouldResemble, bson.D{{"a", int32(1)}, {"b", bson.D{{"c", bson.M{}}}}})
+			_, ok = removeValuePath(removed, "a.z")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
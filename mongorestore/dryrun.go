@@ -0,0 +1,486 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/intents"
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// dryRunIDBatchSize is the number of _ids looked up on the target per query.
+const dryRunIDBatchSize = 1000
+
+// NamespacePlan describes what restoring a single intent would do to the
+// target, as reported by --dryRun.
+type NamespacePlan struct {
+	Namespace string `json:"namespace"`
+	Exists    bool   `json:"exists"`
+	IsView    bool   `json:"isView"`
+
+	// TargetCount is the estimated number of documents already in the target
+	TargetCount int64 `json:"targetCount"`
+	// DumpCount and DumpBytes are the documents and bytes that would be written
+	DumpCount int64 `json:"dumpCount"`
+	DumpBytes int64 `json:"dumpBytes"`
+
+	// IDsChecked of the dumped _ids were looked up on the target, and
+	// IDsExisting of those were found there.
+	IDsChecked  int64 `json:"idsChecked"`
+	IDsExisting int64 `json:"idsExisting"`
+
+	IndexConflicts    []string `json:"indexConflicts,omitempty"`
+	OptionDifferences []string `json:"optionDifferences,omitempty"`
+}
+
+// EstimatedDuplicates extrapolates the number of dumped documents whose _id
+// already exists on the target from the checked sample.
+func (plan NamespacePlan) EstimatedDuplicates() int64 {
+	if plan.IDsChecked == 0 {
+		return 0
+	}
+	if plan.IDsChecked == plan.DumpCount {
+		return plan.IDsExisting
+	}
+	return int64(float64(plan.IDsExisting) / float64(plan.IDsChecked) * float64(plan.DumpCount))
+}
+
+// log pretty-prints the plan.
+func (plan NamespacePlan) log() {
+	switch {
+	case plan.IsView && plan.Exists:
+		log.Logvf(log.Always, "%v: view already exists on target", plan.Namespace)
+	case plan.IsView:
+		log.Logvf(log.Always, "%v: view would be created", plan.Namespace)
+	case plan.Exists:
+		log.Logvf(log.Always, "%v: collection exists on target with ~%v %v",
+			plan.Namespace, plan.TargetCount, util.Pluralize(int(plan.TargetCount), "document", "documents"))
+	default:
+		log.Logvf(log.Always, "%v: collection would be created", plan.Namespace)
+	}
+	if !plan.IsView {
+		log.Logvf(log.Always, "\t%v %v (%v bytes) to write",
+			plan.DumpCount, util.Pluralize(int(plan.DumpCount), "document", "documents"), plan.DumpBytes)
+	}
+	if plan.Exists && plan.IDsChecked > 0 {
+		qualifier := "all"
+		if plan.IDsChecked < plan.DumpCount {
+			qualifier = "a sample of"
+		}
+		log.Logvf(log.Always, "\t%v of %v %v checked _ids already exist on target (~%v duplicate key %v expected)",
+			plan.IDsExisting, qualifier, plan.IDsChecked, plan.EstimatedDuplicates(),
+			util.Pluralize(int(plan.EstimatedDuplicates()), "error", "errors"))
+	}
+	for _, conflict := range plan.IndexConflicts {
+		log.Logvf(log.Always, "\tindex conflict: %v", conflict)
+	}
+	for _, diff := range plan.OptionDifferences {
+		log.Logvf(log.Always, "\toption difference: %v", diff)
+	}
+}
+
+// DryRun reads every intent and compares it against the target without writing
+// anything, logging a plan for each namespace.
+func (restore *MongoRestore) DryRun() ([]NamespacePlan, error) {
+	var plans []NamespacePlan
+	var ioBuf []byte
+	for {
+		intent := restore.manager.Pop()
+		if intent == nil {
+			break
+		}
+		if fileNeedsIOBuffer, ok := intent.BSONFile.(intents.FileNeedsIOBuffer); ok {
+			if ioBuf == nil {
+				ioBuf = make([]byte, db.MaxBSONSize)
+			}
+			fileNeedsIOBuffer.TakeIOBuffer(ioBuf)
+		}
+		plan, err := restore.PlanIntent(intent)
+		if err != nil {
+			return plans, fmt.Errorf("%v: %v", intent.Namespace(), err)
+		}
+		plan.log()
+		plans = append(plans, plan)
+		restore.manager.Finish(intent)
+		if fileNeedsIOBuffer, ok := intent.BSONFile.(intents.FileNeedsIOBuffer); ok {
+			fileNeedsIOBuffer.ReleaseIOBuffer()
+		}
+	}
+
+	if restore.ShouldRestoreUsersAndRoles() {
+		log.Logv(log.Always, "users and roles would be restored")
+	}
+	if restore.InputOptions.OplogReplay {
+		count, err := restore.countOplogEntries()
+		if err != nil {
+			return plans, err
+		}
+		log.Logvf(log.Always, "%v oplog %v would be replayed", count, util.Pluralize(int(count), "entry", "entries"))
+	}
+
+	var totalDocs, totalBytes int64
+	for _, plan := range plans {
+		totalDocs += plan.DumpCount
+		totalBytes += plan.DumpBytes
+	}
+	log.Logvf(log.Always, "%v %v, %v %v (%v bytes) would be restored",
+		len(plans), util.Pluralize(len(plans), "namespace", "namespaces"),
+		totalDocs, util.Pluralize(int(totalDocs), "document", "documents"), totalBytes)
+	return plans, nil
+}
+
+// PlanIntent compares a single intent against the target.
+func (restore *MongoRestore) PlanIntent(intent *intents.Intent) (NamespacePlan, error) {
+	plan := NamespacePlan{Namespace: intent.Namespace()}
+
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return plan, fmt.Errorf("error establishing connection: %v", err)
+	}
+	collection := session.Database(intent.DB).Collection(intent.C)
+
+	plan.Exists, err = restore.CollectionExists(intent)
+	if err != nil {
+		return plan, fmt.Errorf("error reading database: %v", err)
+	}
+	// a dropped collection is recreated from scratch
+	checkTarget := plan.Exists && !restore.OutputOptions.Drop
+
+	var metadata *Metadata
+	if intent.MetadataFile != nil {
+		metadata, err = restore.ReadIntentMetadata(intent)
+		if err != nil {
+			return plan, err
+		}
+	}
+	var indexes []IndexDocument
+	if metadata != nil {
+		plan.IsView = isViewOptions(metadata.Options)
+		indexes = metadata.Indexes
+	} else if dbIndexes, ok := restore.dbCollectionIndexes[intent.DB]; ok {
+		fallback := &Metadata{Indexes: dbIndexes[intent.C]}
+		restore.applyMetadataRules(intent.Namespace(), fallback)
+		indexes = fallback.Indexes
+	}
+
+	if checkTarget {
+		if !plan.IsView {
+			plan.TargetCount, err = collection.EstimatedDocumentCount(nil)
+			if err != nil {
+				return plan, fmt.Errorf("error counting target documents: %v", err)
+			}
+		}
+		if metadata != nil && !restore.OutputOptions.NoOptionsRestore {
+			targetOptions, err := getCollectionOptions(session.Database(intent.DB), intent.C)
+			if err != nil {
+				return plan, err
+			}
+			plan.OptionDifferences = diffCollectionOptions(metadata.Options, targetOptions)
+		}
+		if len(indexes) > 0 && !restore.OutputOptions.NoIndexRestore {
+			targetIndexes, err := getTargetIndexes(collection)
+			if err != nil {
+				return plan, err
+			}
+			plan.IndexConflicts = findIndexConflicts(indexes, targetIndexes)
+		}
+	}
+
+	if intent.BSONFile == nil {
+		return plan, nil
+	}
+	err = intent.BSONFile.Open()
+	if err != nil {
+		return plan, err
+	}
+	defer intent.BSONFile.Close()
+	bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(intent.BSONFile))
+	defer bsonSource.Close()
+
+	checker := &idChecker{
+		collection: collection,
+		sampleSize: restore.OutputOptions.DryRunSampleSize,
+		enabled:    checkTarget && !plan.IsView,
+	}
+	for {
+		doc := bsonSource.LoadNext()
+		if doc == nil {
+			break
+		}
+		plan.DumpCount++
+		plan.DumpBytes += int64(len(doc))
+		if err = checker.add(doc); err != nil {
+			return plan, err
+		}
+	}
+	if err = bsonSource.Err(); err != nil {
+		return plan, fmt.Errorf("reading bson input: %v", err)
+	}
+	if err = checker.finish(); err != nil {
+		return plan, err
+	}
+	plan.IDsChecked, plan.IDsExisting = checker.checked, checker.existing
+	return plan, nil
+}
+
+// idChecker looks up dumped _ids on the target. With a positive sampleSize it
+// keeps a uniform random sample of _ids and checks them at the end; otherwise
+// every _id is checked in batches as documents are read.
+type idChecker struct {
+	collection *mongo.Collection
+	sampleSize int
+	enabled    bool
+
+	seen     int
+	pending  bson.A
+	checked  int64
+	existing int64
+}
+
+func (c *idChecker) add(doc bson.Raw) error {
+	if !c.enabled {
+		return nil
+	}
+	idValue, err := doc.LookupErr("_id")
+	if err != nil {
+		return nil
+	}
+	var id interface{}
+	if err = idValue.Unmarshal(&id); err != nil {
+		return nil
+	}
+	c.seen++
+	if c.sampleSize <= 0 {
+		c.pending = append(c.pending, id)
+		if len(c.pending) >= dryRunIDBatchSize {
+			return c.flush()
+		}
+		return nil
+	}
+	if len(c.pending) < c.sampleSize {
+		c.pending = append(c.pending, id)
+	} else if j := rand.Intn(c.seen); j < c.sampleSize {
+		c.pending[j] = id
+	}
+	return nil
+}
+
+func (c *idChecker) finish() error {
+	for len(c.pending) > 0 {
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush looks up one batch of pending _ids on the target.
+func (c *idChecker) flush() error {
+	batch := c.pending
+	if len(batch) > dryRunIDBatchSize {
+		batch = batch[:dryRunIDBatchSize]
+	}
+	c.pending = c.pending[len(batch):]
+
+	count, err := c.collection.CountDocuments(nil, bson.D{{"_id", bson.D{{"$in", batch}}}})
+	if err != nil {
+		return fmt.Errorf("error checking for existing _ids: %v", err)
+	}
+	c.checked += int64(len(batch))
+	c.existing += count
+	return nil
+}
+
+// getCollectionOptions returns the options of an existing collection in
+// their original order.
+func getCollectionOptions(database *mongo.Database, name string) (bson.D, error) {
+	cursor, err := database.ListCollections(nil, bson.D{{"name", name}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing collections: %v", err)
+	}
+	defer cursor.Close(nil)
+	info := struct {
+		Options bson.D `bson:"options"`
+	}{}
+	if cursor.Next(nil) {
+		if err = cursor.Decode(&info); err != nil {
+			return nil, fmt.Errorf("error reading collection options: %v", err)
+		}
+	}
+	return info.Options, cursor.Err()
+}
+
+// getTargetIndexes returns the indexes of an existing collection.
+func getTargetIndexes(collection *mongo.Collection) ([]IndexDocument, error) {
+	cursor, err := collection.Indexes().List(nil, mopt.ListIndexes())
+	if err != nil {
+		return nil, fmt.Errorf("error listing indexes: %v", err)
+	}
+	defer cursor.Close(nil)
+	var indexes []IndexDocument
+	for cursor.Next(nil) {
+		index := IndexDocument{}
+		if err = cursor.Decode(&index); err != nil {
+			return nil, fmt.Errorf("error reading index: %v", err)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, cursor.Err()
+}
+
+// ignoredOptionDifferences are collection options that are expected to differ
+// between the dump and the target.
+var ignoredOptionDifferences = map[string]bool{
+	"idIndex":     true,
+	"autoIndexId": true,
+}
+
+// diffCollectionOptions describes every option whose value differs between
+// the dump and the target.
+func diffCollectionOptions(dumped, target bson.D) []string {
+	values := map[string][2]string{}
+	for _, elem := range dumped {
+		v := values[elem.Key]
+		v[0] = createExtJSONString(bson.D{elem})
+		values[elem.Key] = v
+	}
+	for _, elem := range target {
+		v := values[elem.Key]
+		v[1] = createExtJSONString(bson.D{elem})
+		values[elem.Key] = v
+	}
+
+	var diffs []string
+	for key, v := range values {
+		if ignoredOptionDifferences[key] || v[0] == v[1] {
+			continue
+		}
+		switch {
+		case v[1] == "":
+			diffs = append(diffs, fmt.Sprintf("%v in dump but %v unset on target", v[0], key))
+		case v[0] == "":
+			diffs = append(diffs, fmt.Sprintf("%v unset in dump but %v on target", key, v[1]))
+		default:
+			diffs = append(diffs, fmt.Sprintf("%v in dump but %v on target", v[0], v[1]))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// comparedIndexOptions are the index options that make two indexes with the
+// same name incompatible when they differ.
+var comparedIndexOptions = []string{"unique", "sparse", "expireAfterSeconds", "collation", "weights"}
+
+// findIndexConflicts describes dumped indexes that createIndexes would reject
+// because of indexes already on the target.
+func findIndexConflicts(dumped, target []IndexDocument) []string {
+	var conflicts []string
+	for _, index := range dumped {
+		name, _ := index.Options["name"].(string)
+		if name == "_id_" {
+			continue
+		}
+		key := createExtJSONString(index.Key)
+		for _, existing := range target {
+			existingName, _ := existing.Options["name"].(string)
+			sameKey := indexKeysEqual(index.Key, existing.Key)
+			switch {
+			case existingName == name && !sameKey:
+				conflicts = append(conflicts, fmt.Sprintf(
+					"index %v has key %v in dump but %v on target", name, key, createExtJSONString(existing.Key)))
+			case existingName == name:
+				for _, opt := range comparedIndexOptions {
+					if !indexOptionsEqual(opt, index.Options[opt], existing.Options[opt]) {
+						conflicts = append(conflicts, fmt.Sprintf("index %v has %v in dump but %v on target", name,
+							createExtJSONString(bson.M{opt: index.Options[opt]}),
+							createExtJSONString(bson.M{opt: existing.Options[opt]})))
+					}
+				}
+			case sameKey && existingName != "_id_":
+				conflicts = append(conflicts, fmt.Sprintf(
+					"index %v on %v already exists on target as %v", name, key, existingName))
+			}
+		}
+	}
+	return conflicts
+}
+
+// indexKeysEqual reports whether two index keys have the same fields in the
+// same order, treating numerically equal directions as equal.
+func indexKeysEqual(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || compareBSONValues(a[i].Value, b[i].Value) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// indexOptionsEqual compares a single index option, treating a false boolean
+// option the same as an unset one.
+func indexOptionsEqual(opt string, a, b interface{}) bool {
+	if opt == "unique" || opt == "sparse" {
+		return util.IsTruthy(a) == util.IsTruthy(b)
+	}
+	return createExtJSONString(bson.M{opt: a}) == createExtJSONString(bson.M{opt: b})
+}
+
+// countOplogEntries reads the oplog intent and counts the entries that would
+// be replayed.
+func (restore *MongoRestore) countOplogEntries() (int64, error) {
+	intent := restore.manager.Oplog()
+	if intent == nil {
+		return 0, nil
+	}
+	if err := intent.BSONFile.Open(); err != nil {
+		return 0, err
+	}
+	defer intent.BSONFile.Close()
+	if fileNeedsIOBuffer, ok := intent.BSONFile.(intents.FileNeedsIOBuffer); ok {
+		fileNeedsIOBuffer.TakeIOBuffer(make([]byte, db.MaxBSONSize))
+		defer fileNeedsIOBuffer.ReleaseIOBuffer()
+	}
+	bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(intent.BSONFile))
+	defer bsonSource.Close()
+
+	var count int64
+	for {
+		entry := db.Oplog{}
+		if !bsonSource.Next(&entry) {
+			break
+		}
+		if !restore.TimestampBeforeLimit(entry.Timestamp) {
+			break
+		}
+		if entry.Operation == "n" || shouldIgnoreNamespace(entry.Namespace) {
+			continue
+		}
+		_, keep, err := restore.filterOplogEntry(entry)
+		if err != nil {
+			return count, fmt.Errorf("error filtering oplog entry: %v", err)
+		}
+		if keep {
+			count++
+		}
+	}
+	if err := bsonSource.Err(); err != nil {
+		return count, fmt.Errorf("error reading oplog bson input: %v", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,89 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDiffCollectionOptions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With dumped and target collection options", t, func() {
+		dumped := bson.D{
+			{"capped", true},
+			{"size", int64(4096)},
+			{"idIndex", bson.D{{"name", "_id_"}}},
+			{"validationLevel", "strict"},
+		}
+
+		Convey("identical options should report no differences", func() {
+			So(diffCollectionOptions(dumped, dumped), ShouldBeEmpty)
+		})
+
+		Convey("changed, missing and extra options should be reported", func() {
+			target := bson.D{
+				{"capped", true},
+				{"size", int64(8192)},
+				{"validationAction", "warn"},
+			}
+			So(diffCollectionOptions(dumped, target), ShouldResemble, []string{
+				`validationAction unset in dump but {"validationAction":"warn"} on target`,
+				`{"size":4096} in dump but {"size":8192} on target`,
+				`{"validationLevel":"strict"} in dump but validationLevel unset on target`,
+			})
+		})
+	})
+}
+
+func TestFindIndexConflicts(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With indexes on the target", t, func() {
+		target := []IndexDocument{
+			{Key: bson.D{{"_id", int32(1)}}, Options: bson.M{"name": "_id_"}},
+			{Key: bson.D{{"a", int32(1)}}, Options: bson.M{"name": "a_1"}},
+			{Key: bson.D{{"b", int32(1)}}, Options: bson.M{"name": "b_idx", "unique": true}},
+		}
+
+		Convey("matching dumped indexes should not conflict", func() {
+			dumped := []IndexDocument{
+				{Key: bson.D{{"_id", int32(1)}}, Options: bson.M{"name": "_id_"}},
+				{Key: bson.D{{"a", 1.0}}, Options: bson.M{"name": "a_1", "unique": false}},
+				{Key: bson.D{{"c", int32(1)}}, Options: bson.M{"name": "c_1"}},
+			}
+			So(findIndexConflicts(dumped, target), ShouldBeEmpty)
+		})
+
+		Convey("conflicting keys, options and names should be reported", func() {
+			dumped := []IndexDocument{
+				{Key: bson.D{{"a", int32(-1)}}, Options: bson.M{"name": "a_1"}},
+				{Key: bson.D{{"b", int32(1)}}, Options: bson.M{"name": "b_idx"}},
+				{Key: bson.D{{"b", int32(1)}}, Options: bson.M{"name": "b_1", "unique": true}},
+			}
+			So(findIndexConflicts(dumped, target), ShouldResemble, []string{
+				`index a_1 has key {"a":-1} in dump but {"a":1} on target`,
+				`index b_idx has {"unique":null} in dump but {"unique":true} on target`,
+				`index b_1 on {"b":1} already exists on target as b_idx`,
+			})
+		})
+	})
+}
+
+func TestEstimatedDuplicates(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Duplicate estimates should extrapolate from the checked sample", t, func() {
+		So(NamespacePlan{DumpCount: 100}.EstimatedDuplicates(), ShouldEqual, 0)
+		So(NamespacePlan{DumpCount: 100, IDsChecked: 100, IDsExisting: 7}.EstimatedDuplicates(), ShouldEqual, 7)
+		So(NamespacePlan{DumpCount: 1000, IDsChecked: 100, IDsExisting: 25}.EstimatedDuplicates(), ShouldEqual, 250)
+	})
+}
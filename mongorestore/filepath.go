@@ -389,6 +389,20 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 					log.Logvf(log.DebugLow, "skipping restoring %v.%v, it is excluded", db, collection)
 					skip = true
 				}
+				// the dumped config database describes how the source cluster was
+				// sharded; use it to shard the restored collections instead of
+				// restoring it over the target's own config database.
+				if db == "config" && restore.OutputOptions.ShardCollections {
+					log.Logvf(log.DebugLow, "not restoring %v.%v, it is used as sharding metadata", db, collection)
+					if restore.InputOptions.Archive == "" && (collection == "collections" || collection == "chunks") {
+						restore.shardingConfigFiles[collection] = &realBSONFile{
+							path:   entry.Path(),
+							intent: &intents.Intent{DB: db, C: collection},
+							gzip:   restore.InputOptions.Gzip,
+						}
+					}
+					skip = true
+				}
 				destNS := restore.renamer.Get(sourceNS)
 				destDB, destC := util.SplitNamespace(destNS)
 				intent := &intents.Intent{
@@ -427,6 +441,7 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 					intent.BSONFile = &realBSONFile{path: entry.Path(), intent: intent, gzip: restore.InputOptions.Gzip}
 				}
 				log.Logvf(log.Info, "found collection %v bson to restore to %v", sourceNS, destNS)
+				restore.recordRename(sourceNS, destNS)
 				restore.manager.PutWithNamespace(sourceNS, intent)
 			case MetadataFileType:
 				if collection == "system.profile" {
@@ -441,6 +456,9 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 					log.Logvf(log.DebugLow, "skipping restoring %v.%v metadata, it is excluded", db, collection)
 					continue
 				}
+				if db == "config" && restore.OutputOptions.ShardCollections {
+					continue
+				}
 
 				usesMetadataFiles = true
 				destNS := restore.renamer.Get(sourceNS)
@@ -462,6 +480,7 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 					intent.MetadataFile = &realMetadataFile{path: entry.Path(), intent: intent, gzip: restore.InputOptions.Gzip}
 				}
 				log.Logvf(log.Info, "found collection metadata from %v to restore to %v", sourceNS, destNS)
+				restore.recordRename(sourceNS, destNS)
 				restore.manager.PutWithNamespace(sourceNS, intent)
 			default:
 				log.Logvf(log.Always, `don't know what to do with file "%v", skipping...`,
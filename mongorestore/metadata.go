@@ -9,6 +9,7 @@ package mongorestore
 import (
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 
 	"github.com/mongodb/mongo-tools-common/db"
 	"github.com/mongodb/mongo-tools-common/intents"
@@ -65,6 +66,35 @@ func (restore *MongoRestore) MetadataFromJSON(jsonBytes []byte) (*Metadata, erro
 	return meta, nil
 }
 
+// ReadIntentMetadata opens and parses the metadata file of an intent.
+func (restore *MongoRestore) ReadIntentMetadata(intent *intents.Intent) (*Metadata, error) {
+	err := intent.MetadataFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer intent.MetadataFile.Close()
+
+	metadataJSON, err := ioutil.ReadAll(intent.MetadataFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata from %v: %v", intent.MetadataLocation, err)
+	}
+	metadata, err := restore.MetadataFromJSON(metadataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing metadata from %v: %v", intent.MetadataLocation, err)
+	}
+	if metadata != nil {
+		if isViewOptions(metadata.Options) {
+			source := intent.Namespace()
+			if renamedFrom, ok := restore.renamedFrom[source]; ok {
+				source = renamedFrom
+			}
+			metadata.Options = restore.renameViewOptions(metadata.Options, source, intent.Namespace())
+		}
+		restore.applyMetadataRules(intent.Namespace(), metadata)
+	}
+	return metadata, nil
+}
+
 // LoadIndexesFromBSON reads indexes from the index BSON files and
 // caches them in the MongoRestore object.
 func (restore *MongoRestore) LoadIndexesFromBSON() error {
@@ -152,6 +182,25 @@ func (restore *MongoRestore) addToKnownCollections(intent *intents.Intent) {
 	restore.knownCollections[intent.DB] = append(restore.knownCollections[intent.DB], intent.C)
 }
 
+// removeFromKnownCollections removes a dropped collection from the
+// restore.knownCollections cache.
+func (restore *MongoRestore) removeFromKnownCollections(intent *intents.Intent) {
+	restore.knownCollectionsMutex.Lock()
+	defer restore.knownCollectionsMutex.Unlock()
+
+	names, ok := restore.knownCollections[intent.DB]
+	if !ok {
+		return
+	}
+	kept := []string{}
+	for _, name := range names {
+		if name != intent.C {
+			kept = append(kept, name)
+		}
+	}
+	restore.knownCollections[intent.DB] = kept
+}
+
 // CreateIndexes takes in an intent and an array of index documents and
 // attempts to create them using the createIndexes command. If that command
 // fails, we fall back to individual index creation.
@@ -314,7 +363,8 @@ func createCollectionCommand(intent *intents.Intent, options bson.D) bson.D {
 //
 // _mergeAuthzCollections is an internal server command implemented specifically for mongorestore. Instead of inserting
 // into the admin.system.{roles, users} collections (which isn't allowed due to some locking policies), we construct
-// temporary collections that are then merged with or replace the existing ones.
+// temporary collections that are then merged with or replace the existing ones. Documents are rewritten on their
+// way into the temporary collections so that users and roles follow database renames (see authRewriter).
 //
 // The "drop" argument that determines whether the merge replaces the existing users/roles or adds to them.
 //
@@ -371,6 +421,11 @@ func (restore *MongoRestore) RestoreUsersOrRoles(users, roles *intents.Intent) e
 		return fmt.Errorf("error establishing connection: %v", err)
 	}
 
+	rewriter, err := restore.newAuthRewriter()
+	if err != nil {
+		return err
+	}
+
 	// For each of the users and roles intents:
 	//   build up the mergeArgs component of the _mergeAuthzCollections command
 	//   upload the BSONFile to a temporary collection
@@ -409,7 +464,13 @@ func (restore *MongoRestore) RestoreUsersOrRoles(users, roles *intents.Intent) e
 		}
 
 		log.Logvf(log.DebugLow, "restoring %v to temporary collection", arg.intentType)
-		result := restore.RestoreCollectionToDB("admin", arg.tempCollectionName, bsonSource, arg.intent.BSONFile, 0)
+		// users and roles that fail to restore are not saved with
+		// --rejectsDir, so that credentials are not written out
+		pipeline := collectionPipeline{rewrite: rewriter.applyRole, noRejects: true}
+		if arg.intentType == "users" {
+			pipeline.rewrite = rewriter.applyUser
+		}
+		result := restore.restoreCollectionToDB("admin", arg.tempCollectionName, bsonSource, arg.intent.BSONFile, 0, pipeline)
 		if result.Err != nil {
 			return fmt.Errorf("error restoring %v: %v", arg.intentType, result.Err)
 		}
@@ -472,6 +533,9 @@ func (restore *MongoRestore) RestoreUsersOrRoles(users, roles *intents.Intent) e
 	if util.IsFalsy(res["ok"]) {
 		return fmt.Errorf("_mergeAuthzCollections command: %v", res["errmsg"])
 	}
+	for _, user := range rewriter.unusedPasswords() {
+		log.Logvf(log.Always, "warning: user %v in %v was not restored", user, UserPasswordsFileOption)
+	}
 	return nil
 }
 
@@ -0,0 +1,198 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MetadataRule is a single entry of a --metadataRulesFile. Rules rewrite the
+// collection options and index specs of every namespace matching NS before
+// the collection and its indexes are created, in this order: options are
+// dropped then set, indexes are dropped then renamed, index key types are
+// converted, and finally index options are dropped then set. Index option
+// rules never apply to the _id index.
+//
+// Option paths may be dotted to reach into sub-documents.
+//
+// Example rules file:
+//
+//	[
+//	   {
+//	      "ns": "*.*",
+//	      "dropOptions": ["storageEngine.wiredTiger.configString"],
+//	      "dropIndexOptions": ["bucketSize"],
+//	      "convertIndexTypes": {"2d": "2dsphere"}
+//	   },
+//	   {
+//	      "ns": "shop.products",
+//	      "setOptions": {"collation": {"locale": "en"}},
+//	      "renameIndexes": {"name_1": "by_name"},
+//	      "dropIndexes": ["legacy_text"]
+//	   }
+//	]
+type MetadataRule struct {
+	NS                string   `bson:"ns"`
+	DropOptions       []string `bson:"dropOptions"`
+	SetOptions        bson.D   `bson:"setOptions"`
+	DropIndexes       []string `bson:"dropIndexes"`
+	RenameIndexes     bson.D   `bson:"renameIndexes"`
+	ConvertIndexTypes bson.D   `bson:"convertIndexTypes"`
+	DropIndexOptions  []string `bson:"dropIndexOptions"`
+	SetIndexOptions   bson.D   `bson:"setIndexOptions"`
+}
+
+// compiledMetadataRule is a MetadataRule prepared for application.
+type compiledMetadataRule struct {
+	MetadataRule
+	matcher       *ns.Matcher
+	renameIndexes map[string]string
+	convertTypes  map[string]string
+}
+
+// LoadMetadataRules reads and compiles the rules in a --metadataRulesFile.
+func LoadMetadataRules(path string) ([]*compiledMetadataRule, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata rules file: %v", err)
+	}
+	return parseMetadataRules(content)
+}
+
+// parseMetadataRules compiles a JSON array of MetadataRules.
+func parseMetadataRules(content []byte) ([]*compiledMetadataRule, error) {
+	var rules []MetadataRule
+	if err := unmarshalExtJSONArray(content, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing metadata rules: %v", err)
+	}
+
+	compiled := make([]*compiledMetadataRule, 0, len(rules))
+	for i, rule := range rules {
+		c, err := compileMetadataRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata rule %v: %v", i, err)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func compileMetadataRule(rule MetadataRule) (*compiledMetadataRule, error) {
+	if rule.NS == "" {
+		return nil, fmt.Errorf(`missing "ns"`)
+	}
+	matcher, err := ns.NewMatcher([]string{rule.NS})
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace pattern '%v': %v", rule.NS, err)
+	}
+	c := &compiledMetadataRule{
+		MetadataRule:  rule,
+		matcher:       matcher,
+		renameIndexes: map[string]string{},
+		convertTypes:  map[string]string{},
+	}
+	for _, name := range rule.DropIndexes {
+		if name == "_id_" {
+			return nil, fmt.Errorf("cannot drop the _id index")
+		}
+	}
+	for _, elem := range rule.RenameIndexes {
+		to, ok := elem.Value.(string)
+		if !ok || to == "" {
+			return nil, fmt.Errorf("new name for index '%v' must be a string", elem.Key)
+		}
+		if elem.Key == "_id_" || to == "_id_" {
+			return nil, fmt.Errorf("cannot rename the _id index")
+		}
+		c.renameIndexes[elem.Key] = to
+	}
+	for _, elem := range rule.ConvertIndexTypes {
+		to, ok := elem.Value.(string)
+		if !ok || to == "" {
+			return nil, fmt.Errorf("conversion for index type '%v' must be an index type name", elem.Key)
+		}
+		c.convertTypes[elem.Key] = to
+	}
+	for _, elem := range rule.SetIndexOptions {
+		if elem.Key == "name" || elem.Key == "key" || elem.Key == "ns" {
+			return nil, fmt.Errorf("cannot set index option '%v'", elem.Key)
+		}
+	}
+	return c, nil
+}
+
+// applyMetadataRules rewrites the options and indexes of a namespace with
+// every matching --metadataRulesFile rule.
+func (restore *MongoRestore) applyMetadataRules(namespace string, metadata *Metadata) {
+	for _, rule := range restore.metadataRules {
+		if rule.matcher.Has(namespace) {
+			rule.apply(namespace, metadata)
+		}
+	}
+}
+
+// apply rewrites metadata in place.
+func (rule *compiledMetadataRule) apply(namespace string, metadata *Metadata) {
+	for _, path := range rule.DropOptions {
+		if options, removed := removeValuePath(metadata.Options, path); removed {
+			metadata.Options = options.(bson.D)
+			log.Logvf(log.Info, "%v: dropped collection option %v", namespace, path)
+		}
+	}
+	for _, elem := range rule.SetOptions {
+		metadata.Options = setValuePath(metadata.Options, elem.Key, elem.Value).(bson.D)
+		log.Logvf(log.Info, "%v: set collection option %v", namespace, elem.Key)
+	}
+
+	kept := metadata.Indexes[:0]
+	for _, index := range metadata.Indexes {
+		name, _ := index.Options["name"].(string)
+		if util.StringSliceContains(rule.DropIndexes, name) {
+			log.Logvf(log.Info, "%v: dropped index %v", namespace, name)
+			continue
+		}
+		if name == "_id_" {
+			kept = append(kept, index)
+			continue
+		}
+		if to, ok := rule.renameIndexes[name]; ok {
+			index.Options["name"] = to
+			log.Logvf(log.Info, "%v: renamed index %v to %v", namespace, name, to)
+		}
+		for i, elem := range index.Key {
+			if indexType, ok := elem.Value.(string); ok {
+				if to, ok := rule.convertTypes[indexType]; ok {
+					index.Key[i].Value = to
+					log.Logvf(log.Info, "%v: converted %v index on %v to %v", namespace, indexType, elem.Key, to)
+				}
+			}
+		}
+		for _, path := range rule.DropIndexOptions {
+			if path == "partialFilterExpression" {
+				index.PartialFilterExpression = nil
+				continue
+			}
+			if options, removed := removeValuePath(index.Options, path); removed {
+				index.Options = options.(bson.M)
+			}
+		}
+		if len(rule.SetIndexOptions) > 0 && index.Options == nil {
+			index.Options = bson.M{}
+		}
+		for _, elem := range rule.SetIndexOptions {
+			index.Options = setValuePath(index.Options, elem.Key, elem.Value).(bson.M)
+		}
+		kept = append(kept, index)
+	}
+	metadata.Indexes = kept
+}
@@ -0,0 +1,114 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseMetadataRules(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With metadata rule files", t, func() {
+		Convey("a valid file should compile", func() {
+			rules, err := parseMetadataRules([]byte(`[
+				{"ns": "*.*", "dropOptions": ["storageEngine"], "convertIndexTypes": {"2d": "2dsphere"}},
+				{"ns": "db.c", "renameIndexes": {"a_1": "by_a"}}
+			]`))
+			So(err, ShouldBeNil)
+			So(len(rules), ShouldEqual, 2)
+			So(rules[1].renameIndexes, ShouldResemble, map[string]string{"a_1": "by_a"})
+		})
+
+		Convey("rules touching the _id index should fail", func() {
+			_, err := parseMetadataRules([]byte(`[{"ns": "db.c", "dropIndexes": ["_id_"]}]`))
+			So(err, ShouldNotBeNil)
+			_, err = parseMetadataRules([]byte(`[{"ns": "db.c", "renameIndexes": {"a_1": "_id_"}}]`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rules setting an index's name or key should fail", func() {
+			_, err := parseMetadataRules([]byte(`[{"ns": "db.c", "setIndexOptions": {"name": "x"}}]`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestApplyMetadataRules(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With rules for every namespace and for one collection", t, func() {
+		rules, err := parseMetadataRules([]byte(`[{
+			"ns": "*.*",
+			"dropOptions": ["storageEngine.wiredTiger.configString"],
+			"convertIndexTypes": {"2d": "2dsphere"},
+			"dropIndexOptions": ["bits", "partialFilterExpression"]
+		}, {
+			"ns": "shop.products",
+			"setOptions": {"collation": {"locale": "en"}},
+			"dropIndexes": ["legacy"],
+			"renameIndexes": {"name_1": "by_name"},
+			"setIndexOptions": {"collation.strength": 2}
+		}]`))
+		So(err, ShouldBeNil)
+		restore := &MongoRestore{metadataRules: rules}
+
+		metadata := func() *Metadata {
+			return &Metadata{
+				Options: bson.D{
+					{"capped", false},
+					{"storageEngine", bson.D{{"wiredTiger", bson.D{{"configString", "block_compressor=zlib"}}}}},
+				},
+				Indexes: []IndexDocument{
+					{Key: bson.D{{"_id", 1}}, Options: bson.M{"name": "_id_", "bits": 26}},
+					{Key: bson.D{{"name", 1}}, Options: bson.M{"name": "name_1"}},
+					{Key: bson.D{{"loc", "2d"}}, Options: bson.M{"name": "loc_2d", "bits": 26},
+						PartialFilterExpression: bson.D{{"loc", bson.D{{"$exists", true}}}}},
+					{Key: bson.D{{"old", 1}}, Options: bson.M{"name": "legacy"}},
+				},
+			}
+		}
+
+		Convey("rules matching the namespace should rewrite options and indexes", func() {
+			m := metadata()
+			restore.applyMetadataRules("shop.products", m)
+			So(m.Options, ShouldResemble, bson.D{
+				{"capped", false},
+				{"storageEngine", bson.D{{"wiredTiger", bson.D{}}}},
+				{"collation", bson.D{{"locale", "en"}}},
+			})
+			So(len(m.Indexes), ShouldEqual, 3)
+			So(m.Indexes[0].Options, ShouldResemble, bson.M{"name": "_id_", "bits": 26})
+			So(m.Indexes[1].Options, ShouldResemble, bson.M{
+				"name": "by_name", "collation": bson.D{{"strength", int32(2)}},
+			})
+			So(m.Indexes[2].Key, ShouldResemble, bson.D{{"loc", "2dsphere"}})
+			So(m.Indexes[2].Options, ShouldResemble, bson.M{
+				"name": "loc_2d", "collation": bson.D{{"strength", int32(2)}},
+			})
+			So(m.Indexes[2].PartialFilterExpression, ShouldBeNil)
+		})
+
+		Convey("other namespaces should only get the matching rules", func() {
+			m := metadata()
+			restore.applyMetadataRules("shop.orders", m)
+			So(len(m.Indexes), ShouldEqual, 4)
+			So(m.Indexes[1].Options["name"], ShouldEqual, "name_1")
+			So(m.Indexes[2].Key, ShouldResemble, bson.D{{"loc", "2dsphere"}})
+		})
+
+		Convey("indexes without options should get the options set", func() {
+			m := &Metadata{Indexes: []IndexDocument{{Key: bson.D{{"a", 1}}}}}
+			restore.applyMetadataRules("shop.products", m)
+			So(m.Indexes[0].Options, ShouldResemble, bson.M{"collation": bson.D{{"strength", int32(2)}}})
+		})
+	})
+}
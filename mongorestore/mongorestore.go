@@ -26,6 +26,7 @@ import (
 	"github.com/mongodb/mongo-tools-common/progress"
 	"github.com/mongodb/mongo-tools-common/util"
 	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -55,6 +56,7 @@ type MongoRestore struct {
 
 	objCheck         bool
 	oplogLimit       primitive.Timestamp
+	oplogOps         map[string]bool
 	isMongos         bool
 	useWriteCommands bool
 	authVersions     authVersionPair
@@ -70,6 +72,50 @@ type MongoRestore struct {
 	// indexes belonging to dbs and collections
 	dbCollectionIndexes map[string]collectionIndexes
 
+	// state for sharding collections when restoring through mongos
+	shardKey            bson.D
+	shardingConfigFiles map[string]*realBSONFile
+	dumpedShardInfo     map[string]*dumpedShardInfo
+	shardNames          []string
+	shardingEnabledDBs  map[string]bool
+	shardingMutex       sync.Mutex
+
+	// document transformation rules from --transformFile
+	transformRules []*compiledTransform
+
+	// collection routing rules from --routeFile
+	routeRules []*compiledRouteRule
+	// namespaces that documents are routed to, which may be shared by several
+	// intents; they are dropped once up front and prepared one at a time
+	routeTargets      map[string]bool
+	routeTargetsMutex sync.Mutex
+
+	// collection option and index rewriting rules from --metadataRulesFile
+	metadataRules []*compiledMetadataRule
+
+	// new passwords of restored users from --userPasswordsFile
+	userPasswords map[string]string
+
+	// statistics for --reportFile, or nil
+	report *restoreReport
+
+	// digests of the documents restored to each namespace for --verify, or
+	// nil
+	verify *docDigests
+
+	// documents that failed to restore for --rejectsDir, or nil
+	rejects *rejectWriter
+
+	// throttle slows inserts down while the target is under pressure
+	throttle *throttle
+
+	// dumped namespaces of renamed intents, keyed by their new namespace
+	renamedFrom map[string]string
+
+	// views waiting to be created once their sources are restored
+	viewsMutex   sync.Mutex
+	pendingViews []pendingView
+
 	archive *archive.Reader
 
 	// channel on which to notify if/when a termination signal is received
@@ -196,6 +242,15 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 			return fmt.Errorf("error parsing timestamp argument to --oplogLimit: %v", err)
 		}
 	}
+	if restore.InputOptions.OplogOps != "" {
+		if !restore.InputOptions.OplogReplay {
+			return fmt.Errorf("cannot use %v without %v enabled", OplogOpsOption, OplogReplayOption)
+		}
+		restore.oplogOps, err = parseOplogOps(restore.InputOptions.OplogOps)
+		if err != nil {
+			return fmt.Errorf("error parsing %v: %v", OplogOpsOption, err)
+		}
+	}
 	if restore.InputOptions.OplogFile != "" {
 		if !restore.InputOptions.OplogReplay {
 			return fmt.Errorf("cannot use --oplogFile without --oplogReplay enabled")
@@ -238,19 +293,6 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		log.Logvf(log.Always, "the --excludeCollections and --excludeCollectionPrefixes options "+
 			"are deprecated and will not exist in the future; use --nsExclude instead")
 	}
-	if restore.InputOptions.OplogReplay {
-		if len(restore.NSOptions.NSInclude) > 0 || restore.NSOptions.DB != "" {
-			return fmt.Errorf("cannot use --oplogReplay with includes specified")
-		}
-		if len(restore.NSOptions.NSExclude) > 0 || len(restore.NSOptions.ExcludedCollections) > 0 ||
-			len(restore.NSOptions.ExcludedCollectionPrefixes) > 0 {
-			return fmt.Errorf("cannot use --oplogReplay with excludes specified")
-		}
-		if len(restore.NSOptions.NSFrom) > 0 {
-			return fmt.Errorf("cannot use --oplogReplay with namespace renames specified")
-		}
-	}
-
 	includes := restore.NSOptions.NSInclude
 	if restore.NSOptions.DB != "" && restore.NSOptions.Collection != "" {
 		includes = append(includes, ns.Escape(restore.NSOptions.DB)+"."+
@@ -266,6 +308,19 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		return fmt.Errorf("invalid includes: %v", err)
 	}
 
+	if restore.NSOptions.ToDB != "" {
+		if restore.NSOptions.DB != "" || restore.NSOptions.Collection != "" {
+			return fmt.Errorf("cannot use %v with --db or --collection; use --nsInclude to choose the database to restore",
+				ToDBOption)
+		}
+		if restore.TargetDirectory == "-" {
+			return fmt.Errorf("cannot use %v when restoring from standard input", ToDBOption)
+		}
+		if err = util.ValidateDBName(restore.NSOptions.ToDB); err != nil {
+			return fmt.Errorf("invalid %v '%v': %v", ToDBOption, restore.NSOptions.ToDB, err)
+		}
+	}
+
 	if len(restore.NSOptions.ExcludedCollections) > 0 && restore.NSOptions.Collection != "" {
 		return fmt.Errorf("--collection is not allowed when --excludeCollection is specified")
 	}
@@ -297,11 +352,99 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 			"cannot specify a negative number of insertion workers per collection")
 	}
 
+	if restore.OutputOptions.NumOplogWorkers < 0 {
+		return fmt.Errorf("cannot specify a negative number of oplog workers")
+	}
+
 	if restore.OutputOptions.MaintainInsertionOrder {
 		restore.OutputOptions.StopOnError = true
 		restore.OutputOptions.NumInsertionWorkers = 1
 	}
 
+	if restore.OutputOptions.MaxReplicationLag < 0 {
+		return fmt.Errorf("cannot specify a negative %v", MaxReplicationLagOption)
+	}
+	if restore.OutputOptions.MinWriteTickets < 0 {
+		return fmt.Errorf("cannot specify a negative %v", MinWriteTicketsOption)
+	}
+	if (restore.OutputOptions.MaxReplicationLag > 0 || restore.OutputOptions.MinWriteTickets > 0) &&
+		restore.OutputOptions.ThrottleInterval < 1 {
+		return fmt.Errorf("throttle interval must be positive")
+	}
+
+	if restore.OutputOptions.Verify && restore.OutputOptions.DryRun {
+		return fmt.Errorf("cannot use %v with %v", VerifyOption, DryRunOption)
+	}
+	// documents already in a collection would not match the restored ones
+	if restore.OutputOptions.Verify && !restore.OutputOptions.Drop {
+		return fmt.Errorf("cannot use %v without %v", VerifyOption, DropOption)
+	}
+
+	if restore.OutputOptions.DryRunSampleSize < 0 {
+		return fmt.Errorf("cannot specify a negative --dryRunSampleSize")
+	}
+
+	if restore.OutputOptions.ShardKey != "" && !restore.OutputOptions.ShardCollections {
+		return fmt.Errorf("cannot use %v without %v", ShardKeyOption, ShardCollectionsOption)
+	}
+	if restore.OutputOptions.ShardCollections {
+		if !restore.isMongos {
+			return fmt.Errorf("cannot use %v unless connected to a mongos", ShardCollectionsOption)
+		}
+		if restore.OutputOptions.ChunksPerShard < 1 {
+			return fmt.Errorf("cannot specify fewer than one chunk per shard")
+		}
+		restore.shardKey = bson.D{{"_id", 1}}
+		if restore.OutputOptions.ShardKey != "" {
+			restore.shardKey, err = parseShardKey(restore.OutputOptions.ShardKey)
+			if err != nil {
+				return fmt.Errorf("error parsing %v: %v", ShardKeyOption, err)
+			}
+		}
+		restore.shardingConfigFiles = map[string]*realBSONFile{}
+	}
+
+	if restore.OutputOptions.TransformFile != "" {
+		restore.transformRules, err = LoadTransformRules(restore.OutputOptions.TransformFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if restore.OutputOptions.ReportFile != "" {
+		restore.report = newRestoreReport()
+	}
+	if restore.OutputOptions.RejectsDir != "" {
+		restore.rejects = newRejectWriter(restore.OutputOptions.RejectsDir)
+	}
+
+	if restore.OutputOptions.MetadataRulesFile != "" {
+		restore.metadataRules, err = LoadMetadataRules(restore.OutputOptions.MetadataRulesFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err = ns.NewMatcher(restore.InputOptions.ExcludeUsers); err != nil {
+		return fmt.Errorf("invalid %v: %v", ExcludeUserOption, err)
+	}
+	if restore.InputOptions.UserPasswordsFile != "" {
+		restore.userPasswords, err = LoadUserPasswords(restore.InputOptions.UserPasswordsFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if restore.OutputOptions.RouteFile != "" {
+		if restore.OutputOptions.PreserveUUID {
+			return fmt.Errorf("cannot use %v with --preserveUUID", RouteFileOption)
+		}
+		restore.routeRules, err = LoadRouteRules(restore.OutputOptions.RouteFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	if restore.OutputOptions.PreserveUUID {
 		if !restore.OutputOptions.Drop {
 			return fmt.Errorf("cannot specify --preserveUUID without --drop")
@@ -335,12 +478,41 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 
 // Restore runs the mongorestore program.
 func (restore *MongoRestore) Restore() Result {
+	result := restore.runRestore()
+	if restore.rejects != nil {
+		count, err := restore.rejects.close()
+		if err != nil {
+			log.Logvf(log.Always, "%v", err)
+			if result.Err == nil {
+				result.Err = err
+			}
+		} else if count > 0 {
+			log.Logvf(log.Always, "%v rejected %v written to %v", count,
+				util.Pluralize(int(count), "document", "documents"), restore.OutputOptions.RejectsDir)
+		}
+	}
+	if restore.report != nil {
+		err := restore.report.write(restore.OutputOptions.ReportFile, result)
+		if err != nil {
+			log.Logvf(log.Always, "%v", err)
+			if result.Err == nil {
+				result.Err = err
+			}
+		}
+	}
+	return result
+}
+
+// runRestore restores the intents found in the target, followed by users and
+// roles and the oplog.
+func (restore *MongoRestore) runRestore() Result {
 	var target archive.DirLike
 	err := restore.ParseAndValidateOptions()
 	if err != nil {
 		log.Logvf(log.DebugLow, "got error from options parsing: %v", err)
 		return Result{Err: err}
 	}
+	endScan := restore.report.phase("scan")
 
 	// Build up all intents to be restored
 	restore.manager = intents.NewIntentManager()
@@ -422,6 +594,12 @@ func (restore *MongoRestore) Restore() Result {
 		restore.archive.Demux = archive.CreateDemux(restore.archive.Prelude.NamespaceMetadatas, restore.archive.In)
 	}
 
+	if restore.NSOptions.ToDB != "" {
+		if err = restore.setupToDB(target); err != nil {
+			return Result{Err: err}
+		}
+	}
+
 	switch {
 	case restore.InputOptions.Archive != "":
 		log.Logvf(log.Always, "preparing collections to restore from")
@@ -481,10 +659,15 @@ func (restore *MongoRestore) Restore() Result {
 		}
 		return Result{Err: fmt.Errorf("cannot restore with conflicting namespace destinations")}
 	}
+	if err = restore.findRouteTargets(); err != nil {
+		return Result{Err: err}
+	}
 
-	if restore.OutputOptions.DryRun {
-		log.Logvf(log.Always, "dry run completed")
-		return Result{}
+	if restore.OutputOptions.ShardCollections {
+		err = restore.LoadDumpedShardingMetadata()
+		if err != nil {
+			return Result{Err: fmt.Errorf("error reading sharding metadata from dump: %v", err)}
+		}
 	}
 
 	demuxFinished := make(chan interface{})
@@ -554,6 +737,7 @@ func (restore *MongoRestore) Restore() Result {
 	if err != nil {
 		return Result{Err: fmt.Errorf("restore error: %v", err)}
 	}
+	endScan()
 
 	// Restore the regular collections
 	if restore.InputOptions.Archive != "" {
@@ -565,16 +749,60 @@ func (restore *MongoRestore) Restore() Result {
 		restore.manager.Finalize(intents.Legacy)
 	}
 
+	if restore.OutputOptions.DryRun {
+		_, err = restore.DryRun()
+		if err != nil {
+			return Result{Err: fmt.Errorf("dry run error: %v", err)}
+		}
+		if restore.InputOptions.Archive != "" {
+			<-demuxFinished
+			if demuxErr != nil {
+				return Result{Err: demuxErr}
+			}
+		}
+		log.Logvf(log.Always, "dry run completed")
+		return Result{}
+	}
+
 	restore.termChan = make(chan struct{})
 
+	if restore.OutputOptions.MaxReplicationLag > 0 || restore.OutputOptions.MinWriteTickets > 0 {
+		restore.throttle, err = restore.startThrottle(
+			time.Duration(restore.OutputOptions.ThrottleInterval) * time.Millisecond)
+		if err != nil {
+			return Result{Err: err}
+		}
+		defer restore.throttle.stop()
+	}
+
+	if err = restore.dropRouteTargets(); err != nil {
+		return Result{Err: err}
+	}
+
+	if restore.OutputOptions.Verify {
+		restore.verify = newDocDigests()
+	}
+
+	endCollections := restore.report.phase("collections")
 	result := restore.RestoreIntents()
+	endCollections()
 	if result.Err != nil {
 		return result
 	}
 
+	// collections are verified once every intent restored to them is done
+	if restore.verify != nil {
+		result.Mismatches, err = restore.verifyRestored()
+		if err != nil {
+			return result.withErr(err)
+		}
+	}
+
 	// Restore users/roles
 	if restore.ShouldRestoreUsersAndRoles() {
+		endUsers := restore.report.phase("users and roles")
 		err = restore.RestoreUsersOrRoles(restore.manager.Users(), restore.manager.Roles())
+		endUsers()
 		if err != nil {
 			return result.withErr(fmt.Errorf("restore error: %v", err))
 		}
@@ -582,7 +810,9 @@ func (restore *MongoRestore) Restore() Result {
 
 	// Restore oplog
 	if restore.InputOptions.OplogReplay {
+		endOplog := restore.report.phase("oplog")
 		err = restore.RestoreOplog()
+		endOplog()
 		if err != nil {
 			return result.withErr(fmt.Errorf("restore error: %v", err))
 		}
@@ -590,7 +820,20 @@ func (restore *MongoRestore) Restore() Result {
 
 	if restore.InputOptions.Archive != "" {
 		<-demuxFinished
-		return result.withErr(demuxErr)
+		if demuxErr != nil {
+			return result.withErr(demuxErr)
+		}
+	}
+
+	if len(result.Mismatches) > 0 {
+		for _, mismatch := range result.Mismatches {
+			log.Logvf(log.Always, "verification failed for %v", mismatch)
+		}
+		return result.withErr(fmt.Errorf("verification failed for %v %v",
+			len(result.Mismatches), util.Pluralize(len(result.Mismatches), "collection", "collections")))
+	}
+	if restore.OutputOptions.Verify {
+		log.Logv(log.Always, "verified all restored collections")
 	}
 
 	return result
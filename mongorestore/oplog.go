@@ -17,6 +17,7 @@ import (
 	"github.com/mongodb/mongo-tools-common/progress"
 	"github.com/mongodb/mongo-tools-common/txn"
 	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -33,6 +34,7 @@ type oplogContext struct {
 	session    *mongo.Client
 	totalOps   int
 	txnBuffer  *txn.Buffer
+	applier    *oplogApplier
 }
 
 // shouldIgnoreNamespace returns true if the given namespace should be ignored during applyOps.
@@ -44,6 +46,157 @@ func shouldIgnoreNamespace(ns string) bool {
 	return false
 }
 
+// oplogOpTypes are the oplog operation types that can be selected with --oplogOps.
+var oplogOpTypes = map[string]bool{"i": true, "u": true, "d": true, "c": true}
+
+// oplogCollectionCommands are the oplog commands whose first field names the
+// collection they act on.
+var oplogCollectionCommands = map[string]bool{
+	"create":           true,
+	"drop":             true,
+	"collMod":          true,
+	"createIndexes":    true,
+	"dropIndexes":      true,
+	"deleteIndexes":    true,
+	"convertToCapped":  true,
+	"emptycapped":      true,
+	"startIndexBuild":  true,
+	"commitIndexBuild": true,
+	"abortIndexBuild":  true,
+}
+
+// parseOplogOps parses a comma-separated list of oplog operation types.
+func parseOplogOps(arg string) (map[string]bool, error) {
+	ops := map[string]bool{}
+	for _, op := range strings.Split(arg, ",") {
+		op = strings.TrimSpace(op)
+		if !oplogOpTypes[op] {
+			return nil, fmt.Errorf("unknown oplog operation type '%v', expected one of i, u, d or c", op)
+		}
+		ops[op] = true
+	}
+	return ops, nil
+}
+
+// includeOplogNamespace returns true if the namespace passes the include and
+// exclude rules.
+func (restore *MongoRestore) includeOplogNamespace(namespace string) bool {
+	return restore.includer.Has(namespace) && !restore.excluder.Has(namespace)
+}
+
+// filterOplogEntry applies the namespace include, exclude and rename rules and
+// the --oplogOps selection to an oplog entry, descending into applyOps. It
+// returns false if nothing in the entry should be replayed.
+func (restore *MongoRestore) filterOplogEntry(op db.Oplog) (db.Oplog, bool, error) {
+	if op.Operation == "c" && isApplyOpsCmd(op.Object) {
+		return restore.filterApplyOpsEntry(op)
+	}
+	if restore.oplogOps != nil && !restore.oplogOps[op.Operation] {
+		return op, false, nil
+	}
+
+	dbName, collName := util.SplitNamespace(op.Namespace)
+	switch {
+	case op.Operation != "c" && collName == "system.indexes":
+		// legacy index builds are inserts that name the indexed collection in "ns"
+		object, source, ok := renameOplogField(op.Object, "ns", restore.renamer)
+		if !ok {
+			return op, false, fmt.Errorf("unknown format for system.indexes insert")
+		}
+		if !restore.includeOplogNamespace(source) {
+			return op, false, nil
+		}
+		destDB, _ := util.SplitNamespace(restore.renamer.Get(source))
+		op.Namespace = destDB + ".system.indexes"
+		op.Object = object
+	case op.Operation != "c":
+		if !restore.includeOplogNamespace(op.Namespace) {
+			return op, false, nil
+		}
+		op.Namespace = restore.renamer.Get(op.Namespace)
+	case len(op.Object) > 0 && op.Object[0].Key == "renameCollection":
+		object, source, ok := renameOplogField(op.Object, "renameCollection", restore.renamer)
+		if !ok {
+			return op, false, fmt.Errorf("unknown format for renameCollection")
+		}
+		if !restore.includeOplogNamespace(source) {
+			return op, false, nil
+		}
+		op.Object, _, ok = renameOplogField(object, "to", restore.renamer)
+		if !ok {
+			return op, false, fmt.Errorf("unknown format for renameCollection")
+		}
+	case len(op.Object) > 0 && oplogCollectionCommands[op.Object[0].Key]:
+		coll, ok := op.Object[0].Value.(string)
+		if !ok {
+			return op, false, fmt.Errorf("unknown format for %v", op.Object[0].Key)
+		}
+		source := dbName + "." + coll
+		if !restore.includeOplogNamespace(source) {
+			return op, false, nil
+		}
+		destDB, destColl := util.SplitNamespace(restore.renamer.Get(source))
+		op.Namespace = destDB + ".$cmd"
+		op.Object = append(bson.D{{op.Object[0].Key, destColl}}, op.Object[1:]...)
+	default:
+		// database-level commands such as dropDatabase
+		if !restore.includeOplogNamespace(op.Namespace) {
+			return op, false, nil
+		}
+		op.Namespace = restore.renamer.Get(op.Namespace)
+	}
+	return op, true, nil
+}
+
+// filterApplyOpsEntry filters the ops nested in an applyOps entry, returning
+// false if none of them should be replayed.
+func (restore *MongoRestore) filterApplyOpsEntry(op db.Oplog) (db.Oplog, bool, error) {
+	ops, err := unwrapNestedApplyOps(op.Object)
+	if err != nil {
+		return op, false, err
+	}
+	kept := make([]db.Oplog, 0, len(ops))
+	for _, nested := range ops {
+		nested, keep, err := restore.filterOplogEntry(nested)
+		if err != nil {
+			return op, false, err
+		}
+		if keep {
+			kept = append(kept, nested)
+		}
+	}
+	if len(kept) == 0 {
+		return op, false, nil
+	}
+	if len(kept) < len(ops) {
+		log.Logvf(log.DebugHigh, "filtered %v of %v ops from applyOps", len(ops)-len(kept), len(ops))
+	}
+	op.Object, err = wrapNestedApplyOps(kept)
+	if err != nil {
+		return op, false, err
+	}
+	return op, true, nil
+}
+
+// renameOplogField returns a copy of doc with the namespace string in field
+// renamed, along with the original namespace.
+func renameOplogField(doc bson.D, field string, renamer *ns.Renamer) (bson.D, string, bool) {
+	renamed := make(bson.D, len(doc))
+	copy(renamed, doc)
+	for i, elem := range renamed {
+		if elem.Key != field {
+			continue
+		}
+		namespace, ok := elem.Value.(string)
+		if !ok {
+			return nil, "", false
+		}
+		renamed[i].Value = renamer.Get(namespace)
+		return renamed, namespace, true
+	}
+	return nil, "", false
+}
+
 // RestoreOplog attempts to restore a MongoDB oplog.
 func (restore *MongoRestore) RestoreOplog() error {
 	log.Logv(log.Always, "replaying oplog")
@@ -78,6 +231,13 @@ func (restore *MongoRestore) RestoreOplog() error {
 	}
 	defer oplogCtx.txnBuffer.Stop()
 
+	if restore.OutputOptions.NumOplogWorkers > 1 {
+		oplogCtx.applier = newOplogApplier(restore.OutputOptions.NumOplogWorkers, func(ops []interface{}) error {
+			return restore.ApplyOps(session, ops)
+		})
+		defer oplogCtx.applier.Close()
+	}
+
 	if restore.ProgressManager != nil {
 		restore.ProgressManager.Attach("oplog", oplogCtx.progressor)
 		defer restore.ProgressManager.Detach("oplog")
@@ -133,11 +293,17 @@ func (restore *MongoRestore) RestoreOplog() error {
 		}
 
 	}
+	if oplogCtx.applier != nil {
+		if err := oplogCtx.applier.Close(); err != nil {
+			return fmt.Errorf("error applying oplog: %v", err)
+		}
+	}
 	if fileNeedsIOBuffer, ok := intent.BSONFile.(intents.FileNeedsIOBuffer); ok {
 		fileNeedsIOBuffer.ReleaseIOBuffer()
 	}
 
 	log.Logvf(log.Always, "applied %v oplog entries", oplogCtx.totalOps)
+	restore.report.recordOplog(oplogCtx.totalOps)
 	if err := bsonSource.Err(); err != nil {
 		return fmt.Errorf("error reading oplog bson input: %v", err)
 	}
@@ -146,13 +312,38 @@ func (restore *MongoRestore) RestoreOplog() error {
 }
 
 func (restore *MongoRestore) HandleNonTxnOp(oplogCtx *oplogContext, op db.Oplog) error {
+	return restore.handleOp(oplogCtx, op, false)
+}
+
+// handleOp filters and applies a single op. With parallel oplog workers, CRUD
+// ops are queued on a worker lane, while commands and transaction ops act as
+// barriers that are applied only after everything queued before them.
+func (restore *MongoRestore) handleOp(oplogCtx *oplogContext, op db.Oplog, inTxn bool) error {
+	op, keep, err := restore.filterOplogEntry(op)
+	if err != nil {
+		return fmt.Errorf("error filtering oplog entry: %v", err)
+	}
+	if !keep {
+		return nil
+	}
 	oplogCtx.totalOps++
 
-	op, err := restore.filterUUIDs(op)
+	op, err = restore.filterUUIDs(op)
 	if err != nil {
 		return fmt.Errorf("error filtering UUIDs from oplog: %v", err)
 	}
 
+	if oplogCtx.applier != nil {
+		if !inTxn {
+			dispatched, err := oplogCtx.applier.Dispatch(op)
+			if err != nil || dispatched {
+				return err
+			}
+		}
+		if err = oplogCtx.applier.Flush(); err != nil {
+			return err
+		}
+	}
 	return restore.ApplyOps(oplogCtx.session, []interface{}{op})
 }
 
@@ -185,7 +376,7 @@ Loop:
 			if !ok {
 				break Loop
 			}
-			err = restore.HandleNonTxnOp(oplogCtx, o)
+			err = restore.handleOp(oplogCtx, o, true)
 			if err != nil {
 				return fmt.Errorf("error applying transaction op: %v", err)
 			}
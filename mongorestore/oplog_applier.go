@@ -0,0 +1,188 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// oplogMaxBatchOps is the maximum number of ops a lane applies in a single
+// applyOps command.
+const oplogMaxBatchOps = 1000
+
+// oplogLaneBuffer is the number of ops that can be queued for each lane.
+const oplogLaneBuffer = 1024
+
+// oplogLaneMsg is either an op to apply or a request to apply everything
+// queued so far.
+type oplogLaneMsg struct {
+	op      *db.Oplog
+	size    int
+	flushed *sync.WaitGroup
+}
+
+// oplogApplier applies CRUD oplog entries in parallel lanes. Entries are
+// assigned to a lane by namespace and document _id so that all entries for a
+// document are applied in their original order. Each lane batches its entries
+// into applyOps commands.
+type oplogApplier struct {
+	apply     func(ops []interface{}) error
+	lanes     []chan oplogLaneMsg
+	done      sync.WaitGroup
+	closeOnce sync.Once
+
+	errMutex sync.Mutex
+	err      error
+}
+
+// newOplogApplier starts numLanes lanes that apply batches of ops with apply.
+func newOplogApplier(numLanes int, apply func(ops []interface{}) error) *oplogApplier {
+	applier := &oplogApplier{
+		apply: apply,
+		lanes: make([]chan oplogLaneMsg, numLanes),
+	}
+	for i := range applier.lanes {
+		applier.lanes[i] = make(chan oplogLaneMsg, oplogLaneBuffer)
+		applier.done.Add(1)
+		go applier.runLane(applier.lanes[i])
+	}
+	return applier
+}
+
+// oplogDocumentKey returns the namespace and _id identifying the document a
+// CRUD op modifies, or false if the op must be applied serially.
+func oplogDocumentKey(op db.Oplog) ([]byte, bool) {
+	var doc bson.D
+	switch op.Operation {
+	case "i", "d":
+		doc = op.Object
+	case "u":
+		doc = op.Query
+	default:
+		return nil, false
+	}
+	for _, elem := range doc {
+		if elem.Key != "_id" {
+			continue
+		}
+		raw, err := bson.Marshal(bson.D{elem})
+		if err != nil {
+			return nil, false
+		}
+		return append([]byte(op.Namespace+"\x00"), raw...), true
+	}
+	return nil, false
+}
+
+// laneFor returns the lane that applies ops with the given document key.
+func (applier *oplogApplier) laneFor(key []byte) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write(key)
+	return int(hash.Sum32() % uint32(len(applier.lanes)))
+}
+
+// Dispatch queues an op on its document's lane, or returns false if the op
+// has no document key and must be applied serially after a Flush.
+func (applier *oplogApplier) Dispatch(op db.Oplog) (bool, error) {
+	if err := applier.getErr(); err != nil {
+		return false, err
+	}
+	key, ok := oplogDocumentKey(op)
+	if !ok {
+		return false, nil
+	}
+	raw, err := bson.Marshal(op)
+	if err != nil {
+		return false, fmt.Errorf("error encoding oplog entry: %v", err)
+	}
+	applier.lanes[applier.laneFor(key)] <- oplogLaneMsg{op: &op, size: len(raw)}
+	return true, nil
+}
+
+// Flush waits until every queued op has been applied. It is used as a barrier
+// before commands and transactions.
+func (applier *oplogApplier) Flush() error {
+	flushed := &sync.WaitGroup{}
+	flushed.Add(len(applier.lanes))
+	for _, lane := range applier.lanes {
+		lane <- oplogLaneMsg{flushed: flushed}
+	}
+	flushed.Wait()
+	return applier.getErr()
+}
+
+// Close applies every queued op and stops the lanes. It is safe to call more
+// than once.
+func (applier *oplogApplier) Close() error {
+	applier.closeOnce.Do(func() {
+		for _, lane := range applier.lanes {
+			close(lane)
+		}
+	})
+	applier.done.Wait()
+	return applier.getErr()
+}
+
+// runLane batches the ops queued on a lane, applying the batch whenever it is
+// full, the lane is idle, or a flush is requested.
+func (applier *oplogApplier) runLane(lane chan oplogLaneMsg) {
+	defer applier.done.Done()
+
+	var batch []interface{}
+	var batchSize int
+	applyBatch := func() {
+		if len(batch) > 0 && applier.getErr() == nil {
+			applier.setErr(applier.apply(batch))
+		}
+		batch, batchSize = nil, 0
+	}
+
+	for {
+		var msg oplogLaneMsg
+		var ok bool
+		select {
+		case msg, ok = <-lane:
+		default:
+			applyBatch()
+			msg, ok = <-lane
+		}
+		if !ok {
+			applyBatch()
+			return
+		}
+		if msg.flushed != nil {
+			applyBatch()
+			msg.flushed.Done()
+			continue
+		}
+		if len(batch) >= oplogMaxBatchOps || (len(batch) > 0 && batchSize+msg.size > oplogMaxCommandSize) {
+			applyBatch()
+		}
+		batch = append(batch, *msg.op)
+		batchSize += msg.size
+	}
+}
+
+func (applier *oplogApplier) getErr() error {
+	applier.errMutex.Lock()
+	defer applier.errMutex.Unlock()
+	return applier.err
+}
+
+// setErr records the first error encountered by any lane.
+func (applier *oplogApplier) setErr(err error) {
+	applier.errMutex.Lock()
+	defer applier.errMutex.Unlock()
+	if applier.err == nil {
+		applier.err = err
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// recordingApplier collects the ops applied by an oplogApplier.
+type recordingApplier struct {
+	mutex   sync.Mutex
+	batches [][]interface{}
+	fail    bool
+}
+
+func (r *recordingApplier) apply(ops []interface{}) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.fail {
+		return fmt.Errorf("applyOps failed")
+	}
+	r.batches = append(r.batches, ops)
+	return nil
+}
+
+// appliedValues returns the last field of each op applied to a namespace, in order.
+func (r *recordingApplier) appliedValues(namespace string) []interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var values []interface{}
+	for _, batch := range r.batches {
+		for _, op := range batch {
+			if op := op.(db.Oplog); op.Namespace == namespace {
+				values = append(values, op.Object[len(op.Object)-1].Value)
+			}
+		}
+	}
+	return values
+}
+
+func TestOplogDocumentKey(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("CRUD ops should be keyed by namespace and _id", t, func() {
+		insert, ok := oplogDocumentKey(db.Oplog{Operation: "i", Namespace: "db.c", Object: bson.D{{"_id", 1}, {"a", 1}}})
+		So(ok, ShouldBeTrue)
+		update, ok := oplogDocumentKey(db.Oplog{
+			Operation: "u", Namespace: "db.c", Query: bson.D{{"_id", 1}}, Object: bson.D{{"$set", bson.D{{"a", 2}}}},
+		})
+		So(ok, ShouldBeTrue)
+		So(update, ShouldResemble, insert)
+		other, ok := oplogDocumentKey(db.Oplog{Operation: "d", Namespace: "db.other", Object: bson.D{{"_id", 1}}})
+		So(ok, ShouldBeTrue)
+		So(other, ShouldNotResemble, insert)
+
+		Convey("while commands and ops without an _id should not be", func() {
+			_, ok := oplogDocumentKey(db.Oplog{Operation: "c", Namespace: "db.$cmd", Object: bson.D{{"drop", "c"}}})
+			So(ok, ShouldBeFalse)
+			_, ok = oplogDocumentKey(db.Oplog{Operation: "u", Namespace: "db.c", Object: bson.D{{"$set", bson.D{{"a", 2}}}}})
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestOplogApplier(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an applier with four lanes", t, func() {
+		recorder := &recordingApplier{}
+		applier := newOplogApplier(4, recorder.apply)
+
+		Convey("updates to one document should be applied in order", func() {
+			for i := 0; i < 5000; i++ {
+				dispatched, err := applier.Dispatch(db.Oplog{
+					Operation: "u", Namespace: fmt.Sprintf("db.c%v", i%3),
+					Query: bson.D{{"_id", 1}}, Object: bson.D{{"$set", bson.D{{"n", i}}}},
+				})
+				So(err, ShouldBeNil)
+				So(dispatched, ShouldBeTrue)
+			}
+			So(applier.Close(), ShouldBeNil)
+
+			values := recorder.appliedValues("db.c0")
+			So(len(values), ShouldEqual, 1667)
+			for i, value := range values {
+				So(value, ShouldResemble, bson.D{{"n", i * 3}})
+			}
+			for _, batch := range recorder.batches {
+				So(len(batch), ShouldBeLessThanOrEqualTo, oplogMaxBatchOps)
+			}
+		})
+
+		Convey("a flush should apply everything queued", func() {
+			for i := 0; i < 100; i++ {
+				_, err := applier.Dispatch(db.Oplog{Operation: "i", Namespace: "db.c", Object: bson.D{{"_id", i}}})
+				So(err, ShouldBeNil)
+			}
+			So(applier.Flush(), ShouldBeNil)
+			So(len(recorder.appliedValues("db.c")), ShouldEqual, 100)
+			So(applier.Close(), ShouldBeNil)
+		})
+
+		Convey("ops without a document key should not be dispatched", func() {
+			dispatched, err := applier.Dispatch(db.Oplog{Operation: "c", Namespace: "db.$cmd", Object: bson.D{{"drop", "c"}}})
+			So(err, ShouldBeNil)
+			So(dispatched, ShouldBeFalse)
+			So(applier.Close(), ShouldBeNil)
+		})
+
+		Convey("apply errors should be returned", func() {
+			recorder.fail = true
+			_, err := applier.Dispatch(db.Oplog{Operation: "i", Namespace: "db.c", Object: bson.D{{"_id", 1}}})
+			So(err, ShouldBeNil)
+			So(applier.Flush(), ShouldNotBeNil)
+			So(applier.Close(), ShouldNotBeNil)
+		})
+	})
+}
@@ -10,8 +10,10 @@ import (
 	"context"
 	"testing"
 
+	"github.com/mongodb/mongo-tools-common/db"
 	"github.com/mongodb/mongo-tools-common/testtype"
 	"github.com/mongodb/mongo-tools-common/testutil"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -191,3 +193,112 @@ func TestOplogRestoreTools2002(t *testing.T) {
 		So(result.Failures, ShouldEqual, 0)
 	})
 }
+
+func TestFilterOplogEntry(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With include, exclude and rename rules", t, func() {
+		includer, err := ns.NewMatcher([]string{"db1.*"})
+		So(err, ShouldBeNil)
+		excluder, err := ns.NewMatcher([]string{"db1.skip"})
+		So(err, ShouldBeNil)
+		renamer, err := ns.NewRenamer([]string{"db1.*"}, []string{"db2.*"})
+		So(err, ShouldBeNil)
+		mr := &MongoRestore{includer: includer, excluder: excluder, renamer: renamer}
+
+		Convey("CRUD ops should be filtered and renamed by namespace", func() {
+			op, keep, err := mr.filterOplogEntry(db.Oplog{Operation: "i", Namespace: "db1.c1", Object: bson.D{{"_id", 1}}})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			So(op.Namespace, ShouldEqual, "db2.c1")
+
+			_, keep, err = mr.filterOplogEntry(db.Oplog{Operation: "u", Namespace: "db1.skip"})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeFalse)
+
+			_, keep, err = mr.filterOplogEntry(db.Oplog{Operation: "d", Namespace: "other.c1"})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeFalse)
+		})
+
+		Convey("collection commands should be filtered and renamed by their target", func() {
+			op, keep, err := mr.filterOplogEntry(db.Oplog{
+				Operation: "c", Namespace: "db1.$cmd", Object: bson.D{{"create", "c1"}, {"capped", true}},
+			})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			So(op.Namespace, ShouldEqual, "db2.$cmd")
+			So(op.Object, ShouldResemble, bson.D{{"create", "c1"}, {"capped", true}})
+
+			_, keep, err = mr.filterOplogEntry(db.Oplog{
+				Operation: "c", Namespace: "db1.$cmd", Object: bson.D{{"drop", "skip"}},
+			})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeFalse)
+
+			op, keep, err = mr.filterOplogEntry(db.Oplog{
+				Operation: "c", Namespace: "admin.$cmd",
+				Object: bson.D{{"renameCollection", "db1.a"}, {"to", "db1.b"}},
+			})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			So(op.Object, ShouldResemble, bson.D{{"renameCollection", "db2.a"}, {"to", "db2.b"}})
+		})
+
+		Convey("legacy index inserts should be filtered by the indexed namespace", func() {
+			op, keep, err := mr.filterOplogEntry(db.Oplog{
+				Operation: "i", Namespace: "db1.system.indexes",
+				Object: bson.D{{"v", 2}, {"key", bson.D{{"a", 1}}}, {"name", "a_1"}, {"ns", "db1.c1"}},
+			})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			So(op.Namespace, ShouldEqual, "db2.system.indexes")
+			So(op.Object[3], ShouldResemble, bson.E{"ns", "db2.c1"})
+		})
+
+		Convey("nested applyOps ops should be filtered individually", func() {
+			nested, err := wrapNestedApplyOps([]db.Oplog{
+				{Operation: "i", Namespace: "db1.c1", Object: bson.D{{"_id", 1}}},
+				{Operation: "i", Namespace: "other.c1", Object: bson.D{{"_id", 2}}},
+			})
+			So(err, ShouldBeNil)
+			op, keep, err := mr.filterOplogEntry(db.Oplog{Operation: "c", Namespace: "admin.$cmd", Object: nested})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			ops, err := unwrapNestedApplyOps(op.Object)
+			So(err, ShouldBeNil)
+			So(len(ops), ShouldEqual, 1)
+			So(ops[0].Namespace, ShouldEqual, "db2.c1")
+
+			nested, err = wrapNestedApplyOps([]db.Oplog{
+				{Operation: "i", Namespace: "other.c1", Object: bson.D{{"_id", 2}}},
+			})
+			So(err, ShouldBeNil)
+			_, keep, err = mr.filterOplogEntry(db.Oplog{Operation: "c", Namespace: "admin.$cmd", Object: nested})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeFalse)
+		})
+
+		Convey("ops should be filtered by type when --oplogOps is set", func() {
+			mr.oplogOps, err = parseOplogOps("i, d")
+			So(err, ShouldBeNil)
+
+			_, keep, err := mr.filterOplogEntry(db.Oplog{Operation: "i", Namespace: "db1.c1"})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			_, keep, err = mr.filterOplogEntry(db.Oplog{Operation: "u", Namespace: "db1.c1"})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeFalse)
+			_, keep, err = mr.filterOplogEntry(db.Oplog{
+				Operation: "c", Namespace: "db1.$cmd", Object: bson.D{{"drop", "c1"}},
+			})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeFalse)
+		})
+	})
+
+	Convey("Unknown oplog operation types should be rejected", t, func() {
+		_, err := parseOplogOps("i,x")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -42,22 +42,28 @@ const (
 	OplogReplayOption            = "--oplogReplay"
 	OplogLimitOption             = "--oplogLimit"
 	OplogFileOption              = "--oplogFile"
+	OplogOpsOption               = "--oplogOps"
 	ArchiveOption                = "--archive" // Value is optional, so must use '=' if specifying one
 	RestoreDBUsersAndRolesOption = "--restoreDbUsersAndRoles"
+	ExcludeUserOption            = "--excludeUser"
+	UserPasswordsFileOption      = "--userPasswordsFile"
 	DirectoryOption              = "--dir"
 	GzipOption                   = "--gzip"
 )
 
 // InputOptions defines the set of options to use in configuring the restore process.
 type InputOptions struct {
-	Objcheck               bool   `long:"objcheck" description:"validate all objects before inserting"`
-	OplogReplay            bool   `long:"oplogReplay" description:"replay oplog for point-in-time restore"`
-	OplogLimit             string `long:"oplogLimit" value-name:"<seconds>[:ordinal]" description:"only include oplog entries before the provided Timestamp"`
-	OplogFile              string `long:"oplogFile" value-name:"<filename>" description:"oplog file to use for replay of oplog"`
-	Archive                string `long:"archive" value-name:"<filename>" optional:"true" optional-value:"-" description:"restore dump from the specified archive file.  If flag is specified without a value, archive is read from stdin"`
-	RestoreDBUsersAndRoles bool   `long:"restoreDbUsersAndRoles" description:"restore user and role definitions for the given database"`
-	Directory              string `long:"dir" value-name:"<directory-name>" description:"input directory, use '-' for stdin"`
-	Gzip                   bool   `long:"gzip" description:"decompress gzipped input"`
+	Objcheck               bool     `long:"objcheck" description:"validate all objects before inserting"`
+	OplogReplay            bool     `long:"oplogReplay" description:"replay oplog for point-in-time restore"`
+	OplogLimit             string   `long:"oplogLimit" value-name:"<seconds>[:ordinal]" description:"only include oplog entries before the provided Timestamp"`
+	OplogFile              string   `long:"oplogFile" value-name:"<filename>" description:"oplog file to use for replay of oplog"`
+	OplogOps               string   `long:"oplogOps" value-name:"<op>[,<op>]" description:"comma-separated oplog operation types to replay, from i (insert), u (update), d (delete) and c (command); defaults to all"`
+	Archive                string   `long:"archive" value-name:"<filename>" optional:"true" optional-value:"-" description:"restore dump from the specified archive file.  If flag is specified without a value, archive is read from stdin"`
+	RestoreDBUsersAndRoles bool     `long:"restoreDbUsersAndRoles" description:"restore user and role definitions for the given database"`
+	ExcludeUsers           []string `long:"excludeUser" value-name:"<db>.<user>" description:"do not restore users matching the given pattern, as named in the dump (wildcards allowed, may be specified multiple times)"`
+	UserPasswordsFile      string   `long:"userPasswordsFile" value-name:"<filename>" description:"JSON document mapping restored users, as \"<db>.<user>\" after any renames, to new passwords"`
+	Directory              string   `long:"dir" value-name:"<directory-name>" description:"input directory, use '-' for stdin"`
+	Gzip                   bool     `long:"gzip" description:"decompress gzipped input"`
 }
 
 // Name returns a human-readable group name for input options.
@@ -77,6 +83,7 @@ const (
 	MaintainInsertionOrderOption   = "--maintainInsertionOrder"
 	NumParallelCollectionsOption   = "--numParallelCollections"
 	NumInsertionWorkersOption      = "--numInsertionWorkersPerCollection"
+	NumOplogWorkersOption          = "--numOplogWorkers"
 	StopOnErrorOption              = "--stopOnError"
 	BypassDocumentValidationOption = "--bypassDocumentValidation"
 	PreserveUUIDOption             = "--preserveUUID"
@@ -84,6 +91,16 @@ const (
 	TempRolesCollOption            = "--tempRolesColl"
 	BulkBufferSizeOption           = "--batchSize"
 	FixDottedHashedIndexesOption   = "--fixDottedHashIndex"
+	ShardCollectionsOption         = "--shardCollections"
+	ShardKeyOption                 = "--shardKey"
+	TransformFileOption            = "--transformFile"
+	RouteFileOption                = "--routeFile"
+	MetadataRulesFileOption        = "--metadataRulesFile"
+	VerifyOption                   = "--verify"
+	MaxReplicationLagOption        = "--maxReplicationLag"
+	MinWriteTicketsOption          = "--minWriteTickets"
+	ReportFileOption               = "--reportFile"
+	RejectsDirOption               = "--rejectsDir"
 )
 
 // OutputOptions defines the set of options for restoring dump data.
@@ -91,6 +108,9 @@ type OutputOptions struct {
 	Drop   bool `long:"drop" description:"drop each collection before import"`
 	DryRun bool `long:"dryRun" description:"view summary without importing anything. recommended with verbosity"`
 
+	// DryRunSampleSize is the number of dumped _ids per collection that --dryRun looks up on the target.
+	DryRunSampleSize int `long:"dryRunSampleSize" value-name:"<n>" default:"1000" description:"number of _ids per collection to check for conflicts on the target during --dryRun; 0 checks every _id"`
+
 	// By default mongorestore uses a write concern of 'majority'.
 	WriteConcern             string `long:"writeConcern" value-name:"<write-concern>" default-mask:"-" description:"write concern options e.g. --writeConcern majority, --writeConcern '{w: 3, wtimeout: 500, fsync: true, j: true}'"`
 	NoIndexRestore           bool   `long:"noIndexRestore" description:"don't restore indexes"`
@@ -100,6 +120,7 @@ type OutputOptions struct {
 	MaintainInsertionOrder   bool   `long:"maintainInsertionOrder" description:"restore the documents in the order of their appearance in the input source. By default the insertions will be performed in an arbitrary order. Setting this flag also enables the behavior of --stopOnError and restricts NumInsertionWorkersPerCollection to 1."`
 	NumParallelCollections   int    `long:"numParallelCollections" short:"j" description:"number of collections to restore in parallel" default:"4" default-mask:"-"`
 	NumInsertionWorkers      int    `long:"numInsertionWorkersPerCollection" description:"number of insert operations to run concurrently per collection" default:"1" default-mask:"-"`
+	NumOplogWorkers          int    `long:"numOplogWorkers" description:"number of workers applying oplog entries concurrently; entries for the same document are always applied in order, and commands and transactions wait for all earlier entries" default:"1" default-mask:"-"`
 	StopOnError              bool   `long:"stopOnError" description:"halt after encountering any error during insertion. By default, mongorestore will attempt to continue through document validation and DuplicateKey errors, but with this option enabled, the tool will stop instead. A small number of documents may be inserted after encountering an error even with this option enabled; use --maintainInsertionOrder to halt immediately after an error"`
 	BypassDocumentValidation bool   `long:"bypassDocumentValidation" description:"bypass document validation"`
 	PreserveUUID             bool   `long:"preserveUUID" description:"preserve original collection UUIDs (off by default, requires drop)"`
@@ -107,6 +128,18 @@ type OutputOptions struct {
 	TempRolesColl            string `long:"tempRolesColl" default:"temproles" hidden:"true"`
 	BulkBufferSize           int    `long:"batchSize" default:"1000" hidden:"true"`
 	FixDottedHashedIndexes   bool   `long:"fixDottedHashIndex" description:"when enabled, all the hashed indexes on dotted fields will be created as single field ascending indexes on the destination"`
+	ShardCollections         bool   `long:"shardCollections" description:"when restoring through mongos, shard each new collection using the shard key from the dumped config database or --shardKey, pre-split it, and group inserts by shard"`
+	ShardKey                 string `long:"shardKey" value-name:"<json>" description:"shard key for collections that have none in the dumped config database, e.g. '{_id: \"hashed\"}' (defaults to {_id: 1})"`
+	ChunksPerShard           int    `long:"chunksPerShard" default:"2" hidden:"true"`
+	TransformFile            string `long:"transformFile" value-name:"<filename>" description:"JSON file of per-namespace rules that filter, rename, drop, set or convert document fields before inserting"`
+	RouteFile                string `long:"routeFile" value-name:"<filename>" description:"JSON file of per-namespace rules that split a collection's documents across several target collections by filter"`
+	MetadataRulesFile        string `long:"metadataRulesFile" value-name:"<filename>" description:"JSON file of per-namespace rules that drop, set or rewrite collection options and index specs before they are created"`
+	MaxReplicationLag        int    `long:"maxReplicationLag" value-name:"<seconds>" description:"slow down inserts by shrinking batches and insertion workers, and eventually pausing, while any non-delayed secondary lags the primary by more than this many seconds, or while the lag cannot be measured"`
+	MinWriteTickets          int    `long:"minWriteTickets" value-name:"<n>" description:"slow down inserts by shrinking batches and insertion workers, and eventually pausing, while fewer than this many WiredTiger write tickets are available"`
+	ThrottleInterval         int    `long:"throttleIntervalMS" default:"1000" hidden:"true"`
+	Verify                   bool   `long:"verify" description:"after restoring all collections, compare the count and an order-independent hash of the documents inserted into each collection with a scan of it, and fail if any collection differs; requires --drop"`
+	ReportFile               string `long:"reportFile" value-name:"<filename>" description:"when the restore finishes or fails, write a JSON report of per-namespace document, error and index statistics, oplog entries applied and time spent in each phase to this file"`
+	RejectsDir               string `long:"rejectsDir" value-name:"<directory>" description:"write each document that fails to restore, with its error code and message, to <directory>/<db>/<collection>.bson"`
 }
 
 // Name returns a human-readable group name for output options.
@@ -124,6 +157,7 @@ const (
 	NSIncludeOption                  = "--nsInclude"
 	NSFromOption                     = "--nsFrom"
 	NSToOption                       = "--nsTo"
+	ToDBOption                       = "--toDb"
 )
 
 // NSOptions defines the set of options for configuring involved namespaces
@@ -136,6 +170,7 @@ type NSOptions struct {
 	NSInclude                  []string `long:"nsInclude" value-name:"<namespace-pattern>" description:"include matching namespaces"`
 	NSFrom                     []string `long:"nsFrom" value-name:"<namespace-pattern>" description:"rename matching namespaces, must have matching nsTo"`
 	NSTo                       []string `long:"nsTo" value-name:"<namespace-pattern>" description:"rename matched namespaces, must have matching nsFrom"`
+	ToDB                       string   `long:"toDb" value-name:"<database-name>" description:"restore the one database in the dump directory or archive under this name, rewriting the views in it to match"`
 }
 
 // Name returns a human-readable group name for output options.
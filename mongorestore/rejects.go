@@ -0,0 +1,118 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// rejectWriter saves the documents that fail to restore for --rejectsDir.
+// Each namespace's rejects go to <dir>/<db>/<collection>.bson as documents of
+// the form {document: <rejected document>, code: <error code>, errmsg: <error
+// message>}, where documents skipped by --transformFile rules have code 0.
+// Documents sent to another collection by --routeFile are saved under the
+// namespace they were routed to, and users and roles are never saved since
+// they hold credentials. A nil rejectWriter saves nothing.
+type rejectWriter struct {
+	dir string
+
+	mutex sync.Mutex
+	files map[string]*rejectFile
+	count int64
+}
+
+type rejectFile struct {
+	file *os.File
+	out  *bufio.Writer
+}
+
+func newRejectWriter(dir string) *rejectWriter {
+	return &rejectWriter{dir: dir, files: map[string]*rejectFile{}}
+}
+
+// write saves one rejected document of the given namespace.
+func (w *rejectWriter) write(ns string, doc bson.Raw, code int, errmsg string) error {
+	if w == nil {
+		return nil
+	}
+	out, err := bson.Marshal(bson.D{{"document", doc}, {"code", code}, {"errmsg", errmsg}})
+	if err != nil {
+		return fmt.Errorf("error encoding rejected document: %v", err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	f, ok := w.files[ns]
+	if !ok {
+		dbName, colName := util.SplitNamespace(ns)
+		dir := filepath.Join(w.dir, dbName)
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating rejects directory: %v", err)
+		}
+		file, err := os.Create(filepath.Join(dir, colName+".bson"))
+		if err != nil {
+			return fmt.Errorf("error creating rejects file: %v", err)
+		}
+		f = &rejectFile{file, bufio.NewWriter(file)}
+		w.files[ns] = f
+	}
+	if _, err = f.out.Write(out); err != nil {
+		return fmt.Errorf("error writing rejected document: %v", err)
+	}
+	w.count++
+	return nil
+}
+
+// writeErrors saves the documents rejected by a bulk insert into the given
+// namespace.
+func (w *rejectWriter) writeErrors(ns string, err error) error {
+	bwe, ok := err.(mongo.BulkWriteException)
+	if w == nil || !ok {
+		return nil
+	}
+	for _, writeErr := range bwe.WriteErrors {
+		insert, ok := writeErr.Request.(*mongo.InsertOneModel)
+		if !ok {
+			continue
+		}
+		doc, ok := insert.Document.([]byte)
+		if !ok {
+			continue
+		}
+		if err := w.write(ns, doc, writeErr.Code, writeErr.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close flushes and closes every rejects file and returns the number of
+// documents saved.
+func (w *rejectWriter) close() (int64, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var firstErr error
+	for ns, f := range w.files {
+		err := f.out.Flush()
+		if closeErr := f.file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error writing rejects for %v: %v", ns, err)
+		}
+	}
+	w.files = map[string]*rejectFile{}
+	return w.count, firstErr
+}
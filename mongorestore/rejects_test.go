@@ -0,0 +1,65 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestRejectWriter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("A nil reject writer saves nothing", t, func() {
+		var w *rejectWriter
+		So(w.write("test.c", mustMarshal(bson.D{{"_id", 1}}), 0, "skipped"), ShouldBeNil)
+		So(w.writeErrors("test.c", mongo.BulkWriteException{}), ShouldBeNil)
+	})
+
+	Convey("With a rejects directory", t, func() {
+		dir, err := ioutil.TempDir("", "rejects")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		w := newRejectWriter(dir)
+
+		Convey("rejected inserts are saved per namespace with their errors", func() {
+			doc := mustMarshal(bson.D{{"_id", 1}, {"a", "x"}})
+			So(w.writeErrors("test.c", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{
+				WriteError: mongo.WriteError{Code: db.ErrDuplicateKeyCode, Message: "duplicate key"},
+				Request:    mongo.NewInsertOneModel().SetDocument([]byte(doc)),
+			}}}), ShouldBeNil)
+			So(w.writeErrors("test.c", fmt.Errorf("not a write error")), ShouldBeNil)
+			So(w.write("other.d", mustMarshal(bson.D{{"_id", 2}}), 0, "transform failed"), ShouldBeNil)
+
+			count, err := w.close()
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			contents, err := ioutil.ReadFile(filepath.Join(dir, "test", "c.bson"))
+			So(err, ShouldBeNil)
+			var reject bson.D
+			So(bson.Unmarshal(contents, &reject), ShouldBeNil)
+			So(reject, ShouldResemble, bson.D{
+				{"document", bson.D{{"_id", int32(1)}, {"a", "x"}}},
+				{"code", int32(db.ErrDuplicateKeyCode)},
+				{"errmsg", "duplicate key"},
+			})
+
+			_, err = os.Stat(filepath.Join(dir, "other", "d.bson"))
+			So(err, ShouldBeNil)
+		})
+	})
+}
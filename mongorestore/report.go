@@ -0,0 +1,229 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxFailedIDSamples is the number of failed _ids kept for each namespace.
+const maxFailedIDSamples = 10
+
+// Report is the machine-readable outcome of a restore that --reportFile
+// writes as JSON once the restore finishes or fails.
+type Report struct {
+	Succeeded           bool               `json:"succeeded"`
+	Error               string             `json:"error,omitempty"`
+	StartTime           time.Time          `json:"startTime"`
+	EndTime             time.Time          `json:"endTime"`
+	DocumentsInserted   int64              `json:"documentsInserted"`
+	DocumentsFailed     int64              `json:"documentsFailed"`
+	OplogEntriesApplied int                `json:"oplogEntriesApplied"`
+	VerifyMismatches    []string           `json:"verifyMismatches,omitempty"`
+	Phases              []PhaseReport      `json:"phases"`
+	Namespaces          []*NamespaceReport `json:"namespaces"`
+}
+
+// PhaseReport is the time spent in one phase of the restore.
+type PhaseReport struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// NamespaceReport holds the statistics of one restored collection.
+type NamespaceReport struct {
+	Namespace          string            `json:"namespace"`
+	DocumentsRead      int64             `json:"documentsRead"`
+	DocumentsInserted  int64             `json:"documentsInserted"`
+	DocumentsFailed    int64             `json:"documentsFailed"`
+	DuplicateKeyErrors int64             `json:"duplicateKeyErrors"`
+	ValidationFailures int64             `json:"validationFailures"`
+	FailedIDSamples    []json.RawMessage `json:"failedIdSamples,omitempty"`
+	Indexes            []IndexReport     `json:"indexes,omitempty"`
+}
+
+// IndexReport is the result of building one index.
+type IndexReport struct {
+	Name  string `json:"name"`
+	Built bool   `json:"built"`
+	Error string `json:"error,omitempty"`
+}
+
+// restoreReport collects a Report from the goroutines of a restore. A nil
+// restoreReport records nothing, so callers need not check for --reportFile.
+type restoreReport struct {
+	mutex      sync.Mutex
+	report     Report
+	namespaces map[string]*NamespaceReport
+}
+
+func newRestoreReport() *restoreReport {
+	return &restoreReport{
+		report:     Report{StartTime: time.Now()},
+		namespaces: map[string]*NamespaceReport{},
+	}
+}
+
+// namespace returns the statistics of the given namespace. The mutex must be
+// held.
+func (r *restoreReport) namespace(ns string) *NamespaceReport {
+	nsReport, ok := r.namespaces[ns]
+	if !ok {
+		nsReport = &NamespaceReport{Namespace: ns}
+		r.namespaces[ns] = nsReport
+	}
+	return nsReport
+}
+
+// phase starts timing a phase of the restore and returns a function that
+// ends it.
+func (r *restoreReport) phase(name string) func() {
+	if r == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.report.Phases = append(r.report.Phases, PhaseReport{name, time.Since(start).Seconds()})
+	}
+}
+
+// recordCollection adds the documents read from a collection's BSON file and
+// the outcome of inserting them.
+func (r *restoreReport) recordCollection(ns string, read int64, result Result) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	nsReport := r.namespace(ns)
+	nsReport.DocumentsRead += read
+	nsReport.DocumentsInserted += result.Successes
+	nsReport.DocumentsFailed += result.Failures
+}
+
+// recordWriteErrors counts the duplicate key and validation errors of a bulk
+// insert into the given namespace and samples the _ids of the documents that
+// failed.
+func (r *restoreReport) recordWriteErrors(ns string, err error) {
+	bwe, ok := err.(mongo.BulkWriteException)
+	if r == nil || !ok {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	nsReport := r.namespace(ns)
+	for _, writeErr := range bwe.WriteErrors {
+		switch writeErr.Code {
+		case db.ErrDuplicateKeyCode:
+			nsReport.DuplicateKeyErrors++
+		case db.ErrFailedDocumentValidation:
+			nsReport.ValidationFailures++
+		}
+		if len(nsReport.FailedIDSamples) >= maxFailedIDSamples {
+			continue
+		}
+		if id, ok := failedID(writeErr.Request); ok {
+			nsReport.FailedIDSamples = append(nsReport.FailedIDSamples, id)
+		}
+	}
+}
+
+// failedID returns the _id of an insert that failed as extended JSON.
+func failedID(model mongo.WriteModel) (json.RawMessage, bool) {
+	insert, ok := model.(*mongo.InsertOneModel)
+	if !ok {
+		return nil, false
+	}
+	raw, ok := insert.Document.([]byte)
+	if !ok {
+		return nil, false
+	}
+	id, err := bson.Raw(raw).LookupErr("_id")
+	if err != nil {
+		return nil, false
+	}
+	extJSON := id.String()
+	if !json.Valid([]byte(extJSON)) {
+		return nil, false
+	}
+	return json.RawMessage(extJSON), true
+}
+
+// recordIndexes adds the outcome of building a collection's indexes.
+func (r *restoreReport) recordIndexes(ns string, indexes []IndexDocument, err error) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	nsReport := r.namespace(ns)
+	for _, index := range indexes {
+		indexReport := IndexReport{Name: fmt.Sprint(index.Options["name"]), Built: err == nil}
+		if err != nil {
+			indexReport.Error = err.Error()
+		}
+		nsReport.Indexes = append(nsReport.Indexes, indexReport)
+	}
+}
+
+// recordOplog adds the number of oplog entries applied.
+func (r *restoreReport) recordOplog(applied int) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.report.OplogEntriesApplied += applied
+}
+
+// finish completes the report with the final result of the restore.
+func (r *restoreReport) finish(result Result) Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	report := r.report
+	report.EndTime = time.Now()
+	report.Succeeded = result.Err == nil
+	if result.Err != nil {
+		report.Error = result.Err.Error()
+	}
+	report.DocumentsInserted = result.Successes
+	report.DocumentsFailed = result.Failures
+	for _, mismatch := range result.Mismatches {
+		report.VerifyMismatches = append(report.VerifyMismatches, mismatch.String())
+	}
+	report.Namespaces = make([]*NamespaceReport, 0, len(r.namespaces))
+	for _, nsReport := range r.namespaces {
+		report.Namespaces = append(report.Namespaces, nsReport)
+	}
+	sort.Slice(report.Namespaces, func(i, j int) bool {
+		return report.Namespaces[i].Namespace < report.Namespaces[j].Namespace
+	})
+	return report
+}
+
+// write finishes the report and writes it to the given file.
+func (r *restoreReport) write(path string, result Result) error {
+	out, err := json.MarshalIndent(r.finish(result), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %v", err)
+	}
+	if err = ioutil.WriteFile(path, append(out, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing report: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestRestoreReport(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	insertError := func(code int, id interface{}) mongo.BulkWriteError {
+		return mongo.BulkWriteError{
+			WriteError: mongo.WriteError{Code: code, Message: "failed"},
+			Request:    mongo.NewInsertOneModel().SetDocument([]byte(mustMarshal(bson.D{{"_id", id}, {"a", 1}}))),
+		}
+	}
+
+	Convey("A nil report records nothing", t, func() {
+		var r *restoreReport
+		r.phase("collections")()
+		r.recordCollection("test.c", 1, Result{Successes: 1})
+		r.recordWriteErrors("test.c", mongo.BulkWriteException{})
+		r.recordIndexes("test.c", nil, nil)
+		r.recordOplog(1)
+	})
+
+	Convey("With a report", t, func() {
+		r := newRestoreReport()
+
+		Convey("write errors are counted by kind and their _ids sampled", func() {
+			r.recordWriteErrors("test.c", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+				insertError(db.ErrDuplicateKeyCode, 1),
+				insertError(db.ErrDuplicateKeyCode, "two"),
+				insertError(db.ErrFailedDocumentValidation, 3.5),
+			}})
+			r.recordWriteErrors("test.c", fmt.Errorf("not a write error"))
+			for i := 0; i < maxFailedIDSamples; i++ {
+				r.recordWriteErrors("test.c", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+					insertError(db.ErrDuplicateKeyCode, i),
+				}})
+			}
+
+			nsReport := r.namespaces["test.c"]
+			So(nsReport.DuplicateKeyErrors, ShouldEqual, 2+maxFailedIDSamples)
+			So(nsReport.ValidationFailures, ShouldEqual, 1)
+			So(nsReport.FailedIDSamples, ShouldHaveLength, maxFailedIDSamples)
+			So(string(nsReport.FailedIDSamples[1]), ShouldEqual, `"two"`)
+			So(json.Valid(nsReport.FailedIDSamples[0]), ShouldBeTrue)
+		})
+
+		Convey("the finished report sorts namespaces and carries the result", func() {
+			r.recordCollection("test.b", 10, Result{Successes: 8, Failures: 2})
+			r.recordCollection("test.a", 5, Result{Successes: 5})
+			r.recordIndexes("test.a", []IndexDocument{{Options: bson.M{"name": "x_1"}}}, fmt.Errorf("bad index"))
+			r.recordOplog(7)
+			r.phase("collections")()
+
+			report := r.finish(Result{
+				Successes:  13,
+				Failures:   2,
+				Err:        fmt.Errorf("verification failed"),
+				Mismatches: []VerifyMismatch{{Namespace: "test.b"}},
+			})
+			So(report.Succeeded, ShouldBeFalse)
+			So(report.Error, ShouldEqual, "verification failed")
+			So(report.DocumentsInserted, ShouldEqual, 13)
+			So(report.DocumentsFailed, ShouldEqual, 2)
+			So(report.OplogEntriesApplied, ShouldEqual, 7)
+			So(report.VerifyMismatches, ShouldHaveLength, 1)
+			So(report.Phases, ShouldHaveLength, 1)
+			So(report.Phases[0].Name, ShouldEqual, "collections")
+			So(report.Namespaces, ShouldHaveLength, 2)
+			So(report.Namespaces[0].Namespace, ShouldEqual, "test.a")
+			So(report.Namespaces[0].Indexes, ShouldResemble, []IndexReport{{Name: "x_1", Error: "bad index"}})
+			So(report.Namespaces[1].DocumentsRead, ShouldEqual, 10)
+			So(report.Namespaces[1].DocumentsFailed, ShouldEqual, 2)
+		})
+
+		Convey("the report is written as JSON", func() {
+			dir, err := ioutil.TempDir("", "report")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "report.json")
+
+			r.recordCollection("test.a", 1, Result{Successes: 1})
+			So(r.write(path, Result{Successes: 1}), ShouldBeNil)
+
+			contents, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			var report Report
+			So(json.Unmarshal(contents, &report), ShouldBeNil)
+			So(report.Succeeded, ShouldBeTrue)
+			So(report.Namespaces[0].DocumentsInserted, ShouldEqual, 1)
+		})
+	})
+}
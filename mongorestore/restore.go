@@ -8,8 +8,8 @@ package mongorestore
 
 import (
 	"fmt"
-	"io/ioutil"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/mongodb/mongo-tools-common/bsonutil"
@@ -58,6 +58,9 @@ type Result struct {
 	Successes int64
 	Failures  int64
 	Err       error
+
+	// Mismatches lists the collections that failed --verify
+	Mismatches []VerifyMismatch
 }
 
 // log pretty-prints the result, associated with restoring the given namespace
@@ -72,6 +75,7 @@ func (result *Result) log(ns string) {
 func (result *Result) combineWith(other Result) {
 	result.Successes += other.Successes
 	result.Failures += other.Failures
+	result.Mismatches = append(result.Mismatches, other.Mismatches...)
 	result.Err = other.Err
 }
 
@@ -94,7 +98,7 @@ func NewResultFromBulkResult(result *mongo.BulkWriteResult, err error) Result {
 		nFailure = int64(len(bwe.WriteErrors))
 	}
 
-	return Result{nSuccess, nFailure, err}
+	return Result{Successes: nSuccess, Failures: nFailure, Err: err}
 }
 
 // RestoreIntents iterates through all of the intents stored in the IntentManager, and restores them.
@@ -148,7 +152,7 @@ func (restore *MongoRestore) RestoreIntents() Result {
 				return totalResult
 			}
 		}
-		return totalResult
+		return totalResult.withErr(restore.RestoreViews())
 	}
 
 	var totalResult Result
@@ -166,47 +170,46 @@ func (restore *MongoRestore) RestoreIntents() Result {
 		}
 		restore.manager.Finish(intent)
 	}
-	return totalResult
+	return totalResult.withErr(restore.RestoreViews())
 }
 
 // RestoreIntent attempts to restore a given intent into MongoDB.
 func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
+	var options bson.D
+	var indexes []IndexDocument
+	var uuid string
+	pipeline := collectionPipeline{transform: restore.transformerFor(intent.Namespace())}
+	if intent.C == "system.views" {
+		pipeline.rewrite = restore.renameViewDocument
+	}
 
-	collectionExists, err := restore.CollectionExists(intent)
+	// documents are restored to the intent's own collection unless they are
+	// routed to several target collections
+	targets := []*intents.Intent{intent}
+	routes, err := restore.routerFor(intent.Namespace())
 	if err != nil {
-		return Result{Err: fmt.Errorf("error reading database: %v", err)}
+		return Result{Err: err}
 	}
-
-	if !restore.OutputOptions.Drop && collectionExists {
-		log.Logvf(log.Always, "restoring to existing collection %v without dropping", intent.Namespace())
+	if routes != nil && intent.BSONFile != nil {
+		pipeline.routes = routes
+		targets = routes.targetIntents(intent)
+		log.Logvf(log.Always, "routing documents of %v to %v", intent.Namespace(), routes.destinations)
 	}
-
-	if restore.OutputOptions.Drop {
-		if collectionExists {
-			if strings.HasPrefix(intent.C, "system.") {
-				log.Logvf(log.Always, "cannot drop system collection %v, skipping", intent.Namespace())
-			} else {
-				log.Logvf(log.Info, "dropping collection %v before restoring", intent.Namespace())
-				err = restore.DropCollection(intent)
-				if err != nil {
-					return Result{Err: err} // no context needed
-				}
-				collectionExists = false
-			}
-		} else {
-			log.Logvf(log.DebugLow, "collection %v doesn't exist, skipping drop command", intent.Namespace())
+	if restore.verify != nil && intent.BSONFile != nil {
+		pipeline.verify = restore.verify
+		for _, target := range targets {
+			pipeline.verify.track(target.Namespace())
 		}
 	}
 
-	var options bson.D
-	var indexes []IndexDocument
-	var uuid string
-
 	// get indexes from system.indexes dump if we have it but don't have metadata files
 	if intent.MetadataFile == nil {
 		if _, ok := restore.dbCollectionIndexes[intent.DB]; ok {
 			if indexes, ok = restore.dbCollectionIndexes[intent.DB][intent.C]; ok {
 				log.Logvf(log.Always, "no metadata; falling back to system.indexes")
+				metadata := &Metadata{Indexes: indexes}
+				restore.applyMetadataRules(intent.Namespace(), metadata)
+				indexes = metadata.Indexes
 			}
 		}
 	}
@@ -216,20 +219,10 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 	// first create the collection with options from the metadata file
 	if intent.MetadataFile != nil {
 		logMessageSuffix = "using options from metadata"
-		err = intent.MetadataFile.Open()
-		if err != nil {
-			return Result{Err: err}
-		}
-		defer intent.MetadataFile.Close()
-
 		log.Logvf(log.Always, "reading metadata for %v from %v", intent.Namespace(), intent.MetadataLocation)
-		metadataJSON, err := ioutil.ReadAll(intent.MetadataFile)
-		if err != nil {
-			return Result{Err: fmt.Errorf("error reading metadata from %v: %v", intent.MetadataLocation, err)}
-		}
-		metadata, err := restore.MetadataFromJSON(metadataJSON)
+		metadata, err := restore.ReadIntentMetadata(intent)
 		if err != nil {
-			return Result{Err: fmt.Errorf("error parsing metadata from %v: %v", intent.MetadataLocation, err)}
+			return Result{Err: err}
 		}
 		if metadata != nil {
 			options = metadata.Options
@@ -282,16 +275,34 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 			options = nil
 		}
 	}
-	if !collectionExists {
-		log.Logvf(log.Info, "creating collection %v %s", intent.Namespace(), logMessageSuffix)
-		log.Logvf(log.DebugHigh, "using collection options: %#v", options)
-		err = restore.CreateCollection(intent, options, uuid)
+	// views are created once every collection has been restored, in the
+	// order of their dependencies on each other
+	if isViewOptions(options) {
+		restore.deferView(intent, options)
+		targets = nil
+	}
+	for _, target := range targets {
+		targetOptions := options
+		if target != intent {
+			targetOptions = optionsForNamespace(options, target.Namespace())
+		}
+		created, err := restore.prepareCollection(target, targetOptions, uuid, logMessageSuffix)
 		if err != nil {
-			return Result{Err: fmt.Errorf("error creating collection %v: %v", intent.Namespace(), err)}
+			return Result{Err: err}
+		}
+		if !restore.OutputOptions.ShardCollections {
+			continue
+		}
+		if pipeline.routes != nil {
+			log.Logvf(log.Always, "not sharding routed collection %v", target.Namespace())
+		} else if !created {
+			log.Logvf(log.Always, "not sharding existing collection %v", intent.Namespace())
+		} else if intent.BSONFile != nil && !isViewOptions(options) && !strings.HasPrefix(intent.C, "system.") {
+			pipeline.router, err = restore.ShardCollection(intent)
+			if err != nil {
+				return Result{Err: fmt.Errorf("error sharding collection %v: %v", intent.Namespace(), err)}
+			}
 		}
-		restore.addToKnownCollections(intent)
-	} else {
-		log.Logvf(log.Info, "collection %v already exists - skipping collection create", intent.Namespace())
 	}
 
 	var result Result
@@ -307,7 +318,7 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 		bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(intent.BSONFile))
 		defer bsonSource.Close()
 
-		result = restore.RestoreCollectionToDB(intent.DB, intent.C, bsonSource, intent.BSONFile, intent.Size)
+		result = restore.restoreCollectionToDB(intent.DB, intent.C, bsonSource, intent.BSONFile, intent.Size, pipeline)
 		if result.Err != nil {
 			result.Err = fmt.Errorf("error restoring from %v: %v", intent.Location, result.Err)
 			return result
@@ -323,10 +334,13 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 		if restore.OutputOptions.FixDottedHashedIndexes {
 			fixDottedHashedIndexes(indexes)
 		}
-		err = restore.CreateIndexes(intent, indexes, hasNonSimpleCollation)
-		if err != nil {
-			result.Err = fmt.Errorf("error creating indexes for %v: %v", intent.Namespace(), err)
-			return result
+		for _, target := range targets {
+			err = restore.CreateIndexes(target, indexes, hasNonSimpleCollation)
+			restore.report.recordIndexes(target.Namespace(), indexes, err)
+			if err != nil {
+				result.Err = fmt.Errorf("error creating indexes for %v: %v", target.Namespace(), err)
+				return result
+			}
 		}
 	} else {
 		log.Logv(log.Always, "no indexes to restore")
@@ -335,6 +349,60 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 	return result
 }
 
+// prepareCollection drops the collection an intent is restored to if --drop
+// is set, then creates it with the given options unless it already exists.
+// It returns true if the collection was created.
+func (restore *MongoRestore) prepareCollection(intent *intents.Intent, options bson.D,
+	uuid, logMessageSuffix string) (bool, error) {
+
+	// route targets shared by several intents were dropped before restoring
+	// and are created by whichever intent gets to them first
+	routeTarget := restore.routeTargets[intent.Namespace()]
+	if routeTarget {
+		restore.routeTargetsMutex.Lock()
+		defer restore.routeTargetsMutex.Unlock()
+	}
+
+	collectionExists, err := restore.CollectionExists(intent)
+	if err != nil {
+		return false, fmt.Errorf("error reading database: %v", err)
+	}
+
+	if !restore.OutputOptions.Drop && collectionExists {
+		log.Logvf(log.Always, "restoring to existing collection %v without dropping", intent.Namespace())
+	}
+
+	if restore.OutputOptions.Drop && !routeTarget {
+		if collectionExists {
+			if strings.HasPrefix(intent.C, "system.") {
+				log.Logvf(log.Always, "cannot drop system collection %v, skipping", intent.Namespace())
+			} else {
+				log.Logvf(log.Info, "dropping collection %v before restoring", intent.Namespace())
+				err = restore.DropCollection(intent)
+				if err != nil {
+					return false, err // no context needed
+				}
+				collectionExists = false
+			}
+		} else {
+			log.Logvf(log.DebugLow, "collection %v doesn't exist, skipping drop command", intent.Namespace())
+		}
+	}
+
+	if collectionExists {
+		log.Logvf(log.Info, "collection %v already exists - skipping collection create", intent.Namespace())
+		return false, nil
+	}
+	log.Logvf(log.Info, "creating collection %v %s", intent.Namespace(), logMessageSuffix)
+	log.Logvf(log.DebugHigh, "using collection options: %#v", options)
+	err = restore.CreateCollection(intent, options, uuid)
+	if err != nil {
+		return false, fmt.Errorf("error creating collection %v: %v", intent.Namespace(), err)
+	}
+	restore.addToKnownCollections(intent)
+	return true, nil
+}
+
 func convertLegacyIndexes(indexes []IndexDocument) {
 	for _, index := range indexes {
 		convertLegacyIndexKeys(index)
@@ -430,10 +498,52 @@ func createExtJSONString(doc interface{}) string {
 	return JSONString
 }
 
+// isViewOptions returns true if the collection options describe a view.
+func isViewOptions(options bson.D) bool {
+	for _, opt := range options {
+		if opt.Key == "viewOn" {
+			return true
+		}
+	}
+	return false
+}
+
 // RestoreCollectionToDB pipes the given BSON data into the database.
 // Returns the number of documents restored and any errors that occurred.
 func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 	bsonSource *db.DecodedBSONSource, file PosReader, fileSize int64) Result {
+	return restore.restoreCollectionToDB(dbName, colName, bsonSource, file, fileSize, collectionPipeline{})
+}
+
+// collectionPipeline holds the optional stages that documents of a single
+// collection pass through between the BSON source and the bulk inserters.
+type collectionPipeline struct {
+	// transform rewrites or filters out documents before insertion
+	transform *docTransformer
+	// router groups inserts by the shard owning each document, so that every
+	// bulk insert sent through mongos targets a single shard
+	router *chunkRouter
+	// routes sends each document to one of several target collections
+	// instead of the collection being restored
+	routes *docRouter
+	// verify collects the digest of the documents inserted into each
+	// namespace for --verify; it is shared by every intent
+	verify *docDigests
+	// rewrite rewrites or filters out special documents such as users,
+	// roles and view definitions
+	rewrite func(raw bson.Raw) (bson.Raw, bool, error)
+	// noRejects keeps documents that fail to restore out of --rejectsDir,
+	// which is needed for users since their documents hold credentials
+	noRejects bool
+}
+
+// restoreCollectionToDB is RestoreCollectionToDB with the given pipeline stages.
+func (restore *MongoRestore) restoreCollectionToDB(dbName, colName string,
+	bsonSource *db.DecodedBSONSource, file PosReader, fileSize int64, pipeline collectionPipeline) Result {
+	if restore.OutputOptions.MaintainInsertionOrder {
+		// grouping documents by shard would reorder them
+		pipeline.router = nil
+	}
 
 	var termErr error
 	session, err := restore.SessionProvider.GetSession()
@@ -441,19 +551,20 @@ func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 		return Result{Err: fmt.Errorf("error establishing connection: %v", err)}
 	}
 
+	namespace := dbName + "." + colName
 	collection := session.Database(dbName).Collection(colName)
 
 	documentCount := int64(0)
 	watchProgressor := progress.NewCounter(fileSize)
 	if restore.ProgressManager != nil {
-		name := fmt.Sprintf("%v.%v", dbName, colName)
-		restore.ProgressManager.Attach(name, watchProgressor)
-		defer restore.ProgressManager.Detach(name)
+		restore.ProgressManager.Attach(namespace, watchProgressor)
+		defer restore.ProgressManager.Detach(namespace)
 	}
 
 	maxInsertWorkers := restore.OutputOptions.NumInsertionWorkers
 
 	docChan := make(chan bson.Raw, insertBufferFactor)
+	var filteredCount, excludedCount, unroutedCount int64
 	resultChan := make(chan Result, maxInsertWorkers)
 
 	// stream documents for this collection on docChan
@@ -482,13 +593,57 @@ func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 	log.Logvf(log.DebugLow, "using %v insertion workers", maxInsertWorkers)
 
 	for i := 0; i < maxInsertWorkers; i++ {
-		go func() {
+		go func(id int) {
 			var result Result
 
-			bulk := db.NewUnorderedBufferedBulkInserter(collection, restore.OutputOptions.BulkBufferSize).
-				SetOrdered(restore.OutputOptions.MaintainInsertionOrder)
-			bulk.SetBypassDocumentValidation(restore.OutputOptions.BypassDocumentValidation)
+			// bulk inserters keyed by shard name or routed namespace;
+			// otherwise all documents share the "" inserter
+			bulks := map[string]*db.BufferedBulkInserter{}
+			// documents buffered in each inserter, so that smaller batches
+			// can be sent while the restore is throttled
+			pending := map[string]int{}
+			// addBulkResult returns a function that records the result of a
+			// bulk write to the given namespace and saves its rejected documents
+			addBulkResult := func(bulkNamespace string) func(*mongo.BulkWriteResult, error) error {
+				return func(bulkResult *mongo.BulkWriteResult, err error) error {
+					restore.report.recordWriteErrors(namespace, err)
+					result.combineWith(NewResultFromBulkResult(bulkResult, err))
+					if !pipeline.noRejects {
+						if rejectErr := restore.rejects.writeErrors(bulkNamespace, err); rejectErr != nil {
+							result.Err = rejectErr
+							return result.Err
+						}
+					}
+					result.Err = db.FilterError(restore.OutputOptions.StopOnError, result.Err)
+					return result.Err
+				}
+			}
+			// bulkNamespace returns the namespace of the inserter with the
+			// given key
+			bulkNamespace := func(key string) string {
+				if pipeline.routes != nil {
+					return key
+				}
+				return namespace
+			}
+			flushBulks := func() error {
+				for key, bulk := range bulks {
+					pending[key] = 0
+					if addBulkResult(bulkNamespace(key))(bulk.Flush()) != nil {
+						return result.Err
+					}
+				}
+				return nil
+			}
 			for rawDoc := range docChan {
+				if restore.throttle != nil && restore.throttle.blocks(id) {
+					// don't hold on to buffered documents while waiting
+					if flushBulks() != nil {
+						resultChan <- result
+						return
+					}
+					restore.throttle.wait(id)
+				}
 				if restore.objCheck {
 					result.Err = bson.Unmarshal(rawDoc, &bson.D{})
 					if result.Err != nil {
@@ -496,19 +651,97 @@ func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 						return
 					}
 				}
-				result.combineWith(NewResultFromBulkResult(bulk.InsertRaw(rawDoc)))
-				result.Err = db.FilterError(restore.OutputOptions.StopOnError, result.Err)
-				if result.Err != nil {
+				if pipeline.transform != nil {
+					transformed, keep, err := pipeline.transform.apply(rawDoc)
+					if err != nil {
+						if restore.OutputOptions.StopOnError {
+							result.Err = err
+							resultChan <- result
+							return
+						}
+						log.Logvf(log.Always, "skipping document: %v", err)
+						result.Failures++
+						if result.Err = restore.rejects.write(namespace, rawDoc, 0, err.Error()); result.Err != nil {
+							resultChan <- result
+							return
+						}
+						continue
+					}
+					if !keep {
+						atomic.AddInt64(&filteredCount, 1)
+						continue
+					}
+					rawDoc = transformed
+				}
+				if pipeline.rewrite != nil {
+					rewritten, keep, err := pipeline.rewrite(rawDoc)
+					if err != nil {
+						result.Err = err
+						resultChan <- result
+						return
+					}
+					if !keep {
+						atomic.AddInt64(&excludedCount, 1)
+						continue
+					}
+					rawDoc = rewritten
+				}
+				var bulkKey string
+				target := collection
+				if pipeline.router != nil {
+					bulkKey = pipeline.router.shardFor(rawDoc)
+				}
+				if pipeline.routes != nil {
+					destination, ok, err := pipeline.routes.route(rawDoc)
+					if err != nil {
+						result.Err = err
+						resultChan <- result
+						return
+					}
+					if !ok {
+						atomic.AddInt64(&unroutedCount, 1)
+						continue
+					}
+					bulkKey = destination
+					destDB, destColl := util.SplitNamespace(destination)
+					target = session.Database(destDB).Collection(destColl)
+				}
+				if pipeline.verify != nil {
+					destination := bulkKey
+					if pipeline.routes == nil {
+						destination = namespace
+					}
+					if result.Err = pipeline.verify.add(destination, rawDoc); result.Err != nil {
+						resultChan <- result
+						return
+					}
+				}
+				bulk, ok := bulks[bulkKey]
+				if !ok {
+					bulk = db.NewUnorderedBufferedBulkInserter(target, restore.OutputOptions.BulkBufferSize).
+						SetOrdered(restore.OutputOptions.MaintainInsertionOrder)
+					bulk.SetBypassDocumentValidation(restore.OutputOptions.BypassDocumentValidation)
+					bulks[bulkKey] = bulk
+				}
+				if addBulkResult(bulkNamespace(bulkKey))(bulk.InsertRaw(rawDoc)) != nil {
 					resultChan <- result
 					return
 				}
+				pending[bulkKey]++
+				if restore.throttle != nil && pending[bulkKey] >= restore.throttle.batchSize() {
+					if addBulkResult(bulkNamespace(bulkKey))(bulk.Flush()) != nil {
+						resultChan <- result
+						return
+					}
+					pending[bulkKey] = 0
+				}
 				watchProgressor.Set(file.Pos())
 			}
 			// flush the remaining docs
-			result.combineWith(NewResultFromBulkResult(bulk.Flush()))
-			resultChan <- result.withErr(db.FilterError(restore.OutputOptions.StopOnError, result.Err))
+			_ = flushBulks()
+			resultChan <- result
 			return
-		}()
+		}(i)
 
 		// sleep to prevent all threads from inserting at the same time at start
 		time.Sleep(time.Duration(i) * 10 * time.Millisecond)
@@ -526,6 +759,23 @@ func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 		}
 	}
 
+	if filteredCount > 0 {
+		log.Logvf(log.Always, "%v %v in %v.%v filtered out by transform rules",
+			filteredCount, util.Pluralize(int(filteredCount), "document", "documents"), dbName, colName)
+	}
+
+	if excludedCount > 0 {
+		log.Logvf(log.Always, "%v %v in %v.%v excluded from the restore",
+			excludedCount, util.Pluralize(int(excludedCount), "document", "documents"), dbName, colName)
+	}
+
+	if unroutedCount > 0 {
+		log.Logvf(log.Always, "%v %v in %v.%v matched no route and were skipped",
+			unroutedCount, util.Pluralize(int(unroutedCount), "document", "documents"), dbName, colName)
+	}
+
+	restore.report.recordCollection(namespace, documentCount, totalResult)
+
 	if finalErr != nil {
 		totalResult.Err = finalErr
 	} else if err = bsonSource.Err(); err != nil {
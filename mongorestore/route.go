@@ -0,0 +1,268 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/mongodb/mongo-tools-common/intents"
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RouteRule is a single entry of a --routeFile. The documents of a collection
+// whose namespace matches NS are split across several target collections:
+// each document is restored to the target of the first route whose filter it
+// matches, and documents matching no route are skipped. A route without a
+// filter matches every document.
+//
+// Targets are namespace patterns in the style of --nsTo, so variables such as
+// $coll$ in NS can be used to build the target. NS is matched against the
+// namespace after any --nsFrom/--nsTo renames. Several collections may be
+// routed to the same target, which --drop then drops once before anything
+// is restored, but a target cannot also be restored from the dump.
+//
+// Example route file:
+//
+//	[
+//	   {
+//	      "ns": "billing.documents",
+//	      "routes": [
+//	         {"filter": {"type": "invoice"}, "to": "billing.invoices"},
+//	         {"to": "billing.other"}
+//	      ]
+//	   }
+//	]
+type RouteRule struct {
+	NS     string  `bson:"ns"`
+	Routes []Route `bson:"routes"`
+}
+
+// Route is a single filter and target of a RouteRule.
+type Route struct {
+	Filter bson.D `bson:"filter"`
+	To     string `bson:"to"`
+}
+
+// routeVariableRE finds $variables$ in a route's namespace pattern.
+var routeVariableRE = regexp.MustCompile(`\$[^$]*\$`)
+
+// compiledRouteRule is a RouteRule prepared for matching.
+type compiledRouteRule struct {
+	matcher *ns.Matcher
+	filters []docPredicate
+	targets []*ns.Renamer
+}
+
+// docRouter routes the documents of a single source collection to their
+// target namespaces.
+type docRouter struct {
+	filters      []docPredicate
+	destinations []string
+}
+
+// LoadRouteRules reads and compiles the rules in a --routeFile.
+func LoadRouteRules(path string) ([]*compiledRouteRule, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading route file: %v", err)
+	}
+	return parseRouteRules(content)
+}
+
+// parseRouteRules compiles a JSON array of RouteRules.
+func parseRouteRules(content []byte) ([]*compiledRouteRule, error) {
+	var rules []RouteRule
+	if err := unmarshalExtJSONArray(content, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing route rules: %v", err)
+	}
+
+	compiled := make([]*compiledRouteRule, 0, len(rules))
+	for i, rule := range rules {
+		c, err := compileRouteRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route rule %v: %v", i, err)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func compileRouteRule(rule RouteRule) (*compiledRouteRule, error) {
+	if rule.NS == "" {
+		return nil, fmt.Errorf(`missing "ns"`)
+	}
+	if len(rule.Routes) == 0 {
+		return nil, fmt.Errorf(`missing "routes"`)
+	}
+	// match on the pattern with any $variables$ treated as wildcards
+	matcher, err := ns.NewMatcher([]string{routeVariableRE.ReplaceAllString(rule.NS, "*")})
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace pattern '%v': %v", rule.NS, err)
+	}
+	c := &compiledRouteRule{matcher: matcher}
+	for i, route := range rule.Routes {
+		if route.To == "" {
+			return nil, fmt.Errorf(`route %v is missing "to"`, i)
+		}
+		target, err := ns.NewRenamer([]string{rule.NS}, []string{route.To})
+		if err != nil {
+			return nil, fmt.Errorf("invalid target for route %v: %v", i, err)
+		}
+		var filter docPredicate
+		if len(route.Filter) > 0 {
+			filter, err = compileFilter(route.Filter)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter for route %v: %v", i, err)
+			}
+		}
+		c.filters = append(c.filters, filter)
+		c.targets = append(c.targets, target)
+	}
+	return c, nil
+}
+
+// routerFor returns a router for the given namespace using the first rule
+// that matches it, or nil if no rule does.
+func (restore *MongoRestore) routerFor(namespace string) (*docRouter, error) {
+	for _, rule := range restore.routeRules {
+		if !rule.matcher.Has(namespace) {
+			continue
+		}
+		router := &docRouter{filters: rule.filters}
+		for _, target := range rule.targets {
+			destination := target.Get(namespace)
+			if dbName, collName := util.SplitNamespace(destination); dbName == "" || collName == "" {
+				return nil, fmt.Errorf("invalid route target '%v' for %v", destination, namespace)
+			}
+			router.destinations = append(router.destinations, destination)
+		}
+		return router, nil
+	}
+	return nil, nil
+}
+
+// findRouteTargets records the target namespaces of every routed intent.
+// Several routed intents may share a target, but a target that an unrouted
+// intent is also restored to is an error. It must be called before the
+// intent manager is finalized.
+func (restore *MongoRestore) findRouteTargets() error {
+	if len(restore.routeRules) == 0 {
+		return nil
+	}
+	restore.routeTargets = map[string]bool{}
+	unrouted := map[string]bool{}
+	for _, intent := range restore.manager.Intents() {
+		if intent.Namespace() == "" {
+			continue
+		}
+		router, err := restore.routerFor(intent.Namespace())
+		if err != nil {
+			return err
+		}
+		if router == nil || intent.BSONFile == nil {
+			unrouted[intent.Namespace()] = true
+			continue
+		}
+		for _, destination := range router.destinations {
+			restore.routeTargets[destination] = true
+		}
+	}
+	for target := range restore.routeTargets {
+		if unrouted[target] {
+			return fmt.Errorf("cannot route documents to %v: it is also restored from the dump", target)
+		}
+	}
+	return nil
+}
+
+// dropRouteTargets drops every route target once with --drop, before any
+// intent is restored, so that documents routed to a shared target by one
+// intent are not dropped by another.
+func (restore *MongoRestore) dropRouteTargets() error {
+	if !restore.OutputOptions.Drop {
+		return nil
+	}
+	for target := range restore.routeTargets {
+		intent := &intents.Intent{}
+		intent.DB, intent.C = util.SplitNamespace(target)
+		if strings.HasPrefix(intent.C, "system.") {
+			log.Logvf(log.Always, "cannot drop system collection %v, skipping", target)
+			continue
+		}
+		log.Logvf(log.Info, "dropping route target %v before restoring", target)
+		if err := restore.DropCollection(intent); err != nil {
+			return err
+		}
+		restore.removeFromKnownCollections(intent)
+	}
+	return nil
+}
+
+// targetIntents returns a copy of the intent for each distinct destination.
+func (r *docRouter) targetIntents(intent *intents.Intent) []*intents.Intent {
+	var targets []*intents.Intent
+	seen := map[string]bool{}
+	for _, destination := range r.destinations {
+		if seen[destination] {
+			continue
+		}
+		seen[destination] = true
+		target := *intent
+		target.DB, target.C = util.SplitNamespace(destination)
+		targets = append(targets, &target)
+	}
+	return targets
+}
+
+// route returns the destination of a document, or false if it matches no route.
+func (r *docRouter) route(raw bson.Raw) (string, bool, error) {
+	var doc bson.D
+	for i, filter := range r.filters {
+		if filter == nil {
+			return r.destinations[i], true, nil
+		}
+		if doc == nil {
+			if err := bson.Unmarshal(raw, &doc); err != nil {
+				return "", false, err
+			}
+		}
+		if filter(doc) {
+			return r.destinations[i], true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// optionsForNamespace returns a copy of collection options whose idIndex
+// refers to the given namespace.
+func optionsForNamespace(options bson.D, namespace string) bson.D {
+	if options == nil {
+		return nil
+	}
+	copied := make(bson.D, len(options))
+	copy(copied, options)
+	for i, opt := range copied {
+		index, ok := opt.Value.(IndexDocument)
+		if opt.Key != "idIndex" || !ok {
+			continue
+		}
+		indexOptions := bson.M{}
+		for k, v := range index.Options {
+			indexOptions[k] = v
+		}
+		indexOptions["ns"] = namespace
+		index.Options = indexOptions
+		copied[i].Value = index
+	}
+	return copied
+}
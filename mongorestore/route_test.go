@@ -0,0 +1,199 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/intents"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools-common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseRouteRules(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With route rule files", t, func() {
+		Convey("a valid file should compile", func() {
+			rules, err := parseRouteRules([]byte(`[{
+				"ns": "billing.documents",
+				"routes": [{"filter": {"type": "invoice"}, "to": "billing.invoices"}, {"to": "billing.other"}]
+			}]`))
+			So(err, ShouldBeNil)
+			So(len(rules), ShouldEqual, 1)
+			So(len(rules[0].targets), ShouldEqual, 2)
+		})
+
+		Convey("rules without routes or targets should fail", func() {
+			_, err := parseRouteRules([]byte(`[{"ns": "db.c"}]`))
+			So(err, ShouldNotBeNil)
+			_, err = parseRouteRules([]byte(`[{"ns": "db.c", "routes": [{"filter": {"a": 1}}]}]`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("targets using unknown variables should fail", func() {
+			_, err := parseRouteRules([]byte(`[{"ns": "db.$coll$", "routes": [{"to": "other.$name$"}]}]`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDocRouter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With rules routing a polymorphic collection", t, func() {
+		rules, err := parseRouteRules([]byte(`[{
+			"ns": "src.$coll$",
+			"routes": [
+				{"filter": {"type": "invoice"}, "to": "billing.$coll$_invoices"},
+				{"filter": {"type": {"$in": ["receipt", "refund"]}}, "to": "billing.$coll$_payments"},
+				{"filter": {"type": "refund"}, "to": "billing.unreachable"}
+			]
+		}]`))
+		So(err, ShouldBeNil)
+		restore := &MongoRestore{routeRules: rules}
+
+		router, err := restore.routerFor("other.docs")
+		So(err, ShouldBeNil)
+		So(router, ShouldBeNil)
+
+		router, err = restore.routerFor("src.docs")
+		So(err, ShouldBeNil)
+		So(router, ShouldNotBeNil)
+		So(router.destinations, ShouldResemble, []string{
+			"billing.docs_invoices", "billing.docs_payments", "billing.unreachable",
+		})
+
+		route := func(doc bson.D) (string, bool) {
+			raw, err := bson.Marshal(doc)
+			So(err, ShouldBeNil)
+			destination, ok, err := router.route(raw)
+			So(err, ShouldBeNil)
+			return destination, ok
+		}
+
+		Convey("documents should go to the first matching route", func() {
+			destination, ok := route(bson.D{{"_id", 1}, {"type", "invoice"}})
+			So(ok, ShouldBeTrue)
+			So(destination, ShouldEqual, "billing.docs_invoices")
+			destination, ok = route(bson.D{{"_id", 2}, {"type", "refund"}})
+			So(ok, ShouldBeTrue)
+			So(destination, ShouldEqual, "billing.docs_payments")
+		})
+
+		Convey("documents matching no route should not be routed", func() {
+			_, ok := route(bson.D{{"_id", 3}, {"type", "quote"}})
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("an intent should be copied for every destination", func() {
+			targets := router.targetIntents(&intents.Intent{DB: "src", C: "docs", Location: "dump/src/docs.bson"})
+			So(len(targets), ShouldEqual, 3)
+			So(targets[1].Namespace(), ShouldEqual, "billing.docs_payments")
+			So(targets[1].Location, ShouldEqual, "dump/src/docs.bson")
+		})
+	})
+
+	Convey("Copied options should point their idIndex at the new namespace", t, func() {
+		index := IndexDocument{Key: bson.D{{"_id", 1}}, Options: bson.M{"name": "_id_", "ns": "src.docs"}}
+		options := bson.D{{"capped", false}, {"idIndex", index}}
+		copied := optionsForNamespace(options, "billing.other")
+		So(copied[1].Value.(IndexDocument).Options["ns"], ShouldEqual, "billing.other")
+		So(index.Options["ns"], ShouldEqual, "src.docs")
+		So(optionsForNamespace(nil, "billing.other"), ShouldBeNil)
+	})
+}
+
+func TestFindRouteTargets(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With intents routed to shared targets", t, func() {
+		rules, err := parseRouteRules([]byte(`[{"ns": "src.$coll$", "routes": [{"to": "dst.all"}]}]`))
+		So(err, ShouldBeNil)
+		restore := &MongoRestore{routeRules: rules, manager: intents.NewIntentManager()}
+		for _, intent := range []*intents.Intent{{DB: "src", C: "a"}, {DB: "src", C: "b"}} {
+			intent.BSONFile = &realBSONFile{intent: intent}
+			restore.manager.Put(intent)
+		}
+
+		Convey("each target should be recorded once", func() {
+			So(restore.findRouteTargets(), ShouldBeNil)
+			So(restore.routeTargets, ShouldResemble, map[string]bool{"dst.all": true})
+		})
+
+		Convey("a target that is also restored from the dump should be an error", func() {
+			intent := &intents.Intent{DB: "dst", C: "all"}
+			intent.BSONFile = &realBSONFile{intent: intent}
+			restore.manager.Put(intent)
+			So(restore.findRouteTargets(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRoutedRestoreDrop(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+	session, err := testutil.GetBareSession()
+	if err != nil {
+		t.Fatalf("No server available")
+	}
+
+	dumpDir := testDumpDir{
+		dirName: "route_drop",
+		collections: []testCollData{
+			{ns: "route_drop.jan", docs: []bson.D{{{"_id", 1}}, {{"_id", 2}}}},
+			{ns: "route_drop.feb", docs: []bson.D{{{"_id", 3}}}},
+		},
+	}
+	err = dumpDir.Create()
+	if err != nil {
+		t.Fatalf("Error creating dump: %v", err)
+	}
+	defer dumpDir.Cleanup()
+
+	routeFile := filepath.Join("testdata", "route_drop.json")
+	err = ioutil.WriteFile(routeFile, []byte(`[{"ns": "route_drop.*", "routes": [{"to": "route_drop.all"}]}]`), 0644)
+	if err != nil {
+		t.Fatalf("Error writing route file: %v", err)
+	}
+	defer os.Remove(routeFile)
+
+	database := session.Database("route_drop")
+
+	Convey("With a shared route target holding a stale document", t, func() {
+		_, err := database.Collection("all").InsertOne(nil, bson.D{{"_id", "stale"}})
+		So(err, ShouldBeNil)
+
+		restore, err := getRestoreWithArgs(NumParallelCollectionsOption, "2", DropOption, RouteFileOption, routeFile, dumpDir.Path())
+		So(err, ShouldBeNil)
+
+		Convey("--drop should empty the target once and keep every routed document", func() {
+			result := restore.Restore()
+			So(result.Err, ShouldBeNil)
+			So(result.Successes, ShouldEqual, 3)
+
+			var docs []bson.D
+			cursor, err := database.Collection("all").Find(nil, bson.M{})
+			So(err, ShouldBeNil)
+			So(cursor.All(context.Background(), &docs), ShouldBeNil)
+			ids := map[interface{}]bool{}
+			for _, doc := range docs {
+				ids[doc[0].Value] = true
+			}
+			So(ids, ShouldResemble, map[interface{}]bool{int32(1): true, int32(2): true, int32(3): true})
+		})
+
+		Reset(func() {
+			database.Drop(nil)
+		})
+	})
+}
@@ -0,0 +1,610 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/intents"
+	"github.com/mongodb/mongo-tools-common/json"
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// splitSamplesPerChunk is the number of shard key values sampled from a
+// dumped collection for every chunk we intend to create.
+const splitSamplesPerChunk = 100
+
+// errCodeAlreadyInitialized is returned by enableSharding on servers where
+// sharding is already enabled for the database.
+const errCodeAlreadyInitialized = 23
+
+// dumpedShardInfo holds the shard key and chunk boundaries of a collection as
+// they were recorded in the config database of the dump.
+type dumpedShardInfo struct {
+	Key         bson.D
+	Unique      bool
+	SplitPoints []bson.D
+}
+
+// configCollectionDoc models the parts of a config.collections document used
+// for restoring into a sharded cluster.
+type configCollectionDoc struct {
+	ID      string `bson:"_id"`
+	Key     bson.D `bson:"key"`
+	Unique  bool   `bson:"unique"`
+	Dropped bool   `bson:"dropped"`
+}
+
+// configChunkDoc models the parts of a config.chunks document used for
+// restoring into a sharded cluster.
+type configChunkDoc struct {
+	Namespace string `bson:"ns"`
+	Min       bson.D `bson:"min"`
+}
+
+// chunkRouter maps shard key values to the shard that owns the chunk
+// containing them, mirroring the splits and moves made when the collection
+// was pre-split.
+type chunkRouter struct {
+	keyFields []string
+	// splitPoints are sorted; chunk i covers [splitPoints[i-1], splitPoints[i])
+	splitPoints []bson.D
+	// shards holds the owning shard of each chunk, len(splitPoints)+1 entries
+	shards []string
+}
+
+// shardFor returns the name of the shard that owns the given document.
+func (cr *chunkRouter) shardFor(doc bson.Raw) string {
+	key := extractShardKey(doc, cr.keyFields)
+	i := sort.Search(len(cr.splitPoints), func(i int) bool {
+		return compareShardKeys(cr.splitPoints[i], key) > 0
+	})
+	return cr.shards[i]
+}
+
+// parseShardKey parses the --shardKey argument into an ordered key document.
+func parseShardKey(raw string) (bson.D, error) {
+	key := bson.D{}
+	err := json.Unmarshal([]byte(raw), &key)
+	if err != nil {
+		return nil, fmt.Errorf("shard key '%v' is not valid JSON: %v", raw, err)
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("shard key '%v' has no fields", raw)
+	}
+	return key, nil
+}
+
+// isHashedShardKey returns true if any field of the key is hashed.
+func isHashedShardKey(key bson.D) bool {
+	for _, elem := range key {
+		if elem.Value == "hashed" {
+			return true
+		}
+	}
+	return false
+}
+
+// shardKeyFields returns the field names of a shard key document.
+func shardKeyFields(key bson.D) []string {
+	fields := make([]string, len(key))
+	for i, elem := range key {
+		fields[i] = elem.Key
+	}
+	return fields
+}
+
+// extractShardKey builds the shard key document for a raw document. Missing
+// fields are treated as null, matching the server's behavior.
+func extractShardKey(doc bson.Raw, fields []string) bson.D {
+	key := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		var value interface{}
+		rawValue, err := doc.LookupErr(strings.Split(field, ".")...)
+		if err == nil {
+			if err = rawValue.Unmarshal(&value); err != nil {
+				value = nil
+			}
+		}
+		key = append(key, bson.E{Key: field, Value: value})
+	}
+	return key
+}
+
+// boundaryKey returns a key document with every field set to the given value,
+// used for the MinKey and MaxKey bounds of the first and last chunks.
+func boundaryKey(fields []string, value interface{}) bson.D {
+	key := make(bson.D, len(fields))
+	for i, field := range fields {
+		key[i] = bson.E{Key: field, Value: value}
+	}
+	return key
+}
+
+// compareShardKeys compares two shard key documents field by field.
+func compareShardKeys(a, b bson.D) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareBSONValues(a[i].Value, b[i].Value); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// canonicalTypeOrder returns the position of a value's type in the server's
+// BSON comparison order.
+func canonicalTypeOrder(v interface{}) int {
+	switch v.(type) {
+	case primitive.MinKey:
+		return 0
+	case nil, primitive.Null, primitive.Undefined:
+		return 1
+	case int32, int64, float64, int, primitive.Decimal128:
+		return 2
+	case string, primitive.Symbol:
+		return 3
+	case bson.D, bson.M:
+		return 4
+	case bson.A, []interface{}:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	case primitive.MaxKey:
+		return 13
+	}
+	return 12
+}
+
+// compareBSONValues orders two values the way the server orders shard key
+// values. Types that cannot appear in shard keys compare by type only.
+func compareBSONValues(a, b interface{}) int {
+	ta, tb := canonicalTypeOrder(a), canonicalTypeOrder(b)
+	if ta != tb {
+		return ta - tb
+	}
+	switch va := a.(type) {
+	case int32, int64, float64, int, primitive.Decimal128:
+		return compareNumbers(va, b)
+	case string:
+		return strings.Compare(va, stringValue(b))
+	case primitive.Symbol:
+		return strings.Compare(string(va), stringValue(b))
+	case primitive.ObjectID:
+		vb := b.(primitive.ObjectID)
+		return strings.Compare(string(va[:]), string(vb[:]))
+	case bool:
+		vb := b.(bool)
+		if va == vb {
+			return 0
+		}
+		if !va {
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		vb := b.(primitive.DateTime)
+		switch {
+		case va < vb:
+			return -1
+		case va > vb:
+			return 1
+		}
+	case primitive.Timestamp:
+		vb := b.(primitive.Timestamp)
+		switch {
+		case util.TimestampLessThan(va, vb):
+			return -1
+		case util.TimestampGreaterThan(va, vb):
+			return 1
+		}
+	case bson.D:
+		if vb, ok := b.(bson.D); ok {
+			return compareShardKeys(va, vb)
+		}
+	}
+	return 0
+}
+
+func stringValue(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case primitive.Symbol:
+		return string(s)
+	}
+	return ""
+}
+
+// compareNumbers orders two numbers. Integers are compared exactly, with each
+// other and with doubles; only decimals are compared through their closest
+// double. NaN sorts before every other number, as it does on the server.
+func compareNumbers(a, b interface{}) int {
+	ia, aInt := integerNumber(a)
+	ib, bInt := integerNumber(b)
+	if aInt && bInt {
+		switch {
+		case ia < ib:
+			return -1
+		case ia > ib:
+			return 1
+		}
+		return 0
+	}
+
+	fa, _ := numberAsFloat(a)
+	fb, _ := numberAsFloat(b)
+	aNaN, bNaN := math.IsNaN(fa), math.IsNaN(fb)
+	switch {
+	case aNaN && bNaN:
+		return 0
+	case aNaN:
+		return -1
+	case bNaN:
+		return 1
+	}
+	_, aDecimal := a.(primitive.Decimal128)
+	_, bDecimal := b.(primitive.Decimal128)
+	if (aInt || bInt) && !aDecimal && !bDecimal {
+		// an integer and a double, which both convert exactly
+		return exactFloat(a).Cmp(exactFloat(b))
+	}
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+// integerNumber returns the value of an int, int32 or int64.
+func integerNumber(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+// exactFloat returns the exact value of an integer or a double that is not
+// NaN.
+func exactFloat(v interface{}) *big.Float {
+	if n, ok := integerNumber(v); ok {
+		return new(big.Float).SetInt64(n)
+	}
+	f, _ := numberAsFloat(v)
+	return new(big.Float).SetFloat64(f)
+}
+
+func numberAsFloat(v interface{}) (float64, bool) {
+	if d, ok := v.(primitive.Decimal128); ok {
+		f, err := strconv.ParseFloat(d.String(), 64)
+		return f, err == nil
+	}
+	f, err := util.ToFloat64(v)
+	return f, err == nil
+}
+
+// splitPointsFromSamples picks up to numChunks-1 evenly spaced, distinct split
+// points from a set of sampled shard key values.
+func splitPointsFromSamples(samples []bson.D, numChunks int) []bson.D {
+	if numChunks < 2 || len(samples) == 0 {
+		return nil
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return compareShardKeys(samples[i], samples[j]) < 0
+	})
+	var points []bson.D
+	for i := 1; i < numChunks; i++ {
+		candidate := samples[i*len(samples)/numChunks]
+		if len(points) > 0 && compareShardKeys(points[len(points)-1], candidate) == 0 {
+			continue
+		}
+		points = append(points, candidate)
+	}
+	return points
+}
+
+// LoadDumpedShardingMetadata reads shard keys and chunk boundaries from the
+// config database files found in the dump directory.
+func (restore *MongoRestore) LoadDumpedShardingMetadata() error {
+	restore.dumpedShardInfo = map[string]*dumpedShardInfo{}
+
+	collectionsFile := restore.shardingConfigFiles["collections"]
+	if collectionsFile == nil {
+		log.Logv(log.Info, "no config.collections file in dump; using --shardKey for all collections")
+		return nil
+	}
+	err := collectionsFile.Open()
+	if err != nil {
+		return err
+	}
+	defer collectionsFile.Close()
+	bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(collectionsFile))
+	defer bsonSource.Close()
+
+	for {
+		doc := configCollectionDoc{}
+		if !bsonSource.Next(&doc) {
+			break
+		}
+		if doc.Dropped || len(doc.Key) == 0 {
+			continue
+		}
+		destNS := restore.renamer.Get(doc.ID)
+		restore.dumpedShardInfo[destNS] = &dumpedShardInfo{Key: doc.Key, Unique: doc.Unique}
+		log.Logvf(log.DebugLow, "found dumped shard key %v for %v", createExtJSONString(doc.Key), destNS)
+	}
+	if err = bsonSource.Err(); err != nil {
+		return fmt.Errorf("error reading config.collections from dump: %v", err)
+	}
+
+	chunksFile := restore.shardingConfigFiles["chunks"]
+	if chunksFile == nil {
+		return nil
+	}
+	err = chunksFile.Open()
+	if err != nil {
+		return err
+	}
+	defer chunksFile.Close()
+	chunkSource := db.NewDecodedBSONSource(db.NewBSONSource(chunksFile))
+	defer chunkSource.Close()
+
+	for {
+		chunk := configChunkDoc{}
+		if !chunkSource.Next(&chunk) {
+			break
+		}
+		info := restore.dumpedShardInfo[restore.renamer.Get(chunk.Namespace)]
+		if info == nil || len(chunk.Min) == 0 {
+			continue
+		}
+		if compareShardKeys(chunk.Min, boundaryKey(shardKeyFields(info.Key), primitive.MinKey{})) == 0 {
+			continue
+		}
+		info.SplitPoints = append(info.SplitPoints, chunk.Min)
+	}
+	if err = chunkSource.Err(); err != nil {
+		return fmt.Errorf("error reading config.chunks from dump: %v", err)
+	}
+
+	for _, info := range restore.dumpedShardInfo {
+		sort.Slice(info.SplitPoints, func(i, j int) bool {
+			return compareShardKeys(info.SplitPoints[i], info.SplitPoints[j]) < 0
+		})
+	}
+	return nil
+}
+
+// getShardNames returns the names of all shards in the target cluster.
+func (restore *MongoRestore) getShardNames(session *mongo.Client) ([]string, error) {
+	restore.shardingMutex.Lock()
+	defer restore.shardingMutex.Unlock()
+	if restore.shardNames != nil {
+		return restore.shardNames, nil
+	}
+
+	res := struct {
+		Shards []struct {
+			ID string `bson:"_id"`
+		} `bson:"shards"`
+	}{}
+	err := session.Database("admin").RunCommand(nil, bson.D{{"listShards", 1}}).Decode(&res)
+	if err != nil {
+		return nil, fmt.Errorf("error listing shards: %v", err)
+	}
+	for _, shard := range res.Shards {
+		restore.shardNames = append(restore.shardNames, shard.ID)
+	}
+	if len(restore.shardNames) == 0 {
+		return nil, fmt.Errorf("target cluster has no shards")
+	}
+	return restore.shardNames, nil
+}
+
+// enableShardingForDB enables sharding on the intent's database, once per restore.
+func (restore *MongoRestore) enableShardingForDB(session *mongo.Client, dbName string) error {
+	restore.shardingMutex.Lock()
+	defer restore.shardingMutex.Unlock()
+	if restore.shardingEnabledDBs == nil {
+		restore.shardingEnabledDBs = map[string]bool{}
+	}
+	if restore.shardingEnabledDBs[dbName] {
+		return nil
+	}
+	err := session.Database("admin").RunCommand(nil, bson.D{{"enableSharding", dbName}}).Err()
+	if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == errCodeAlreadyInitialized {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("error enabling sharding on database %v: %v", dbName, err)
+	}
+	restore.shardingEnabledDBs[dbName] = true
+	return nil
+}
+
+// primaryShard returns the primary shard of the given database.
+func primaryShard(session *mongo.Client, dbName string) (string, error) {
+	res := struct {
+		Primary string `bson:"primary"`
+	}{}
+	err := session.Database("config").Collection("databases").
+		FindOne(nil, bson.D{{"_id", dbName}}).Decode(&res)
+	if err != nil {
+		return "", fmt.Errorf("error finding primary shard of database %v: %v", dbName, err)
+	}
+	return res.Primary, nil
+}
+
+// shardKeyForIntent returns the shard key to use for the intent, along with
+// any split points recorded in the dump.
+func (restore *MongoRestore) shardKeyForIntent(intent *intents.Intent) (bson.D, bool, []bson.D) {
+	if info := restore.dumpedShardInfo[intent.Namespace()]; info != nil {
+		return info.Key, info.Unique, info.SplitPoints
+	}
+	return restore.shardKey, false, nil
+}
+
+// sampleSplitPoints reads the intent's BSON file and picks split points from
+// a random sample of its shard key values. Only dump directories can be read
+// twice, so archives and stdin return no split points.
+func (restore *MongoRestore) sampleSplitPoints(intent *intents.Intent, keyFields []string, numChunks int) ([]bson.D, error) {
+	source, ok := intent.BSONFile.(*realBSONFile)
+	if !ok || numChunks < 2 {
+		return nil, nil
+	}
+	file := &realBSONFile{path: source.path, intent: intent, gzip: source.gzip}
+	err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(file))
+	defer bsonSource.Close()
+
+	sampleSize := numChunks * splitSamplesPerChunk
+	samples := make([]bson.D, 0, sampleSize)
+	seen := 0
+	for {
+		doc := bsonSource.LoadNext()
+		if doc == nil {
+			break
+		}
+		seen++
+		// reservoir sampling keeps memory bounded for large collections
+		if len(samples) < sampleSize {
+			samples = append(samples, extractShardKey(doc, keyFields))
+		} else if j := rand.Intn(seen); j < sampleSize {
+			samples[j] = extractShardKey(doc, keyFields)
+		}
+	}
+	if err = bsonSource.Err(); err != nil {
+		return nil, fmt.Errorf("error sampling shard key values: %v", err)
+	}
+	return splitPointsFromSamples(samples, numChunks), nil
+}
+
+// ShardCollection shards the intent's newly created collection, pre-splits it
+// and spreads the chunks across all shards. It returns a router for grouping
+// inserts by shard, or nil if documents cannot be routed on the client.
+func (restore *MongoRestore) ShardCollection(intent *intents.Intent) (*chunkRouter, error) {
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return nil, fmt.Errorf("error establishing connection: %v", err)
+	}
+	shards, err := restore.getShardNames(session)
+	if err != nil {
+		return nil, err
+	}
+	if err = restore.enableShardingForDB(session, intent.DB); err != nil {
+		return nil, err
+	}
+
+	key, unique, splitPoints := restore.shardKeyForIntent(intent)
+	keyFields := shardKeyFields(key)
+	numChunks := len(shards) * restore.OutputOptions.ChunksPerShard
+
+	command := bson.D{
+		{"shardCollection", intent.Namespace()},
+		{"key", key},
+		{"unique", unique},
+	}
+	hashed := isHashedShardKey(key)
+	if hashed {
+		// the server pre-splits and distributes hashed keys on its own
+		command = append(command, bson.E{"numInitialChunks", numChunks})
+	}
+	log.Logvf(log.Info, "sharding collection %v with key %v", intent.Namespace(), createExtJSONString(key))
+	err = session.Database("admin").RunCommand(nil, command).Err()
+	if err != nil {
+		return nil, fmt.Errorf("error sharding collection: %v", err)
+	}
+	if hashed {
+		return nil, nil
+	}
+
+	if len(splitPoints) == 0 {
+		splitPoints, err = restore.sampleSplitPoints(intent, keyFields, numChunks)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		log.Logvf(log.DebugLow, "using %v dumped chunk boundaries for %v", len(splitPoints), intent.Namespace())
+	}
+
+	for _, point := range splitPoints {
+		err = session.Database("admin").RunCommand(nil, bson.D{
+			{"split", intent.Namespace()},
+			{"middle", point},
+		}).Err()
+		if err != nil {
+			return nil, fmt.Errorf("error splitting chunk at %v: %v", createExtJSONString(point), err)
+		}
+	}
+
+	primary, err := primaryShard(session, intent.DB)
+	if err != nil {
+		return nil, err
+	}
+	router := &chunkRouter{
+		keyFields:   keyFields,
+		splitPoints: splitPoints,
+		shards:      make([]string, len(splitPoints)+1),
+	}
+	for i := range router.shards {
+		router.shards[i] = shards[i%len(shards)]
+		if router.shards[i] == primary {
+			continue
+		}
+		lower := boundaryKey(keyFields, primitive.MinKey{})
+		if i > 0 {
+			lower = splitPoints[i-1]
+		}
+		upper := boundaryKey(keyFields, primitive.MaxKey{})
+		if i < len(splitPoints) {
+			upper = splitPoints[i]
+		}
+		err = session.Database("admin").RunCommand(nil, bson.D{
+			{"moveChunk", intent.Namespace()},
+			{"bounds", bson.A{lower, upper}},
+			{"to", router.shards[i]},
+		}).Err()
+		if err != nil {
+			return nil, fmt.Errorf("error moving chunk %v to %v: %v", i, router.shards[i], err)
+		}
+	}
+	log.Logvf(log.Always, "pre-split %v into %v %v across %v %v",
+		intent.Namespace(), len(router.shards), util.Pluralize(len(router.shards), "chunk", "chunks"),
+		len(shards), util.Pluralize(len(shards), "shard", "shards"))
+	return router, nil
+}
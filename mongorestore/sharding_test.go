@@ -0,0 +1,209 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools-common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseShardKey(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a shard key argument", t, func() {
+		Convey("a compound key should keep its field order", func() {
+			key, err := parseShardKey(`{b: 1, a: 1}`)
+			So(err, ShouldBeNil)
+			So(shardKeyFields(key), ShouldResemble, []string{"b", "a"})
+			So(isHashedShardKey(key), ShouldBeFalse)
+		})
+
+		Convey("a hashed key should be detected", func() {
+			key, err := parseShardKey(`{_id: "hashed"}`)
+			So(err, ShouldBeNil)
+			So(isHashedShardKey(key), ShouldBeTrue)
+		})
+
+		Convey("an empty or invalid key should fail", func() {
+			_, err := parseShardKey(`{}`)
+			So(err, ShouldNotBeNil)
+			_, err = parseShardKey(`{a: `)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCompareShardKeys(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Shard key values should compare like the server orders them", t, func() {
+		So(compareBSONValues(primitive.MinKey{}, nil), ShouldBeLessThan, 0)
+		So(compareBSONValues(int32(5), 4.5), ShouldBeGreaterThan, 0)
+		So(compareBSONValues(int64(3), 3.0), ShouldEqual, 0)
+		So(compareBSONValues(int64(1<<60+1), int64(1<<60)), ShouldBeGreaterThan, 0)
+		So(compareBSONValues(int64(1<<60+1), float64(1<<60)), ShouldBeGreaterThan, 0)
+		So(compareBSONValues(float64(1<<60), int64(1<<60+1)), ShouldBeLessThan, 0)
+		So(compareBSONValues(math.NaN(), int32(-5)), ShouldBeLessThan, 0)
+		So(compareBSONValues("a", int32(100)), ShouldBeGreaterThan, 0)
+		So(compareBSONValues("abc", "abd"), ShouldBeLessThan, 0)
+		So(compareBSONValues(primitive.ObjectID{1}, primitive.ObjectID{2}), ShouldBeLessThan, 0)
+		So(compareBSONValues(primitive.MaxKey{}, "zzz"), ShouldBeGreaterThan, 0)
+
+		Convey("and compound keys should compare field by field", func() {
+			a := bson.D{{"x", int32(1)}, {"y", "b"}}
+			b := bson.D{{"x", int32(1)}, {"y", "c"}}
+			So(compareShardKeys(a, b), ShouldBeLessThan, 0)
+			So(compareShardKeys(b, a), ShouldBeGreaterThan, 0)
+			So(compareShardKeys(a, a), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestSplitPointsFromSamples(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With 100 sampled shard key values", t, func() {
+		var samples []bson.D
+		for i := 99; i >= 0; i-- {
+			samples = append(samples, bson.D{{"_id", int32(i)}})
+		}
+
+		Convey("four chunks should produce three evenly spaced split points", func() {
+			points := splitPointsFromSamples(samples, 4)
+			So(points, ShouldResemble, []bson.D{
+				{{"_id", int32(25)}},
+				{{"_id", int32(50)}},
+				{{"_id", int32(75)}},
+			})
+		})
+
+		Convey("a single chunk should produce no split points", func() {
+			So(splitPointsFromSamples(samples, 1), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Duplicate sampled values should not produce duplicate split points", t, func() {
+		samples := []bson.D{{{"a", "x"}}, {{"a", "x"}}, {{"a", "x"}}, {{"a", "y"}}}
+		points := splitPointsFromSamples(samples, 4)
+		So(points, ShouldResemble, []bson.D{{{"a", "x"}}, {{"a", "y"}}})
+	})
+}
+
+func TestChunkRouter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a router for three chunks on two shards", t, func() {
+		router := &chunkRouter{
+			keyFields:   []string{"a.b"},
+			splitPoints: []bson.D{{{"a.b", int32(10)}}, {{"a.b", int32(20)}}},
+			shards:      []string{"shard0", "shard1", "shard0"},
+		}
+		route := func(doc bson.D) string {
+			raw, err := bson.Marshal(doc)
+			So(err, ShouldBeNil)
+			return router.shardFor(raw)
+		}
+
+		Convey("documents should be routed to the chunk containing their key", func() {
+			So(route(bson.D{{"a", bson.D{{"b", int32(5)}}}}), ShouldEqual, "shard0")
+			So(route(bson.D{{"a", bson.D{{"b", int32(10)}}}}), ShouldEqual, "shard1")
+			So(route(bson.D{{"a", bson.D{{"b", 19.5}}}}), ShouldEqual, "shard1")
+			So(route(bson.D{{"a", bson.D{{"b", int64(20)}}}}), ShouldEqual, "shard0")
+		})
+
+		Convey("documents missing the key should be routed as null", func() {
+			So(route(bson.D{{"c", int32(50)}}), ShouldEqual, "shard0")
+		})
+	})
+}
+
+func TestShardCollectionsRestore(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+	sessionProvider, _, err := testutil.GetBareSessionProvider()
+	if err != nil {
+		t.Fatalf("No cluster available: %v", err)
+	}
+	session, err := sessionProvider.GetSession()
+	if err != nil {
+		t.Fatalf("No client available")
+	}
+	isMongos, err := sessionProvider.IsMongos()
+	if err != nil {
+		t.Fatalf("Error checking node type: %v", err)
+	}
+
+	docs := make([]bson.D, 100)
+	for i := range docs {
+		docs[i] = bson.D{{"_id", i}, {"n", i % 10}}
+	}
+	dumpDir := testDumpDir{
+		dirName:     "shard_restore",
+		collections: []testCollData{{ns: "shard_restore.docs", docs: docs}},
+	}
+	err = dumpDir.Create()
+	if err != nil {
+		t.Fatalf("Error creating dump: %v", err)
+	}
+	defer dumpDir.Cleanup()
+
+	args := []string{
+		DropOption,
+		ShardCollectionsOption,
+		ShardKeyOption, `{"n": 1}`,
+		dumpDir.Path(),
+	}
+
+	if !isMongos {
+		Convey("With a test MongoRestore sharding collections on a non-mongos", t, func() {
+			restore, err := getRestoreWithArgs(args...)
+			So(err, ShouldBeNil)
+
+			Convey("the restore should be rejected", func() {
+				result := restore.Restore()
+				So(result.Err, ShouldNotBeNil)
+				So(result.Err.Error(), ShouldContainSubstring, "unless connected to a mongos")
+			})
+		})
+		return
+	}
+
+	database := session.Database("shard_restore")
+
+	Convey("With a test MongoRestore sharding collections on a mongos", t, func() {
+		restore, err := getRestoreWithArgs(args...)
+		So(err, ShouldBeNil)
+
+		Convey("the collection should be sharded by --shardKey and fully restored", func() {
+			result := restore.Restore()
+			So(result.Err, ShouldBeNil)
+
+			var config struct {
+				Key bson.D `bson:"key"`
+			}
+			err := session.Database("config").Collection("collections").
+				FindOne(nil, bson.D{{"_id", "shard_restore.docs"}}).Decode(&config)
+			So(err, ShouldBeNil)
+			So(len(config.Key), ShouldEqual, 1)
+			So(config.Key[0].Key, ShouldEqual, "n")
+			So(compareNumbers(config.Key[0].Value, 1), ShouldEqual, 0)
+
+			count, err := database.Collection("docs").CountDocuments(nil, bson.M{})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 100)
+		})
+
+		Reset(func() {
+			database.Drop(nil)
+		})
+	})
+}
@@ -0,0 +1,281 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/mongostat/status"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// throttle slows a restore down while the target is under pressure. It polls
+// the replication lag of the target's secondaries and the number of available
+// WiredTiger write tickets. Each poll that finds a threshold crossed halves the
+// insert batch size and the number of active insertion workers, down to one of
+// each and then to a full pause; each poll that finds the target comfortably
+// below both thresholds doubles them again.
+type throttle struct {
+	maxLag      time.Duration
+	minTickets  int64
+	baseBatch   int
+	baseWorkers int
+	pauseLevel  int
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	level   int
+	stopped bool
+
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// delayed holds the hosts of delayed secondaries, whose lag is expected
+	delayed map[string]bool
+}
+
+// replSetStatus is the part of a replSetGetStatus response the throttle uses.
+type replSetStatus struct {
+	Members []struct {
+		Name       string    `bson:"name"`
+		State      int       `bson:"state"`
+		OptimeDate time.Time `bson:"optimeDate"`
+	} `bson:"members"`
+}
+
+// replSetConfig is the part of a replSetGetConfig response the throttle uses.
+type replSetConfig struct {
+	Config struct {
+		Members []struct {
+			Host               string `bson:"host"`
+			SlaveDelay         int64  `bson:"slaveDelay"`
+			SecondaryDelaySecs int64  `bson:"secondaryDelaySecs"`
+		} `bson:"members"`
+	} `bson:"config"`
+}
+
+const (
+	memberStatePrimary   = 1
+	memberStateSecondary = 2
+)
+
+// newThrottle returns a throttle for the restore's thresholds.
+func newThrottle(maxLag time.Duration, minTickets int64, batchSize, workers int) *throttle {
+	t := &throttle{
+		maxLag:      maxLag,
+		minTickets:  minTickets,
+		baseBatch:   batchSize,
+		baseWorkers: workers,
+		delayed:     map[string]bool{},
+		done:        make(chan struct{}),
+	}
+	// halve until both the batch size and the worker count are one, then pause
+	t.pauseLevel = bits.Len(uint(util.MaxInt(batchSize, workers)))
+	t.cond = sync.NewCond(&t.mutex)
+	return t
+}
+
+// batchSize returns the number of documents to buffer before each insert.
+func (t *throttle) batchSize() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return util.MaxInt(1, t.baseBatch>>uint(t.level))
+}
+
+// blocks returns true if the given insertion worker must wait before its next
+// insert.
+func (t *throttle) blocks(worker int) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.blocksLocked(worker)
+}
+
+func (t *throttle) blocksLocked(worker int) bool {
+	if t.stopped {
+		return false
+	}
+	return t.level >= t.pauseLevel || worker >= util.MaxInt(1, t.baseWorkers>>uint(t.level))
+}
+
+// wait blocks the given insertion worker until it may insert again.
+func (t *throttle) wait(worker int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for t.blocksLocked(worker) {
+		t.cond.Wait()
+	}
+}
+
+// stop releases every waiting worker and disables the throttle. It is safe
+// to call more than once.
+func (t *throttle) stop() {
+	t.doneOnce.Do(func() { close(t.done) })
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.stopped = true
+	t.cond.Broadcast()
+}
+
+// adjust moves the throttle one level given the latest lag and ticket
+// readings. Negative readings are unavailable. An unavailable ticket reading
+// is ignored, but an unavailable lag reading counts as over the threshold,
+// since the secondaries may be falling behind unseen. It returns true if the
+// level changed.
+func (t *throttle) adjust(lag time.Duration, tickets int64) bool {
+	over := (t.maxLag > 0 && (lag < 0 || lag > t.maxLag)) || (t.minTickets > 0 && tickets >= 0 && tickets < t.minTickets)
+	healthy := (t.maxLag == 0 || (lag >= 0 && lag <= t.maxLag/2)) && (t.minTickets == 0 || tickets < 0 || tickets >= 2*t.minTickets)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	previous := t.level
+	switch {
+	case over && t.level < t.pauseLevel:
+		t.level++
+	case healthy && t.level > 0:
+		t.level--
+	}
+	if t.level == previous {
+		return false
+	}
+	t.cond.Broadcast()
+	return true
+}
+
+// describe summarizes the current throttling level.
+func (t *throttle) describe() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	switch {
+	case t.level == 0:
+		return "no longer throttling inserts"
+	case t.level >= t.pauseLevel:
+		return "pausing inserts"
+	}
+	workers := util.MaxInt(1, t.baseWorkers>>uint(t.level))
+	return fmt.Sprintf("throttling inserts to batches of %v with %v %v",
+		util.MaxInt(1, t.baseBatch>>uint(t.level)), workers, util.Pluralize(workers, "worker", "workers"))
+}
+
+// maxSecondaryLag returns how far the furthest behind secondary that is not
+// delayed trails the primary, or false if there is no primary.
+func maxSecondaryLag(rs replSetStatus, delayed map[string]bool) (time.Duration, bool) {
+	var primary time.Time
+	var found bool
+	for _, member := range rs.Members {
+		if member.State == memberStatePrimary {
+			primary, found = member.OptimeDate, true
+		}
+	}
+	if !found {
+		return 0, false
+	}
+	var lag time.Duration
+	for _, member := range rs.Members {
+		if member.State != memberStateSecondary || delayed[member.Name] {
+			continue
+		}
+		if behind := primary.Sub(member.OptimeDate); behind > lag {
+			lag = behind
+		}
+	}
+	return lag, true
+}
+
+// startThrottle polls the target every interval and adjusts the throttle
+// until the restore finishes or is interrupted.
+func (restore *MongoRestore) startThrottle(interval time.Duration) (*throttle, error) {
+	t := newThrottle(
+		time.Duration(restore.OutputOptions.MaxReplicationLag)*time.Second,
+		int64(restore.OutputOptions.MinWriteTickets),
+		restore.OutputOptions.BulkBufferSize,
+		restore.OutputOptions.NumInsertionWorkers,
+	)
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return nil, fmt.Errorf("error establishing connection: %v", err)
+	}
+	admin := session.Database("admin")
+
+	checkLag := t.maxLag > 0
+	if checkLag {
+		var config replSetConfig
+		if err = admin.RunCommand(nil, bson.D{{"replSetGetConfig", 1}}).Decode(&config); err != nil {
+			return nil, fmt.Errorf("cannot use %v: error reading replica set config: %v", MaxReplicationLagOption, err)
+		}
+		for _, member := range config.Config.Members {
+			if member.SlaveDelay > 0 || member.SecondaryDelaySecs > 0 {
+				log.Logvf(log.Info, "ignoring replication lag of delayed secondary %v", member.Host)
+				t.delayed[member.Host] = true
+			}
+		}
+	}
+
+	// lagUnavailable is set while the replication lag cannot be read, so
+	// that the warning is only logged when reading it starts failing
+	var lagUnavailable bool
+	poll := func() {
+		lag, tickets := time.Duration(0), int64(-1)
+		if checkLag {
+			var rs replSetStatus
+			var lagErr error
+			if err := admin.RunCommand(nil, bson.D{{"replSetGetStatus", 1}}).Decode(&rs); err != nil {
+				lagErr = fmt.Errorf("error reading replica set status: %v", err)
+			} else if measured, ok := maxSecondaryLag(rs, t.delayed); !ok {
+				lagErr = fmt.Errorf("replica set has no primary")
+			} else if lag = measured; lag > t.maxLag {
+				log.Logvf(log.Info, "replication lag %v exceeds %v", lag, t.maxLag)
+			}
+			if lagErr != nil {
+				if !lagUnavailable {
+					log.Logvf(log.Always, "warning: cannot measure replication lag, throttling inserts until it can be: %v", lagErr)
+				}
+				lagUnavailable = true
+				lag = -1
+			} else if lagUnavailable {
+				log.Logv(log.Always, "replication lag can be measured again")
+				lagUnavailable = false
+			}
+		}
+		if t.minTickets > 0 {
+			var stat status.ServerStatus
+			err := admin.RunCommand(nil, bson.D{{"serverStatus", 1}, {"recordStats", 0}}).Decode(&stat)
+			if err != nil {
+				log.Logvf(log.DebugLow, "error reading server status: %v", err)
+			} else if stat.WiredTiger != nil {
+				tickets = stat.WiredTiger.Concurrent.Write.Available
+				if tickets < t.minTickets {
+					log.Logvf(log.Info, "%v write tickets available, below %v", tickets, t.minTickets)
+				}
+			}
+		}
+		if t.adjust(lag, tickets) {
+			log.Logvf(log.Always, "%v", t.describe())
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-restore.termChan:
+				t.stop()
+				return
+			case <-t.done:
+				return
+			case <-ticker.C:
+				poll()
+			}
+		}
+	}()
+	return t, nil
+}
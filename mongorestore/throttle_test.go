@@ -0,0 +1,200 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools-common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestThrottleLevels(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a throttle on lag and write tickets", t, func() {
+		th := newThrottle(10*time.Second, 20, 8, 4)
+		So(th.pauseLevel, ShouldEqual, 4)
+		So(th.batchSize(), ShouldEqual, 8)
+		So(th.blocks(3), ShouldBeFalse)
+
+		Convey("crossing a threshold halves batches and workers", func() {
+			So(th.adjust(11*time.Second, 100), ShouldBeTrue)
+			So(th.batchSize(), ShouldEqual, 4)
+			So(th.blocks(1), ShouldBeFalse)
+			So(th.blocks(2), ShouldBeTrue)
+			So(th.describe(), ShouldEqual, "throttling inserts to batches of 4 with 2 workers")
+
+			So(th.adjust(0, 5), ShouldBeTrue)
+			So(th.batchSize(), ShouldEqual, 2)
+			So(th.blocks(0), ShouldBeFalse)
+			So(th.blocks(1), ShouldBeTrue)
+		})
+
+		Convey("staying over a threshold eventually pauses every worker", func() {
+			for i := 0; i < 10; i++ {
+				th.adjust(time.Minute, -1)
+			}
+			So(th.batchSize(), ShouldEqual, 1)
+			So(th.blocks(0), ShouldBeTrue)
+			So(th.describe(), ShouldEqual, "pausing inserts")
+		})
+
+		Convey("an unavailable lag reading throttles until lag can be read again", func() {
+			So(th.adjust(-1, 100), ShouldBeTrue)
+			So(th.adjust(-1, -1), ShouldBeTrue)
+			So(th.adjust(time.Second, 100), ShouldBeTrue)
+		})
+
+		Convey("readings between the thresholds and their halves hold the level", func() {
+			So(th.adjust(11*time.Second, 100), ShouldBeTrue)
+			So(th.adjust(7*time.Second, 100), ShouldBeFalse)
+			So(th.adjust(0, 30), ShouldBeFalse)
+			So(th.batchSize(), ShouldEqual, 4)
+		})
+
+		Convey("comfortable readings restore the full rate", func() {
+			th.adjust(11*time.Second, 100)
+			th.adjust(11*time.Second, 100)
+			So(th.adjust(time.Second, 100), ShouldBeTrue)
+			So(th.adjust(time.Second, -1), ShouldBeTrue)
+			So(th.adjust(time.Second, 100), ShouldBeFalse)
+			So(th.batchSize(), ShouldEqual, 8)
+			So(th.describe(), ShouldEqual, "no longer throttling inserts")
+		})
+
+		Convey("waiting workers are released when the level drops or the throttle stops", func() {
+			for i := 0; i < 10; i++ {
+				th.adjust(time.Minute, -1)
+			}
+			released := make(chan int, 2)
+			go func() {
+				th.wait(0)
+				released <- 0
+			}()
+			go func() {
+				th.wait(3)
+				released <- 3
+			}()
+
+			th.adjust(0, -1)
+			So(<-released, ShouldEqual, 0)
+
+			th.stop()
+			th.stop()
+			So(<-released, ShouldEqual, 3)
+			So(th.blocks(3), ShouldBeFalse)
+		})
+	})
+}
+
+func TestMaxSecondaryLag(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Secondary lag is measured from the primary's optime", t, func() {
+		now := time.Now().Truncate(time.Millisecond)
+		raw := mustMarshal(bson.D{{"members", bson.A{
+			bson.D{{"name", "a:1"}, {"state", 1}, {"optimeDate", now}},
+			bson.D{{"name", "b:1"}, {"state", 2}, {"optimeDate", now.Add(-3 * time.Second)}},
+			bson.D{{"name", "c:1"}, {"state", 2}, {"optimeDate", now.Add(-time.Hour)}},
+			bson.D{{"name", "d:1"}, {"state", 7}},
+		}}})
+		var rs replSetStatus
+		So(bson.Unmarshal(raw, &rs), ShouldBeNil)
+
+		lag, ok := maxSecondaryLag(rs, map[string]bool{})
+		So(ok, ShouldBeTrue)
+		So(lag, ShouldEqual, time.Hour)
+
+		lag, ok = maxSecondaryLag(rs, map[string]bool{"c:1": true})
+		So(ok, ShouldBeTrue)
+		So(lag, ShouldEqual, 3*time.Second)
+
+		rs.Members = rs.Members[1:]
+		_, ok = maxSecondaryLag(rs, map[string]bool{})
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestThrottledRestore(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+	sessionProvider, _, err := testutil.GetBareSessionProvider()
+	if err != nil {
+		t.Fatalf("No cluster available: %v", err)
+	}
+	session, err := sessionProvider.GetSession()
+	if err != nil {
+		t.Fatalf("No client available")
+	}
+	isReplicaSet, err := sessionProvider.IsReplicaSet()
+	if err != nil {
+		t.Fatalf("Error checking node type: %v", err)
+	}
+
+	docs := make([]bson.D, 1000)
+	for i := range docs {
+		docs[i] = bson.D{{"_id", i}}
+	}
+	dumpDir := testDumpDir{
+		dirName:     "throttle_restore",
+		collections: []testCollData{{ns: "throttle_restore.docs", docs: docs}},
+	}
+	err = dumpDir.Create()
+	if err != nil {
+		t.Fatalf("Error creating dump: %v", err)
+	}
+	defer dumpDir.Cleanup()
+
+	database := session.Database("throttle_restore")
+
+	Convey("With a test MongoRestore throttled on write tickets", t, func() {
+		restore, err := getRestoreWithArgs(DropOption, MinWriteTicketsOption, "1", BulkBufferSizeOption, "10", dumpDir.Path())
+		So(err, ShouldBeNil)
+
+		Convey("every document should be restored", func() {
+			result := restore.Restore()
+			So(result.Err, ShouldBeNil)
+			So(result.Successes, ShouldEqual, 1000)
+			count, err := database.Collection("docs").CountDocuments(nil, bson.M{})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1000)
+		})
+
+		Reset(func() {
+			database.Drop(nil)
+		})
+	})
+
+	Convey("With a test MongoRestore throttled on replication lag", t, func() {
+		restore, err := getRestoreWithArgs(DropOption, MaxReplicationLagOption, "60", dumpDir.Path())
+		So(err, ShouldBeNil)
+
+		if isReplicaSet {
+			Convey("every document should be restored", func() {
+				result := restore.Restore()
+				So(result.Err, ShouldBeNil)
+				So(result.Successes, ShouldEqual, 1000)
+				count, err := database.Collection("docs").CountDocuments(nil, bson.M{})
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 1000)
+			})
+		} else {
+			Convey("the restore should be rejected without a replica set", func() {
+				result := restore.Restore()
+				So(result.Err, ShouldNotBeNil)
+				So(result.Err.Error(), ShouldContainSubstring, "cannot use "+MaxReplicationLagOption)
+			})
+		}
+
+		Reset(func() {
+			database.Drop(nil)
+		})
+	})
+}
@@ -0,0 +1,585 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TransformRule is a single entry of a --transformFile. Rules are applied to
+// every document restored to a namespace matching NS, in this order: the
+// document is dropped unless it matches Filter, then fields are renamed,
+// converted, dropped and finally set to constant values.
+//
+// Example rule file:
+//
+//	[
+//	   {
+//	      "ns": "shop.orders",
+//	      "filter": {"status": {"$ne": "deleted"}},
+//	      "rename": {"cust": "customerId"},
+//	      "convert": {"placed": {"to": "date", "format": "2006-01-02"}, "total": "decimal"},
+//	      "drop": ["legacy.flags"],
+//	      "set": {"migrated": true}
+//	   }
+//	]
+type TransformRule struct {
+	NS      string   `bson:"ns"`
+	Filter  bson.D   `bson:"filter"`
+	Rename  bson.D   `bson:"rename"`
+	Convert bson.D   `bson:"convert"`
+	Drop    []string `bson:"drop"`
+	Set     bson.D   `bson:"set"`
+}
+
+// fieldConversion converts the value at a dotted path to another BSON type.
+type fieldConversion struct {
+	path   string
+	to     string
+	format string
+}
+
+// compiledTransform is a TransformRule prepared for application.
+type compiledTransform struct {
+	matcher *ns.Matcher
+	filter  docPredicate
+	rename  [][2]string
+	convert []fieldConversion
+	drop    []string
+	set     bson.D
+}
+
+// docTransformer applies every rule matching a single namespace.
+type docTransformer struct {
+	rules []*compiledTransform
+}
+
+// supportedConversions lists the target types accepted in "convert".
+var supportedConversions = map[string]bool{
+	"bool":     true,
+	"date":     true,
+	"decimal":  true,
+	"double":   true,
+	"int":      true,
+	"long":     true,
+	"objectId": true,
+	"string":   true,
+}
+
+// LoadTransformRules reads and compiles the rules in a --transformFile.
+func LoadTransformRules(path string) ([]*compiledTransform, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading transform file: %v", err)
+	}
+	return parseTransformRules(content)
+}
+
+// unmarshalExtJSONArray decodes a JSON array of extended JSON values into
+// out, which points to a slice. The extended JSON parser only accepts
+// documents at the top level, so the array is parsed as a field of one.
+func unmarshalExtJSONArray(content []byte, out interface{}) error {
+	var wrapped bson.Raw
+	err := bson.UnmarshalExtJSON(append(append([]byte(`{"array":`), content...), '}'), false, &wrapped)
+	if err != nil {
+		return err
+	}
+	return wrapped.Lookup("array").Unmarshal(out)
+}
+
+// parseTransformRules compiles a JSON array of TransformRules.
+func parseTransformRules(content []byte) ([]*compiledTransform, error) {
+	var rules []TransformRule
+	if err := unmarshalExtJSONArray(content, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing transform rules: %v", err)
+	}
+
+	compiled := make([]*compiledTransform, 0, len(rules))
+	for i, rule := range rules {
+		c, err := compileTransformRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform rule %v: %v", i, err)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func compileTransformRule(rule TransformRule) (*compiledTransform, error) {
+	if rule.NS == "" {
+		return nil, fmt.Errorf(`missing "ns"`)
+	}
+	matcher, err := ns.NewMatcher([]string{rule.NS})
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace pattern '%v': %v", rule.NS, err)
+	}
+	c := &compiledTransform{matcher: matcher, drop: rule.Drop, set: rule.Set}
+
+	if len(rule.Filter) > 0 {
+		c.filter, err = compileFilter(rule.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %v", err)
+		}
+	}
+	for _, elem := range rule.Rename {
+		to, ok := elem.Value.(string)
+		if !ok || to == "" {
+			return nil, fmt.Errorf("rename target for '%v' must be a field name", elem.Key)
+		}
+		c.rename = append(c.rename, [2]string{elem.Key, to})
+	}
+	for _, elem := range rule.Convert {
+		conv := fieldConversion{path: elem.Key}
+		switch spec := elem.Value.(type) {
+		case string:
+			conv.to = spec
+		case bson.D:
+			for _, opt := range spec {
+				value, _ := opt.Value.(string)
+				switch opt.Key {
+				case "to":
+					conv.to = value
+				case "format":
+					conv.format = value
+				default:
+					return nil, fmt.Errorf("unknown conversion option '%v' for '%v'", opt.Key, elem.Key)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("conversion for '%v' must be a type name or a document", elem.Key)
+		}
+		if !supportedConversions[conv.to] {
+			return nil, fmt.Errorf("unsupported conversion type '%v' for '%v'", conv.to, elem.Key)
+		}
+		c.convert = append(c.convert, conv)
+	}
+	return c, nil
+}
+
+// transformerFor returns a transformer with the rules that apply to the given
+// destination namespace, or nil if there are none.
+func (restore *MongoRestore) transformerFor(namespace string) *docTransformer {
+	var matched []*compiledTransform
+	for _, rule := range restore.transformRules {
+		if rule.matcher.Has(namespace) {
+			matched = append(matched, rule)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	return &docTransformer{rules: matched}
+}
+
+// apply runs the transformer's rules on a raw document. It returns false if
+// the document was filtered out.
+func (t *docTransformer) apply(raw bson.Raw) (bson.Raw, bool, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, false, err
+	}
+	for _, rule := range t.rules {
+		if rule.filter != nil && !rule.filter(doc) {
+			return nil, false, nil
+		}
+		for _, rename := range rule.rename {
+			if value, ok := getValuePath(doc, rename[0]); ok {
+				removed, _ := removeValuePath(doc, rename[0])
+				doc = setValuePath(removed, rename[1], value).(bson.D)
+			}
+		}
+		for _, conv := range rule.convert {
+			value, ok := getValuePath(doc, conv.path)
+			if !ok {
+				continue
+			}
+			converted, err := convertValue(value, conv.to, conv.format)
+			if err != nil {
+				return nil, false, fmt.Errorf("error converting field '%v' to %v: %v", conv.path, conv.to, err)
+			}
+			doc = setValuePath(doc, conv.path, converted).(bson.D)
+		}
+		for _, path := range rule.drop {
+			removed, _ := removeValuePath(doc, path)
+			doc = removed.(bson.D)
+		}
+		for _, elem := range rule.set {
+			doc = setValuePath(doc, elem.Key, elem.Value).(bson.D)
+		}
+	}
+	out, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// convertValue converts a BSON value to the named type.
+func convertValue(value interface{}, to, format string) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	str, isString := value.(string)
+	switch to {
+	case "string":
+		if oid, ok := value.(primitive.ObjectID); ok {
+			return oid.Hex(), nil
+		}
+		return fmt.Sprintf("%v", value), nil
+	case "int", "long":
+		n, err := integerValue(value)
+		if err != nil {
+			return nil, err
+		}
+		if to == "long" {
+			return n, nil
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("%v is out of range for an int", n)
+		}
+		return int32(n), nil
+	case "double":
+		if isString {
+			return strconv.ParseFloat(strings.TrimSpace(str), 64)
+		}
+		if b, ok := value.(bool); ok {
+			if b {
+				return float64(1), nil
+			}
+			return float64(0), nil
+		}
+		f, ok := numberAsFloat(value)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %T to a number", value)
+		}
+		return f, nil
+	case "decimal":
+		if !isString {
+			str = fmt.Sprintf("%v", value)
+		}
+		return primitive.ParseDecimal128(strings.TrimSpace(str))
+	case "bool":
+		if isString {
+			return strconv.ParseBool(strings.TrimSpace(str))
+		}
+		if f, ok := numberAsFloat(value); ok {
+			return f != 0, nil
+		}
+		return nil, fmt.Errorf("cannot convert %T to a boolean", value)
+	case "date":
+		if isString {
+			if format == "" {
+				format = time.RFC3339
+			}
+			t, err := time.Parse(format, strings.TrimSpace(str))
+			if err != nil {
+				return nil, err
+			}
+			return primitive.NewDateTimeFromTime(t), nil
+		}
+		if f, ok := numberAsFloat(value); ok {
+			// numbers are interpreted as milliseconds since the epoch
+			return primitive.DateTime(int64(f)), nil
+		}
+		return nil, fmt.Errorf("cannot convert %T to a date", value)
+	case "objectId":
+		if !isString {
+			return nil, fmt.Errorf("cannot convert %T to an ObjectId", value)
+		}
+		return primitive.ObjectIDFromHex(strings.TrimSpace(str))
+	}
+	return nil, fmt.Errorf("unsupported conversion type '%v'", to)
+}
+
+// integerValue returns a value as an int64 without going through a float64,
+// so that large longs are not rounded. It returns an error for values that
+// are not integral or do not fit in a long.
+func integerValue(value interface{}) (int64, error) {
+	var str string
+	switch v := value.(type) {
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) || v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, fmt.Errorf("%v is not an integer in the range of a long", v)
+		}
+		return int64(v), nil
+	case string:
+		str = strings.TrimSpace(v)
+		if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return n, nil
+		}
+	case primitive.Decimal128:
+		str = v.String()
+	default:
+		return 0, fmt.Errorf("cannot convert %T to a number", value)
+	}
+	// values such as "12.0", "1e3" or decimals are converted exactly if they
+	// are integral
+	r, ok := new(big.Rat).SetString(str)
+	if !ok {
+		return 0, fmt.Errorf("cannot parse '%v' as a number", str)
+	}
+	if !r.IsInt() || !r.Num().IsInt64() {
+		return 0, fmt.Errorf("%v is not an integer in the range of a long", str)
+	}
+	return r.Num().Int64(), nil
+}
+
+// docPredicate reports whether a document matches a compiled filter.
+type docPredicate func(bson.D) bool
+
+// valuePredicate reports whether a field value matches a compiled operator.
+// The bool argument tells whether the field exists.
+type valuePredicate func(interface{}, bool) bool
+
+// compileFilter compiles a subset of the query language into a predicate:
+// implicit equality, the comparison operators, $in, $nin, $exists, $regex,
+// $not, $and, $or and $nor.
+func compileFilter(filter bson.D) (docPredicate, error) {
+	var preds []docPredicate
+	for _, elem := range filter {
+		switch elem.Key {
+		case "$and", "$or", "$nor":
+			subs, err := compileFilterList(elem.Key, elem.Value)
+			if err != nil {
+				return nil, err
+			}
+			preds = append(preds, combineFilters(elem.Key, subs))
+		default:
+			if strings.HasPrefix(elem.Key, "$") {
+				return nil, fmt.Errorf("unsupported top-level operator '%v'", elem.Key)
+			}
+			valuePred, err := compileFieldCondition(elem.Value)
+			if err != nil {
+				return nil, fmt.Errorf("field '%v': %v", elem.Key, err)
+			}
+			path := elem.Key
+			preds = append(preds, func(doc bson.D) bool {
+				value, exists := getValuePath(doc, path)
+				return valuePred(value, exists)
+			})
+		}
+	}
+	return func(doc bson.D) bool {
+		for _, pred := range preds {
+			if !pred(doc) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func compileFilterList(op string, value interface{}) ([]docPredicate, error) {
+	list, ok := value.(bson.A)
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%v must be a non-empty array", op)
+	}
+	subs := make([]docPredicate, len(list))
+	for i, item := range list {
+		sub, ok := item.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%v entries must be documents", op)
+		}
+		pred, err := compileFilter(sub)
+		if err != nil {
+			return nil, err
+		}
+		subs[i] = pred
+	}
+	return subs, nil
+}
+
+func combineFilters(op string, subs []docPredicate) docPredicate {
+	return func(doc bson.D) bool {
+		for _, sub := range subs {
+			matched := sub(doc)
+			if op == "$and" && !matched {
+				return false
+			}
+			if op != "$and" && matched {
+				return op == "$or"
+			}
+		}
+		return op != "$or"
+	}
+}
+
+// compileFieldCondition compiles the condition for one field, which is either
+// a value to compare for equality or a document of operators.
+func compileFieldCondition(cond interface{}) (valuePredicate, error) {
+	ops, ok := cond.(bson.D)
+	if !ok || len(ops) == 0 || !strings.HasPrefix(ops[0].Key, "$") {
+		if re, ok := cond.(primitive.Regex); ok {
+			return regexPredicate(re.Pattern, re.Options)
+		}
+		return anyElement(func(v interface{}) bool { return valuesEqual(v, cond) }), nil
+	}
+
+	var preds []valuePredicate
+	var regexOptions string
+	for _, op := range ops {
+		if op.Key == "$options" {
+			regexOptions, _ = op.Value.(string)
+		}
+	}
+	for _, op := range ops {
+		operand := op.Value
+		var pred valuePredicate
+		switch op.Key {
+		case "$eq":
+			pred = anyElement(func(v interface{}) bool { return valuesEqual(v, operand) })
+		case "$ne":
+			eq := anyElement(func(v interface{}) bool { return valuesEqual(v, operand) })
+			pred = func(v interface{}, exists bool) bool { return !eq(v, exists) }
+		case "$gt", "$gte", "$lt", "$lte":
+			name := op.Key
+			pred = anyElement(func(v interface{}) bool {
+				if canonicalTypeOrder(v) != canonicalTypeOrder(operand) {
+					return false
+				}
+				c := compareBSONValues(v, operand)
+				switch name {
+				case "$gt":
+					return c > 0
+				case "$gte":
+					return c >= 0
+				case "$lt":
+					return c < 0
+				}
+				return c <= 0
+			})
+		case "$in", "$nin":
+			list, ok := operand.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("%v needs an array", op.Key)
+			}
+			in := anyElement(func(v interface{}) bool {
+				for _, item := range list {
+					if valuesEqual(v, item) {
+						return true
+					}
+				}
+				return false
+			})
+			if op.Key == "$in" {
+				pred = in
+			} else {
+				pred = func(v interface{}, exists bool) bool { return !in(v, exists) }
+			}
+		case "$exists":
+			want := util.IsTruthy(operand)
+			pred = func(_ interface{}, exists bool) bool { return exists == want }
+		case "$regex":
+			pattern, ok := operand.(string)
+			if !ok {
+				return nil, fmt.Errorf("$regex needs a string")
+			}
+			var err error
+			pred, err = regexPredicate(pattern, regexOptions)
+			if err != nil {
+				return nil, err
+			}
+		case "$options":
+			continue
+		case "$not":
+			inner, err := compileFieldCondition(operand)
+			if err != nil {
+				return nil, err
+			}
+			pred = func(v interface{}, exists bool) bool { return !inner(v, exists) }
+		default:
+			return nil, fmt.Errorf("unsupported operator '%v'", op.Key)
+		}
+		preds = append(preds, pred)
+	}
+	return func(v interface{}, exists bool) bool {
+		for _, pred := range preds {
+			if !pred(v, exists) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// anyElement builds a predicate that matches a missing field as null, and
+// matches arrays if either the whole array or any of its elements match.
+func anyElement(match func(interface{}) bool) valuePredicate {
+	return func(v interface{}, exists bool) bool {
+		if !exists {
+			return match(nil)
+		}
+		if match(v) {
+			return true
+		}
+		if arr, ok := v.(bson.A); ok {
+			for _, item := range arr {
+				if match(item) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+func regexPredicate(pattern, options string) (valuePredicate, error) {
+	var flags string
+	for _, opt := range options {
+		if strings.ContainsRune("ims", opt) {
+			flags += string(opt)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %v", err)
+	}
+	return anyElement(func(v interface{}) bool {
+		s, ok := v.(string)
+		return ok && re.MatchString(s)
+	}), nil
+}
+
+// valuesEqual compares two values for query equality; numbers of different
+// types are equal if they have the same value.
+func valuesEqual(a, b interface{}) bool {
+	if canonicalTypeOrder(a) != canonicalTypeOrder(b) {
+		return false
+	}
+	switch a.(type) {
+	case int32, int64, float64, int, primitive.Decimal128, string, primitive.Symbol:
+		return compareBSONValues(a, b) == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
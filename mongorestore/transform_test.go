@@ -0,0 +1,205 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func applyTransform(t *docTransformer, doc bson.D) (bson.D, bool, error) {
+	raw, err := bson.Marshal(doc)
+	So(err, ShouldBeNil)
+	out, keep, err := t.apply(raw)
+	if err != nil || !keep {
+		return nil, keep, err
+	}
+	var result bson.D
+	So(bson.Unmarshal(out, &result), ShouldBeNil)
+	return result, keep, nil
+}
+
+func TestParseTransformRules(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With transform rule files", t, func() {
+		Convey("a valid file should compile", func() {
+			rules, err := parseTransformRules([]byte(`[
+				{"ns": "db.*", "drop": ["a"]},
+				{"ns": "db.c", "convert": {"d": {"to": "date", "format": "2006-01-02"}, "n": "int"}}
+			]`))
+			So(err, ShouldBeNil)
+			So(len(rules), ShouldEqual, 2)
+			So(rules[1].convert, ShouldResemble, []fieldConversion{
+				{path: "d", to: "date", format: "2006-01-02"},
+				{path: "n", to: "int"},
+			})
+		})
+
+		Convey("rules without a namespace should fail", func() {
+			_, err := parseTransformRules([]byte(`[{"drop": ["a"]}]`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("files that are not an array of rules should fail", func() {
+			for _, content := range []string{``, `{"ns": "db.c"}`, `[1]`, `[{"ns": "db.c"}] [`} {
+				_, err := parseTransformRules([]byte(content))
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("unknown conversions should fail", func() {
+			_, err := parseTransformRules([]byte(`[{"ns": "db.c", "convert": {"a": "uuid"}}]`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("unsupported filter operators should fail", func() {
+			_, err := parseTransformRules([]byte(`[{"ns": "db.c", "filter": {"a": {"$where": "x"}}}]`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDocTransformer(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With rules for a namespace", t, func() {
+		rules, err := parseTransformRules([]byte(`[{
+			"ns": "shop.orders",
+			"filter": {"status": {"$ne": "deleted"}},
+			"rename": {"cust": "customer.id"},
+			"convert": {"placed": {"to": "date", "format": "2006-01-02"}, "total": "decimal"},
+			"drop": ["legacy.flags"],
+			"set": {"migrated": true}
+		}, {
+			"ns": "other.*",
+			"drop": ["x"]
+		}]`))
+		So(err, ShouldBeNil)
+		restore := &MongoRestore{transformRules: rules}
+
+		So(restore.transformerFor("shop.customers"), ShouldBeNil)
+		So(len(restore.transformerFor("other.stuff").rules), ShouldEqual, 1)
+		transformer := restore.transformerFor("shop.orders")
+		So(transformer, ShouldNotBeNil)
+
+		Convey("matching documents should be rewritten", func() {
+			doc, keep, err := applyTransform(transformer, bson.D{
+				{"_id", int32(1)},
+				{"status", "open"},
+				{"cust", "c1"},
+				{"placed", "2019-06-01"},
+				{"total", "12.50"},
+				{"legacy", bson.D{{"flags", int32(3)}, {"keep", true}}},
+			})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			total, _ := primitive.ParseDecimal128("12.50")
+			So(doc, ShouldResemble, bson.D{
+				{"_id", int32(1)},
+				{"status", "open"},
+				{"placed", primitive.NewDateTimeFromTime(time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC))},
+				{"total", total},
+				{"legacy", bson.D{{"keep", true}}},
+				{"customer", bson.D{{"id", "c1"}}},
+				{"migrated", true},
+			})
+		})
+
+		Convey("documents not matching the filter should be dropped", func() {
+			_, keep, err := applyTransform(transformer, bson.D{{"_id", int32(2)}, {"status", "deleted"}})
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeFalse)
+		})
+
+		Convey("failed conversions should return an error", func() {
+			_, _, err := applyTransform(transformer, bson.D{{"_id", int32(3)}, {"placed", "yesterday"}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConvertValue(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Integer conversions should be exact", t, func() {
+		value, err := convertValue("9007199254740993", "long", "")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, int64(9007199254740993))
+		value, err = convertValue(int64(9007199254740993), "long", "")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, int64(9007199254740993))
+		value, err = convertValue("12.0", "int", "")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, int32(12))
+		value, err = convertValue(3.0, "int", "")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, int32(3))
+	})
+
+	Convey("Values that are not integral or out of range should be errors", t, func() {
+		_, err := convertValue("1.5", "int", "")
+		So(err, ShouldNotBeNil)
+		_, err = convertValue(2.5, "long", "")
+		So(err, ShouldNotBeNil)
+		_, err = convertValue(int64(1)<<31, "int", "")
+		So(err, ShouldNotBeNil)
+		_, err = convertValue("99999999999999999999", "long", "")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCompileFilter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	doc := bson.D{
+		{"n", int32(5)},
+		{"s", "hello"},
+		{"tags", bson.A{"a", "b"}},
+		{"sub", bson.D{{"x", 2.5}}},
+	}
+	matches := func(filter bson.D) bool {
+		pred, err := compileFilter(filter)
+		So(err, ShouldBeNil)
+		return pred(doc)
+	}
+
+	Convey("Filters should match like server queries", t, func() {
+		So(matches(bson.D{{"n", int64(5)}}), ShouldBeTrue)
+		So(matches(bson.D{{"n", bson.D{{"$gt", int32(4)}, {"$lte", 5.0}}}}), ShouldBeTrue)
+		So(matches(bson.D{{"n", bson.D{{"$lt", int32(5)}}}}), ShouldBeFalse)
+		So(matches(bson.D{{"s", bson.D{{"$in", bson.A{"hi", "hello"}}}}}), ShouldBeTrue)
+		So(matches(bson.D{{"tags", "b"}}), ShouldBeTrue)
+		So(matches(bson.D{{"tags", bson.D{{"$nin", bson.A{"a"}}}}}), ShouldBeFalse)
+		So(matches(bson.D{{"sub.x", bson.D{{"$gte", int32(2)}}}}), ShouldBeTrue)
+		So(matches(bson.D{{"missing", nil}}), ShouldBeTrue)
+		So(matches(bson.D{{"missing", bson.D{{"$exists", true}}}}), ShouldBeFalse)
+		So(matches(bson.D{{"s", bson.D{{"$regex", "^HE"}, {"$options", "i"}}}}), ShouldBeTrue)
+		So(matches(bson.D{{"s", bson.D{{"$not", bson.D{{"$regex", "^he"}}}}}}), ShouldBeFalse)
+		So(matches(bson.D{{"$or", bson.A{bson.D{{"n", int32(1)}}, bson.D{{"s", "hello"}}}}}), ShouldBeTrue)
+		So(matches(bson.D{{"$nor", bson.A{bson.D{{"n", int32(5)}}}}}), ShouldBeFalse)
+		So(matches(bson.D{{"$and", bson.A{bson.D{{"n", int32(5)}}, bson.D{{"s", "bye"}}}}}), ShouldBeFalse)
+	})
+
+	Convey("Filters on large longs should compare them exactly", t, func() {
+		large := bson.D{{"id", int64(1<<60 + 1)}}
+		matchesLarge := func(filter bson.D) bool {
+			pred, err := compileFilter(filter)
+			So(err, ShouldBeNil)
+			return pred(large)
+		}
+		So(matchesLarge(bson.D{{"id", int64(1 << 60)}}), ShouldBeFalse)
+		So(matchesLarge(bson.D{{"id", bson.D{{"$gt", int64(1 << 60)}}}}), ShouldBeTrue)
+		So(matchesLarge(bson.D{{"id", bson.D{{"$lte", float64(1 << 60)}}}}), ShouldBeFalse)
+		So(matchesLarge(bson.D{{"id", bson.D{{"$in", bson.A{int64(1 << 60), int64(1<<60 + 1)}}}}}), ShouldBeTrue)
+	})
+}
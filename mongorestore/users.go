@@ -0,0 +1,303 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/mongodb/mongo-tools-common/bsonutil"
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"github.com/xdg/scram"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// authRewriter rewrites dumped user and role documents before they are
+// merged into the target's auth collections. Database names and privilege
+// resources follow the --nsFrom/--nsTo renames, users matching --excludeUser
+// are skipped, and passwords listed in --userPasswordsFile are reset.
+type authRewriter struct {
+	renameDB  func(string) string
+	renameNS  func(string) string
+	excluder  *ns.Matcher
+	passwords map[string]string
+
+	// reset records the users whose passwords were reset
+	mutex sync.Mutex
+	reset map[string]bool
+}
+
+// LoadUserPasswords reads a --userPasswordsFile, a JSON document mapping
+// "<db>.<user>" names, after any renames, to new passwords.
+func LoadUserPasswords(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading user passwords file: %v", err)
+	}
+	return parseUserPasswords(content)
+}
+
+func parseUserPasswords(content []byte) (map[string]string, error) {
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON(content, false, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing user passwords: %v", err)
+	}
+	passwords := map[string]string{}
+	for _, elem := range doc {
+		password, ok := elem.Value.(string)
+		if !ok || password == "" {
+			return nil, fmt.Errorf("password for user '%v' must be a non-empty string", elem.Key)
+		}
+		if dbName, user := util.SplitNamespace(elem.Key); dbName == "" || user == "" {
+			return nil, fmt.Errorf("user '%v' must be given as <db>.<user>", elem.Key)
+		}
+		passwords[elem.Key] = password
+	}
+	return passwords, nil
+}
+
+// newAuthRewriter returns the rewriter for the users and roles of a restore.
+func (restore *MongoRestore) newAuthRewriter() (*authRewriter, error) {
+	excluder, err := ns.NewMatcher(restore.InputOptions.ExcludeUsers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v: %v", ExcludeUserOption, err)
+	}
+	return &authRewriter{
+		renameDB:  restore.renameAuthDB,
+		renameNS:  restore.renamer.Get,
+		excluder:  excluder,
+		passwords: restore.userPasswords,
+		reset:     map[string]bool{},
+	}, nil
+}
+
+// renameAuthDB returns the database that users and roles of the given dumped
+// database are restored to. A database follows the rename of its system.users
+// namespace, so wildcard renames such as --nsFrom 'a.*' --nsTo 'b.*' carry
+// over to its users and roles.
+func (restore *MongoRestore) renameAuthDB(dbName string) string {
+	if dbName == "" {
+		return ""
+	}
+	renamed, _ := util.SplitNamespace(restore.renamer.Get(dbName + ".system.users"))
+	return renamed
+}
+
+// applyUser rewrites a user document, returning false if it is excluded.
+func (r *authRewriter) applyUser(raw bson.Raw) (bson.Raw, bool, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, false, err
+	}
+	dbName, _ := bsonutil.FindStringValueByKey("db", &doc)
+	user, _ := bsonutil.FindStringValueByKey("user", &doc)
+	if r.excluder.Has(dbName + "." + user) {
+		log.Logvf(log.Info, "not restoring excluded user %v.%v", dbName, user)
+		return nil, false, nil
+	}
+	target := r.renameDB(dbName)
+	if target != dbName {
+		log.Logvf(log.Info, "restoring user %v.%v as %v.%v", dbName, user, target, user)
+	}
+	for i, elem := range doc {
+		switch elem.Key {
+		case "_id":
+			doc[i].Value = target + "." + user
+		case "db":
+			doc[i].Value = target
+		case "roles":
+			doc[i].Value = r.renameRoleRefs(elem.Value)
+		}
+	}
+	if password, ok := r.passwords[target+"."+user]; ok {
+		if err := resetCredentials(doc, user, password); err != nil {
+			return nil, false, fmt.Errorf("error resetting password of %v.%v: %v", target, user, err)
+		}
+		r.mutex.Lock()
+		r.reset[target+"."+user] = true
+		r.mutex.Unlock()
+		log.Logvf(log.Info, "reset password of user %v.%v", target, user)
+	}
+	out, err := bson.Marshal(doc)
+	return out, err == nil, err
+}
+
+// applyRole rewrites a role document.
+func (r *authRewriter) applyRole(raw bson.Raw) (bson.Raw, bool, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, false, err
+	}
+	dbName, _ := bsonutil.FindStringValueByKey("db", &doc)
+	role, _ := bsonutil.FindStringValueByKey("role", &doc)
+	target := r.renameDB(dbName)
+	for i, elem := range doc {
+		switch elem.Key {
+		case "_id":
+			doc[i].Value = target + "." + role
+		case "db":
+			doc[i].Value = target
+		case "roles":
+			doc[i].Value = r.renameRoleRefs(elem.Value)
+		case "privileges":
+			doc[i].Value = r.renamePrivileges(elem.Value)
+		}
+	}
+	out, err := bson.Marshal(doc)
+	return out, err == nil, err
+}
+
+// unusedPasswords returns the users in the passwords file that were not
+// restored.
+func (r *authRewriter) unusedPasswords() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var unused []string
+	for user := range r.passwords {
+		if !r.reset[user] {
+			unused = append(unused, user)
+		}
+	}
+	return unused
+}
+
+// renameRoleRefs renames the db of each {role, db} document in an array.
+func (r *authRewriter) renameRoleRefs(value interface{}) interface{} {
+	refs, ok := value.(bson.A)
+	if !ok {
+		return value
+	}
+	for _, ref := range refs {
+		if doc, ok := ref.(bson.D); ok {
+			r.renameField(doc, "db")
+		}
+	}
+	return refs
+}
+
+// renamePrivileges renames the resource of each privilege in an array. A
+// resource naming a collection follows the rename of its namespace, and
+// otherwise only its db is renamed. An empty resource db refers to every
+// database and is left as is.
+func (r *authRewriter) renamePrivileges(value interface{}) interface{} {
+	privileges, ok := value.(bson.A)
+	if !ok {
+		return value
+	}
+	for _, privilege := range privileges {
+		doc, ok := privilege.(bson.D)
+		if !ok {
+			continue
+		}
+		for _, elem := range doc {
+			if resource, ok := elem.Value.(bson.D); ok && elem.Key == "resource" {
+				r.renameResource(resource)
+			}
+		}
+	}
+	return privileges
+}
+
+// renameResource renames the db and collection of a privilege resource.
+func (r *authRewriter) renameResource(resource bson.D) {
+	dbName, _ := bsonutil.FindStringValueByKey("db", &resource)
+	collName, _ := bsonutil.FindStringValueByKey("collection", &resource)
+	if dbName == "" {
+		return
+	}
+	if collName != "" {
+		namespace := dbName + "." + collName
+		if renamed := r.renameNS(namespace); renamed != namespace {
+			targetDB, targetColl := util.SplitNamespace(renamed)
+			for i, elem := range resource {
+				switch elem.Key {
+				case "db":
+					resource[i].Value = targetDB
+				case "collection":
+					resource[i].Value = targetColl
+				}
+			}
+			return
+		}
+	}
+	r.renameField(resource, "db")
+}
+
+// renameField renames the database named by a string field of a document.
+func (r *authRewriter) renameField(doc bson.D, key string) {
+	for i, elem := range doc {
+		if name, ok := elem.Value.(string); ok && elem.Key == key {
+			doc[i].Value = r.renameDB(name)
+		}
+	}
+}
+
+// scramMechanisms lists the credential mechanisms that can be regenerated
+// from a password, with the salt length the server uses for each.
+var scramMechanisms = []struct {
+	name     string
+	newCreds func(user, password string) (*scram.Client, error)
+	saltSize int
+}{
+	{"SCRAM-SHA-1", func(user, password string) (*scram.Client, error) {
+		// SCRAM-SHA-1 credentials are derived from the legacy MONGODB-CR digest
+		digest := md5.Sum([]byte(user + ":mongo:" + password))
+		return scram.SHA1.NewClientUnprepped(user, hex.EncodeToString(digest[:]), "")
+	}, 16},
+	{"SCRAM-SHA-256", func(user, password string) (*scram.Client, error) {
+		return scram.SHA256.NewClient(user, password, "")
+	}, 28},
+}
+
+// resetCredentials replaces the SCRAM credentials of a user document with
+// ones derived from a new password, keeping each mechanism's iteration count.
+func resetCredentials(doc bson.D, user, password string) error {
+	credentials, err := bsonutil.FindSubdocumentByKey("credentials", &doc)
+	if err != nil {
+		return fmt.Errorf("user has no SCRAM credentials")
+	}
+	var found bool
+	for i, cred := range credentials {
+		for _, mech := range scramMechanisms {
+			if cred.Key != mech.name {
+				continue
+			}
+			current, _ := cred.Value.(bson.D)
+			iterations, err := bsonutil.FindIntByKey("iterationCount", &current)
+			if err != nil {
+				return fmt.Errorf("%v credentials have no iterationCount", mech.name)
+			}
+			client, err := mech.newCreds(user, password)
+			if err != nil {
+				return fmt.Errorf("invalid password: %v", err)
+			}
+			salt := make([]byte, mech.saltSize)
+			if _, err = rand.Read(salt); err != nil {
+				return err
+			}
+			stored := client.GetStoredCredentials(scram.KeyFactors{Salt: string(salt), Iters: iterations})
+			credentials[i].Value = bson.D{
+				{"iterationCount", int32(iterations)},
+				{"salt", base64.StdEncoding.EncodeToString(salt)},
+				{"storedKey", base64.StdEncoding.EncodeToString(stored.StoredKey)},
+				{"serverKey", base64.StdEncoding.EncodeToString(stored.ServerKey)},
+			}
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("user has no SCRAM credentials")
+	}
+	return nil
+}
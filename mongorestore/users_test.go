@@ -0,0 +1,295 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools-common/testutil"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xdg/scram"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newTestAuthRewriter(excludes []string, passwords map[string]string) *authRewriter {
+	renamer, err := ns.NewRenamer([]string{"tenantA.*", "tenantA.orders"}, []string{"tenantB.*", "tenantB.sales"})
+	So(err, ShouldBeNil)
+	restore := &MongoRestore{
+		InputOptions:  &InputOptions{ExcludeUsers: excludes},
+		renamer:       renamer,
+		userPasswords: passwords,
+	}
+	rewriter, err := restore.newAuthRewriter()
+	So(err, ShouldBeNil)
+	return rewriter
+}
+
+func TestParseUserPasswords(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Parsing a passwords file", t, func() {
+		passwords, err := parseUserPasswords([]byte(`{"tenantB.alice": "s3cret", "admin.root": "pw"}`))
+		So(err, ShouldBeNil)
+		So(passwords, ShouldResemble, map[string]string{"tenantB.alice": "s3cret", "admin.root": "pw"})
+
+		_, err = parseUserPasswords([]byte(`{"alice": "s3cret"}`))
+		So(err, ShouldNotBeNil)
+		_, err = parseUserPasswords([]byte(`{"tenantB.alice": 1}`))
+		So(err, ShouldNotBeNil)
+		_, err = parseUserPasswords([]byte(`["tenantB.alice"]`))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestAuthRewriter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With databases renamed from tenantA to tenantB", t, func() {
+		user := mustMarshal(bson.D{
+			{"_id", "tenantA.alice"},
+			{"user", "alice"},
+			{"db", "tenantA"},
+			{"credentials", bson.D{
+				{"SCRAM-SHA-1", bson.D{{"iterationCount", int32(10000)}, {"salt", "x"}, {"storedKey", "x"}, {"serverKey", "x"}}},
+				{"SCRAM-SHA-256", bson.D{{"iterationCount", int32(15000)}, {"salt", "x"}, {"storedKey", "x"}, {"serverKey", "x"}}},
+			}},
+			{"roles", bson.A{
+				bson.D{{"role", "readWrite"}, {"db", "tenantA"}},
+				bson.D{{"role", "clusterMonitor"}, {"db", "admin"}},
+			}},
+		})
+
+		Convey("users follow the rename", func() {
+			rewriter := newTestAuthRewriter(nil, nil)
+			out, keep, err := rewriter.applyUser(user)
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			var doc bson.D
+			So(bson.Unmarshal(out, &doc), ShouldBeNil)
+			So(doc[0].Value, ShouldEqual, "tenantB.alice")
+			So(doc[2].Value, ShouldEqual, "tenantB")
+			So(doc[3].Value, ShouldResemble, bson.D{
+				{"SCRAM-SHA-1", bson.D{{"iterationCount", int32(10000)}, {"salt", "x"}, {"storedKey", "x"}, {"serverKey", "x"}}},
+				{"SCRAM-SHA-256", bson.D{{"iterationCount", int32(15000)}, {"salt", "x"}, {"storedKey", "x"}, {"serverKey", "x"}}},
+			})
+			So(doc[4].Value, ShouldResemble, bson.A{
+				bson.D{{"role", "readWrite"}, {"db", "tenantB"}},
+				bson.D{{"role", "clusterMonitor"}, {"db", "admin"}},
+			})
+		})
+
+		Convey("excluded users are skipped by their dumped name", func() {
+			rewriter := newTestAuthRewriter([]string{"tenantA.al*"}, nil)
+			_, keep, err := rewriter.applyUser(user)
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeFalse)
+		})
+
+		Convey("passwords are reset by the restored name", func() {
+			rewriter := newTestAuthRewriter(nil, map[string]string{"tenantB.alice": "n3w", "tenantB.bob": "pw"})
+			out, keep, err := rewriter.applyUser(user)
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			So(rewriter.unusedPasswords(), ShouldResemble, []string{"tenantB.bob"})
+
+			var doc bson.D
+			So(bson.Unmarshal(out, &doc), ShouldBeNil)
+			creds := doc[3].Value.(bson.D)
+			sha256Creds := creds[1].Value.(bson.D)
+			So(sha256Creds[0].Value, ShouldEqual, int32(15000))
+			salt, err := base64.StdEncoding.DecodeString(sha256Creds[1].Value.(string))
+			So(err, ShouldBeNil)
+			So(len(salt), ShouldEqual, 28)
+
+			client, err := scram.SHA256.NewClient("alice", "n3w", "")
+			So(err, ShouldBeNil)
+			expected := client.GetStoredCredentials(scram.KeyFactors{Salt: string(salt), Iters: 15000})
+			So(sha256Creds[2].Value, ShouldEqual, base64.StdEncoding.EncodeToString(expected.StoredKey))
+			So(sha256Creds[3].Value, ShouldEqual, base64.StdEncoding.EncodeToString(expected.ServerKey))
+		})
+
+		Convey("resetting the password of a user without SCRAM credentials fails", func() {
+			rewriter := newTestAuthRewriter(nil, map[string]string{"$external.CN=client": "pw"})
+			_, _, err := rewriter.applyUser(mustMarshal(bson.D{
+				{"_id", "$external.CN=client"}, {"user", "CN=client"}, {"db", "$external"},
+			}))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("roles and their privilege resources follow the rename", func() {
+			rewriter := newTestAuthRewriter(nil, nil)
+			out, keep, err := rewriter.applyRole(mustMarshal(bson.D{
+				{"_id", "tenantA.app"},
+				{"role", "app"},
+				{"db", "tenantA"},
+				{"privileges", bson.A{
+					bson.D{{"resource", bson.D{{"db", "tenantA"}, {"collection", "orders"}}}, {"actions", bson.A{"find"}}},
+					bson.D{{"resource", bson.D{{"db", "tenantA"}, {"collection", ""}}}, {"actions", bson.A{"find"}}},
+					bson.D{{"resource", bson.D{{"db", ""}, {"collection", "logs"}}}, {"actions", bson.A{"insert"}}},
+					bson.D{{"resource", bson.D{{"cluster", true}}}, {"actions", bson.A{"serverStatus"}}},
+				}},
+				{"roles", bson.A{bson.D{{"role", "base"}, {"db", "tenantA"}}}},
+			}))
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			var doc bson.D
+			So(bson.Unmarshal(out, &doc), ShouldBeNil)
+			So(doc, ShouldResemble, bson.D{
+				{"_id", "tenantB.app"},
+				{"role", "app"},
+				{"db", "tenantB"},
+				{"privileges", bson.A{
+					bson.D{{"resource", bson.D{{"db", "tenantB"}, {"collection", "sales"}}}, {"actions", bson.A{"find"}}},
+					bson.D{{"resource", bson.D{{"db", "tenantB"}, {"collection", ""}}}, {"actions", bson.A{"find"}}},
+					bson.D{{"resource", bson.D{{"db", ""}, {"collection", "logs"}}}, {"actions", bson.A{"insert"}}},
+					bson.D{{"resource", bson.D{{"cluster", true}}}, {"actions", bson.A{"serverStatus"}}},
+				}},
+				{"roles", bson.A{bson.D{{"role", "base"}, {"db", "tenantB"}}}},
+			})
+		})
+	})
+}
+
+func TestRestoreRenamedUsersAndRoles(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+	session, err := testutil.GetBareSession()
+	if err != nil {
+		t.Fatalf("No server available")
+	}
+
+	credentials := bson.D{
+		{"SCRAM-SHA-1", bson.D{
+			{"iterationCount", int32(10000)},
+			{"salt", "hiAEfCthk7Jt/F/5uHxB3g=="},
+			{"storedKey", "dAvifvj2l5akKWkm982jOoEDOl0="},
+			{"serverKey", "GL9N5zASehNnxXX6dVo0IWMF93o="},
+		}},
+		{"SCRAM-SHA-256", bson.D{
+			{"iterationCount", int32(15000)},
+			{"salt", "FQcWUouhJxev+4E8JujE7oA2ayFRwx11O+V8ZQ=="},
+			{"storedKey", "3X4uSuYPG3mdclRE6AaoYQOd/GReQOGQwP64BSVt9CE="},
+			{"serverKey", "JhyPBihmzLsXZe8GmTELaqlMd5O3dwOuez3cAfzOfqQ="},
+		}},
+	}
+	dumpDir := testDumpDir{
+		dirName: "renamed_users",
+		collections: []testCollData{
+			{
+				ns: "admin.system.users",
+				docs: []bson.D{
+					{
+						{"_id", "tenant1.app"},
+						{"user", "app"},
+						{"db", "tenant1"},
+						{"credentials", credentials},
+						{"roles", bson.A{
+							bson.D{{"role", "readWrite"}, {"db", "tenant1"}},
+							bson.D{{"role", "appRole"}, {"db", "tenant1"}},
+						}},
+					},
+					{
+						{"_id", "tenant1.legacy"},
+						{"user", "legacy"},
+						{"db", "tenant1"},
+						{"credentials", credentials},
+						{"roles", bson.A{bson.D{{"role", "read"}, {"db", "tenant1"}}}},
+					},
+				},
+			},
+			{
+				ns: "admin.system.roles",
+				docs: []bson.D{
+					{
+						{"_id", "tenant1.appRole"},
+						{"role", "appRole"},
+						{"db", "tenant1"},
+						{"privileges", bson.A{
+							bson.D{
+								{"resource", bson.D{{"db", "tenant1"}, {"collection", "orders"}}},
+								{"actions", bson.A{"find", "insert"}},
+							},
+						}},
+						{"roles", bson.A{}},
+					},
+				},
+			},
+			{
+				ns:   "admin.system.version",
+				docs: []bson.D{{{"_id", "authSchema"}, {"currentVersion", int32(5)}}},
+			},
+			{
+				ns:   "tenant1.orders",
+				docs: []bson.D{{{"_id", 1}}},
+			},
+		},
+	}
+	err = dumpDir.Create()
+	if err != nil {
+		t.Fatalf("Error creating dump: %v", err)
+	}
+	defer dumpDir.Cleanup()
+
+	admin := session.Database("admin")
+	cleanup := func() {
+		for _, dbName := range []string{"tenant1", "tenant2"} {
+			session.Database(dbName).RunCommand(nil, bson.D{{"dropAllUsersFromDatabase", 1}})
+			session.Database(dbName).RunCommand(nil, bson.D{{"dropAllRolesFromDatabase", 1}})
+			session.Database(dbName).Drop(nil)
+		}
+	}
+	cleanup()
+
+	Convey("With a test MongoRestore renaming tenant1 to tenant2", t, func() {
+		args := []string{
+			NumParallelCollectionsOption, "1",
+			NSFromOption, "tenant1.*",
+			NSToOption, "tenant2.*",
+			ExcludeUserOption, "tenant1.legacy",
+			dumpDir.Path(),
+		}
+		restore, err := getRestoreWithArgs(args...)
+		So(err, ShouldBeNil)
+
+		Convey("users and roles should be restored to tenant2", func() {
+			result := restore.Restore()
+			So(result.Err, ShouldBeNil)
+
+			var user bson.M
+			err := admin.Collection("system.users").FindOne(nil, bson.D{{"_id", "tenant2.app"}}).Decode(&user)
+			So(err, ShouldBeNil)
+			So(user["db"], ShouldEqual, "tenant2")
+			So(user["roles"], ShouldResemble, bson.A{
+				bson.M{"role": "readWrite", "db": "tenant2"},
+				bson.M{"role": "appRole", "db": "tenant2"},
+			})
+
+			var role bson.M
+			err = admin.Collection("system.roles").FindOne(nil, bson.D{{"_id", "tenant2.appRole"}}).Decode(&role)
+			So(err, ShouldBeNil)
+			So(role["db"], ShouldEqual, "tenant2")
+			privileges := role["privileges"].(bson.A)
+			So(len(privileges), ShouldEqual, 1)
+			So(privileges[0].(bson.M)["resource"], ShouldResemble, bson.M{"db": "tenant2", "collection": "orders"})
+
+			Convey("without keeping the dumped names or the excluded user", func() {
+				for _, id := range []string{"tenant1.app", "tenant1.legacy", "tenant2.legacy"} {
+					count, err := admin.Collection("system.users").CountDocuments(nil, bson.D{{"_id", id}})
+					So(err, ShouldBeNil)
+					So(count, ShouldEqual, 0)
+				}
+				count, err := admin.Collection("system.roles").CountDocuments(nil, bson.D{{"_id", "tenant1.appRole"}})
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 0)
+			})
+		})
+
+		Reset(cleanup)
+	})
+}
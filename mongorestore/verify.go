@@ -0,0 +1,188 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CollectionDigest summarizes a set of documents independently of their order:
+// Hash is the sum of a hash of every document.
+type CollectionDigest struct {
+	Count int64
+	Hash  uint64
+}
+
+// add includes a document in the digest. The server always stores _id as the
+// first field, so documents are hashed with _id moved to the front.
+func (d *CollectionDigest) add(raw bson.Raw) error {
+	raw, err := idFirst(raw)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(raw)
+	d.Count++
+	d.Hash += binary.BigEndian.Uint64(sum[:8])
+	return nil
+}
+
+// idFirst returns the document with its _id field first.
+func idFirst(raw bson.Raw) (bson.Raw, error) {
+	elems, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) == 0 || elems[0].Key() == "_id" {
+		return raw, nil
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	for i, elem := range doc {
+		if elem.Key == "_id" {
+			reordered := append(bson.D{elem}, doc[:i]...)
+			reordered = append(reordered, doc[i+1:]...)
+			return bson.Marshal(reordered)
+		}
+	}
+	return raw, nil
+}
+
+// docDigests collects the digests of the documents sent to each namespace
+// by every intent restored.
+type docDigests struct {
+	mutex   sync.Mutex
+	digests map[string]*CollectionDigest
+}
+
+func newDocDigests() *docDigests {
+	return &docDigests{digests: map[string]*CollectionDigest{}}
+}
+
+// track starts the digest of a namespace, so that it is verified even if no
+// documents are restored to it.
+func (d *docDigests) track(namespace string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, ok := d.digests[namespace]; !ok {
+		d.digests[namespace] = &CollectionDigest{}
+	}
+}
+
+// add includes a document in the digest of the given namespace.
+func (d *docDigests) add(namespace string, raw bson.Raw) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	digest, ok := d.digests[namespace]
+	if !ok {
+		digest = &CollectionDigest{}
+		d.digests[namespace] = digest
+	}
+	return digest.add(raw)
+}
+
+// namespaces returns the namespaces with a digest, in order.
+func (d *docDigests) namespaces() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	namespaces := make([]string, 0, len(d.digests))
+	for namespace := range d.digests {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// get returns the digest of the given namespace.
+func (d *docDigests) get(namespace string) CollectionDigest {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if digest, ok := d.digests[namespace]; ok {
+		return *digest
+	}
+	return CollectionDigest{}
+}
+
+// VerifyMismatch describes a restored collection whose contents differ from
+// the documents restored to it.
+type VerifyMismatch struct {
+	Namespace string
+	Expected  CollectionDigest
+	Actual    CollectionDigest
+}
+
+func (m VerifyMismatch) String() string {
+	if m.Expected.Count != m.Actual.Count {
+		return fmt.Sprintf("%v: restored %v %v but the collection has %v",
+			m.Namespace, m.Expected.Count, util.Pluralize(int(m.Expected.Count), "document", "documents"),
+			m.Actual.Count)
+	}
+	return fmt.Sprintf("%v: document hash %016x does not match collection hash %016x",
+		m.Namespace, m.Expected.Hash, m.Actual.Hash)
+}
+
+// scanDigest computes the digest of every document in a collection.
+func (restore *MongoRestore) scanDigest(namespace string) (CollectionDigest, error) {
+	var digest CollectionDigest
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return digest, fmt.Errorf("error establishing connection: %v", err)
+	}
+	dbName, collName := util.SplitNamespace(namespace)
+	cursor, err := session.Database(dbName).Collection(collName).Find(nil, bson.D{})
+	if err != nil {
+		return digest, err
+	}
+	defer cursor.Close(nil)
+	for cursor.Next(nil) {
+		if err = digest.add(cursor.Current); err != nil {
+			return digest, err
+		}
+	}
+	return digest, cursor.Err()
+}
+
+// verifyNamespace compares the expected digest of a restored collection with
+// a scan of the collection, returning a mismatch if they differ.
+func (restore *MongoRestore) verifyNamespace(namespace string, expected CollectionDigest) (*VerifyMismatch, error) {
+	actual, err := restore.scanDigest(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying %v: %v", namespace, err)
+	}
+	if actual == expected {
+		log.Logvf(log.Info, "verified %v (%v %v)",
+			namespace, actual.Count, util.Pluralize(int(actual.Count), "document", "documents"))
+		return nil, nil
+	}
+	return &VerifyMismatch{Namespace: namespace, Expected: expected, Actual: actual}, nil
+}
+
+// verifyRestored compares the documents restored to each namespace, by all
+// of the intents restored to it, with a scan of the collection. It runs once
+// every intent has been restored.
+func (restore *MongoRestore) verifyRestored() ([]VerifyMismatch, error) {
+	var mismatches []VerifyMismatch
+	for _, namespace := range restore.verify.namespaces() {
+		mismatch, err := restore.verifyNamespace(namespace, restore.verify.get(namespace))
+		if err != nil {
+			return mismatches, err
+		}
+		if mismatch != nil {
+			mismatches = append(mismatches, *mismatch)
+		}
+	}
+	return mismatches, nil
+}
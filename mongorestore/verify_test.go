@@ -0,0 +1,213 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools-common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func mustMarshal(doc interface{}) bson.Raw {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func TestCollectionDigest(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a set of documents", t, func() {
+		docs := []bson.Raw{
+			mustMarshal(bson.D{{"_id", 1}, {"a", "x"}}),
+			mustMarshal(bson.D{{"_id", 2}, {"a", "y"}}),
+			mustMarshal(bson.D{{"_id", 3}, {"a", "z"}}),
+		}
+
+		Convey("the digest does not depend on document order", func() {
+			var forward, backward CollectionDigest
+			for i := range docs {
+				So(forward.add(docs[i]), ShouldBeNil)
+				So(backward.add(docs[len(docs)-1-i]), ShouldBeNil)
+			}
+			So(forward.Count, ShouldEqual, 3)
+			So(forward, ShouldResemble, backward)
+		})
+
+		Convey("the digest changes when a document changes", func() {
+			var original, changed CollectionDigest
+			for _, doc := range docs {
+				So(original.add(doc), ShouldBeNil)
+			}
+			So(changed.add(docs[0]), ShouldBeNil)
+			So(changed.add(docs[1]), ShouldBeNil)
+			So(changed.add(mustMarshal(bson.D{{"_id", 3}, {"a", "Z"}})), ShouldBeNil)
+			So(changed.Count, ShouldEqual, original.Count)
+			So(changed.Hash, ShouldNotEqual, original.Hash)
+		})
+
+		Convey("documents are hashed with _id first", func() {
+			var stored, dumped CollectionDigest
+			So(stored.add(mustMarshal(bson.D{{"_id", 1}, {"a", "x"}})), ShouldBeNil)
+			So(dumped.add(mustMarshal(bson.D{{"a", "x"}, {"_id", 1}})), ShouldBeNil)
+			So(dumped, ShouldResemble, stored)
+		})
+	})
+
+	Convey("Digests are collected per namespace", t, func() {
+		digests := newDocDigests()
+		So(digests.add("db.a", mustMarshal(bson.D{{"_id", 1}})), ShouldBeNil)
+		So(digests.add("db.a", mustMarshal(bson.D{{"_id", 2}})), ShouldBeNil)
+		So(digests.add("db.b", mustMarshal(bson.D{{"_id", 1}})), ShouldBeNil)
+		So(digests.get("db.a").Count, ShouldEqual, 2)
+		So(digests.get("db.b").Count, ShouldEqual, 1)
+		So(digests.get("db.c"), ShouldResemble, CollectionDigest{})
+		So(digests.namespaces(), ShouldResemble, []string{"db.a", "db.b"})
+	})
+
+	Convey("Tracked namespaces are verified even without documents", t, func() {
+		digests := newDocDigests()
+		So(digests.add("db.b", mustMarshal(bson.D{{"_id", 1}})), ShouldBeNil)
+		digests.track("db.a")
+		digests.track("db.b")
+		So(digests.namespaces(), ShouldResemble, []string{"db.a", "db.b"})
+		So(digests.get("db.a"), ShouldResemble, CollectionDigest{})
+		So(digests.get("db.b").Count, ShouldEqual, 1)
+	})
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Mismatches describe the difference", t, func() {
+		mismatch := VerifyMismatch{
+			Namespace: "db.c",
+			Expected:  CollectionDigest{Count: 3, Hash: 1},
+			Actual:    CollectionDigest{Count: 2, Hash: 1},
+		}
+		So(mismatch.String(), ShouldEqual, "db.c: restored 3 documents but the collection has 2")
+
+		mismatch.Actual.Count = 3
+		mismatch.Actual.Hash = 2
+		So(mismatch.String(), ShouldEqual,
+			"db.c: document hash 0000000000000001 does not match collection hash 0000000000000002")
+	})
+
+	Convey("Combined results keep every mismatch", t, func() {
+		var result Result
+		result.combineWith(Result{Mismatches: []VerifyMismatch{{Namespace: "db.a"}}})
+		result.combineWith(Result{Successes: 1})
+		result.combineWith(Result{Mismatches: []VerifyMismatch{{Namespace: "db.b"}}})
+		So(result.Successes, ShouldEqual, 1)
+		So(len(result.Mismatches), ShouldEqual, 2)
+	})
+}
+
+func TestVerifyRoutedRestore(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+	session, err := testutil.GetBareSession()
+	if err != nil {
+		t.Fatalf("No server available")
+	}
+
+	dumpDir := testDumpDir{
+		dirName: "verify_routes",
+		collections: []testCollData{
+			{
+				ns: "verify_routes.docs1",
+				docs: []bson.D{
+					{{"_id", 1}, {"type", "a"}},
+					{{"_id", 2}, {"type", "b"}},
+				},
+			},
+			{
+				ns: "verify_routes.docs2",
+				docs: []bson.D{
+					{{"_id", 3}, {"type", "a"}},
+					{{"_id", 4}, {"type", "b"}},
+					{{"_id", 5}, {"type", "b"}},
+				},
+			},
+		},
+	}
+	err = dumpDir.Create()
+	if err != nil {
+		t.Fatalf("Error creating dump: %v", err)
+	}
+	defer dumpDir.Cleanup()
+
+	routeFile := filepath.Join("testdata", "verify_routes.json")
+	err = ioutil.WriteFile(routeFile, []byte(`[{
+		"ns": "verify_routes.$coll$",
+		"routes": [{"filter": {"type": "a"}, "to": "verify_routes.a"}, {"to": "verify_routes.other"}]
+	}]`), 0644)
+	if err != nil {
+		t.Fatalf("Error writing route file: %v", err)
+	}
+	defer os.Remove(routeFile)
+
+	database := session.Database("verify_routes")
+
+	Convey("With a test MongoRestore routing two collections to the same targets", t, func() {
+		args := []string{
+			NumParallelCollectionsOption, "2",
+			DropOption,
+			VerifyOption,
+			RouteFileOption, routeFile,
+			dumpDir.Path(),
+		}
+		restore, err := getRestoreWithArgs(args...)
+		So(err, ShouldBeNil)
+
+		Convey("the targets should hold every routed document and verify", func() {
+			result := restore.Restore()
+			So(result.Err, ShouldBeNil)
+			So(result.Mismatches, ShouldBeEmpty)
+
+			count, err := database.Collection("a").CountDocuments(nil, bson.M{})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+			count, err = database.Collection("other").CountDocuments(nil, bson.M{})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 3)
+
+			Convey("and a document added afterwards should be a mismatch", func() {
+				_, err := database.Collection("other").InsertOne(nil, bson.D{{"_id", 6}, {"type", "b"}})
+				So(err, ShouldBeNil)
+				mismatches, err := restore.verifyRestored()
+				So(err, ShouldBeNil)
+				So(len(mismatches), ShouldEqual, 1)
+				So(mismatches[0].Namespace, ShouldEqual, "verify_routes.other")
+				So(mismatches[0].Expected.Count, ShouldEqual, 3)
+				So(mismatches[0].Actual.Count, ShouldEqual, 4)
+			})
+		})
+
+		Reset(func() {
+			database.Drop(nil)
+		})
+	})
+
+	Convey("With a test MongoRestore verifying without --drop", t, func() {
+		restore, err := getRestoreWithArgs(VerifyOption, dumpDir.Path())
+		So(err, ShouldBeNil)
+
+		Convey("the restore should be rejected", func() {
+			result := restore.Restore()
+			So(result.Err, ShouldNotBeNil)
+			So(result.Err.Error(), ShouldContainSubstring, "cannot use --verify without --drop")
+		})
+	})
+}
@@ -0,0 +1,327 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools-common/archive"
+	"github.com/mongodb/mongo-tools-common/bsonutil"
+	"github.com/mongodb/mongo-tools-common/intents"
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// systemDBs are never renamed by --toDb.
+var systemDBs = map[string]bool{"admin": true, "config": true, "local": true}
+
+// setupToDB finds the single database in the dump that --toDb renames and
+// adds the rename ahead of any --nsFrom/--nsTo renames, which take precedence.
+func (restore *MongoRestore) setupToDB(target archive.DirLike) error {
+	if !target.IsDir() {
+		return fmt.Errorf("%v requires a dump directory or archive", ToDBOption)
+	}
+	entries, err := target.ReadDir()
+	if err != nil {
+		return fmt.Errorf("error reading root dump folder: %v", err)
+	}
+	var candidates []string
+	for _, entry := range entries {
+		if !entry.IsDir() || systemDBs[entry.Name()] {
+			continue
+		}
+		included, err := restore.includesAnyCollection(entry)
+		if err != nil {
+			return err
+		}
+		if included {
+			candidates = append(candidates, entry.Name())
+		}
+	}
+	sort.Strings(candidates)
+	if len(candidates) != 1 {
+		return fmt.Errorf("%v requires exactly one database to restore, found %v; "+
+			"use --nsInclude to choose one", ToDBOption, len(candidates))
+	}
+
+	source := candidates[0]
+	log.Logvf(log.Always, "restoring database %v as %v", source, restore.NSOptions.ToDB)
+	restore.renamer, err = ns.NewRenamer(
+		append([]string{ns.Escape(source) + ".*"}, restore.NSOptions.NSFrom...),
+		append([]string{restore.NSOptions.ToDB + ".*"}, restore.NSOptions.NSTo...),
+	)
+	if err != nil {
+		return fmt.Errorf("invalid renames: %v", err)
+	}
+	return nil
+}
+
+// includesAnyCollection returns true if a dumped database directory holds a
+// collection that is included and not excluded.
+func (restore *MongoRestore) includesAnyCollection(dir archive.DirLike) (bool, error) {
+	entries, err := dir.ReadDir()
+	if err != nil {
+		return false, fmt.Errorf("error reading db folder %v: %v", dir.Name(), err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		collection, _, err := restore.getInfoFromFilename(entry.Name())
+		if err != nil {
+			return false, err
+		}
+		namespace := dir.Name() + "." + collection
+		if restore.includer.Has(namespace) && !restore.excluder.Has(namespace) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// recordRename remembers the dumped namespace of a renamed intent, so that
+// the definitions of renamed views can be rewritten.
+func (restore *MongoRestore) recordRename(source, dest string) {
+	if source == dest {
+		return
+	}
+	if restore.renamedFrom == nil {
+		restore.renamedFrom = map[string]string{}
+	}
+	restore.renamedFrom[dest] = source
+}
+
+// renameViewOptions rewrites the collections a view depends on to follow
+// the renames applied to the view itself. Views can only depend on
+// collections in their own database, so references that are renamed into
+// another database are left as they are.
+func (restore *MongoRestore) renameViewOptions(options bson.D, source, dest string) bson.D {
+	sourceDB, _ := util.SplitNamespace(source)
+	destDB, _ := util.SplitNamespace(dest)
+	rename := func(coll string) string {
+		renamedDB, renamedColl := util.SplitNamespace(restore.renamer.Get(sourceDB + "." + coll))
+		if renamedDB != destDB {
+			log.Logvf(log.Always, "warning: view %v depends on %v.%v, which is restored to database %v",
+				dest, sourceDB, coll, renamedDB)
+			return coll
+		}
+		return renamedColl
+	}
+
+	renamed := make(bson.D, len(options))
+	copy(renamed, options)
+	for i, opt := range renamed {
+		switch opt.Key {
+		case "viewOn":
+			if coll, ok := opt.Value.(string); ok {
+				renamed[i].Value = rename(coll)
+			}
+		case "pipeline":
+			renamed[i].Value = renamePipelineCollections(opt.Value, rename)
+		}
+	}
+	return renamed
+}
+
+// renameViewDocument rewrites a document of a dumped system.views collection,
+// whose _id is the view's namespace.
+func (restore *MongoRestore) renameViewDocument(raw bson.Raw) (bson.Raw, bool, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, false, err
+	}
+	source, err := bsonutil.FindStringValueByKey("_id", &doc)
+	if err != nil {
+		return nil, false, fmt.Errorf("view definition has no namespace: %v", err)
+	}
+	dest := restore.renamer.Get(source)
+	doc = restore.renameViewOptions(doc, source, dest)
+	for i, elem := range doc {
+		if elem.Key == "_id" {
+			doc[i].Value = dest
+		}
+	}
+	out, err := bson.Marshal(doc)
+	return out, err == nil, err
+}
+
+// renamePipelineCollections renames the collections that $lookup,
+// $graphLookup and $unionWith stages of a pipeline read from, including
+// those in nested and $facet pipelines.
+func renamePipelineCollections(pipeline interface{}, rename func(string) string) interface{} {
+	stages, ok := pipeline.(bson.A)
+	if !ok {
+		return pipeline
+	}
+	for i, stage := range stages {
+		stageDoc, ok := stage.(bson.D)
+		if !ok {
+			continue
+		}
+		for j, op := range stageDoc {
+			switch op.Key {
+			case "$lookup", "$graphLookup", "$unionWith":
+				stageDoc[j].Value = renameStageCollections(op.Value, rename)
+			case "$facet":
+				facets, ok := op.Value.(bson.D)
+				if !ok {
+					continue
+				}
+				for k, facet := range facets {
+					facets[k].Value = renamePipelineCollections(facet.Value, rename)
+				}
+			}
+		}
+		stages[i] = stageDoc
+	}
+	return stages
+}
+
+// renameStageCollections renames the "from" or "coll" collection of a
+// single stage's specification and any sub-pipeline it runs.
+func renameStageCollections(spec interface{}, rename func(string) string) interface{} {
+	switch s := spec.(type) {
+	case string:
+		// {$unionWith: "coll"}
+		return rename(s)
+	case bson.D:
+		for i, elem := range s {
+			switch elem.Key {
+			case "from", "coll":
+				if coll, ok := elem.Value.(string); ok {
+					s[i].Value = rename(coll)
+				}
+			case "pipeline":
+				s[i].Value = renamePipelineCollections(elem.Value, rename)
+			}
+		}
+		return s
+	}
+	return spec
+}
+
+// pendingView is a view whose creation is deferred until every collection and
+// the views it depends on have been restored.
+type pendingView struct {
+	intent  *intents.Intent
+	options bson.D
+}
+
+// deferView queues a view to be created by RestoreViews.
+func (restore *MongoRestore) deferView(intent *intents.Intent, options bson.D) {
+	restore.viewsMutex.Lock()
+	defer restore.viewsMutex.Unlock()
+	log.Logvf(log.Info, "deferring creation of view %v until its sources are restored", intent.Namespace())
+	restore.pendingViews = append(restore.pendingViews, pendingView{intent, options})
+}
+
+// RestoreViews creates the deferred views, each after any other views it
+// reads from. This makes views on views resolve against their restored
+// sources, and lets the server check that their collations match.
+func (restore *MongoRestore) RestoreViews() error {
+	restore.viewsMutex.Lock()
+	views := restore.pendingViews
+	restore.pendingViews = nil
+	restore.viewsMutex.Unlock()
+
+	ordered, err := orderViews(views)
+	if err != nil {
+		return err
+	}
+	for _, view := range ordered {
+		log.Logvf(log.Always, "restoring view %v", view.intent.Namespace())
+		_, err = restore.prepareCollection(view.intent, view.options, "", "using options from metadata")
+		if err != nil {
+			return fmt.Errorf("%v: %v", view.intent.Namespace(), err)
+		}
+	}
+	return nil
+}
+
+// viewDependencies returns the namespaces a view reads from: its viewOn
+// collection and the collections of its pipeline's $lookup, $graphLookup and
+// $unionWith stages.
+func viewDependencies(namespace string, options bson.D) []string {
+	dbName, _ := util.SplitNamespace(namespace)
+	var deps []string
+	record := func(coll string) string {
+		deps = append(deps, dbName+"."+coll)
+		return coll
+	}
+	for _, opt := range options {
+		switch opt.Key {
+		case "viewOn":
+			if coll, ok := opt.Value.(string); ok {
+				record(coll)
+			}
+		case "pipeline":
+			renamePipelineCollections(opt.Value, record)
+		}
+	}
+	return deps
+}
+
+// orderViews sorts views so that every view comes after the views it depends
+// on, breaking ties by namespace. It fails if views depend on each other in a
+// cycle.
+func orderViews(views []pendingView) ([]pendingView, error) {
+	byNamespace := map[string]pendingView{}
+	for _, view := range views {
+		byNamespace[view.intent.Namespace()] = view
+	}
+	// dependents maps a view to the views reading from it; waiting counts the
+	// views each view still waits for
+	dependents := map[string][]string{}
+	waiting := map[string]int{}
+	for namespace, view := range byNamespace {
+		seen := map[string]bool{}
+		for _, dep := range viewDependencies(namespace, view.options) {
+			if _, isView := byNamespace[dep]; !isView || seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			dependents[dep] = append(dependents[dep], namespace)
+			waiting[namespace]++
+		}
+	}
+
+	var ready []string
+	for namespace := range byNamespace {
+		if waiting[namespace] == 0 {
+			ready = append(ready, namespace)
+		}
+	}
+	ordered := make([]pendingView, 0, len(byNamespace))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		namespace := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, byNamespace[namespace])
+		for _, dependent := range dependents[namespace] {
+			waiting[dependent]--
+			if waiting[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(ordered) < len(byNamespace) {
+		var cycle []string
+		for namespace := range byNamespace {
+			if waiting[namespace] > 0 {
+				cycle = append(cycle, namespace)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("views depend on each other in a cycle: %v", strings.Join(cycle, ", "))
+	}
+	return ordered, nil
+}
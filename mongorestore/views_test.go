@@ -0,0 +1,179 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/intents"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSetupToDB(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a dump directory", t, func() {
+		dir, err := ioutil.TempDir("", "toDb")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		for _, file := range []string{
+			"shop/orders.bson", "shop/orders.metadata.json",
+			"admin/system.users.bson",
+			"logs/events.bson",
+		} {
+			So(os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, file), nil, 0644), ShouldBeNil)
+		}
+		target, err := newActualPath(dir)
+		So(err, ShouldBeNil)
+
+		restore := &MongoRestore{NSOptions: &NSOptions{ToDB: "tenant1"}, InputOptions: &InputOptions{}}
+		restore.excluder, err = ns.NewMatcher(nil)
+		So(err, ShouldBeNil)
+
+		Convey("more than one database is an error", func() {
+			restore.includer, err = ns.NewMatcher([]string{"*"})
+			So(err, ShouldBeNil)
+			So(restore.setupToDB(target), ShouldNotBeNil)
+		})
+
+		Convey("the included database is renamed, with explicit renames taking precedence", func() {
+			restore.includer, err = ns.NewMatcher([]string{"shop.*", "admin.*"})
+			So(err, ShouldBeNil)
+			restore.NSOptions.NSFrom = []string{"shop.orders"}
+			restore.NSOptions.NSTo = []string{"tenant1.purchases"}
+			So(restore.setupToDB(target), ShouldBeNil)
+			So(restore.renamer.Get("shop.customers"), ShouldEqual, "tenant1.customers")
+			So(restore.renamer.Get("shop.orders"), ShouldEqual, "tenant1.purchases")
+			So(restore.renamer.Get("admin.system.users"), ShouldEqual, "admin.system.users")
+			So(restore.renameAuthDB("shop"), ShouldEqual, "tenant1")
+		})
+	})
+}
+
+func TestRenameViews(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a database renamed and one collection moved elsewhere", t, func() {
+		renamer, err := ns.NewRenamer([]string{"shop.*", "shop.archive"}, []string{"tenant1.*", "cold.archive"})
+		So(err, ShouldBeNil)
+		restore := &MongoRestore{renamer: renamer}
+
+		Convey("view options follow the renames of the collections they read", func() {
+			options := bson.D{
+				{"viewOn", "orders"},
+				{"pipeline", bson.A{
+					bson.D{{"$lookup", bson.D{{"from", "customers"}, {"as", "c"}, {"pipeline", bson.A{
+						bson.D{{"$unionWith", "archive"}},
+					}}}}},
+					bson.D{{"$facet", bson.D{{"recent", bson.A{
+						bson.D{{"$graphLookup", bson.D{{"from", "orders"}}}},
+						bson.D{{"$unionWith", bson.D{{"coll", "returns"}}}},
+					}}}}},
+				}},
+			}
+			renamed := restore.renameViewOptions(options, "shop.bigOrders", "tenant1.bigOrders")
+			So(renamed, ShouldResemble, bson.D{
+				{"viewOn", "orders"},
+				{"pipeline", bson.A{
+					bson.D{{"$lookup", bson.D{{"from", "customers"}, {"as", "c"}, {"pipeline", bson.A{
+						// moved to another database, so left as it was
+						bson.D{{"$unionWith", "archive"}},
+					}}}}},
+					bson.D{{"$facet", bson.D{{"recent", bson.A{
+						bson.D{{"$graphLookup", bson.D{{"from", "orders"}}}},
+						bson.D{{"$unionWith", bson.D{{"coll", "returns"}}}},
+					}}}}},
+				}},
+			})
+		})
+
+		Convey("collection renames within the database are applied", func() {
+			renamer, err := ns.NewRenamer([]string{"shop.*", "shop.orders"}, []string{"tenant1.*", "tenant1.purchases"})
+			So(err, ShouldBeNil)
+			restore.renamer = renamer
+			renamed := restore.renameViewOptions(bson.D{
+				{"viewOn", "orders"},
+				{"pipeline", bson.A{bson.D{{"$lookup", bson.D{{"from", "orders"}}}}}},
+			}, "shop.v", "tenant1.v")
+			So(renamed, ShouldResemble, bson.D{
+				{"viewOn", "purchases"},
+				{"pipeline", bson.A{bson.D{{"$lookup", bson.D{{"from", "purchases"}}}}}},
+			})
+		})
+
+		Convey("system.views documents are renamed", func() {
+			out, keep, err := restore.renameViewDocument(mustMarshal(bson.D{
+				{"_id", "shop.bigOrders"}, {"viewOn", "orders"}, {"pipeline", bson.A{}},
+			}))
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			var doc bson.D
+			So(bson.Unmarshal(out, &doc), ShouldBeNil)
+			So(doc, ShouldResemble, bson.D{
+				{"_id", "tenant1.bigOrders"}, {"viewOn", "orders"}, {"pipeline", bson.A{}},
+			})
+		})
+	})
+}
+
+func TestOrderViews(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	view := func(c string, options bson.D) pendingView {
+		return pendingView{&intents.Intent{DB: "shop", C: c}, options}
+	}
+	namespaces := func(views []pendingView) []string {
+		var out []string
+		for _, v := range views {
+			out = append(out, v.intent.Namespace())
+		}
+		return out
+	}
+
+	Convey("Dependencies come from viewOn and pipeline lookups in the view's database", t, func() {
+		deps := viewDependencies("shop.v", bson.D{
+			{"viewOn", "orders"},
+			{"pipeline", bson.A{
+				bson.D{{"$lookup", bson.D{{"from", "customers"}}}},
+				bson.D{{"$unionWith", "returns"}},
+			}},
+		})
+		So(deps, ShouldResemble, []string{"shop.orders", "shop.customers", "shop.returns"})
+	})
+
+	Convey("Views are created after the views they read from", t, func() {
+		ordered, err := orderViews([]pendingView{
+			view("top", bson.D{{"viewOn", "middle"}, {"pipeline", bson.A{
+				bson.D{{"$lookup", bson.D{{"from", "base"}}}},
+			}}}),
+			view("middle", bson.D{{"viewOn", "base"}, {"pipeline", bson.A{}}}),
+			view("base", bson.D{{"viewOn", "orders"}, {"pipeline", bson.A{}}}),
+			view("other", bson.D{{"viewOn", "orders"}, {"pipeline", bson.A{}}}),
+		})
+		So(err, ShouldBeNil)
+		So(namespaces(ordered), ShouldResemble, []string{"shop.base", "shop.middle", "shop.other", "shop.top"})
+	})
+
+	Convey("Views that depend on each other in a cycle are an error", t, func() {
+		_, err := orderViews([]pendingView{
+			view("a", bson.D{{"viewOn", "b"}, {"pipeline", bson.A{}}}),
+			view("b", bson.D{{"viewOn", "orders"}, {"pipeline", bson.A{
+				bson.D{{"$unionWith", bson.D{{"coll", "a"}}}},
+			}}}),
+			view("c", bson.D{{"viewOn", "orders"}, {"pipeline", bson.A{}}}),
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "shop.a, shop.b")
+	})
+}
@@ -50,7 +50,9 @@ type ConcurrentTransactions struct {
 }
 
 type ConcurrentTransStats struct {
-	Out int64 `bson:"out"`
+	Out          int64 `bson:"out"`
+	Available    int64 `bson:"available"`
+	TotalTickets int64 `bson:"totalTickets"`
 }
 
 type StorageEngine struct {